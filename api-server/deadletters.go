@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"microservices-demo/shared"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultDeadLetterLimit and maxDeadLetterLimit bound GET /api/dead-letters
+const (
+	defaultDeadLetterLimit = 50
+	maxDeadLetterLimit     = 500
+)
+
+// requireRabbitMQ responds with 503 and returns false when the broker is not available
+func (s *APIServer) requireRabbitMQ(c *gin.Context) bool {
+	if s.rabbitmq == nil || s.rabbitmq.IsConnectionClosed() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Message broker is not available"})
+		return false
+	}
+	return true
+}
+
+func (s *APIServer) listDeadLetters(c *gin.Context) {
+	limit := defaultDeadLetterLimit
+	if raw := c.Query("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		if parsed > maxDeadLetterLimit {
+			parsed = maxDeadLetterLimit
+		}
+		limit = parsed
+	}
+
+	if !s.requireRabbitMQ(c) {
+		return
+	}
+
+	deadLetters, err := s.rabbitmq.ListDeadLetters(limit)
+	if err != nil {
+		log.Printf("Failed to list dead letters: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list dead letters"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"dead_letters": deadLetters,
+		"count":        len(deadLetters),
+	})
+}
+
+func (s *APIServer) getDeadLetter(c *gin.Context) {
+	if !s.requireRabbitMQ(c) {
+		return
+	}
+
+	deadLetter, err := s.rabbitmq.GetDeadLetter(c.Param("id"))
+	if errors.Is(err, shared.ErrDeadLetterNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dead letter not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get dead letter: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get dead letter"})
+		return
+	}
+
+	c.JSON(http.StatusOK, deadLetter)
+}
+
+// replayDeadLetter puts a dead-lettered job back on the job queue and resets
+// the job so the new run's updates are shown
+func (s *APIServer) replayDeadLetter(c *gin.Context) {
+	if !s.requireRabbitMQ(c) {
+		return
+	}
+
+	id := c.Param("id")
+	deadLetter, err := s.rabbitmq.GetDeadLetter(id)
+	if errors.Is(err, shared.ErrDeadLetterNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dead letter not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get dead letter %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to replay dead letter"})
+		return
+	}
+	if deadLetter.JobID == "" {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Dead letter is not a valid job message"})
+		return
+	}
+
+	// Replaying a cancelled job would run research nobody is waiting for
+	if job, err := s.store.Get(deadLetter.JobID); err == nil && job.Status == shared.JobStatusCancelled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Job was cancelled"})
+		return
+	}
+
+	deadLetter, err = s.rabbitmq.ReplayDeadLetter(c.Request.Context(), id)
+	if errors.Is(err, shared.ErrDeadLetterNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dead letter not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to replay dead letter %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to replay dead letter"})
+		return
+	}
+
+	job, err := s.store.Update(deadLetter.JobID, func(job *shared.Job) error {
+		job.Status = shared.JobStatusPending
+		job.Error = ""
+		job.Result = ""
+		job.PartialResult = ""
+		job.StartedAt = nil
+		job.CompletedAt = nil
+		return nil
+	})
+	if err == nil {
+		s.events.Publish(job)
+	} else if !errors.Is(err, ErrJobNotFound) {
+		log.Printf("Failed to reset research %s after replay: %v", deadLetter.JobID, err)
+	}
+
+	log.Printf("Dead letter %s replayed for research %s", id, deadLetter.JobID)
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Dead letter replayed",
+		"dead_letter": deadLetter,
+	})
+}
+
+func (s *APIServer) purgeDeadLetters(c *gin.Context) {
+	if !s.requireRabbitMQ(c) {
+		return
+	}
+
+	purged, err := s.rabbitmq.PurgeDeadLetters()
+	if err != nil {
+		log.Printf("Failed to purge dead letters: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge dead letters"})
+		return
+	}
+
+	log.Printf("Purged %d dead letters", purged)
+	c.JSON(http.StatusOK, gin.H{"purged": purged})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"sync"
+
+	"microservices-demo/shared"
+)
+
+// jobEventBuffer is how many updates a slow subscriber may fall behind
+// before it is dropped
+const jobEventBuffer = 32
+
+// Server-Sent Event names
+const (
+	jobEventName     = "job"
+	partialEventName = "partial"
+)
+
+// JobEvent is a single Server-Sent Event about a job
+type JobEvent struct {
+	Name  string
+	JobID string
+	Data  interface{}
+}
+
+// terminal reports whether the event carries a job in a final state
+func (e JobEvent) terminal() bool {
+	job, ok := e.Data.(*shared.Job)
+	return ok && job.Status.IsTerminal()
+}
+
+// JobEventHub fans job updates out to Server-Sent Events subscribers
+type JobEventHub struct {
+	mu          sync.RWMutex
+	subscribers map[chan JobEvent]string
+}
+
+// NewJobEventHub creates a hub with no subscribers
+func NewJobEventHub() *JobEventHub {
+	return &JobEventHub{
+		subscribers: make(map[chan JobEvent]string),
+	}
+}
+
+// Subscribe registers for events about jobID, or about every job when jobID
+// is empty. The returned function must be called to unsubscribe. The channel
+// is closed if the subscriber falls too far behind; it should then end its
+// stream so the client reconnects and starts over from a fresh snapshot.
+func (h *JobEventHub) Subscribe(jobID string) (<-chan JobEvent, func()) {
+	ch := make(chan JobEvent, jobEventBuffer)
+
+	h.mu.Lock()
+	h.subscribers[ch] = jobID
+	h.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.subscribers, ch)
+			h.mu.Unlock()
+		})
+	}
+}
+
+// Publish delivers a full job update to all interested subscribers
+func (h *JobEventHub) Publish(job *shared.Job) {
+	h.publish(JobEvent{Name: jobEventName, JobID: job.ID, Data: job})
+}
+
+// PublishPartial delivers a chunk of streamed report text to subscribers
+func (h *JobEventHub) PublishPartial(partial shared.JobPartialResult) {
+	h.publish(JobEvent{Name: partialEventName, JobID: partial.JobID, Data: partial})
+}
+
+// publish sends an event without blocking on slow subscribers. A subscriber
+// whose buffer is full is dropped rather than sent an incomplete stream, since
+// a missed final update or report chunk cannot be made up by later events.
+func (h *JobEventHub) publish(event JobEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch, jobID := range h.subscribers {
+		if jobID != "" && jobID != event.JobID {
+			continue
+		}
+		select {
+		case ch <- event:
+		default:
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+}
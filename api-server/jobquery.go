@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"microservices-demo/shared"
+)
+
+const (
+	defaultJobListLimit = 50
+	maxJobListLimit     = 100
+)
+
+// jobSortKeys maps the supported ?sort= values to a function returning a
+// lexically comparable key for a job
+var jobSortKeys = map[string]func(job *shared.Job) string{
+	"created_at":   func(job *shared.Job) string { return sortableTime(&job.CreatedAt) },
+	"started_at":   func(job *shared.Job) string { return sortableTime(job.StartedAt) },
+	"completed_at": func(job *shared.Job) string { return sortableTime(job.CompletedAt) },
+	"title":        func(job *shared.Job) string { return strings.ToLower(job.Title) },
+	"status":       func(job *shared.Job) string { return string(job.Status) },
+	"confidence":   func(job *shared.Job) string { return fmt.Sprintf("%012.6f", job.Confidence) },
+	"tokens_used":  func(job *shared.Job) string { return fmt.Sprintf("%020d", job.TokensUsed) },
+}
+
+// sortableTime formats a timestamp so string order matches chronological order.
+// Missing timestamps sort before all others.
+func sortableTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format("2006-01-02T15:04:05.000000000Z")
+}
+
+// JobQuery holds the filtering, sorting and pagination options for GET /api/jobs
+type JobQuery struct {
+	Statuses      []shared.JobStatus
+	ResearchTypes []shared.ResearchType
+	MCPServices   []shared.MCPService
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	Text          string
+	Sort          string
+	Descending    bool
+	Limit         int
+	Offset        int
+	Cursor        *jobCursor
+}
+
+// JobPage is a single page of results from a JobQuery
+type JobPage struct {
+	Jobs       []*shared.Job `json:"jobs"`
+	Total      int           `json:"total"`
+	Limit      int           `json:"limit"`
+	Offset     int           `json:"offset"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// jobCursor marks the position of the last job on a page for keyset pagination
+type jobCursor struct {
+	Sort       string `json:"s"`
+	Descending bool   `json:"d"`
+	Key        string `json:"k"`
+	ID         string `json:"i"`
+}
+
+func (c jobCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeJobCursor(raw string) (*jobCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	var cursor jobCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &cursor, nil
+}
+
+// splitList splits comma-separated and repeated query values into one list
+func splitList(values []string) []string {
+	var items []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// parseJobQuery builds a JobQuery from URL query parameters
+func parseJobQuery(values url.Values) (JobQuery, error) {
+	query := JobQuery{
+		Sort:       "created_at",
+		Descending: true,
+		Limit:      defaultJobListLimit,
+		Text:       strings.TrimSpace(values.Get("q")),
+	}
+
+	for _, status := range splitList(values["status"]) {
+		if !shared.JobStatus(status).IsValid() {
+			return query, fmt.Errorf("unknown status: %s", status)
+		}
+		query.Statuses = append(query.Statuses, shared.JobStatus(status))
+	}
+	for _, researchType := range splitList(values["research_type"]) {
+		if !shared.ResearchType(researchType).IsValid() {
+			return query, fmt.Errorf("unknown research type: %s", researchType)
+		}
+		query.ResearchTypes = append(query.ResearchTypes, shared.ResearchType(researchType))
+	}
+	for _, service := range splitList(values["mcp_services"]) {
+		query.MCPServices = append(query.MCPServices, shared.MCPService(service))
+	}
+
+	for param, target := range map[string]**time.Time{
+		"created_after":  &query.CreatedAfter,
+		"created_before": &query.CreatedBefore,
+	} {
+		if raw := values.Get(param); raw != "" {
+			t, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return query, fmt.Errorf("%s must be an RFC3339 timestamp", param)
+			}
+			*target = &t
+		}
+	}
+
+	if sortField := values.Get("sort"); sortField != "" {
+		if _, ok := jobSortKeys[sortField]; !ok {
+			return query, fmt.Errorf("unsupported sort field: %s", sortField)
+		}
+		query.Sort = sortField
+	}
+
+	switch order := strings.ToLower(values.Get("order")); order {
+	case "", "desc":
+		query.Descending = true
+	case "asc":
+		query.Descending = false
+	default:
+		return query, fmt.Errorf("order must be 'asc' or 'desc'")
+	}
+
+	if raw := values.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 {
+			return query, fmt.Errorf("limit must be a positive integer")
+		}
+		if limit > maxJobListLimit {
+			limit = maxJobListLimit
+		}
+		query.Limit = limit
+	}
+
+	if raw := values.Get("offset"); raw != "" {
+		offset, err := strconv.Atoi(raw)
+		if err != nil || offset < 0 {
+			return query, fmt.Errorf("offset must be a non-negative integer")
+		}
+		query.Offset = offset
+	}
+
+	if raw := values.Get("cursor"); raw != "" {
+		if query.Offset > 0 {
+			return query, fmt.Errorf("cursor and offset cannot be combined")
+		}
+		cursor, err := decodeJobCursor(raw)
+		if err != nil {
+			return query, err
+		}
+		if cursor.Sort != query.Sort || cursor.Descending != query.Descending {
+			return query, fmt.Errorf("cursor does not match the requested sort order")
+		}
+		query.Cursor = cursor
+	}
+
+	return query, nil
+}
+
+// matches reports whether a job satisfies the query filters
+func (q JobQuery) matches(job *shared.Job) bool {
+	if len(q.Statuses) > 0 && !containsValue(q.Statuses, job.Status) {
+		return false
+	}
+	if len(q.ResearchTypes) > 0 && !containsValue(q.ResearchTypes, job.ResearchType) {
+		return false
+	}
+	if len(q.MCPServices) > 0 {
+		found := false
+		for _, service := range job.MCPServices {
+			if containsValue(q.MCPServices, service) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if q.CreatedAfter != nil && job.CreatedAt.Before(*q.CreatedAfter) {
+		return false
+	}
+	if q.CreatedBefore != nil && !job.CreatedAt.Before(*q.CreatedBefore) {
+		return false
+	}
+	if q.Text != "" && !strings.Contains(strings.ToLower(job.Title), strings.ToLower(q.Text)) {
+		return false
+	}
+	return true
+}
+
+func containsValue[T comparable](values []T, value T) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply filters, sorts and paginates jobs according to the query
+func (q JobQuery) Apply(jobs []*shared.Job) JobPage {
+	keyOf := jobSortKeys[q.Sort]
+
+	filtered := make([]*shared.Job, 0, len(jobs))
+	for _, job := range jobs {
+		if q.matches(job) {
+			filtered = append(filtered, job)
+		}
+	}
+
+	// Order by sort key, breaking ties by ID so cursors are stable
+	less := func(keyA, idA, keyB, idB string) bool {
+		if keyA != keyB {
+			if q.Descending {
+				return keyA > keyB
+			}
+			return keyA < keyB
+		}
+		if q.Descending {
+			return idA > idB
+		}
+		return idA < idB
+	}
+	sort.SliceStable(filtered, func(i, j int) bool {
+		return less(keyOf(filtered[i]), filtered[i].ID, keyOf(filtered[j]), filtered[j].ID)
+	})
+
+	start := q.Offset
+	if q.Cursor != nil {
+		start = sort.Search(len(filtered), func(i int) bool {
+			return less(q.Cursor.Key, q.Cursor.ID, keyOf(filtered[i]), filtered[i].ID)
+		})
+	}
+	if start > len(filtered) {
+		start = len(filtered)
+	}
+	end := start + q.Limit
+	if end > len(filtered) {
+		end = len(filtered)
+	}
+
+	page := JobPage{
+		Jobs:   filtered[start:end],
+		Total:  len(filtered),
+		Limit:  q.Limit,
+		Offset: start,
+	}
+
+	if end < len(filtered) {
+		last := filtered[end-1]
+		page.NextCursor = jobCursor{
+			Sort:       q.Sort,
+			Descending: q.Descending,
+			Key:        keyOf(last),
+			ID:         last.ID,
+		}.encode()
+	}
+
+	return page
+}
@@ -2,11 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"sort"
-	"sync"
 	"time"
 
 	"microservices-demo/shared"
@@ -15,18 +17,42 @@ import (
 	"github.com/google/uuid"
 )
 
+// errJobFinished is returned when an operation requires a job that is still in progress
+var errJobFinished = errors.New("job has already finished")
+
+// sseHeartbeatInterval keeps idle event streams open through proxies
+const sseHeartbeatInterval = 15 * time.Second
+
 type APIServer struct {
-	jobs      map[string]*shared.Job
-	jobsMutex sync.RWMutex
-	rabbitmq  *shared.RabbitMQClient
+	store        JobStore
+	events       *JobEventHub
+	mcpCatalog   *MCPCatalogCache
+	mcpStatus    *MCPStatusCache
+	modelCatalog *ModelCatalogCache
+	rabbitmq     *shared.RabbitMQClient
 }
 
 func NewAPIServer() *APIServer {
 	return &APIServer{
-		jobs: make(map[string]*shared.Job),
+		store:        NewMemoryJobStore(),
+		events:       NewJobEventHub(),
+		mcpCatalog:   NewMCPCatalogCache(),
+		mcpStatus:    NewMCPStatusCache(),
+		modelCatalog: NewModelCatalogCache(),
 	}
 }
 
+func (s *APIServer) initJobStore() error {
+	store, err := newJobStoreFromEnv()
+	if err != nil {
+		return err
+	}
+
+	s.store = store
+	log.Printf("Job store initialized (%s)", getEnvOrDefault("JOB_STORE", "memory"))
+	return nil
+}
+
 func (s *APIServer) initRabbitMQ() error {
 	var err error
 
@@ -41,8 +67,14 @@ func (s *APIServer) initRabbitMQ() error {
 		return err
 	}
 
-	// Start consuming job results
+	// Start consuming job results, streamed partial output, the MCP
+	// services and models the job runners advertise and the health of
+	// those services
 	go s.consumeJobResults()
+	go s.consumePartialResults()
+	go consumeReports("MCP catalog", s.rabbitmq.ConsumeMCPCatalogs, s.mcpCatalog.Update)
+	go consumeReports("MCP status", s.rabbitmq.ConsumeMCPStatus, s.mcpStatus.Update)
+	go consumeReports("model catalog", s.rabbitmq.ConsumeModelCatalogs, s.modelCatalog.Update)
 
 	return nil
 }
@@ -65,19 +97,78 @@ func (s *APIServer) consumeJobResults() {
 	}
 }
 
+func (s *APIServer) consumePartialResults() {
+	partials, err := s.rabbitmq.ConsumePartialResults()
+	if err != nil {
+		log.Printf("Failed to consume partial results: %v", err)
+		return
+	}
+
+	for delivery := range partials {
+		var partial shared.JobPartialResult
+		if err := json.Unmarshal(delivery.Body, &partial); err != nil {
+			log.Printf("Failed to unmarshal partial result: %v", err)
+			continue
+		}
+
+		s.appendPartialResult(partial)
+	}
+}
+
+// appendPartialResult accumulates streamed report text on a processing job
+func (s *APIServer) appendPartialResult(partial shared.JobPartialResult) {
+	_, err := s.store.Update(partial.JobID, func(job *shared.Job) error {
+		// Chunks that arrive after the final result are stale
+		if job.Status.IsTerminal() {
+			return errJobFinished
+		}
+		job.PartialResult += partial.Chunk
+		return nil
+	})
+	if errors.Is(err, ErrJobNotFound) || errors.Is(err, errJobFinished) {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to store partial result for research %s: %v", partial.JobID, err)
+		return
+	}
+
+	s.events.PublishPartial(partial)
+}
+
 func (s *APIServer) updateJobStatus(result shared.JobResult) {
-	s.jobsMutex.Lock()
-	defer s.jobsMutex.Unlock()
+	var previousStatus shared.JobStatus
+	job, err := s.store.Update(result.JobID, func(job *shared.Job) error {
+		// A cancelled job keeps its final state even if the runner reports
+		// later, but the tokens the runner spent until it stopped still count
+		if job.Status == shared.JobStatusCancelled {
+			if !result.Status.IsTerminal() || result.TokensUsed == 0 {
+				return errJobFinished
+			}
+			previousStatus = job.Status
+			recordUsage(job, result)
+			return nil
+		}
 
-	if job, exists := s.jobs[result.JobID]; exists {
 		// Update job status and timing
-		previousStatus := job.Status
+		previousStatus = job.Status
 		job.Status = result.Status
 		job.Result = result.Result
+		job.Report = result.Report
 		job.Error = result.Error
 		job.Sources = result.Sources
+		job.References = result.References
+		job.InvalidCitations = result.InvalidCitations
 		job.Confidence = result.Confidence
-		job.TokensUsed = result.TokensUsed
+		recordUsage(job, result)
+		if result.PromptTemplate != "" {
+			job.PromptTemplate = result.PromptTemplate
+			job.PromptVersion = result.PromptVersion
+		}
+		job.Trace = result.Trace
+		job.ServiceOutcomes = result.ServiceOutcomes
+		job.Provenance = result.Provenance
+		job.CacheHits = result.CacheHits
 
 		// Handle different status updates
 		switch result.Status {
@@ -88,9 +179,15 @@ func (s *APIServer) updateJobStatus(result shared.JobResult) {
 				job.StartedAt = &startTime
 				log.Printf("Research %s started processing at %v", result.JobID, startTime)
 			}
-		case shared.JobStatusCompleted, shared.JobStatusFailed:
+		case shared.JobStatusCompleted, shared.JobStatusFailed, shared.JobStatusCancelled:
 			// Set completion time for final states
-			job.CompletedAt = &result.CompletedAt
+			completedAt := result.CompletedAt
+			job.CompletedAt = &completedAt
+
+			// The final report supersedes the streamed partial output
+			if result.Status == shared.JobStatusCompleted {
+				job.PartialResult = ""
+			}
 
 			// Calculate and log processing duration
 			if job.StartedAt != nil {
@@ -99,12 +196,42 @@ func (s *APIServer) updateJobStatus(result shared.JobResult) {
 			}
 		}
 
-		// Log status change for monitoring
-		if previousStatus != result.Status {
-			log.Printf("Research %s status changed: %s -> %s", result.JobID, previousStatus, result.Status)
-		}
-	} else {
+		return nil
+	})
+
+	if errors.Is(err, ErrJobNotFound) {
 		log.Printf("Received result for unknown research: %s", result.JobID)
+		return
+	}
+	if errors.Is(err, errJobFinished) {
+		log.Printf("Ignoring %s result for cancelled research %s", result.Status, result.JobID)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to update research %s: %v", result.JobID, err)
+		return
+	}
+
+	// Log status change for monitoring
+	if previousStatus == shared.JobStatusCancelled {
+		log.Printf("Recorded the tokens of cancelled research %s", result.JobID)
+	} else if previousStatus != result.Status {
+		log.Printf("Research %s status changed: %s -> %s", result.JobID, previousStatus, result.Status)
+	}
+
+	s.events.Publish(job)
+}
+
+// recordUsage copies the token accounting and the model of a result
+func recordUsage(job *shared.Job, result shared.JobResult) {
+	job.TokensUsed = result.TokensUsed
+	job.PromptTokens = result.PromptTokens
+	job.CompletionTokens = result.CompletionTokens
+	job.EstimatedPromptTokens = result.EstimatedPromptTokens
+	job.EstimatedCompletionTokens = result.EstimatedCompletionTokens
+	job.TokensPerSecond = result.TokensPerSecond
+	if result.Model != "" {
+		job.ModelUsed = result.Model
 	}
 }
 
@@ -115,19 +242,40 @@ func (s *APIServer) createJob(c *gin.Context) {
 		return
 	}
 
+	if err := s.validateModel(req.Model, req.Options); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	job := &shared.Job{
 		ID:           uuid.New().String(),
 		Title:        req.Title,
 		Query:        req.Query,
 		ResearchType: req.ResearchType,
 		MCPServices:  req.MCPServices,
+		Model:        req.Model,
+		Options:      req.Options,
+		NoCache:      req.NoCache,
 		Status:       shared.JobStatusPending,
 		CreatedAt:    time.Now(),
+		Attempt:      1,
 	}
 
-	s.jobsMutex.Lock()
-	s.jobs[job.ID] = job
-	s.jobsMutex.Unlock()
+	if !s.enqueueJob(c, job) {
+		return
+	}
+
+	c.JSON(http.StatusCreated, job)
+}
+
+// enqueueJob stores a new job and publishes it to the research queue. On
+// failure it writes the error response and returns false.
+func (s *APIServer) enqueueJob(c *gin.Context, job *shared.Job) bool {
+	if err := s.store.Create(job); err != nil {
+		log.Printf("Failed to store research request: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store research request"})
+		return false
+	}
 
 	// Send research request to queue (only if RabbitMQ is initialized)
 	if s.rabbitmq != nil {
@@ -137,49 +285,379 @@ func (s *APIServer) createJob(c *gin.Context) {
 			Query:        job.Query,
 			ResearchType: job.ResearchType,
 			MCPServices:  job.MCPServices,
+			Model:        job.Model,
+			Options:      job.Options,
+			NoCache:      job.NoCache,
 		}
 
-		if err := s.rabbitmq.PublishJob(jobMessage); err != nil {
-			log.Printf("Failed to publish research request: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue research request"})
-			return
+		if err := s.rabbitmq.PublishJob(c.Request.Context(), jobMessage); err != nil {
+			log.Printf("Failed to publish research request %s: %v", job.ID, err)
+			s.failUnqueuedJob(job.ID, err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error":  "Failed to queue research request",
+				"job_id": job.ID,
+			})
+			return false
 		}
 	} else {
 		log.Println("RabbitMQ not initialized - research request not queued (test mode?)")
 	}
 
+	s.events.Publish(job)
+	return true
+}
+
+// failUnqueuedJob marks a job the broker never accepted as failed, so it does
+// not sit in pending forever
+func (s *APIServer) failUnqueuedJob(jobID string, publishErr error) {
+	job, err := s.store.Update(jobID, func(job *shared.Job) error {
+		completedAt := time.Now()
+		job.Status = shared.JobStatusFailed
+		job.Error = fmt.Sprintf("Failed to queue research request: %v", publishErr)
+		job.CompletedAt = &completedAt
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to mark research %s as failed: %v", jobID, err)
+		return
+	}
+	s.events.Publish(job)
+}
+
+func (s *APIServer) retryJob(c *gin.Context) {
+	jobID := c.Param("id")
+
+	// The request body is optional; an empty body re-runs the job unchanged
+	var req shared.RetryRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.validateModel(req.Model, req.Options); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	original, err := s.store.Get(jobID)
+	if errors.Is(err, ErrJobNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load research %s: %v", jobID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load job"})
+		return
+	}
+
+	if !original.Status.IsTerminal() {
+		c.JSON(http.StatusConflict, gin.H{"error": "Job is still in progress"})
+		return
+	}
+
+	jobs, err := s.store.List()
+	if err != nil {
+		log.Printf("Failed to list research jobs: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list jobs"})
+		return
+	}
+
+	// Number the new attempt after every earlier attempt of the same research
+	attempt := 1
+	for _, previous := range jobAttempts(jobs, original.ID) {
+		if previous.Attempt > attempt {
+			attempt = previous.Attempt
+		}
+	}
+
+	job := &shared.Job{
+		ID:           uuid.New().String(),
+		Title:        original.Title,
+		Query:        original.Query,
+		ResearchType: original.ResearchType,
+		MCPServices:  original.MCPServices,
+		Model:        original.Model,
+		Options:      original.Options,
+		NoCache:      original.NoCache,
+		Status:       shared.JobStatusPending,
+		CreatedAt:    time.Now(),
+		ParentJobID:  original.ID,
+		Attempt:      attempt + 1,
+	}
+	if req.Model != "" {
+		job.Model = req.Model
+	}
+	if req.Options != nil {
+		job.Options = req.Options
+	}
+	if req.ResearchType != "" {
+		job.ResearchType = req.ResearchType
+	}
+	if len(req.MCPServices) > 0 {
+		job.MCPServices = req.MCPServices
+	}
+	if req.NoCache != nil {
+		job.NoCache = *req.NoCache
+	}
+
+	if !s.enqueueJob(c, job) {
+		return
+	}
+
+	log.Printf("Research %s retried as %s (attempt %d)", original.ID, job.ID, job.Attempt)
 	c.JSON(http.StatusCreated, job)
 }
 
+// jobAttempts returns every attempt of the research that jobID belongs to,
+// from the original job through all of its retries, ordered by attempt
+func jobAttempts(jobs []*shared.Job, jobID string) []*shared.Job {
+	byID := make(map[string]*shared.Job, len(jobs))
+	for _, job := range jobs {
+		byID[job.ID] = job
+	}
+
+	rootOf := func(job *shared.Job) string {
+		seen := map[string]bool{}
+		for job.ParentJobID != "" && !seen[job.ID] {
+			seen[job.ID] = true
+			parent, exists := byID[job.ParentJobID]
+			if !exists {
+				return job.ParentJobID
+			}
+			job = parent
+		}
+		return job.ID
+	}
+
+	target, exists := byID[jobID]
+	if !exists {
+		return nil
+	}
+	root := rootOf(target)
+
+	var attempts []*shared.Job
+	for _, job := range jobs {
+		if rootOf(job) == root {
+			attempts = append(attempts, job)
+		}
+	}
+
+	sort.SliceStable(attempts, func(i, j int) bool {
+		if attempts[i].Attempt != attempts[j].Attempt {
+			return attempts[i].Attempt < attempts[j].Attempt
+		}
+		return attempts[i].CreatedAt.Before(attempts[j].CreatedAt)
+	})
+	return attempts
+}
+
+func (s *APIServer) listJobAttempts(c *gin.Context) {
+	jobID := c.Param("id")
+
+	jobs, err := s.store.List()
+	if err != nil {
+		log.Printf("Failed to list research jobs: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list jobs"})
+		return
+	}
+
+	attempts := jobAttempts(jobs, jobID)
+	if attempts == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"attempts": attempts})
+}
+
 func (s *APIServer) getJob(c *gin.Context) {
 	jobID := c.Param("id")
 
-	s.jobsMutex.RLock()
-	job, exists := s.jobs[jobID]
-	s.jobsMutex.RUnlock()
+	job, err := s.store.Get(jobID)
+	if errors.Is(err, ErrJobNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load research %s: %v", jobID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load job"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
 
-	if !exists {
+// getJobReport returns the structured report of a completed job, or with
+// ?format=markdown the report rendered as markdown
+func (s *APIServer) getJobReport(c *gin.Context) {
+	jobID := c.Param("id")
+
+	job, err := s.store.Get(jobID)
+	if errors.Is(err, ErrJobNotFound) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
 		return
 	}
+	if err != nil {
+		log.Printf("Failed to load research %s: %v", jobID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load job"})
+		return
+	}
 
+	if job.Status != shared.JobStatusCompleted {
+		c.JSON(http.StatusConflict, gin.H{"error": "Job has not completed", "status": job.Status})
+		return
+	}
+	// Jobs whose model ignored the report schema only have the markdown result
+	if job.Report == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job has no structured report"})
+		return
+	}
+
+	switch c.Query("format") {
+	case "", "json":
+		c.JSON(http.StatusOK, job.Report)
+	case "markdown":
+		c.Data(http.StatusOK, "text/markdown; charset=utf-8", []byte(job.Report.Markdown()))
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or markdown"})
+	}
+}
+
+func (s *APIServer) cancelJob(c *gin.Context) {
+	jobID := c.Param("id")
+
+	job, err := s.store.Update(jobID, func(job *shared.Job) error {
+		if job.Status.IsTerminal() {
+			return errJobFinished
+		}
+		now := time.Now()
+		job.Status = shared.JobStatusCancelled
+		job.Error = "Research cancelled by user"
+		job.CompletedAt = &now
+		return nil
+	})
+	if errors.Is(err, ErrJobNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	if errors.Is(err, errJobFinished) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Job has already finished"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to cancel research %s: %v", jobID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel job"})
+		return
+	}
+
+	// Tell the job runners to abort the research if it is queued or in flight
+	if s.rabbitmq != nil {
+		controlMessage := shared.ControlMessage{
+			Type:        shared.ControlMessageCancel,
+			JobID:       jobID,
+			RequestedAt: time.Now(),
+		}
+		if err := s.rabbitmq.PublishControl(c.Request.Context(), controlMessage); err != nil {
+			log.Printf("Failed to publish cancellation for research %s: %v", jobID, err)
+		}
+	} else {
+		log.Println("RabbitMQ not initialized - cancellation not broadcast (test mode?)")
+	}
+
+	log.Printf("Research %s cancelled", jobID)
+	s.events.Publish(job)
 	c.JSON(http.StatusOK, job)
 }
 
 func (s *APIServer) listJobs(c *gin.Context) {
-	s.jobsMutex.RLock()
-	jobs := make([]*shared.Job, 0, len(s.jobs))
-	for _, job := range s.jobs {
-		jobs = append(jobs, job)
+	query, err := parseJobQuery(c.Request.URL.Query())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
-	s.jobsMutex.RUnlock()
 
-	// Sort jobs by CreatedAt in descending order (newest first)
-	sort.Slice(jobs, func(i, j int) bool {
-		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
-	})
+	jobs, err := s.store.List()
+	if err != nil {
+		log.Printf("Failed to list research jobs: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list jobs"})
+		return
+	}
+
+	// By default jobs are sorted by CreatedAt in descending order (newest first)
+	c.JSON(http.StatusOK, query.Apply(jobs))
+}
+
+// streamJobEvents streams updates for a single job as Server-Sent Events,
+// starting with its current state and ending once it reaches a final state
+func (s *APIServer) streamJobEvents(c *gin.Context) {
+	jobID := c.Param("id")
+
+	// Subscribe before reading the snapshot so no update is missed in between
+	updates, unsubscribe := s.events.Subscribe(jobID)
+	defer unsubscribe()
+
+	job, err := s.store.Get(jobID)
+	if errors.Is(err, ErrJobNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load research %s: %v", jobID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load job"})
+		return
+	}
+
+	streamEvents(c, []*shared.Job{job}, updates, true)
+}
+
+// streamAllJobEvents streams updates for every job as Server-Sent Events
+func (s *APIServer) streamAllJobEvents(c *gin.Context) {
+	updates, unsubscribe := s.events.Subscribe("")
+	defer unsubscribe()
 
-	c.JSON(http.StatusOK, gin.H{"jobs": jobs})
+	streamEvents(c, nil, updates, false)
+}
+
+// streamEvents writes initial and subsequent job events until the client
+// disconnects. A single-job stream also carries partial report chunks and
+// ends once the job reaches a final state.
+func streamEvents(c *gin.Context, initial []*shared.Job, updates <-chan JobEvent, singleJob bool) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	for _, job := range initial {
+		c.SSEvent(jobEventName, job)
+		if singleJob && job.Status.IsTerminal() {
+			c.Writer.Flush()
+			return
+		}
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case event, ok := <-updates:
+			if !ok {
+				// Fell behind; the client reconnects for a fresh snapshot
+				return false
+			}
+			if event.Name == partialEventName && !singleJob {
+				return true
+			}
+			c.SSEvent(event.Name, event.Data)
+			return !(singleJob && event.terminal())
+		case <-heartbeat.C:
+			_, err := io.WriteString(w, ": keepalive\n\n")
+			return err == nil
+		}
+	})
 }
 
 func (s *APIServer) healthCheck(c *gin.Context) {
@@ -189,37 +667,51 @@ func (s *APIServer) healthCheck(c *gin.Context) {
 		"service":   "api-server",
 	}
 
-	if s.rabbitmq.IsConnectionClosed() {
-		status["status"] = "unhealthy"
-		status["rabbitmq"] = "disconnected"
-		c.JSON(http.StatusServiceUnavailable, status)
-		return
+	// What the job runners run on comes from their latest reports, since
+	// each runner has its own inference server and MCP servers. Reports
+	// received before a RabbitMQ outage are shown with their age.
+	models := s.modelCatalog.Models()
+	status["llm"] = gin.H{
+		"provider":   models.Provider,
+		"model":      models.DefaultModel,
+		"models":     len(models.Models),
+		"updated_at": models.UpdatedAt,
 	}
 
-	status["rabbitmq"] = "connected"
-
-	// Add Ollama server endpoint information
-	ollamaURL := getEnvOrDefault("OLLAMA_URL", "http://localhost:11434")
-	status["ollama"] = gin.H{
-		"endpoint": ollamaURL,
-		"model":    getEnvOrDefault("OLLAMA_MODEL", "llama3.2"),
+	services, runners, testMode, updatedAt := s.mcpStatus.Status()
+	mcpServices := make([]gin.H, 0, len(services))
+	for _, service := range services {
+		mcpServices = append(mcpServices, gin.H{
+			"name":            service.Name,
+			"breaker":         service.Breaker,
+			"healthy_runners": service.HealthyRunners,
+		})
+	}
+	status["mcp"] = gin.H{
+		"test_mode":  testMode,
+		"runners":    runners,
+		"services":   mcpServices,
+		"updated_at": updatedAt,
 	}
 
-	// Add MCP configuration information
-	mcpTestMode := getEnvOrDefault("MCP_TEST_MODE", "false") == "true"
-	mcpInfo := gin.H{
-		"test_mode": mcpTestMode,
+	if s.rabbitmq == nil {
+		status["status"] = "unhealthy"
+		status["rabbitmq"] = "disconnected"
+		c.JSON(http.StatusServiceUnavailable, status)
+		return
 	}
 
-	if !mcpTestMode {
-		mcpInfo["endpoints"] = gin.H{
-			"web_search": getEnvOrDefault("MCP_WEB_SERVER_URL", "http://localhost:3001"),
-			"github":     getEnvOrDefault("MCP_GITHUB_SERVER_URL", "http://localhost:3002"),
-			"files":      getEnvOrDefault("MCP_FILES_SERVER_URL", "http://localhost:3003"),
-		}
+	// The client reconnects on its own; report its state while it does
+	connStatus := s.rabbitmq.Status()
+	status["rabbitmq_connection"] = connStatus
+	if connStatus.State != shared.ConnectionStateConnected {
+		status["status"] = "unhealthy"
+		status["rabbitmq"] = "disconnected"
+		c.JSON(http.StatusServiceUnavailable, status)
+		return
 	}
 
-	status["mcp"] = mcpInfo
+	status["rabbitmq"] = "connected"
 
 	c.JSON(http.StatusOK, status)
 }
@@ -244,8 +736,22 @@ func (s *APIServer) setupRoutes() *gin.Engine {
 	api := r.Group("/api")
 	{
 		api.POST("/jobs", s.createJob)
+		api.GET("/jobs/events", s.streamAllJobEvents)
 		api.GET("/jobs/:id", s.getJob)
+		api.GET("/jobs/:id/events", s.streamJobEvents)
+		api.POST("/jobs/:id/cancel", s.cancelJob)
+		api.POST("/jobs/:id/retry", s.retryJob)
+		api.GET("/jobs/:id/attempts", s.listJobAttempts)
+		api.GET("/jobs/:id/report", s.getJobReport)
 		api.GET("/jobs", s.listJobs)
+		api.GET("/dead-letters", s.listDeadLetters)
+		api.DELETE("/dead-letters", s.purgeDeadLetters)
+		api.GET("/dead-letters/:id", s.getDeadLetter)
+		api.POST("/dead-letters/:id/replay", s.replayDeadLetter)
+		api.GET("/mcp/services", s.listMCPServices)
+		api.GET("/mcp/status", s.getMCPStatus)
+		api.GET("/models", s.listModels)
+		api.GET("/usage", s.getUsage)
 		api.GET("/health", s.healthCheck)
 	}
 
@@ -263,6 +769,11 @@ func getEnvOrDefault(key, defaultValue string) string {
 func main() {
 	server := NewAPIServer()
 
+	if err := server.initJobStore(); err != nil {
+		log.Fatalf("Failed to initialize job store: %v", err)
+	}
+	defer server.store.Close()
+
 	if err := server.initRabbitMQ(); err != nil {
 		log.Fatalf("Failed to initialize RabbitMQ: %v", err)
 	}
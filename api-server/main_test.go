@@ -1,10 +1,16 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"io"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 	"time"
 
@@ -26,6 +32,7 @@ func TestCreateJob(t *testing.T) {
 		Query:        "Research about AI and machine learning",
 		ResearchType: shared.ResearchTypeGeneral,
 		MCPServices:  []shared.MCPService{shared.MCPServiceWeb},
+		NoCache:      true,
 	}
 
 	body, _ := json.Marshal(researchRequest)
@@ -51,6 +58,10 @@ func TestCreateJob(t *testing.T) {
 		t.Errorf("Expected research title %s, got %s", researchRequest.Title, response.Title)
 	}
 
+	if !response.NoCache {
+		t.Error("Expected no_cache to be kept on the job")
+	}
+
 	if response.Query != researchRequest.Query {
 		t.Errorf("Expected research query %s, got %s", researchRequest.Query, response.Query)
 	}
@@ -77,9 +88,9 @@ func TestGetJob(t *testing.T) {
 		CreatedAt:    time.Now(),
 	}
 
-	server.jobsMutex.Lock()
-	server.jobs[testJob.ID] = testJob
-	server.jobsMutex.Unlock()
+	if err := server.store.Create(testJob); err != nil {
+		t.Fatalf("Failed to create test job: %v", err)
+	}
 
 	req, _ := http.NewRequest("GET", "/api/jobs/test-123", nil)
 	w := httptest.NewRecorder()
@@ -100,6 +111,172 @@ func TestGetJob(t *testing.T) {
 	}
 }
 
+func TestGetJobReport(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	server := NewAPIServer()
+	router := server.setupRoutes()
+
+	report := &shared.Report{
+		Summary:     "Go is great.",
+		KeyFindings: []string{"Generics landed in 1.18"},
+		Citations:   []shared.Citation{{Title: "Go blog", URL: "https://go.dev/blog"}},
+	}
+	jobs := []*shared.Job{
+		{ID: "structured", Status: shared.JobStatusCompleted, Result: report.Markdown(), Report: report},
+		{ID: "markdown-only", Status: shared.JobStatusCompleted, Result: "# Report"},
+		{ID: "running", Status: shared.JobStatusProcessing},
+	}
+	for _, job := range jobs {
+		job.CreatedAt = time.Now()
+		if err := server.store.Create(job); err != nil {
+			t.Fatalf("Failed to create test job: %v", err)
+		}
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/api/jobs/structured/report")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+	var got shared.Report
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if got.Summary != report.Summary || len(got.KeyFindings) != 1 || len(got.Citations) != 1 {
+		t.Errorf("Unexpected report: %+v", got)
+	}
+
+	w = get("/api/jobs/structured/report?format=markdown")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/markdown") {
+		t.Fatalf("Expected a markdown response, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	if w.Body.String() != report.Markdown() {
+		t.Errorf("Unexpected markdown: %q", w.Body.String())
+	}
+
+	for path, code := range map[string]int{
+		"/api/jobs/structured/report?format=pdf": http.StatusBadRequest,
+		"/api/jobs/markdown-only/report":         http.StatusNotFound,
+		"/api/jobs/running/report":               http.StatusConflict,
+		"/api/jobs/missing/report":               http.StatusNotFound,
+	} {
+		if w := get(path); w.Code != code {
+			t.Errorf("Expected status code %d for %s, got %d", code, path, w.Code)
+		}
+	}
+}
+
+func TestHealthCheckWithoutRabbitMQ(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	server := NewAPIServer()
+	router := server.setupRoutes()
+
+	// Reports received before the outage are still shown
+	server.modelCatalog.Update(shared.ModelCatalog{
+		RunnerID:     "runner-a",
+		Provider:     "openai",
+		DefaultModel: "qwen2.5:7b",
+		Models:       []shared.ModelInfo{{Name: "qwen2.5:7b"}, {Name: "llama3.2"}},
+	})
+	server.mcpStatus.Update(shared.MCPStatusReport{
+		RunnerID: "runner-a",
+		Services: []shared.MCPServiceHealth{{Name: shared.MCPServiceWeb, Breaker: shared.BreakerClosed, Healthy: true}},
+	})
+
+	req, _ := http.NewRequest("GET", "/api/health", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+
+	var response struct {
+		RabbitMQ string `json:"rabbitmq"`
+		LLM      struct {
+			Provider  string     `json:"provider"`
+			Model     string     `json:"model"`
+			Models    int        `json:"models"`
+			UpdatedAt *time.Time `json:"updated_at"`
+		} `json:"llm"`
+		MCP struct {
+			TestMode bool               `json:"test_mode"`
+			Runners  int                `json:"runners"`
+			Services []MCPServiceStatus `json:"services"`
+		} `json:"mcp"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if response.RabbitMQ != "disconnected" {
+		t.Errorf("Expected rabbitmq to be disconnected, got %v", response.RabbitMQ)
+	}
+	if llm := response.LLM; llm.Provider != "openai" || llm.Model != "qwen2.5:7b" || llm.Models != 2 || llm.UpdatedAt == nil {
+		t.Errorf("Expected the runner's model catalog, got %+v", llm)
+	}
+	if mcp := response.MCP; mcp.TestMode || mcp.Runners != 1 || len(mcp.Services) != 1 || mcp.Services[0].Name != shared.MCPServiceWeb || mcp.Services[0].HealthyRunners != 1 {
+		t.Errorf("Expected the runner's MCP status, got %+v", mcp)
+	}
+}
+
+func TestDeadLettersWithoutRabbitMQ(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	server := NewAPIServer()
+	router := server.setupRoutes()
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{"GET", "/api/dead-letters", http.StatusServiceUnavailable},
+		{"GET", "/api/dead-letters?limit=0", http.StatusBadRequest},
+		{"GET", "/api/dead-letters/dl-1", http.StatusServiceUnavailable},
+		{"POST", "/api/dead-letters/dl-1/replay", http.StatusServiceUnavailable},
+		{"DELETE", "/api/dead-letters", http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, tt.path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != tt.status {
+			t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.path, tt.status, w.Code)
+		}
+	}
+}
+
+func TestFailUnqueuedJob(t *testing.T) {
+	server := NewAPIServer()
+	server.store.Create(&shared.Job{
+		ID:        "unqueued-job",
+		Title:     "Test Research",
+		Status:    shared.JobStatusPending,
+		CreatedAt: time.Now(),
+	})
+
+	server.failUnqueuedJob("unqueued-job", shared.ErrPublishNacked)
+
+	job, err := server.store.Get("unqueued-job")
+	if err != nil {
+		t.Fatalf("Failed to get job: %v", err)
+	}
+	if job.Status != shared.JobStatusFailed {
+		t.Errorf("Expected status %s, got %s", shared.JobStatusFailed, job.Status)
+	}
+	if job.CompletedAt == nil || !strings.Contains(job.Error, "rejected by the broker") {
+		t.Errorf("Expected completed job with publish error, got %+v", job)
+	}
+}
+
 func TestGetJobNotFound(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
@@ -153,11 +330,11 @@ func TestListJobs(t *testing.T) {
 		},
 	}
 
-	server.jobsMutex.Lock()
 	for _, job := range testJobs {
-		server.jobs[job.ID] = job
+		if err := server.store.Create(job); err != nil {
+			t.Fatalf("Failed to create test job: %v", err)
+		}
 	}
-	server.jobsMutex.Unlock()
 
 	req, _ := http.NewRequest("GET", "/api/jobs", nil)
 	w := httptest.NewRecorder()
@@ -214,23 +391,40 @@ func TestUpdateJobStatus(t *testing.T) {
 		CreatedAt:    time.Now(),
 	}
 
-	server.jobsMutex.Lock()
-	server.jobs[testJob.ID] = testJob
-	server.jobsMutex.Unlock()
+	if err := server.store.Create(testJob); err != nil {
+		t.Fatalf("Failed to create test job: %v", err)
+	}
 
 	// Update job status
 	result := shared.JobResult{
-		JobID:       "test-123",
-		Status:      shared.JobStatusCompleted,
-		Result:      "Job completed successfully",
-		CompletedAt: time.Now(),
+		JobID:            "test-123",
+		Status:           shared.JobStatusCompleted,
+		Result:           "Job completed successfully",
+		CompletedAt:      time.Now(),
+		TokensUsed:       1250,
+		PromptTokens:     1000,
+		CompletionTokens: 250,
+		TokensPerSecond:  42.5,
+		Model:            "llama3.2",
+		PromptTemplate:   "market",
+		PromptVersion:    2,
+		Report:           &shared.Report{Summary: "Job completed successfully"},
+		References:       []shared.Reference{{Number: 1, Service: shared.MCPServiceWeb, URL: "https://go.dev", Cited: true}},
+		InvalidCitations: []int{4},
+		ServiceOutcomes: []shared.ServiceOutcome{
+			{Service: shared.MCPServiceWeb, Status: shared.ServiceStatusOK, Calls: 2, LatencyMs: 850, Bytes: 4096},
+			{Service: shared.MCPServiceGitHub, Status: shared.ServiceStatusTimeout, Calls: 1, LatencyMs: 30000, Error: "no answer within 30s"},
+		},
+		Provenance: shared.ProvenanceReal,
+		CacheHits:  1,
 	}
 
 	server.updateJobStatus(result)
 
-	server.jobsMutex.RLock()
-	updatedJob := server.jobs["test-123"]
-	server.jobsMutex.RUnlock()
+	updatedJob, err := server.store.Get("test-123")
+	if err != nil {
+		t.Fatalf("Failed to get updated job: %v", err)
+	}
 
 	if updatedJob.Status != shared.JobStatusCompleted {
 		t.Errorf("Expected status %s, got %s", shared.JobStatusCompleted, updatedJob.Status)
@@ -241,4 +435,675 @@ func TestUpdateJobStatus(t *testing.T) {
 	if updatedJob.CompletedAt == nil {
 		t.Error("Expected CompletedAt to be set")
 	}
+	if updatedJob.PromptTokens != 1000 || updatedJob.CompletionTokens != 250 || updatedJob.TokensPerSecond != 42.5 || updatedJob.ModelUsed != "llama3.2" {
+		t.Errorf("Expected the token accounting to be stored, got %+v", updatedJob)
+	}
+	if updatedJob.PromptTemplate != "market" || updatedJob.PromptVersion != 2 {
+		t.Errorf("Expected the prompt template to be stored, got %s v%d", updatedJob.PromptTemplate, updatedJob.PromptVersion)
+	}
+	if updatedJob.Report == nil || updatedJob.Report.Summary != "Job completed successfully" {
+		t.Errorf("Expected the structured report to be stored, got %+v", updatedJob.Report)
+	}
+	if len(updatedJob.References) != 1 || !updatedJob.References[0].Cited || len(updatedJob.InvalidCitations) != 1 {
+		t.Errorf("Expected the citations to be stored, got %+v %v", updatedJob.References, updatedJob.InvalidCitations)
+	}
+	if len(updatedJob.ServiceOutcomes) != 2 || updatedJob.ServiceOutcomes[1].Status != shared.ServiceStatusTimeout {
+		t.Errorf("Expected the service outcomes to be stored, got %+v", updatedJob.ServiceOutcomes)
+	}
+	if updatedJob.Provenance != shared.ProvenanceReal {
+		t.Errorf("Expected the provenance to be stored, got %q", updatedJob.Provenance)
+	}
+	if updatedJob.CacheHits != 1 {
+		t.Errorf("Expected the cache hits to be stored, got %d", updatedJob.CacheHits)
+	}
+}
+
+func TestListJobsFilteringAndPagination(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	server := NewAPIServer()
+	router := server.setupRoutes()
+
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		status := shared.JobStatusCompleted
+		if i%2 == 0 {
+			status = shared.JobStatusFailed
+		}
+		job := &shared.Job{
+			ID:           fmt.Sprintf("job-%d", i),
+			Title:        fmt.Sprintf("Kubernetes research %d", i),
+			ResearchType: shared.ResearchTypeTechnical,
+			MCPServices:  []shared.MCPService{shared.MCPServiceWeb},
+			Status:       status,
+			CreatedAt:    now.Add(time.Duration(i) * time.Minute),
+		}
+		if err := server.store.Create(job); err != nil {
+			t.Fatalf("Failed to create test job: %v", err)
+		}
+	}
+	if err := server.store.Create(&shared.Job{
+		ID:           "other",
+		Title:        "Market sizing",
+		ResearchType: shared.ResearchTypeMarket,
+		MCPServices:  []shared.MCPService{shared.MCPServiceGitHub},
+		Status:       shared.JobStatusCompleted,
+		CreatedAt:    now,
+	}); err != nil {
+		t.Fatalf("Failed to create test job: %v", err)
+	}
+
+	list := func(query string) JobPage {
+		req, _ := http.NewRequest("GET", "/api/jobs?"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d for %q, got %d: %s", http.StatusOK, query, w.Code, w.Body.String())
+		}
+		var page JobPage
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		return page
+	}
+
+	if page := list("status=failed&research_type=technical"); page.Total != 3 {
+		t.Errorf("Expected 3 failed technical jobs, got %d", page.Total)
+	}
+	if page := list("mcp_services=github"); page.Total != 1 || page.Jobs[0].ID != "other" {
+		t.Errorf("Expected only the github job, got %+v", page.Jobs)
+	}
+	if page := list("q=KUBERNETES"); page.Total != 5 {
+		t.Errorf("Expected case-insensitive title match on 5 jobs, got %d", page.Total)
+	}
+	after := url.QueryEscape(now.Add(2 * time.Minute).Format(time.RFC3339Nano))
+	if page := list("created_after=" + after); page.Total != 3 {
+		t.Errorf("Expected 3 jobs created after cutoff, got %d", page.Total)
+	}
+
+	// Walk all pages with a cursor in ascending title order
+	var seen []string
+	query := "q=kubernetes&sort=title&order=asc&limit=2"
+	for {
+		page := list(query)
+		for _, job := range page.Jobs {
+			seen = append(seen, job.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		query = "q=kubernetes&sort=title&order=asc&limit=2&cursor=" + page.NextCursor
+	}
+	expected := []string{"job-0", "job-1", "job-2", "job-3", "job-4"}
+	if strings.Join(seen, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected cursor walk %v, got %v", expected, seen)
+	}
+
+	if page := list("limit=2&offset=4"); len(page.Jobs) != 2 || page.Offset != 4 || page.NextCursor != "" {
+		t.Errorf("Expected last page of 2 jobs at offset 4, got %+v", page)
+	}
+
+	for _, query := range []string{"sort=bogus", "status=done", "status=failed,bogus", "research_type=science"} {
+		req, _ := http.NewRequest("GET", "/api/jobs?"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for %s, got %d", http.StatusBadRequest, query, w.Code)
+		}
+	}
+}
+
+func TestCancelJob(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	server := NewAPIServer()
+	router := server.setupRoutes()
+
+	for _, job := range []*shared.Job{
+		{ID: "running", Status: shared.JobStatusProcessing, CreatedAt: time.Now()},
+		{ID: "done", Status: shared.JobStatusCompleted, CreatedAt: time.Now()},
+	} {
+		if err := server.store.Create(job); err != nil {
+			t.Fatalf("Failed to create test job: %v", err)
+		}
+	}
+
+	cancel := func(id string) int {
+		req, _ := http.NewRequest("POST", "/api/jobs/"+id+"/cancel", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := cancel("running"); code != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d", http.StatusOK, code)
+	}
+	if code := cancel("done"); code != http.StatusConflict {
+		t.Errorf("Expected status code %d for finished job, got %d", http.StatusConflict, code)
+	}
+	if code := cancel("missing"); code != http.StatusNotFound {
+		t.Errorf("Expected status code %d for missing job, got %d", http.StatusNotFound, code)
+	}
+
+	// Late results from the runner must not overwrite the cancellation, but
+	// the tokens it spent are recorded
+	server.updateJobStatus(shared.JobResult{
+		JobID:            "running",
+		Status:           shared.JobStatusCompleted,
+		Result:           "Too late",
+		CompletedAt:      time.Now(),
+		TokensUsed:       120,
+		PromptTokens:     100,
+		CompletionTokens: 20,
+		Model:            "llama3.2",
+	})
+
+	job, err := server.store.Get("running")
+	if err != nil {
+		t.Fatalf("Failed to get job: %v", err)
+	}
+	if job.Status != shared.JobStatusCancelled || job.CompletedAt == nil {
+		t.Errorf("Expected cancelled job with completion time, got %s", job.Status)
+	}
+	if job.Result != "" {
+		t.Errorf("Expected late result to be ignored, got %q", job.Result)
+	}
+	if job.TokensUsed != 120 || job.PromptTokens != 100 || job.CompletionTokens != 20 || job.ModelUsed != "llama3.2" {
+		t.Errorf("Expected the tokens of the cancelled job to be recorded, got %+v", job)
+	}
+}
+
+func TestRetryJob(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	server := NewAPIServer()
+	router := server.setupRoutes()
+
+	for _, job := range []*shared.Job{
+		{
+			ID:           "failed-job",
+			Title:        "Flaky research",
+			Query:        "Research something",
+			ResearchType: shared.ResearchTypeGeneral,
+			MCPServices:  []shared.MCPService{shared.MCPServiceWeb},
+			Status:       shared.JobStatusFailed,
+			CreatedAt:    time.Now(),
+			Attempt:      1,
+		},
+		{ID: "running-job", Status: shared.JobStatusProcessing, CreatedAt: time.Now(), Attempt: 1},
+	} {
+		if err := server.store.Create(job); err != nil {
+			t.Fatalf("Failed to create test job: %v", err)
+		}
+	}
+
+	retry := func(id, body string) (int, shared.Job) {
+		req, _ := http.NewRequest("POST", "/api/jobs/"+id+"/retry", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var job shared.Job
+		json.Unmarshal(w.Body.Bytes(), &job)
+		return w.Code, job
+	}
+
+	code, second := retry("failed-job", "")
+	if code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d", http.StatusCreated, code)
+	}
+	if second.ParentJobID != "failed-job" || second.Attempt != 2 || second.Query != "Research something" {
+		t.Errorf("Expected attempt 2 linked to failed-job, got %+v", second)
+	}
+
+	// Retry the second attempt with overrides once it has failed too
+	server.updateJobStatus(shared.JobResult{JobID: second.ID, Status: shared.JobStatusFailed, CompletedAt: time.Now()})
+	code, third := retry(second.ID, `{"model":"mistral","mcp_services":["github"],"no_cache":true}`)
+	if code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d", http.StatusCreated, code)
+	}
+	if third.Attempt != 3 || third.Model != "mistral" || len(third.MCPServices) != 1 || third.MCPServices[0] != shared.MCPServiceGitHub || !third.NoCache {
+		t.Errorf("Expected attempt 3 with overrides, got %+v", third)
+	}
+	if second.NoCache {
+		t.Error("Expected attempts to use the cache unless asked not to")
+	}
+
+	if code, _ := retry("running-job", ""); code != http.StatusConflict {
+		t.Errorf("Expected status code %d for running job, got %d", http.StatusConflict, code)
+	}
+
+	req, _ := http.NewRequest("GET", "/api/jobs/"+third.ID+"/attempts", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var response struct {
+		Attempts []shared.Job `json:"attempts"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if len(response.Attempts) != 3 || response.Attempts[0].ID != "failed-job" || response.Attempts[2].ID != third.ID {
+		t.Errorf("Expected 3 attempts in order, got %+v", response.Attempts)
+	}
+
+	// The cache setting is inherited unless the retry sets it either way
+	server.updateJobStatus(shared.JobResult{JobID: third.ID, Status: shared.JobStatusFailed, CompletedAt: time.Now()})
+	if _, fourth := retry(third.ID, ""); !fourth.NoCache {
+		t.Error("Expected attempt 4 to inherit no_cache")
+	} else {
+		server.updateJobStatus(shared.JobResult{JobID: fourth.ID, Status: shared.JobStatusFailed, CompletedAt: time.Now()})
+		if _, fifth := retry(fourth.ID, `{"no_cache":false}`); fifth.Attempt != 5 || fifth.NoCache {
+			t.Errorf("Expected attempt 5 to use the cache again, got %+v", fifth)
+		}
+	}
+}
+
+func TestStreamJobEvents(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	server := NewAPIServer()
+	ts := httptest.NewServer(server.setupRoutes())
+	defer ts.Close()
+
+	if err := server.store.Create(&shared.Job{ID: "sse-job", Status: shared.JobStatusPending, CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("Failed to create test job: %v", err)
+	}
+
+	resp, err := http.Get(ts.URL + "/api/jobs/sse-job/events")
+	if err != nil {
+		t.Fatalf("Failed to open event stream: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "text/event-stream") {
+		t.Errorf("Expected text/event-stream, got %s", contentType)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	nextJob := func() shared.Job {
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("Failed to read event stream: %v", err)
+			}
+			if data, ok := strings.CutPrefix(line, "data:"); ok {
+				var job shared.Job
+				if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &job); err != nil {
+					t.Fatalf("Failed to unmarshal event: %v", err)
+				}
+				return job
+			}
+		}
+	}
+
+	if job := nextJob(); job.Status != shared.JobStatusPending {
+		t.Errorf("Expected initial pending snapshot, got %s", job.Status)
+	}
+
+	server.updateJobStatus(shared.JobResult{JobID: "sse-job", Status: shared.JobStatusCompleted, CompletedAt: time.Now()})
+
+	if job := nextJob(); job.Status != shared.JobStatusCompleted {
+		t.Errorf("Expected completed update, got %s", job.Status)
+	}
+
+	// The stream ends once the job reaches a final state
+	if _, err := io.ReadAll(reader); err != nil {
+		t.Errorf("Expected stream to close cleanly, got %v", err)
+	}
+}
+
+func TestJobEventHubDropsSlowSubscribers(t *testing.T) {
+	hub := NewJobEventHub()
+	slow, unsubscribeSlow := hub.Subscribe("job-1")
+	defer unsubscribeSlow()
+	other, unsubscribeOther := hub.Subscribe("job-2")
+	defer unsubscribeOther()
+
+	for i := 0; i <= jobEventBuffer; i++ {
+		hub.PublishPartial(shared.JobPartialResult{JobID: "job-1", Sequence: i, Chunk: "x"})
+	}
+
+	// The buffered events are delivered, then the channel closes instead of
+	// skipping the events that did not fit
+	received := 0
+	for range slow {
+		received++
+	}
+	if received != jobEventBuffer {
+		t.Errorf("Expected %d buffered events before the close, got %d", jobEventBuffer, received)
+	}
+
+	hub.Publish(&shared.Job{ID: "job-2", Status: shared.JobStatusCompleted})
+	if event := <-other; !event.terminal() {
+		t.Errorf("Expected other subscribers to be unaffected, got %+v", event)
+	}
+	unsubscribeSlow()
+}
+
+func TestAppendPartialResult(t *testing.T) {
+	server := NewAPIServer()
+
+	if err := server.store.Create(&shared.Job{ID: "streaming", Status: shared.JobStatusProcessing, CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("Failed to create test job: %v", err)
+	}
+
+	updates, unsubscribe := server.events.Subscribe("streaming")
+	defer unsubscribe()
+
+	server.appendPartialResult(shared.JobPartialResult{JobID: "streaming", Sequence: 0, Chunk: "# Report"})
+	server.appendPartialResult(shared.JobPartialResult{JobID: "streaming", Sequence: 1, Chunk: " body"})
+
+	job, _ := server.store.Get("streaming")
+	if job.PartialResult != "# Report body" {
+		t.Errorf("Expected accumulated partial result, got %q", job.PartialResult)
+	}
+	if event := <-updates; event.Name != partialEventName {
+		t.Errorf("Expected partial event, got %s", event.Name)
+	}
+
+	server.updateJobStatus(shared.JobResult{JobID: "streaming", Status: shared.JobStatusCompleted, Result: "# Report body", CompletedAt: time.Now()})
+	server.appendPartialResult(shared.JobPartialResult{JobID: "streaming", Sequence: 2, Chunk: " stale"})
+
+	job, _ = server.store.Get("streaming")
+	if job.PartialResult != "" || job.Result != "# Report body" {
+		t.Errorf("Expected final result to replace partial output, got partial %q result %q", job.PartialResult, job.Result)
+	}
+}
+
+func TestListMCPServices(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	server := NewAPIServer()
+	router := server.setupRoutes()
+
+	now := time.Now()
+	server.mcpCatalog.now = func() time.Time { return now }
+
+	// A runner that stopped reporting is ignored
+	server.mcpCatalog.Update(shared.MCPCatalog{
+		RunnerID: "runner-stale",
+		Services: []shared.MCPServiceInfo{{Name: "slack", Title: "Slack", Available: true}},
+	})
+	now = now.Add(mcpCatalogTTL + time.Second)
+
+	server.mcpCatalog.Update(shared.MCPCatalog{
+		RunnerID: "runner-a",
+		Services: []shared.MCPServiceInfo{
+			{Name: shared.MCPServiceWeb, Title: "Web Search", Available: true, Tools: []shared.MCPToolInfo{{Name: "web_search"}}},
+			{Name: shared.MCPServiceGitHub, Title: "GitHub", Error: "connection refused"},
+		},
+	})
+	server.mcpCatalog.Update(shared.MCPCatalog{
+		RunnerID: "runner-b",
+		Services: []shared.MCPServiceInfo{
+			{Name: shared.MCPServiceGitHub, Title: "GitHub", Available: true},
+			{Name: shared.MCPServiceFiles, Title: "Local Files", Available: true},
+		},
+	})
+
+	req, _ := http.NewRequest("GET", "/api/mcp/services", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var response struct {
+		Services []shared.MCPServiceInfo `json:"services"`
+		Count    int                     `json:"count"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+
+	var names []string
+	for _, service := range response.Services {
+		names = append(names, string(service.Name))
+		if !service.Available {
+			t.Errorf("Expected %s to be available from some runner", service.Name)
+		}
+	}
+	if strings.Join(names, ",") != "web,github,files" || response.Count != 3 {
+		t.Errorf("Expected services web,github,files, got %v", names)
+	}
+}
+
+func TestListMCPServicesEmpty(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	server := NewAPIServer()
+	router := server.setupRoutes()
+
+	req, _ := http.NewRequest("GET", "/api/mcp/services", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if !strings.Contains(w.Body.String(), `"services":[]`) {
+		t.Errorf("Expected an empty service list, got %s", w.Body.String())
+	}
+}
+
+func TestGetMCPStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	server := NewAPIServer()
+	router := server.setupRoutes()
+
+	now := time.Now()
+	server.mcpStatus.now = func() time.Time { return now }
+
+	// A runner that stopped reporting is ignored
+	server.mcpStatus.Update(shared.MCPStatusReport{
+		RunnerID: "runner-stale",
+		Services: []shared.MCPServiceHealth{{Name: "slack", Breaker: shared.BreakerClosed, Healthy: true}},
+	})
+	now = now.Add(mcpStatusTTL + time.Second)
+
+	retryAt := now.Add(30 * time.Second)
+	server.mcpStatus.Update(shared.MCPStatusReport{
+		RunnerID: "runner-b",
+		Services: []shared.MCPServiceHealth{
+			{Name: shared.MCPServiceWeb, Breaker: shared.BreakerHalfOpen, Healthy: true},
+			{Name: shared.MCPServiceGitHub, Breaker: shared.BreakerOpen, ConsecutiveFailures: 3, LastError: "connection refused", RetryAt: &retryAt},
+		},
+	})
+	server.mcpStatus.Update(shared.MCPStatusReport{
+		RunnerID: "runner-a",
+		Services: []shared.MCPServiceHealth{
+			{Name: shared.MCPServiceWeb, Breaker: shared.BreakerClosed, Healthy: true},
+			{Name: shared.MCPServiceGitHub, Breaker: shared.BreakerOpen, ConsecutiveFailures: 5, LastError: "timeout"},
+		},
+	})
+
+	req, _ := http.NewRequest("GET", "/api/mcp/status", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var response struct {
+		Services []MCPServiceStatus `json:"services"`
+		Count    int                `json:"count"`
+		Runners  int                `json:"runners"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if response.Count != 2 || response.Runners != 2 {
+		t.Fatalf("Expected 2 services from 2 runners, got %s", w.Body.String())
+	}
+
+	web := response.Services[0]
+	if web.Name != shared.MCPServiceWeb || web.Breaker != shared.BreakerClosed || web.HealthyRunners != 2 {
+		t.Errorf("Expected web to be usable through runner-a, got %+v", web)
+	}
+	github := response.Services[1]
+	if github.Breaker != shared.BreakerOpen || github.HealthyRunners != 0 || len(github.Runners) != 2 {
+		t.Fatalf("Expected github to be down everywhere, got %+v", github)
+	}
+	if github.Runners[0].RunnerID != "runner-a" || github.Runners[1].LastError != "connection refused" || github.Runners[1].RetryAt == nil {
+		t.Errorf("Expected each runner's breaker, got %+v", github.Runners)
+	}
+}
+
+func TestListModels(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	server := NewAPIServer()
+	router := server.setupRoutes()
+
+	server.modelCatalog.Update(shared.ModelCatalog{
+		RunnerID:     "runner-b",
+		Provider:     "ollama",
+		DefaultModel: "qwen2.5:7b",
+		Models:       []shared.ModelInfo{{Name: "qwen2.5:7b"}, {Name: "llama3.2:latest"}},
+	})
+	server.modelCatalog.Update(shared.ModelCatalog{
+		RunnerID:     "runner-a",
+		Provider:     "ollama",
+		DefaultModel: "llama3.2",
+		Models:       []shared.ModelInfo{{Name: "llama3.2:latest", ParameterSize: "3.2B"}},
+	})
+
+	req, _ := http.NewRequest("GET", "/api/models", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var response struct {
+		Models       []shared.ModelInfo `json:"models"`
+		Count        int                `json:"count"`
+		DefaultModel string             `json:"default_model"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+
+	if response.Count != 2 || response.Models[0].Name != "llama3.2:latest" || response.Models[1].Name != "qwen2.5:7b" {
+		t.Errorf("Expected the merged, sorted models, got %+v", response.Models)
+	}
+	if response.DefaultModel != "llama3.2" {
+		t.Errorf("Expected the default of the first runner, got %q", response.DefaultModel)
+	}
+}
+
+func TestCreateJobValidatesModel(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	server := NewAPIServer()
+	router := server.setupRoutes()
+
+	create := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/api/jobs", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Before any runner has reported, models cannot be checked
+	if w := create(`{"title":"t","query":"q","model":"mistral"}`); w.Code != http.StatusCreated {
+		t.Errorf("Expected an unchecked model to be accepted, got %d", w.Code)
+	}
+
+	server.modelCatalog.Update(shared.ModelCatalog{
+		RunnerID: "runner-a",
+		Models:   []shared.ModelInfo{{Name: "llama3.2:latest"}},
+	})
+
+	w := create(`{"title":"t","query":"q","model":"llama3.2","options":{"temperature":0.2,"num_ctx":8192,"max_tokens":1024}}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	var job shared.Job
+	json.Unmarshal(w.Body.Bytes(), &job)
+	if job.Model != "llama3.2" || job.Options == nil || job.Options.NumCtx != 8192 {
+		t.Errorf("Expected the model and options on the job, got %+v", job)
+	}
+
+	if w := create(`{"title":"t","query":"q","model":"mistral"}`); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "unknown model") {
+		t.Errorf("Expected an unknown model to be rejected, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := create(`{"title":"t","query":"q","options":{"temperature":3}}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an out of range temperature to be rejected, got %d", w.Code)
+	}
+}
+
+func TestGetUsage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	server := NewAPIServer()
+	router := server.setupRoutes()
+
+	now := time.Now().UTC()
+	yesterday := now.AddDate(0, 0, -1)
+	longAgo := now.AddDate(0, 0, -60)
+	for _, job := range []*shared.Job{
+		{ID: "1", ModelUsed: "llama3.2", CompletedAt: &yesterday, PromptTokens: 1000, CompletionTokens: 100, TokensPerSecond: 50},
+		{ID: "2", ModelUsed: "llama3.2", CompletedAt: &now, PromptTokens: 500, CompletionTokens: 300, TokensPerSecond: 25},
+		{ID: "3", Model: "qwen2.5:7b", CompletedAt: &now, PromptTokens: 200, CompletionTokens: 20},
+		// Outside the window, estimated only, and unfinished jobs are not counted
+		{ID: "4", ModelUsed: "llama3.2", CompletedAt: &longAgo, PromptTokens: 1000, CompletionTokens: 100},
+		{ID: "5", ModelUsed: "llama3.2", CompletedAt: &now, TokensUsed: 900},
+		{ID: "6", ModelUsed: "llama3.2", PromptTokens: 1000},
+		// Estimated calls are summed apart from the reported ones
+		{ID: "7", ModelUsed: "llama3.2", CompletedAt: &now, PromptTokens: 300, CompletionTokens: 80, EstimatedPromptTokens: 100, EstimatedCompletionTokens: 30, TokensPerSecond: 25},
+		{ID: "8", Model: "qwen2.5:7b", CompletedAt: &now, PromptTokens: 50, CompletionTokens: 10, EstimatedPromptTokens: 50, EstimatedCompletionTokens: 10},
+		// A failed job without a speed counts its tokens but not towards the speed
+		{ID: "9", ModelUsed: "llama3.2", Status: shared.JobStatusFailed, CompletedAt: &now, PromptTokens: 100, CompletionTokens: 100},
+	} {
+		if err := server.store.Create(job); err != nil {
+			t.Fatalf("Failed to create test job: %v", err)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/api/usage", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var report struct {
+		Days    int          `json:"days"`
+		Total   usageTotals  `json:"total"`
+		ByModel []modelUsage `json:"by_model"`
+		ByDay   []dayUsage   `json:"by_day"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+
+	if report.Days != 30 || report.Total.Jobs != 6 || report.Total.TotalTokens != 2570 || report.Total.EstimatedTokens != 190 {
+		t.Errorf("Unexpected totals: %+v", report.Total)
+	}
+	if len(report.ByModel) != 2 || report.ByModel[0].Model != "llama3.2" || report.ByModel[1].Model != "qwen2.5:7b" {
+		t.Fatalf("Unexpected models: %+v", report.ByModel)
+	}
+	// 450 tokens generated in 2s + 12s + 2s
+	if llama := report.ByModel[0]; llama.PromptTokens != 1800 || llama.CompletionTokens != 550 || llama.EstimatedTokens != 130 || math.Abs(llama.TokensPerSecond-450.0/16) > 1e-9 {
+		t.Errorf("Unexpected llama3.2 usage: %+v", llama)
+	}
+	if qwen := report.ByModel[1]; qwen.Jobs != 2 || qwen.TotalTokens != 220 || qwen.EstimatedTokens != 60 || qwen.TokensPerSecond != 0 {
+		t.Errorf("Unexpected qwen2.5:7b usage: %+v", qwen)
+	}
+	if len(report.ByDay) != 2 || report.ByDay[1].Date != now.Format("2006-01-02") || report.ByDay[1].Jobs != 5 || len(report.ByDay[1].Models) != 2 {
+		t.Errorf("Unexpected daily usage: %+v", report.ByDay)
+	}
+
+	req, _ = http.NewRequest("GET", "/api/usage?days=0", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an invalid window, got %d", http.StatusBadRequest, w.Code)
+	}
 }
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"microservices-demo/shared"
+
+	"github.com/gin-gonic/gin"
+)
+
+// mcpCatalogTTL is how long a runner's catalog is trusted without a refresh.
+// Runners advertise every 30 seconds by default.
+const mcpCatalogTTL = 2 * time.Minute
+
+// MCPCatalogCache keeps the latest MCP catalog advertised by each job runner
+type MCPCatalogCache struct {
+	*runnerCache[shared.MCPCatalog]
+}
+
+// NewMCPCatalogCache creates an empty catalog cache
+func NewMCPCatalogCache() *MCPCatalogCache {
+	return &MCPCatalogCache{newRunnerCache(mcpCatalogTTL, func(catalog shared.MCPCatalog) string {
+		return catalog.RunnerID
+	})}
+}
+
+// Services merges the catalogs of the runners heard from recently. A service
+// is available if any runner can reach it; its tools come from that runner.
+// It also reports whether the runners are in test mode and when the newest
+// catalog arrived.
+func (c *MCPCatalogCache) Services() ([]shared.MCPServiceInfo, bool, *time.Time) {
+	catalogs, updatedAt := c.fresh()
+
+	services := []shared.MCPServiceInfo{}
+	index := make(map[shared.MCPService]int)
+	testMode := false
+
+	for _, catalog := range catalogs {
+		if catalog.TestMode {
+			testMode = true
+		}
+
+		for _, service := range catalog.Services {
+			i, seen := index[service.Name]
+			if !seen {
+				index[service.Name] = len(services)
+				services = append(services, service)
+				continue
+			}
+			if service.Available && !services[i].Available {
+				services[i] = service
+			}
+		}
+	}
+
+	return services, testMode, updatedAt
+}
+
+// listMCPServices returns the MCP services the job runners advertise. The
+// list is empty until the first runner has reported in.
+func (s *APIServer) listMCPServices(c *gin.Context) {
+	services, testMode, updatedAt := s.mcpCatalog.Services()
+
+	c.JSON(http.StatusOK, gin.H{
+		"services":   services,
+		"count":      len(services),
+		"test_mode":  testMode,
+		"updated_at": updatedAt,
+	})
+}
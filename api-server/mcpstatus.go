@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"microservices-demo/shared"
+
+	"github.com/gin-gonic/gin"
+)
+
+// mcpStatusTTL is how long a runner's MCP status is trusted without a
+// refresh. Runners probe and report every 15 seconds by default.
+const mcpStatusTTL = time.Minute
+
+// MCPRunnerHealth is one runner's view of an MCP service
+type MCPRunnerHealth struct {
+	RunnerID string `json:"runner_id"`
+	shared.MCPServiceHealth
+}
+
+// MCPServiceStatus is the health of an MCP service across the job runners
+type MCPServiceStatus struct {
+	Name shared.MCPService `json:"name"`
+	// Breaker is the most permissive state of any runner's breaker, since
+	// jobs go to whichever runner is free
+	Breaker        shared.BreakerState `json:"breaker"`
+	HealthyRunners int                 `json:"healthy_runners"`
+	Runners        []MCPRunnerHealth   `json:"runners"`
+}
+
+// MCPStatusCache keeps the latest MCP status reported by each job runner
+type MCPStatusCache struct {
+	*runnerCache[shared.MCPStatusReport]
+}
+
+// NewMCPStatusCache creates an empty status cache
+func NewMCPStatusCache() *MCPStatusCache {
+	return &MCPStatusCache{newRunnerCache(mcpStatusTTL, func(report shared.MCPStatusReport) string {
+		return report.RunnerID
+	})}
+}
+
+// breakerRank orders breaker states from most to least permissive
+var breakerRank = map[shared.BreakerState]int{
+	shared.BreakerClosed:   0,
+	shared.BreakerHalfOpen: 1,
+	shared.BreakerOpen:     2,
+}
+
+// Status merges the reports of the runners heard from recently by service.
+// It also reports how many runners that is, whether they are in test mode
+// and when the newest report arrived.
+func (c *MCPStatusCache) Status() ([]MCPServiceStatus, int, bool, *time.Time) {
+	reports, updatedAt := c.fresh()
+
+	services := []MCPServiceStatus{}
+	index := make(map[shared.MCPService]int)
+	testMode := false
+
+	for _, report := range reports {
+		if report.TestMode {
+			testMode = true
+		}
+
+		for _, health := range report.Services {
+			i, seen := index[health.Name]
+			if !seen {
+				i = len(services)
+				index[health.Name] = i
+				services = append(services, MCPServiceStatus{Name: health.Name, Breaker: health.Breaker})
+			}
+
+			service := &services[i]
+			if breakerRank[health.Breaker] < breakerRank[service.Breaker] {
+				service.Breaker = health.Breaker
+			}
+			if health.Healthy {
+				service.HealthyRunners++
+			}
+			service.Runners = append(service.Runners, MCPRunnerHealth{RunnerID: report.RunnerID, MCPServiceHealth: health})
+		}
+	}
+
+	return services, len(reports), testMode, updatedAt
+}
+
+// getMCPStatus returns the circuit breaker state and probed health of each
+// MCP service across the job runners
+func (s *APIServer) getMCPStatus(c *gin.Context) {
+	services, runners, testMode, updatedAt := s.mcpStatus.Status()
+
+	c.JSON(http.StatusOK, gin.H{
+		"services":   services,
+		"count":      len(services),
+		"runners":    runners,
+		"test_mode":  testMode,
+		"updated_at": updatedAt,
+	})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"microservices-demo/shared"
+
+	"github.com/gin-gonic/gin"
+)
+
+// modelCatalogTTL is how long a runner's model list is trusted without a
+// refresh. Runners advertise every 30 seconds by default.
+const modelCatalogTTL = 2 * time.Minute
+
+// ModelCatalogCache keeps the latest model list advertised by each job runner
+type ModelCatalogCache struct {
+	*runnerCache[shared.ModelCatalog]
+}
+
+// NewModelCatalogCache creates an empty model catalog cache
+func NewModelCatalogCache() *ModelCatalogCache {
+	return &ModelCatalogCache{newRunnerCache(modelCatalogTTL, func(catalog shared.ModelCatalog) string {
+		return catalog.RunnerID
+	})}
+}
+
+// modelList is the merged view of the runners heard from recently
+type modelList struct {
+	Models       []shared.ModelInfo
+	DefaultModel string
+	Provider     string
+	UpdatedAt    *time.Time
+}
+
+// Models merges the model lists of the runners heard from recently. The
+// default model and provider come from the first runner by ID.
+func (c *ModelCatalogCache) Models() modelList {
+	catalogs, updatedAt := c.fresh()
+
+	list := modelList{Models: []shared.ModelInfo{}, UpdatedAt: updatedAt}
+	seen := make(map[string]bool)
+	for i, catalog := range catalogs {
+		if i == 0 {
+			list.DefaultModel = catalog.DefaultModel
+			list.Provider = catalog.Provider
+		}
+
+		for _, model := range catalog.Models {
+			if !seen[model.Name] {
+				seen[model.Name] = true
+				list.Models = append(list.Models, model)
+			}
+		}
+	}
+
+	sort.Slice(list.Models, func(i, j int) bool {
+		return list.Models[i].Name < list.Models[j].Name
+	})
+	return list
+}
+
+// has reports whether a model is in the list. Ollama resolves a name without
+// a tag to its latest tag, so "llama3.2" matches "llama3.2:latest".
+func (l modelList) has(name string) bool {
+	for _, model := range l.Models {
+		if model.Name == name || (!strings.Contains(name, ":") && model.Name == name+":latest") {
+			return true
+		}
+	}
+	return false
+}
+
+// validateModel checks the model and options a job asks for. Models can only
+// be checked once a runner has advertised its list; until then any name is
+// accepted and an unknown model fails the job on the runner.
+func (s *APIServer) validateModel(model string, options *shared.ModelOptions) error {
+	if err := options.Validate(); err != nil {
+		return err
+	}
+	if model == "" {
+		return nil
+	}
+
+	list := s.modelCatalog.Models()
+	if list.UpdatedAt == nil {
+		log.Printf("No model catalog received yet, accepting model %q unchecked", model)
+		return nil
+	}
+	if !list.has(model) {
+		return fmt.Errorf("unknown model %q, see GET /api/models for available models", model)
+	}
+	return nil
+}
+
+// listModels returns the models the job runners advertise. The list is
+// empty until the first runner has reported in.
+func (s *APIServer) listModels(c *gin.Context) {
+	list := s.modelCatalog.Models()
+
+	c.JSON(http.StatusOK, gin.H{
+		"models":        list.Models,
+		"count":         len(list.Models),
+		"default_model": list.DefaultModel,
+		"provider":      list.Provider,
+		"updated_at":    list.UpdatedAt,
+	})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// receivedReport is a report a runner broadcast and when it arrived
+type receivedReport[T any] struct {
+	report     T
+	receivedAt time.Time
+}
+
+// runnerCache keeps the latest report of one kind broadcast by each job
+// runner. Reports older than the TTL belong to runners that stopped
+// reporting and are left out.
+type runnerCache[T any] struct {
+	mu       sync.RWMutex
+	reports  map[string]receivedReport[T]
+	runnerID func(T) string
+	ttl      time.Duration
+	now      func() time.Time
+}
+
+// newRunnerCache creates an empty cache for reports identified by runnerID
+func newRunnerCache[T any](ttl time.Duration, runnerID func(T) string) *runnerCache[T] {
+	return &runnerCache[T]{
+		reports:  make(map[string]receivedReport[T]),
+		runnerID: runnerID,
+		ttl:      ttl,
+		now:      time.Now,
+	}
+}
+
+// Update records the report a runner broadcast
+func (c *runnerCache[T]) Update(report T) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reports[c.runnerID(report)] = receivedReport[T]{report: report, receivedAt: c.now()}
+}
+
+// fresh returns the reports of the runners heard from recently, ordered by
+// runner ID so views merged from them do not flap, and when the newest
+// arrived. The time is nil if no runner has reported recently.
+func (c *runnerCache[T]) fresh() ([]T, *time.Time) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := c.now()
+	var received []receivedReport[T]
+	for _, r := range c.reports {
+		if now.Sub(r.receivedAt) <= c.ttl {
+			received = append(received, r)
+		}
+	}
+	sort.Slice(received, func(i, j int) bool {
+		return c.runnerID(received[i].report) < c.runnerID(received[j].report)
+	})
+
+	reports := make([]T, 0, len(received))
+	var updatedAt *time.Time
+	for _, r := range received {
+		reports = append(reports, r.report)
+		if updatedAt == nil || r.receivedAt.After(*updatedAt) {
+			receivedAt := r.receivedAt
+			updatedAt = &receivedAt
+		}
+	}
+	return reports, updatedAt
+}
+
+// consumeReports subscribes with subscribe and passes every report the
+// runners broadcast to update. kind names the report in the log.
+func consumeReports[T any](kind string, subscribe func() (<-chan amqp.Delivery, error), update func(T)) {
+	deliveries, err := subscribe()
+	if err != nil {
+		log.Printf("Failed to consume %s reports: %v", kind, err)
+		return
+	}
+
+	for delivery := range deliveries {
+		var report T
+		if err := json.Unmarshal(delivery.Body, &report); err != nil {
+			log.Printf("Failed to unmarshal %s: %v", kind, err)
+			continue
+		}
+
+		update(report)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"microservices-demo/shared"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrJobNotFound is returned by a JobStore when no job exists for an ID
+var ErrJobNotFound = errors.New("job not found")
+
+// JobStore persists research jobs for the API server
+type JobStore interface {
+	// Create stores a new job
+	Create(job *shared.Job) error
+	// Get returns a copy of the job with the given ID
+	Get(id string) (*shared.Job, error)
+	// List returns copies of all jobs, newest first
+	List() ([]*shared.Job, error)
+	// Update atomically applies mutate to the stored job and returns the result.
+	// If mutate returns an error the job is left unchanged.
+	Update(id string, mutate func(job *shared.Job) error) (*shared.Job, error)
+	// Delete removes the job with the given ID
+	Delete(id string) error
+	// Close releases any resources held by the store
+	Close() error
+}
+
+// newJobStoreFromEnv creates the job store selected by JOB_STORE
+func newJobStoreFromEnv() (JobStore, error) {
+	switch backend := getEnvOrDefault("JOB_STORE", "memory"); backend {
+	case "memory":
+		return NewMemoryJobStore(), nil
+	case "bolt":
+		return NewBoltJobStore(getEnvOrDefault("JOB_STORE_PATH", "data/jobs.db"))
+	default:
+		return nil, fmt.Errorf("unknown JOB_STORE backend: %s", backend)
+	}
+}
+
+// copyJob returns a deep copy of a job so callers cannot mutate stored state
+func copyJob(job *shared.Job) *shared.Job {
+	data, err := json.Marshal(job)
+	if err != nil {
+		clone := *job
+		return &clone
+	}
+	var clone shared.Job
+	if err := json.Unmarshal(data, &clone); err != nil {
+		clone = *job
+	}
+	return &clone
+}
+
+// sortJobsNewestFirst sorts jobs by CreatedAt in descending order
+func sortJobsNewestFirst(jobs []*shared.Job) {
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+}
+
+// MemoryJobStore keeps jobs in process memory; jobs are lost on restart
+type MemoryJobStore struct {
+	jobs map[string]*shared.Job
+	mu   sync.RWMutex
+}
+
+// NewMemoryJobStore creates an empty in-memory job store
+func NewMemoryJobStore() *MemoryJobStore {
+	return &MemoryJobStore{
+		jobs: make(map[string]*shared.Job),
+	}
+}
+
+func (m *MemoryJobStore) Create(job *shared.Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.jobs[job.ID]; exists {
+		return fmt.Errorf("job %s already exists", job.ID)
+	}
+	m.jobs[job.ID] = copyJob(job)
+	return nil
+}
+
+func (m *MemoryJobStore) Get(id string) (*shared.Job, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	job, exists := m.jobs[id]
+	if !exists {
+		return nil, ErrJobNotFound
+	}
+	return copyJob(job), nil
+}
+
+func (m *MemoryJobStore) List() ([]*shared.Job, error) {
+	m.mu.RLock()
+	jobs := make([]*shared.Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, copyJob(job))
+	}
+	m.mu.RUnlock()
+
+	sortJobsNewestFirst(jobs)
+	return jobs, nil
+}
+
+func (m *MemoryJobStore) Update(id string, mutate func(job *shared.Job) error) (*shared.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, exists := m.jobs[id]
+	if !exists {
+		return nil, ErrJobNotFound
+	}
+
+	updated := copyJob(job)
+	if err := mutate(updated); err != nil {
+		return nil, err
+	}
+	m.jobs[id] = updated
+	return copyJob(updated), nil
+}
+
+func (m *MemoryJobStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.jobs[id]; !exists {
+		return ErrJobNotFound
+	}
+	delete(m.jobs, id)
+	return nil
+}
+
+func (m *MemoryJobStore) Close() error {
+	return nil
+}
+
+var (
+	boltJobsBucket = []byte("jobs")
+	boltMetaBucket = []byte("meta")
+	boltSchemaKey  = []byte("schema_version")
+)
+
+// boltMigrations upgrade the on-disk schema. Entry i migrates version i to i+1;
+// append new migrations to the end and never edit existing ones.
+var boltMigrations = []func(tx *bolt.Tx) error{
+	// v1: jobs bucket holding JSON-encoded shared.Job values keyed by ID
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltJobsBucket)
+		return err
+	},
+}
+
+// BoltJobStore persists jobs in an embedded BoltDB file
+type BoltJobStore struct {
+	db *bolt.DB
+}
+
+// NewBoltJobStore opens (or creates) the database at path and applies any
+// pending schema migrations
+func NewBoltJobStore(path string) (*BoltJobStore, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create job store directory: %w", err)
+		}
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open job store: %w", err)
+	}
+
+	store := &BoltJobStore{db: db}
+	if err := store.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// migrate applies all migrations newer than the stored schema version
+func (b *BoltJobStore) migrate() error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(boltMetaBucket)
+		if err != nil {
+			return err
+		}
+
+		version := 0
+		if raw := meta.Get(boltSchemaKey); raw != nil {
+			if _, err := fmt.Sscanf(string(raw), "%d", &version); err != nil {
+				return fmt.Errorf("invalid job store schema version %q: %w", raw, err)
+			}
+		}
+
+		if version > len(boltMigrations) {
+			return fmt.Errorf("job store schema version %d is newer than supported version %d", version, len(boltMigrations))
+		}
+
+		for i := version; i < len(boltMigrations); i++ {
+			if err := boltMigrations[i](tx); err != nil {
+				return fmt.Errorf("job store migration to v%d failed: %w", i+1, err)
+			}
+		}
+
+		return meta.Put(boltSchemaKey, []byte(fmt.Sprintf("%d", len(boltMigrations))))
+	})
+}
+
+func (b *BoltJobStore) Create(job *shared.Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltJobsBucket)
+		if bucket.Get([]byte(job.ID)) != nil {
+			return fmt.Errorf("job %s already exists", job.ID)
+		}
+		return bucket.Put([]byte(job.ID), data)
+	})
+}
+
+func (b *BoltJobStore) Get(id string) (*shared.Job, error) {
+	var job shared.Job
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltJobsBucket).Get([]byte(id))
+		if data == nil {
+			return ErrJobNotFound
+		}
+		return json.Unmarshal(data, &job)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (b *BoltJobStore) List() ([]*shared.Job, error) {
+	var jobs []*shared.Job
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltJobsBucket).ForEach(func(_, data []byte) error {
+			var job shared.Job
+			if err := json.Unmarshal(data, &job); err != nil {
+				return err
+			}
+			jobs = append(jobs, &job)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortJobsNewestFirst(jobs)
+	return jobs, nil
+}
+
+func (b *BoltJobStore) Update(id string, mutate func(job *shared.Job) error) (*shared.Job, error) {
+	var job shared.Job
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltJobsBucket)
+		data := bucket.Get([]byte(id))
+		if data == nil {
+			return ErrJobNotFound
+		}
+		if err := json.Unmarshal(data, &job); err != nil {
+			return err
+		}
+		if err := mutate(&job); err != nil {
+			return err
+		}
+		updated, err := json.Marshal(&job)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(id), updated)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (b *BoltJobStore) Delete(id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltJobsBucket)
+		if bucket.Get([]byte(id)) == nil {
+			return ErrJobNotFound
+		}
+		return bucket.Delete([]byte(id))
+	})
+}
+
+func (b *BoltJobStore) Close() error {
+	return b.db.Close()
+}
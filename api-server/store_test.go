@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"microservices-demo/shared"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func jobStoresForTest(t *testing.T) map[string]JobStore {
+	boltStore, err := NewBoltJobStore(filepath.Join(t.TempDir(), "jobs.db"))
+	if err != nil {
+		t.Fatalf("Failed to open bolt job store: %v", err)
+	}
+	t.Cleanup(func() { boltStore.Close() })
+
+	return map[string]JobStore{
+		"memory": NewMemoryJobStore(),
+		"bolt":   boltStore,
+	}
+}
+
+func TestJobStoreCRUD(t *testing.T) {
+	for name, store := range jobStoresForTest(t) {
+		t.Run(name, func(t *testing.T) {
+			now := time.Now()
+			older := &shared.Job{ID: "job-1", Title: "Older", Status: shared.JobStatusPending, CreatedAt: now.Add(-time.Hour)}
+			newer := &shared.Job{ID: "job-2", Title: "Newer", Status: shared.JobStatusPending, CreatedAt: now}
+
+			for _, job := range []*shared.Job{older, newer} {
+				if err := store.Create(job); err != nil {
+					t.Fatalf("Create failed: %v", err)
+				}
+			}
+			if err := store.Create(older); err == nil {
+				t.Error("Expected error creating duplicate job")
+			}
+
+			job, err := store.Get("job-1")
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			if job.Title != "Older" {
+				t.Errorf("Expected title 'Older', got %s", job.Title)
+			}
+
+			jobs, err := store.List()
+			if err != nil {
+				t.Fatalf("List failed: %v", err)
+			}
+			if len(jobs) != 2 || jobs[0].ID != "job-2" {
+				t.Errorf("Expected newest job first, got %+v", jobs)
+			}
+
+			updated, err := store.Update("job-1", func(job *shared.Job) error {
+				job.Status = shared.JobStatusCompleted
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Update failed: %v", err)
+			}
+			if updated.Status != shared.JobStatusCompleted {
+				t.Errorf("Expected updated status completed, got %s", updated.Status)
+			}
+
+			abort := errors.New("abort")
+			if _, err := store.Update("job-1", func(job *shared.Job) error {
+				job.Status = shared.JobStatusFailed
+				return abort
+			}); !errors.Is(err, abort) {
+				t.Errorf("Expected mutate error to be returned, got %v", err)
+			}
+			if job, _ := store.Get("job-1"); job.Status != shared.JobStatusCompleted {
+				t.Errorf("Expected aborted update to leave job unchanged, got %s", job.Status)
+			}
+
+			if err := store.Delete("job-1"); err != nil {
+				t.Fatalf("Delete failed: %v", err)
+			}
+			if _, err := store.Get("job-1"); !errors.Is(err, ErrJobNotFound) {
+				t.Errorf("Expected ErrJobNotFound after delete, got %v", err)
+			}
+			if _, err := store.Update("missing", func(*shared.Job) error { return nil }); !errors.Is(err, ErrJobNotFound) {
+				t.Errorf("Expected ErrJobNotFound updating missing job, got %v", err)
+			}
+		})
+	}
+}
+
+func TestBoltJobStorePersistsAcrossReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.db")
+
+	store, err := NewBoltJobStore(path)
+	if err != nil {
+		t.Fatalf("Failed to open bolt job store: %v", err)
+	}
+	if err := store.Create(&shared.Job{ID: "persisted", Title: "Persisted", CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	store.Close()
+
+	store, err = NewBoltJobStore(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen bolt job store: %v", err)
+	}
+	defer store.Close()
+
+	job, err := store.Get("persisted")
+	if err != nil {
+		t.Fatalf("Expected job to survive reopen: %v", err)
+	}
+	if job.Title != "Persisted" {
+		t.Errorf("Expected title 'Persisted', got %s", job.Title)
+	}
+
+	err = store.db.View(func(tx *bolt.Tx) error {
+		expected := fmt.Sprintf("%d", len(boltMigrations))
+		if version := string(tx.Bucket(boltMetaBucket).Get(boltSchemaKey)); version != expected {
+			t.Errorf("Expected schema version %s, got %s", expected, version)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
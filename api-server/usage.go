@@ -0,0 +1,175 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"microservices-demo/shared"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultUsageDays = 30
+	maxUsageDays     = 365
+)
+
+// usageTotals sums the token accounting of a group of jobs. The token counts
+// are those reported by the inference server; tokens of model calls it did
+// not report were estimated from word counts and are summed separately.
+type usageTotals struct {
+	Jobs             int     `json:"jobs"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	TokensPerSecond  float64 `json:"tokens_per_second"`
+	EstimatedTokens  int     `json:"estimated_tokens"`
+	// timedTokens and generationSeconds are the completion tokens of the
+	// jobs with a known speed and the time spent generating them, recovered
+	// from that speed, so the speed of a group is weighted by tokens
+	timedTokens       int
+	generationSeconds float64
+}
+
+func (t *usageTotals) add(job *shared.Job) {
+	promptTokens := job.PromptTokens - job.EstimatedPromptTokens
+	completionTokens := job.CompletionTokens - job.EstimatedCompletionTokens
+
+	t.Jobs++
+	t.PromptTokens += promptTokens
+	t.CompletionTokens += completionTokens
+	t.TotalTokens += promptTokens + completionTokens
+	t.EstimatedTokens += job.EstimatedPromptTokens + job.EstimatedCompletionTokens
+	if job.TokensPerSecond > 0 && completionTokens > 0 {
+		t.timedTokens += completionTokens
+		t.generationSeconds += float64(completionTokens) / job.TokensPerSecond
+	}
+	if t.generationSeconds > 0 {
+		t.TokensPerSecond = float64(t.timedTokens) / t.generationSeconds
+	}
+}
+
+// modelUsage is the usage of one model
+type modelUsage struct {
+	Model string `json:"model"`
+	usageTotals
+}
+
+// dayUsage is the usage of one UTC day, broken down by model
+type dayUsage struct {
+	Date string `json:"date"`
+	usageTotals
+	Models []modelUsage `json:"models"`
+}
+
+// usageReport is the response of GET /api/usage
+type usageReport struct {
+	Since   time.Time    `json:"since"`
+	Days    int          `json:"days"`
+	Total   usageTotals  `json:"total"`
+	ByModel []modelUsage `json:"by_model"`
+	ByDay   []dayUsage   `json:"by_day"`
+}
+
+// usageModel names the model a job ran on. Jobs finished before runners
+// reported the model fall back to the one requested.
+func usageModel(job *shared.Job) string {
+	switch {
+	case job.ModelUsed != "":
+		return job.ModelUsed
+	case job.Model != "":
+		return job.Model
+	default:
+		return "unknown"
+	}
+}
+
+// groupByModel sums jobs per model, sorted by model name
+func groupByModel(jobs []*shared.Job) []modelUsage {
+	totals := make(map[string]*usageTotals)
+	for _, job := range jobs {
+		model := usageModel(job)
+		if totals[model] == nil {
+			totals[model] = &usageTotals{}
+		}
+		totals[model].add(job)
+	}
+
+	usage := make([]modelUsage, 0, len(totals))
+	for model, total := range totals {
+		usage = append(usage, modelUsage{Model: model, usageTotals: *total})
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		return usage[i].Model < usage[j].Model
+	})
+	return usage
+}
+
+// aggregateUsage sums the tokens of the jobs that finished since the given
+// time, including failed and cancelled jobs. Older jobs only carry a word
+// count estimate and are not included.
+func aggregateUsage(jobs []*shared.Job, since time.Time) (usageTotals, []modelUsage, []dayUsage) {
+	var total usageTotals
+	var counted []*shared.Job
+	days := make(map[string][]*shared.Job)
+	for _, job := range jobs {
+		if job.CompletedAt == nil || job.CompletedAt.Before(since) {
+			continue
+		}
+		if job.PromptTokens == 0 && job.CompletionTokens == 0 {
+			continue
+		}
+
+		total.add(job)
+		counted = append(counted, job)
+		date := job.CompletedAt.UTC().Format("2006-01-02")
+		days[date] = append(days[date], job)
+	}
+
+	byDay := make([]dayUsage, 0, len(days))
+	for date, dayJobs := range days {
+		day := dayUsage{Date: date, Models: groupByModel(dayJobs)}
+		for _, job := range dayJobs {
+			day.add(job)
+		}
+		byDay = append(byDay, day)
+	}
+	sort.Slice(byDay, func(i, j int) bool {
+		return byDay[i].Date < byDay[j].Date
+	})
+
+	return total, groupByModel(counted), byDay
+}
+
+// getUsage reports the tokens used per model and per day over the last
+// ?days= days (default 30), counting today as the last day
+func (s *APIServer) getUsage(c *gin.Context) {
+	days := defaultUsageDays
+	if raw := c.Query("days"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > maxUsageDays {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "days must be an integer between 1 and 365"})
+			return
+		}
+		days = parsed
+	}
+
+	jobs, err := s.store.List()
+	if err != nil {
+		log.Printf("Failed to list research jobs: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list jobs"})
+		return
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	report := usageReport{
+		Since: today.AddDate(0, 0, 1-days),
+		Days:  days,
+	}
+	report.Total, report.ByModel, report.ByDay = aggregateUsage(jobs, report.Since)
+
+	c.JSON(http.StatusOK, report)
+}
@@ -3,12 +3,15 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"time"
 
 	"microservices-demo/shared"
@@ -33,9 +36,9 @@ func NewFrontend() *Frontend {
 	return &Frontend{}
 }
 
-func (f *Frontend) loadTemplates() error {
-	var err error
-	f.templates, err = template.New("").Funcs(template.FuncMap{
+// templateFuncs returns the helper functions available to all page templates
+func templateFuncs() template.FuncMap {
+	return template.FuncMap{
 		"formatTime": func(t time.Time) string {
 			return t.Format("2006-01-02 15:04:05")
 		},
@@ -56,10 +59,46 @@ func (f *Frontend) loadTemplates() error {
 				return "success"
 			case shared.JobStatusFailed:
 				return "danger"
+			case shared.JobStatusCancelled:
+				return "dark"
 			default:
 				return "secondary"
 			}
 		},
+		"traceStepColor": func(stepType shared.TraceStepType) string {
+			switch stepType {
+			case shared.TraceStepPlan:
+				return "primary"
+			case shared.TraceStepToolCall:
+				return "info"
+			case shared.TraceStepReport:
+				return "success"
+			default:
+				return "secondary"
+			}
+		},
+		"serviceStatusColor": func(status shared.ServiceStatus) string {
+			switch status {
+			case shared.ServiceStatusOK:
+				return "success"
+			case shared.ServiceStatusTimeout:
+				return "warning"
+			case shared.ServiceStatusError:
+				return "danger"
+			default:
+				return "secondary"
+			}
+		},
+		"toJSON": func(v interface{}) string {
+			data, err := json.MarshalIndent(v, "", "  ")
+			if err != nil {
+				return ""
+			}
+			return string(data)
+		},
+		"isTerminal": func(status shared.JobStatus) bool {
+			return status.IsTerminal()
+		},
 		"multiply": func(a, b float64) float64 {
 			return a * b
 		},
@@ -69,7 +108,17 @@ func (f *Frontend) loadTemplates() error {
 		"hasPrefix": func(s, prefix string) bool {
 			return len(s) >= len(prefix) && s[:len(prefix)] == prefix
 		},
-	}).ParseGlob("templates/*.html")
+		// isWebURL guards links to sources, which come from tool output
+		"isWebURL": func(s string) bool {
+			u, err := url.Parse(s)
+			return err == nil && (u.Scheme == "http" || u.Scheme == "https")
+		},
+	}
+}
+
+func (f *Frontend) loadTemplates() error {
+	var err error
+	f.templates, err = template.New("").Funcs(templateFuncs()).ParseGlob("templates/*.html")
 
 	if err != nil {
 		// If templates directory doesn't exist, create inline templates
@@ -80,54 +129,42 @@ func (f *Frontend) loadTemplates() error {
 }
 
 func (f *Frontend) createInlineTemplates() {
-	f.templates = template.Must(template.New("").Funcs(template.FuncMap{
-		"formatTime": func(t time.Time) string {
-			return t.Format("2006-01-02 15:04:05")
-		},
-		"formatDuration": func(start, end *time.Time) string {
-			if start == nil || end == nil {
-				return "N/A"
-			}
-			duration := end.Sub(*start)
-			return duration.String()
-		},
-		"statusColor": func(status shared.JobStatus) string {
-			switch status {
-			case shared.JobStatusPending:
-				return "warning"
-			case shared.JobStatusProcessing:
-				return "info"
-			case shared.JobStatusCompleted:
-				return "success"
-			case shared.JobStatusFailed:
-				return "danger"
-			default:
-				return "secondary"
-			}
-		},
-		"multiply": func(a, b float64) float64 {
-			return a * b
-		},
-		"add": func(a, b int) int {
-			return a + b
-		},
-		"hasPrefix": func(s, prefix string) bool {
-			return len(s) >= len(prefix) && s[:len(prefix)] == prefix
-		},
-	}).Parse(indexTemplate + researchStatusTemplate))
+	f.templates = template.Must(template.New("").Funcs(templateFuncs()).Parse(indexTemplate + researchStatusTemplate))
 }
 
 func (f *Frontend) homePage(c *gin.Context) {
-	// Get recent jobs
-	jobs, err := f.fetchJobs()
+	// Get one page of recent jobs; older pages are linked by cursor
+	query := url.Values{"limit": {strconv.Itoa(jobListPageSize)}}
+	if cursor := c.Query("cursor"); cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	page, err := f.fetchJobPage(query.Encode())
 	if err != nil {
 		log.Printf("Failed to fetch jobs: %v", err)
-		jobs = []shared.Job{} // Empty slice on error
+		page = &jobListResponse{Jobs: []shared.Job{}} // Empty page on error
+	}
+
+	services, err := f.fetchMCPServices()
+	if err != nil {
+		log.Printf("Failed to fetch MCP services: %v", err)
+	}
+
+	models, err := f.fetchModels()
+	if err != nil {
+		log.Printf("Failed to fetch models: %v", err)
+		models = &modelListResponse{}
 	}
 
 	data := gin.H{
-		"Title": "Microservices Demo",
-		"Jobs":  jobs,
+		"Title":             "Microservices Demo",
+		"Jobs":              page.Jobs,
+		"JobsTotal":         page.Total,
+		"NextCursor":        page.NextCursor,
+		"OlderPage":         query.Has("cursor"),
+		"MCPServices":       services,
+		"DefaultMCPService": defaultMCPService(services),
+		"Models":            models.Models,
+		"DefaultModel":      models.DefaultModel,
 	}
 
 	c.Header("Content-Type", "text/html")
@@ -161,6 +198,12 @@ func (f *Frontend) submitResearch(c *gin.Context) {
 			return
 		}
 
+		options, err := formModelOptions(c)
+		if err != nil {
+			c.Redirect(http.StatusSeeOther, "/?error="+url.QueryEscape(err.Error()))
+			return
+		}
+
 		// Convert string array to MCPService array
 		var services []shared.MCPService
 		for _, service := range mcpServices {
@@ -172,19 +215,23 @@ func (f *Frontend) submitResearch(c *gin.Context) {
 			Query:        query,
 			ResearchType: shared.ResearchType(researchType),
 			MCPServices:  services,
+			Model:        c.PostForm("model"),
+			Options:      options,
+			NoCache:      c.PostForm("no_cache") == "true",
 		}
 	}
 
 	job, err := f.createResearchJob(researchRequest)
 	if err != nil {
 		log.Printf("Failed to create research job: %v", err)
+		status, message := createJobError(err)
 		if c.GetHeader("Accept") == "application/json" || c.GetHeader("Content-Type") == "application/json" {
-			c.JSON(http.StatusInternalServerError, gin.H{
+			c.JSON(status, gin.H{
 				"success": false,
-				"error":   "Failed to start research",
+				"error":   message,
 			})
 		} else {
-			c.Redirect(http.StatusSeeOther, "/?error=Failed to start research")
+			c.Redirect(http.StatusSeeOther, "/?error="+url.QueryEscape(message))
 		}
 		return
 	}
@@ -213,9 +260,15 @@ func (f *Frontend) researchStatus(c *gin.Context) {
 		return
 	}
 
+	attempts, err := f.fetchJobAttempts(jobID)
+	if err != nil {
+		log.Printf("Failed to fetch attempts for research %s: %v", jobID, err)
+	}
+
 	data := gin.H{
-		"Title": fmt.Sprintf("Research Status - %s", job.Title),
-		"Job":   job,
+		"Title":    fmt.Sprintf("Research Status - %s", job.Title),
+		"Job":      job,
+		"Attempts": attempts,
 	}
 
 	c.Header("Content-Type", "text/html")
@@ -269,15 +322,22 @@ func (f *Frontend) createResearchJob(researchRequest shared.ResearchRequest) (*s
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusCreated {
-		return nil, fmt.Errorf("API returned status %d", resp.StatusCode)
-	}
-
 	responseBody, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
 	}
 
+	if resp.StatusCode != http.StatusCreated {
+		apiErr := &apiError{StatusCode: resp.StatusCode}
+		var response struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(responseBody, &response) == nil {
+			apiErr.Message = response.Error
+		}
+		return nil, apiErr
+	}
+
 	var job shared.Job
 	if err := json.Unmarshal(responseBody, &job); err != nil {
 		return nil, err
@@ -286,6 +346,30 @@ func (f *Frontend) createResearchJob(researchRequest shared.ResearchRequest) (*s
 	return &job, nil
 }
 
+// apiError is an error response from the API server
+type apiError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *apiError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("API returned status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("API returned status %d: %s", e.StatusCode, e.Message)
+}
+
+// createJobError maps a failure to create a job to the response shown to the
+// user. Requests the API server rejected, such as an unknown model, keep its
+// explanation; anything else is reported as a generic failure.
+func createJobError(err error) (int, string) {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusBadRequest && apiErr.Message != "" {
+		return http.StatusBadRequest, apiErr.Message
+	}
+	return http.StatusInternalServerError, "Failed to start research"
+}
+
 func (f *Frontend) fetchJob(jobID string) (*shared.Job, error) {
 	resp, err := http.Get(fmt.Sprintf("%s/api/jobs/%s", apiServerURL, jobID))
 	if err != nil {
@@ -310,8 +394,9 @@ func (f *Frontend) fetchJob(jobID string) (*shared.Job, error) {
 	return &job, nil
 }
 
-func (f *Frontend) fetchJobs() ([]shared.Job, error) {
-	resp, err := http.Get(apiServerURL + "/api/jobs")
+// fetchJobAttempts returns every attempt of the research jobID belongs to
+func (f *Frontend) fetchJobAttempts(jobID string) ([]shared.Job, error) {
+	resp, err := http.Get(fmt.Sprintf("%s/api/jobs/%s/attempts", apiServerURL, jobID))
 	if err != nil {
 		return nil, err
 	}
@@ -321,23 +406,157 @@ func (f *Frontend) fetchJobs() ([]shared.Job, error) {
 		return nil, fmt.Errorf("API returned status %d", resp.StatusCode)
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	var response struct {
+		Attempts []shared.Job `json:"attempts"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+
+	return response.Attempts, nil
+}
+
+// fetchMCPServices lists the MCP services the job runners advertise
+func (f *Frontend) fetchMCPServices() ([]shared.MCPServiceInfo, error) {
+	resp, err := http.Get(apiServerURL + "/api/mcp/services")
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API returned status %d", resp.StatusCode)
+	}
 
 	var response struct {
-		Jobs []shared.Job `json:"jobs"`
+		Services []shared.MCPServiceInfo `json:"services"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+
+	return response.Services, nil
+}
+
+// modelListResponse mirrors the API server's model catalog
+type modelListResponse struct {
+	Models       []shared.ModelInfo `json:"models"`
+	DefaultModel string             `json:"default_model"`
+}
+
+// fetchModels lists the models the job runners advertise
+func (f *Frontend) fetchModels() (*modelListResponse, error) {
+	resp, err := http.Get(apiServerURL + "/api/models")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API returned status %d", resp.StatusCode)
+	}
+
+	var response modelListResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+// formModelOptions reads the optional model settings of the research form.
+// Fields left empty keep the model's defaults.
+func formModelOptions(c *gin.Context) (*shared.ModelOptions, error) {
+	var options shared.ModelOptions
+	set := false
+
+	if value := c.PostForm("temperature"); value != "" {
+		temperature, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid temperature %q", value)
+		}
+		options.Temperature = &temperature
+		set = true
+	}
+	for name, field := range map[string]*int{"num_ctx": &options.NumCtx, "max_tokens": &options.MaxTokens} {
+		if value := c.PostForm(name); value != "" {
+			number, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %q", name, value)
+			}
+			*field = number
+			set = true
+		}
+	}
+
+	if !set {
+		return nil, nil
+	}
+	return &options, nil
+}
+
+// defaultMCPService picks the service selected by default: web search if it
+// is available, otherwise the first available service
+func defaultMCPService(services []shared.MCPServiceInfo) shared.MCPService {
+	var first shared.MCPService
+	for _, service := range services {
+		if !service.Available {
+			continue
+		}
+		if service.Name == shared.MCPServiceWeb {
+			return service.Name
+		}
+		if first == "" {
+			first = service.Name
+		}
+	}
+	return first
+}
+
+// jobListPageSize is how many jobs the home page shows at once
+const jobListPageSize = 50
+
+// jobListResponse mirrors the paginated response of the API server's job list
+type jobListResponse struct {
+	Jobs       []shared.Job `json:"jobs"`
+	Total      int          `json:"total"`
+	Limit      int          `json:"limit"`
+	Offset     int          `json:"offset"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
+// fetchJobPage lists jobs, forwarding rawQuery (filters, sorting, pagination) to the API server
+func (f *Frontend) fetchJobPage(rawQuery string) (*jobListResponse, error) {
+	listURL := apiServerURL + "/api/jobs"
+	if rawQuery != "" {
+		listURL += "?" + rawQuery
+	}
+
+	resp, err := http.Get(listURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API returned status %d", resp.StatusCode)
 	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var response jobListResponse
 	if err := json.Unmarshal(body, &response); err != nil {
 		return nil, err
 	}
 
-	return response.Jobs, nil
+	return &response, nil
 }
 
 func (f *Frontend) apiJobs(c *gin.Context) {
-	jobs, err := f.fetchJobs()
+	page, err := f.fetchJobPage(c.Request.URL.RawQuery)
 	if err != nil {
 		log.Printf("Failed to fetch jobs: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -346,9 +565,7 @@ func (f *Frontend) apiJobs(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"jobs": jobs,
-	})
+	c.JSON(http.StatusOK, page)
 }
 
 func (f *Frontend) apiGetJob(c *gin.Context) {
@@ -366,6 +583,82 @@ func (f *Frontend) apiGetJob(c *gin.Context) {
 	c.JSON(http.StatusOK, job)
 }
 
+// proxyJobAction forwards a POST action such as /api/jobs/:id/cancel to the
+// API server and relays its response
+func (f *Frontend) proxyJobAction(action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actionURL := fmt.Sprintf("%s/api/jobs/%s/%s", apiServerURL, c.Param("id"), action)
+
+		resp, err := http.Post(actionURL, "application/json", c.Request.Body)
+		if err != nil {
+			log.Printf("Failed to %s job %s: %v", action, c.Param("id"), err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "API server unavailable",
+			})
+			return
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		c.Data(resp.StatusCode, "application/json", body)
+	}
+}
+
+// proxyEventStream relays a Server-Sent Events stream from the API server,
+// flushing each chunk to the browser as soon as it arrives
+func (f *Frontend) proxyEventStream(c *gin.Context) {
+	streamURL := apiServerURL + c.Request.URL.Path
+
+	req, err := http.NewRequestWithContext(c.Request.Context(), "GET", streamURL, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("Failed to open event stream %s: %v", c.Request.URL.Path, err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "API server unavailable"})
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		c.Data(resp.StatusCode, "application/json", body)
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, writeErr := c.Writer.Write(buf[:n]); writeErr != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
 func (f *Frontend) submitResearchAPI(c *gin.Context) {
 	var researchRequest shared.ResearchRequest
 	if err := c.ShouldBindJSON(&researchRequest); err != nil {
@@ -385,8 +678,9 @@ func (f *Frontend) submitResearchAPI(c *gin.Context) {
 	job, err := f.createResearchJob(researchRequest)
 	if err != nil {
 		log.Printf("Failed to create research job: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to start research",
+		status, message := createJobError(err)
+		c.JSON(status, gin.H{
+			"error": message,
 		})
 		return
 	}
@@ -406,8 +700,12 @@ func (f *Frontend) setupRoutes() *gin.Engine {
 	r.GET("/status/:id", f.researchStatus)
 	r.GET("/api/status", f.apiStatus)
 	r.GET("/api/jobs", f.apiJobs)
+	r.GET("/api/jobs/events", f.proxyEventStream)
 	r.GET("/api/jobs/:id", f.apiGetJob)
+	r.GET("/api/jobs/:id/events", f.proxyEventStream)
 	r.POST("/api/jobs", f.submitResearchAPI)
+	r.POST("/api/jobs/:id/cancel", f.proxyJobAction("cancel"))
+	r.POST("/api/jobs/:id/retry", f.proxyJobAction("retry"))
 
 	// Static files (if needed)
 	r.Static("/static", "./static")
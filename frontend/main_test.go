@@ -6,6 +6,9 @@ import (
 	"net/url"
 	"strings"
 	"testing"
+	"time"
+
+	"microservices-demo/shared"
 
 	"github.com/gin-gonic/gin"
 )
@@ -117,3 +120,337 @@ func TestFormatTimeFunction(t *testing.T) {
 		t.Error("Expected research-status template to exist")
 	}
 }
+
+func TestIsWebURL(t *testing.T) {
+	isWebURL := templateFuncs()["isWebURL"].(func(string) bool)
+
+	expected := map[string]bool{
+		"https://go.dev/doc":      true,
+		"http://example.com":      true,
+		"javascript:alert(1)":     false,
+		"JavaScript:alert(1)":     false,
+		"data:text/html,<b>x</b>": false,
+		"go.dev/doc":              false,
+		"":                        false,
+	}
+	for value, want := range expected {
+		if got := isWebURL(value); got != want {
+			t.Errorf("isWebURL(%q) = %v, expected %v", value, got, want)
+		}
+	}
+}
+
+func TestStatusColor(t *testing.T) {
+	statusColor := templateFuncs()["statusColor"].(func(shared.JobStatus) string)
+
+	expected := map[shared.JobStatus]string{
+		shared.JobStatusPending:    "warning",
+		shared.JobStatusProcessing: "info",
+		shared.JobStatusCompleted:  "success",
+		shared.JobStatusFailed:     "danger",
+		shared.JobStatusCancelled:  "dark",
+	}
+
+	for status, color := range expected {
+		if got := statusColor(status); got != color {
+			t.Errorf("Expected color %s for %s, got %s", color, status, got)
+		}
+	}
+}
+
+func TestResearchStatusTemplate(t *testing.T) {
+	frontend := NewFrontend()
+	frontend.createInlineTemplates()
+
+	job := shared.Job{
+		ID:        "attempt-2",
+		Title:     "Retried research",
+		Status:    shared.JobStatusFailed,
+		Error:     "Failed to analyze with AI",
+		Attempt:   2,
+		CreatedAt: time.Now(),
+	}
+	attempts := []shared.Job{
+		{ID: "attempt-1", Status: shared.JobStatusFailed, Attempt: 1, CreatedAt: time.Now()},
+		job,
+	}
+
+	var out strings.Builder
+	err := frontend.templates.ExecuteTemplate(&out, "research-status", gin.H{
+		"Title":    "Research Status",
+		"Job":      &job,
+		"Attempts": attempts,
+	})
+	if err != nil {
+		t.Fatalf("Template execution error: %v", err)
+	}
+
+	body := out.String()
+	for _, expected := range []string{"Retry Research", "Attempt History (2)", "/status/attempt-1"} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected status page to contain %q", expected)
+		}
+	}
+	if strings.Contains(body, `id="cancelBtn"`) {
+		t.Error("Expected no cancel button for a finished job")
+	}
+	if strings.Contains(body, "Simulated data") {
+		t.Error("Expected no simulated data warning for a job without simulated data")
+	}
+}
+
+func TestResearchStatusTemplateTokens(t *testing.T) {
+	frontend := NewFrontend()
+	frontend.createInlineTemplates()
+
+	job := shared.Job{
+		ID:               "tokens",
+		Status:           shared.JobStatusCompleted,
+		TokensUsed:       1250,
+		PromptTokens:     1000,
+		CompletionTokens: 250,
+		TokensPerSecond:  42.46,
+		ModelUsed:        "llama3.2",
+		PromptTemplate:   "market",
+		PromptVersion:    2,
+		CreatedAt:        time.Now(),
+	}
+
+	var out strings.Builder
+	err := frontend.templates.ExecuteTemplate(&out, "research-status", gin.H{
+		"Title":    "Research Status",
+		"Job":      &job,
+		"Attempts": []shared.Job{job},
+	})
+	if err != nil {
+		t.Fatalf("Template execution error: %v", err)
+	}
+
+	for _, expected := range []string{"1000 prompt • 250 completion • 42.5 tokens/s • llama3.2", "Prompt: market v2"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected status page to contain %q", expected)
+		}
+	}
+}
+
+func TestResearchStatusTemplateTrace(t *testing.T) {
+	frontend := NewFrontend()
+	frontend.createInlineTemplates()
+
+	job := shared.Job{
+		ID:        "traced",
+		Title:     "Traced research",
+		Status:    shared.JobStatusProcessing,
+		CreatedAt: time.Now(),
+		Trace: []shared.TraceStep{
+			{Step: 1, Type: shared.TraceStepPlan, Content: "Search the web first"},
+			{Step: 2, Type: shared.TraceStepToolCall, Service: shared.MCPServiceWeb, Tool: "web_search",
+				Arguments: map[string]interface{}{"q": "go generics"}, Result: "Results for go generics", Cached: true},
+			{Step: 3, Type: shared.TraceStepToolCall, Service: shared.MCPServiceWeb, Tool: "fetch", Error: "timeout"},
+		},
+	}
+
+	var out strings.Builder
+	err := frontend.templates.ExecuteTemplate(&out, "research-status", gin.H{
+		"Title":    "Research Status",
+		"Job":      &job,
+		"Attempts": []shared.Job{job},
+	})
+	if err != nil {
+		t.Fatalf("Template execution error: %v", err)
+	}
+
+	body := out.String()
+	for _, expected := range []string{
+		`<span id="trace-count">3</span>`,
+		"Search the web first",
+		"<code>web/web_search</code>",
+		`<span class="badge bg-info" title="Answered from the MCP result cache">cached</span>`,
+		"&#34;q&#34;: &#34;go generics&#34;",
+		"Results for go generics",
+		`<div class="text-danger small">timeout</div>`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected status page to contain %q", expected)
+		}
+	}
+}
+
+func TestResearchStatusTemplateReferences(t *testing.T) {
+	frontend := NewFrontend()
+	frontend.createInlineTemplates()
+
+	job := shared.Job{
+		ID:     "cited",
+		Status: shared.JobStatusCompleted,
+		Result: "Routing got faster [1] [7].",
+		References: []shared.Reference{
+			{Number: 1, Service: shared.MCPServiceWeb, Tool: "web_search", URL: "https://go.dev/blog", Cited: true},
+			{Number: 2, Service: shared.MCPServiceFiles, Tool: "read_file", Provenance: shared.ProvenanceSimulated},
+		},
+		InvalidCitations: []int{7},
+		ServiceOutcomes: []shared.ServiceOutcome{
+			{Service: shared.MCPServiceWeb, Status: shared.ServiceStatusOK, Calls: 2, CacheHits: 1, LatencyMs: 420, Bytes: 2048},
+			{Service: shared.MCPServiceGitHub, Status: shared.ServiceStatusTimeout, Calls: 2, LatencyMs: 60000, Error: "no answer within 30s"},
+			{Service: shared.MCPServiceFiles, Status: shared.ServiceStatusError, Calls: 1, LatencyMs: 3, Error: "connection refused", Provenance: shared.ProvenanceSimulated},
+		},
+		Provenance: shared.ProvenanceSimulated,
+		CreatedAt:  time.Now(),
+	}
+
+	var out strings.Builder
+	err := frontend.templates.ExecuteTemplate(&out, "research-status", gin.H{
+		"Title":    "Research Status",
+		"Job":      &job,
+		"Attempts": []shared.Job{job},
+	})
+	if err != nil {
+		t.Fatalf("Template execution error: %v", err)
+	}
+
+	body := out.String()
+	for _, expected := range []string{
+		"Sources (2)",
+		`<div class="mb-2 source-reference" id="source-1">`,
+		`<a href="https://go.dev/blog" target="_blank" rel="noopener" class="text-decoration-none">https://go.dev/blog</a>`,
+		`<div class="mb-2 source-reference text-muted" id="source-2">`,
+		"<code>read_file (files)</code>",
+		"The report cites [7], which match none of the gathered sources.",
+		`const jobReferences = [{"number":1,`,
+		`<span class="badge bg-success">ok</span>`,
+		"2 calls (1 cached) • 420 ms • 2048 bytes",
+		"1 call • 3 ms",
+		`<span class="badge bg-warning">timeout</span>`,
+		`<div class="text-danger small">no answer within 30s</div>`,
+		`<span class="badge bg-danger fs-6" title="Some of the data this research is based on was simulated">Simulated data</span>`,
+		`not on real sources <span class="badge bg-danger">files</span>. Do not rely on its findings.`,
+		`<span class="badge bg-danger">simulated data</span>`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected status page to contain %q", expected)
+		}
+	}
+}
+
+func TestIndexTemplateMCPServices(t *testing.T) {
+	frontend := NewFrontend()
+	frontend.createInlineTemplates()
+
+	services := []shared.MCPServiceInfo{
+		{Name: shared.MCPServiceGitHub, Title: "GitHub", Available: true},
+		{Name: shared.MCPServiceWeb, Title: "Web Search", Available: true, Tools: []shared.MCPToolInfo{{Name: "web_search"}}},
+		{Name: "jira", Title: "Jira", Error: "connection refused"},
+	}
+
+	var out strings.Builder
+	err := frontend.templates.ExecuteTemplate(&out, "index", gin.H{
+		"Title":             "Microservices Demo",
+		"MCPServices":       services,
+		"DefaultMCPService": defaultMCPService(services),
+		"Jobs":              []shared.Job{{ID: "simulated", Title: "Simulated research", Status: shared.JobStatusCompleted, Provenance: shared.ProvenanceSimulated}},
+	})
+	if err != nil {
+		t.Fatalf("Template execution error: %v", err)
+	}
+
+	body := out.String()
+	for _, expected := range []string{
+		`value="github">`,
+		`value="web" checked data-default="true">`,
+		`value="jira" disabled>`,
+		"(tools: web_search)",
+		"Unavailable: connection refused",
+		`<span class="badge bg-danger status-badge" title="Based on simulated data">simulated</span>`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected index page to contain %q", expected)
+		}
+	}
+}
+
+func TestDefaultMCPService(t *testing.T) {
+	services := []shared.MCPServiceInfo{
+		{Name: shared.MCPServiceWeb},
+		{Name: shared.MCPServiceGitHub, Available: true},
+	}
+	if got := defaultMCPService(services); got != shared.MCPServiceGitHub {
+		t.Errorf("Expected github when web is unavailable, got %q", got)
+	}
+	if got := defaultMCPService(nil); got != "" {
+		t.Errorf("Expected no default without services, got %q", got)
+	}
+}
+
+func TestIndexTemplateModels(t *testing.T) {
+	frontend := NewFrontend()
+	frontend.createInlineTemplates()
+
+	var out strings.Builder
+	err := frontend.templates.ExecuteTemplate(&out, "index", gin.H{
+		"Title":        "Microservices Demo",
+		"Models":       []shared.ModelInfo{{Name: "llama3.2:latest", ParameterSize: "3.2B"}, {Name: "qwen2.5:7b"}},
+		"DefaultModel": "llama3.2",
+	})
+	if err != nil {
+		t.Fatalf("Template execution error: %v", err)
+	}
+
+	body := out.String()
+	for _, expected := range []string{
+		`<option value="">Default (llama3.2)</option>`,
+		`<option value="llama3.2:latest">llama3.2:latest (3.2B)</option>`,
+		`<option value="qwen2.5:7b">qwen2.5:7b</option>`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected index page to contain %q", expected)
+		}
+	}
+}
+
+func TestCreateJobError(t *testing.T) {
+	status, message := createJobError(&apiError{StatusCode: http.StatusBadRequest, Message: `unknown model "mistral"`})
+	if status != http.StatusBadRequest || message != `unknown model "mistral"` {
+		t.Errorf("Expected the API server's explanation, got %d %q", status, message)
+	}
+
+	status, message = createJobError(&apiError{StatusCode: http.StatusServiceUnavailable})
+	if status != http.StatusInternalServerError || message != "Failed to start research" {
+		t.Errorf("Expected a generic failure, got %d %q", status, message)
+	}
+}
+
+func TestHomePageShowsOnePage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var queries []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/jobs" {
+			http.NotFound(w, r)
+			return
+		}
+		queries = append(queries, r.URL.RawQuery)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"jobs":[{"id":"job-3","title":"Third"},{"id":"job-2","title":"Second"}],"total":120,"next_cursor":"page-3"}`))
+	}))
+	defer api.Close()
+
+	previous := apiServerURL
+	apiServerURL = api.URL
+	defer func() { apiServerURL = previous }()
+
+	frontend := NewFrontend()
+	frontend.createInlineTemplates()
+	router := frontend.setupRoutes()
+
+	req, _ := http.NewRequest("GET", "/?cursor=page-2", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if len(queries) != 1 || queries[0] != "cursor=page-2&limit=50" {
+		t.Errorf("Expected one page to be requested, got %v", queries)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "Showing 2 of 120") || !strings.Contains(body, `href="/?cursor=page-3"`) || !strings.Contains(body, `href="/" class="btn`) {
+		t.Errorf("Expected the total and links to the newest and older pages, got %s", body)
+	}
+}
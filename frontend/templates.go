@@ -77,21 +77,45 @@ const indexTemplate = `
                             <div class="mb-3">
                                 <label class="form-label">MCP Services to Use</label>
                                 <div>
-                                    <div class="form-check form-check-inline">
-                                        <input class="form-check-input" type="checkbox" id="mcp_web" name="mcp_services" value="web" checked>
-                                        <label class="form-check-label" for="mcp_web">Web Search</label>
-                                    </div>
-                                    <div class="form-check form-check-inline">
-                                        <input class="form-check-input" type="checkbox" id="mcp_github" name="mcp_services" value="github">
-                                        <label class="form-check-label" for="mcp_github">GitHub</label>
-                                    </div>
-                                    <div class="form-check form-check-inline">
-                                        <input class="form-check-input" type="checkbox" id="mcp_files" name="mcp_services" value="files">
-                                        <label class="form-check-label" for="mcp_files">Local Files</label>
+                                    {{range .MCPServices}}
+                                    <div class="form-check form-check-inline" title="{{if .Available}}{{.Description}}{{range $i, $tool := .Tools}}{{if eq $i 0}} (tools: {{else}}, {{end}}{{$tool.Name}}{{end}}{{if .Tools}}){{end}}{{else}}Unavailable: {{.Error}}{{end}}">
+                                        <input class="form-check-input" type="checkbox" id="mcp_{{.Name}}" name="mcp_services" value="{{.Name}}"{{if not .Available}} disabled{{else if eq .Name $.DefaultMCPService}} checked data-default="true"{{end}}>
+                                        <label class="form-check-label{{if not .Available}} text-muted{{end}}" for="mcp_{{.Name}}">{{.Title}}</label>
                                     </div>
+                                    {{else}}
+                                    <span class="text-muted">No MCP services are currently advertised by the job runners.</span>
+                                    {{end}}
                                 </div>
                                 <small class="form-text text-muted">Select which MCP services to use for data gathering</small>
                             </div>
+                            <div class="mb-3">
+                                <label for="model" class="form-label">Model</label>
+                                <select class="form-select" id="model" name="model">
+                                    <option value="">Default{{if .DefaultModel}} ({{.DefaultModel}}){{end}}</option>
+                                    {{range .Models}}
+                                    <option value="{{.Name}}">{{.Name}}{{if .ParameterSize}} ({{.ParameterSize}}){{end}}</option>
+                                    {{end}}
+                                </select>
+                            </div>
+                            <div class="row mb-3">
+                                <div class="col">
+                                    <label for="temperature" class="form-label">Temperature</label>
+                                    <input type="number" class="form-control" id="temperature" name="temperature" min="0" max="2" step="0.1" placeholder="Model default">
+                                </div>
+                                <div class="col">
+                                    <label for="num_ctx" class="form-label">Context Window</label>
+                                    <input type="number" class="form-control" id="num_ctx" name="num_ctx" min="0" step="1024" placeholder="Model default">
+                                </div>
+                                <div class="col">
+                                    <label for="max_tokens" class="form-label">Max Tokens</label>
+                                    <input type="number" class="form-control" id="max_tokens" name="max_tokens" min="0" step="256" placeholder="Model default">
+                                </div>
+                            </div>
+                            <div class="form-check mb-3">
+                                <input class="form-check-input" type="checkbox" id="no_cache" name="no_cache" value="true">
+                                <label class="form-check-label" for="no_cache">Bypass the MCP result cache</label>
+                                <small class="form-text text-muted d-block">Query every service afresh instead of reusing recent results of the same query</small>
+                            </div>
                             <button type="submit" class="btn btn-primary" id="submitBtn">Start Research</button>
                         </form>
                         
@@ -142,6 +166,9 @@ const indexTemplate = `
                                     <span class="badge bg-{{statusColor .Status}} status-badge">
                                         {{.Status}}
                                     </span>
+                                    {{if eq .Provenance "simulated"}}
+                                    <span class="badge bg-danger status-badge" title="Based on simulated data">simulated</span>
+                                    {{end}}
                                 </div>
                                 <hr class="my-2">
                             </div>
@@ -150,6 +177,15 @@ const indexTemplate = `
                             <p class="text-muted">No research requests yet. Start your first research!</p>
                         {{end}}
                     </div>
+                    {{if or .NextCursor .OlderPage}}
+                    <div class="card-footer d-flex justify-content-between align-items-center">
+                        <small class="text-muted">Showing {{len .Jobs}} of {{.JobsTotal}}</small>
+                        <div>
+                            {{if .OlderPage}}<a href="/" class="btn btn-sm btn-outline-secondary">Newest</a>{{end}}
+                            {{if .NextCursor}}<a href="/?cursor={{.NextCursor}}" class="btn btn-sm btn-outline-secondary">Older</a>{{end}}
+                        </div>
+                    </div>
+                    {{end}}
                 </div>
             </div>
         </div>
@@ -177,13 +213,17 @@ const indexTemplate = `
     <script src="https://cdn.jsdelivr.net/npm/marked@4.3.0/marked.min.js"></script>
     <script>
         let researchRefreshInterval;
+        let currentResearch = [];
+        // Older pages only update the jobs they show
+        const firstResearchPage = !new URLSearchParams(window.location.search).has('cursor');
 
         // Function to refresh research list via AJAX
         function refreshResearch() {
-            fetch('/api/jobs')
+            fetch('/api/jobs' + window.location.search)
                 .then(response => response.json())
                 .then(data => {
                     if (data.jobs) {
+                        currentResearch = data.jobs;
                         updateResearchList(data.jobs);
                     }
                 })
@@ -246,6 +286,7 @@ const indexTemplate = `
                             '<span class="badge bg-' + statusColor + ' status-badge">' +
                                 item.status +
                             '</span>' +
+                            (item.provenance === 'simulated' ? '<span class="badge bg-danger status-badge" title="Based on simulated data">simulated</span>' : '') +
                         '</div>' +
                         '<hr class="my-2">' +
                     '</div>';
@@ -261,6 +302,7 @@ const indexTemplate = `
                 case 'processing': return 'info';
                 case 'completed': return 'success';
                 case 'failed': return 'danger';
+                case 'cancelled': return 'dark';
                 default: return 'secondary';
             }
         }
@@ -293,6 +335,15 @@ const indexTemplate = `
                 mcpServices.push(checkbox.value);
             });
             
+            // Only send the model settings that were filled in
+            const options = {};
+            const temperature = document.getElementById('temperature').value;
+            const numCtx = document.getElementById('num_ctx').value;
+            const maxTokens = document.getElementById('max_tokens').value;
+            if (temperature !== '') options.temperature = parseFloat(temperature);
+            if (numCtx !== '') options.num_ctx = parseInt(numCtx, 10);
+            if (maxTokens !== '') options.max_tokens = parseInt(maxTokens, 10);
+            
             // Disable button and show loading
             submitBtn.disabled = true;
             submitBtn.innerHTML = '<span class="spinner-border spinner-border-sm me-2"></span>Starting research...';
@@ -307,7 +358,10 @@ const indexTemplate = `
                     title: title,
                     query: query,
                     research_type: researchType,
-                    mcp_services: mcpServices
+                    mcp_services: mcpServices,
+                    model: document.getElementById('model').value,
+                    options: Object.keys(options).length > 0 ? options : undefined,
+                    no_cache: document.getElementById('no_cache').checked || undefined
                 })
             })
             .then(response => response.json())
@@ -319,8 +373,13 @@ const indexTemplate = `
                     document.getElementById('title').value = '';
                     document.getElementById('query').value = '';
                     document.getElementById('research_type').selectedIndex = 0;
+                    document.getElementById('model').selectedIndex = 0;
+                    ['temperature', 'num_ctx', 'max_tokens'].forEach(id => {
+                        document.getElementById(id).value = '';
+                    });
+                    document.getElementById('no_cache').checked = false;
                     document.querySelectorAll('input[name="mcp_services"]').forEach(checkbox => {
-                        checkbox.checked = checkbox.value === 'web'; // Reset to just web checked
+                        checkbox.checked = checkbox.dataset.default === 'true'; // Reset to the default service
                     });
                     
                     // Refresh research list immediately
@@ -352,30 +411,36 @@ const indexTemplate = `
                     '<strong>API Server:</strong> ' + data.status + '<br>' +
                     '<strong>RabbitMQ:</strong> ' + rabbitStatus + '<br>';
                 
-                // Add Ollama information
-                if (data.ollama) {
-                    statusHTML += '<strong>Ollama AI:</strong> ' + data.ollama.model + 
-                                 ' <small>(' + data.ollama.endpoint + ')</small><br>';
+                // Runner names and models are rendered as text
+                const escapeText = function(text) {
+                    const span = document.createElement('span');
+                    span.textContent = text;
+                    return span.innerHTML;
+                };
+
+                // Add the inference server the job runners report
+                if (data.llm && data.llm.updated_at) {
+                    statusHTML += '<strong>LLM:</strong> ' + escapeText(data.llm.model) +
+                                 ' <small>(' + escapeText(data.llm.provider) + ', ' + data.llm.models + ' models)</small><br>';
                 } else {
-                    statusHTML += '<strong>Ollama AI:</strong> Unknown<br>';
+                    statusHTML += '<strong>LLM:</strong> No job runner reporting<br>';
                 }
                 
                 // Add MCP information
-                if (data.mcp) {
+                if (data.mcp && data.mcp.runners > 0) {
                     if (data.mcp.test_mode) {
                         statusHTML += '<strong>MCP Services:</strong> Test Mode (Simulated)<br>';
                     } else {
                         statusHTML += '<strong>MCP Services:</strong> Production Mode<br>';
-                        if (data.mcp.endpoints) {
-                            statusHTML += '<div class="ms-3 small">';
-                            statusHTML += '• Web Search: ' + data.mcp.endpoints.web_search + '<br>';
-                            statusHTML += '• GitHub: ' + data.mcp.endpoints.github + '<br>';
-                            statusHTML += '• Files: ' + data.mcp.endpoints.files + '<br>';
-                            statusHTML += '</div>';
-                        }
+                        statusHTML += '<div class="ms-3 small">';
+                        data.mcp.services.forEach(function(service) {
+                            statusHTML += '• ' + escapeText(service.name) + ': ' + escapeText(service.breaker) +
+                                ', healthy on ' + service.healthy_runners + ' of ' + data.mcp.runners + ' runners<br>';
+                        });
+                        statusHTML += '</div>';
                     }
                 } else {
-                    statusHTML += '<strong>MCP Services:</strong> Unknown<br>';
+                    statusHTML += '<strong>MCP Services:</strong> No job runner reporting<br>';
                 }
                 
                 statusHTML += '<small>Last checked: ' + new Date(data.timestamp).toLocaleString() + '</small>' +
@@ -388,8 +453,47 @@ const indexTemplate = `
                     '<div class="text-warning">Unable to check AI agent status</div>';
             });
 
-        // Auto-refresh research list every 3 seconds
-        researchRefreshInterval = setInterval(refreshResearch, 3000);
+        // Fall back to refreshing the research list every 3 seconds
+        function startResearchPolling() {
+            if (!researchRefreshInterval) {
+                researchRefreshInterval = setInterval(refreshResearch, 3000);
+            }
+        }
+
+        // Merge a single job update from the event stream into the list
+        function applyResearchEvent(job) {
+            const index = currentResearch.findIndex(item => item.id === job.id);
+            if (index >= 0) {
+                currentResearch[index] = job;
+            } else if (firstResearchPage) {
+                currentResearch.unshift(job);
+            } else {
+                return;
+            }
+            currentResearch.sort((a, b) => new Date(b.created_at) - new Date(a.created_at));
+            currentResearch = currentResearch.slice(0, 50);
+            updateResearchList(currentResearch);
+        }
+
+        // Receive live updates via Server-Sent Events, polling only when SSE is unavailable
+        if (window.EventSource) {
+            const researchEvents = new EventSource('/api/jobs/events');
+            let researchStreamOpened = false;
+            researchEvents.onopen = function() {
+                researchStreamOpened = true;
+            };
+            researchEvents.addEventListener('job', function(event) {
+                applyResearchEvent(JSON.parse(event.data));
+            });
+            researchEvents.onerror = function() {
+                if (!researchStreamOpened || researchEvents.readyState === EventSource.CLOSED) {
+                    researchEvents.close();
+                    startResearchPolling();
+                }
+            };
+        } else {
+            startResearchPolling();
+        }
         
         // Initial load of research
         refreshResearch();
@@ -423,6 +527,30 @@ const researchStatusTemplate = `
             max-height: 200px;
             overflow-y: auto;
         }
+        .trace-list {
+            max-height: 500px;
+            overflow-y: auto;
+        }
+        .citation a {
+            text-decoration: none;
+        }
+        .citation-invalid {
+            color: #dc3545;
+            text-decoration: line-through;
+            cursor: help;
+        }
+        .source-reference:target {
+            background-color: #fff3cd;
+        }
+        .trace-detail {
+            background-color: #f8f9fa;
+            padding: 0.5rem;
+            border-radius: 0.25rem;
+            font-size: 0.8rem;
+            max-height: 150px;
+            overflow-y: auto;
+            white-space: pre-wrap;
+        }
         .research-result {
             line-height: 1.6;
         }
@@ -514,6 +642,21 @@ const researchStatusTemplate = `
                                 <span class="badge bg-{{statusColor .Job.Status}} fs-6">
                                     {{.Job.Status}}
                                 </span>
+                                {{if eq .Job.Provenance "simulated"}}
+                                <span class="badge bg-danger fs-6" title="Some of the data this research is based on was simulated">Simulated data</span>
+                                {{end}}
+                                {{if gt .Job.Attempt 1}}
+                                <span class="badge bg-light text-dark border">Attempt {{.Job.Attempt}}</span>
+                                {{end}}
+                                {{if not (isTerminal .Job.Status)}}
+                                <div class="mt-2">
+                                    <button type="button" class="btn btn-sm btn-outline-danger" id="cancelBtn">Cancel Research</button>
+                                </div>
+                                {{else}}
+                                <div class="mt-2">
+                                    <button type="button" class="btn btn-sm btn-outline-primary" id="retryBtn">Retry Research</button>
+                                </div>
+                                {{end}}
                                 {{if .Job.Confidence}}
                                 <div class="mt-2">
                                     <small class="text-muted">Confidence: {{printf "%.0f%%" (multiply .Job.Confidence 100)}}</small>
@@ -549,10 +692,16 @@ const researchStatusTemplate = `
                                 {{if .Job.TokensUsed}}
                                 <strong>Tokens Used:</strong><br>
                                 {{.Job.TokensUsed}}
+                                {{if or .Job.PromptTokens .Job.CompletionTokens}}
+                                <br><small class="text-muted">{{.Job.PromptTokens}} prompt • {{.Job.CompletionTokens}} completion{{with add .Job.EstimatedPromptTokens .Job.EstimatedCompletionTokens}} ({{.}} estimated){{end}}{{if .Job.TokensPerSecond}} • {{printf "%.1f" .Job.TokensPerSecond}} tokens/s{{end}}{{if .Job.ModelUsed}} • {{.Job.ModelUsed}}{{end}}</small>
+                                {{end}}
                                 {{else}}
                                 <strong>Research Type:</strong><br>
                                 {{.Job.ResearchType}}
                                 {{end}}
+                                {{if .Job.PromptTemplate}}
+                                <br><small class="text-muted" title="Prompt template">Prompt: {{.Job.PromptTemplate}} v{{.Job.PromptVersion}}</small>
+                                {{end}}
                             </div>
                         </div>
                         
@@ -563,22 +712,70 @@ const researchStatusTemplate = `
                                 <h6 class="mb-0">Research Results</h6>
                             </div>
                             <div class="card-body">
+                                {{if eq .Job.Provenance "simulated"}}
+                                <div class="alert alert-danger py-2" id="simulated-data-alert">
+                                    <strong>Simulated data.</strong>
+                                    This report is based in part on placeholder data, not on real sources{{range .Job.ServiceOutcomes}}{{if eq .Provenance "simulated"}} <span class="badge bg-danger">{{.Service}}</span>{{end}}{{end}}. Do not rely on its findings.
+                                </div>
+                                {{end}}
+                                {{if .Job.InvalidCitations}}
+                                <div class="alert alert-warning py-2 small">
+                                    The report cites {{range $index, $number := .Job.InvalidCitations}}{{if $index}}, {{end}}[{{$number}}]{{end}}, which match none of the gathered sources.
+                                </div>
+                                {{end}}
                                 <div class="research-result" id="research-result-content">{{.Job.Result}}</div>
                             </div>
                         </div>
+                        {{else if not (isTerminal .Job.Status)}}
+                        <div id="live-report-card" {{if not .Job.PartialResult}}style="display: none;"{{end}}>
+                            <hr>
+                            <div class="card">
+                                <div class="card-header d-flex justify-content-between align-items-center">
+                                    <h6 class="mb-0">Research Results</h6>
+                                    <span class="badge bg-info" id="live-report-badge">Generating...</span>
+                                </div>
+                                <div class="card-body">
+                                    <div class="research-result" id="research-result-content">{{.Job.PartialResult}}</div>
+                                </div>
+                            </div>
+                        </div>
                         {{end}}
                         
-                        {{if .Job.Sources}}
+                        {{if .Job.References}}
                         <hr>
                         <div class="card">
                             <div class="card-header">
-                                <h6 class="mb-0">Sources ({{len .Job.Sources}})</h6>
+                                <h6 class="mb-0" id="sources-header">Sources ({{len .Job.References}})</h6>
+                            </div>
+                            <div class="card-body sources-list" id="sources-list">
+                                {{range .Job.References}}
+                                <div class="mb-2 source-reference{{if not .Cited}} text-muted{{end}}" id="source-{{.Number}}">
+                                    <strong>[{{.Number}}]</strong>
+                                    <span class="badge bg-light text-dark">{{.Service}}</span>
+                                    {{if isWebURL .URL}}
+                                    <a href="{{.URL}}" target="_blank" rel="noopener" class="text-decoration-none">{{.URL}}</a>
+                                    {{else if .URL}}
+                                    <span>{{.URL}}</span>
+                                    {{else}}
+                                    <code>{{.Label}}</code>
+                                    {{end}}
+                                    {{if eq .Provenance "simulated"}}<span class="badge bg-danger">simulated</span>{{end}}
+                                    {{if not .Cited}}<small>(not cited)</small>{{end}}
+                                </div>
+                                {{end}}
                             </div>
-                            <div class="card-body sources-list">
+                        </div>
+                        {{else if .Job.Sources}}
+                        <hr>
+                        <div class="card">
+                            <div class="card-header">
+                                <h6 class="mb-0" id="sources-header">Sources ({{len .Job.Sources}})</h6>
+                            </div>
+                            <div class="card-body sources-list" id="sources-list">
                                 {{range $index, $source := .Job.Sources}}
                                 <div class="mb-2">
                                     <strong>{{add $index 1}}.</strong>
-                                    {{if hasPrefix $source "http"}}
+                                    {{if isWebURL $source}}
                                     <a href="{{$source}}" target="_blank" class="text-decoration-none">{{$source}}</a>
                                     {{else}}
                                     <code>{{$source}}</code>
@@ -588,6 +785,49 @@ const researchStatusTemplate = `
                             </div>
                         </div>
                                 {{end}}
+
+                        {{if .Job.ServiceOutcomes}}
+                        <hr>
+                        <div class="card">
+                            <div class="card-header">
+                                <h6 class="mb-0">Data Sources</h6>
+                            </div>
+                            <ul class="list-group list-group-flush" id="service-outcomes">
+                                {{range .Job.ServiceOutcomes}}
+                                <li class="list-group-item">
+                                    <span class="badge bg-{{serviceStatusColor .Status}}">{{.Status}}</span>
+                                    <strong>{{.Service}}</strong>
+                                    {{if eq .Provenance "simulated"}}<span class="badge bg-danger">simulated data</span>{{end}}
+                                    <small class="text-muted">{{.Calls}} call{{if ne .Calls 1}}s{{end}}{{if .CacheHits}} ({{.CacheHits}} cached){{end}} • {{.LatencyMs}} ms • {{.Bytes}} bytes</small>
+                                    {{if .Error}}<div class="text-danger small">{{.Error}}</div>{{end}}
+                                </li>
+                                {{end}}
+                            </ul>
+                        </div>
+                        {{end}}
+
+                        <div id="trace-card" {{if not .Job.Trace}}style="display: none;"{{end}}>
+                            <hr>
+                            <div class="card">
+                                <div class="card-header">
+                                    <h6 class="mb-0">Agent Trace (<span id="trace-count">{{len .Job.Trace}}</span> steps)</h6>
+                                </div>
+                                <ul class="list-group list-group-flush trace-list" id="trace-list">
+                                    {{range .Job.Trace}}
+                                    <li class="list-group-item">
+                                        <span class="badge bg-{{traceStepColor .Type}}">{{.Type}}</span>
+                                        <strong>Step {{.Step}}</strong>
+                                        {{if .Tool}}<code>{{if .Service}}{{.Service}}/{{end}}{{.Tool}}</code>{{end}}
+                                        {{if .Cached}}<span class="badge bg-info" title="Answered from the MCP result cache">cached</span>{{end}}
+                                        <small class="text-muted">{{if .DurationMs}}{{.DurationMs}} ms{{end}}{{if .Tokens}} • {{.Tokens}} tokens{{end}}</small>
+                                        {{if .Content}}<div class="mt-1">{{.Content}}</div>{{end}}
+                                        {{if .Arguments}}<pre class="trace-detail mt-1 mb-1">{{toJSON .Arguments}}</pre>{{end}}
+                                        {{if .Error}}<div class="text-danger small">{{.Error}}</div>{{else if .Result}}<pre class="trace-detail mb-0">{{.Result}}</pre>{{end}}
+                                    </li>
+                                    {{end}}
+                                </ul>
+                            </div>
+                        </div>
                             </div>
                         </div>
                         
@@ -599,7 +839,13 @@ const researchStatusTemplate = `
                         </div>
                         {{end}}
                         
-                        {{if .Job.Error}}
+                        {{if eq .Job.Status "cancelled"}}
+                        <hr>
+                        <div class="alert alert-secondary">
+                            <strong>Research Cancelled:</strong><br>
+                            {{.Job.Error}}
+                        </div>
+                        {{else if .Job.Error}}
                         <hr>
                         <div class="alert alert-danger">
                             <strong>Research Failed:</strong><br>
@@ -607,6 +853,31 @@ const researchStatusTemplate = `
                         </div>
                         {{end}}
                         
+                        {{if gt (len .Attempts) 1}}
+                        <hr>
+                        <div class="card mb-3">
+                            <div class="card-header">
+                                <h6 class="mb-0">Attempt History ({{len .Attempts}})</h6>
+                            </div>
+                            <ul class="list-group list-group-flush">
+                                {{range .Attempts}}
+                                <li class="list-group-item d-flex justify-content-between align-items-center">
+                                    <span>
+                                        <strong>Attempt {{.Attempt}}</strong>
+                                        {{if eq .ID $.Job.ID}}
+                                        <span class="text-muted">(this attempt)</span>
+                                        {{else}}
+                                        <a href="/status/{{.ID}}" class="text-decoration-none">{{.ID}}</a>
+                                        {{end}}
+                                        <br><small class="text-muted">{{formatTime .CreatedAt}}{{if .Model}} • {{.Model}}{{end}}</small>
+                                    </span>
+                                    <span class="badge bg-{{statusColor .Status}}">{{.Status}}</span>
+                                </li>
+                                {{end}}
+                            </ul>
+                        </div>
+                        {{end}}
+
                         {{if eq .Job.Status "pending"}}
                         <div class="alert alert-info">
                             <div class="d-flex align-items-center">
@@ -631,7 +902,7 @@ const researchStatusTemplate = `
                 
                 <div class="auto-refresh mt-3">
                     <small class="text-muted">
-                        <em>This page auto-refreshes every 3 seconds while research is in progress.</em>
+                        <em>This page updates live while research is in progress.</em>
                     </small>
                 </div>
             </div>
@@ -641,6 +912,67 @@ const researchStatusTemplate = `
     <script src="https://cdn.jsdelivr.net/npm/bootstrap@5.1.3/dist/js/bootstrap.bundle.min.js"></script>
     <script src="https://cdn.jsdelivr.net/npm/marked@4.3.0/marked.min.js"></script>
     <script>
+        // The numbered sources the report cites as [n]
+        const jobReferences = {{.Job.References}};
+
+        // Turn [n] citations into links to the numbered sources; numbers
+        // without a source are struck through
+        function linkCitations(container, references) {
+            if (!references || references.length === 0) {
+                return;
+            }
+            const citationPattern = /\[(\d+(?:\s*,\s*\d+)*)\]/g;
+            const walker = document.createTreeWalker(container, NodeFilter.SHOW_TEXT, {
+                acceptNode: function(node) {
+                    return node.parentNode.closest('a, code, pre') ? NodeFilter.FILTER_REJECT : NodeFilter.FILTER_ACCEPT;
+                }
+            });
+            const textNodes = [];
+            while (walker.nextNode()) {
+                citationPattern.lastIndex = 0;
+                if (citationPattern.test(walker.currentNode.nodeValue)) {
+                    textNodes.push(walker.currentNode);
+                }
+            }
+
+            textNodes.forEach(function(node) {
+                const text = node.nodeValue;
+                const fragment = document.createDocumentFragment();
+                let last = 0;
+                text.replace(citationPattern, function(match, numbers, offset) {
+                    fragment.appendChild(document.createTextNode(text.slice(last, offset)));
+                    const citation = document.createElement('sup');
+                    citation.className = 'citation';
+                    citation.appendChild(document.createTextNode('['));
+                    numbers.split(',').forEach(function(raw, index) {
+                        const number = parseInt(raw, 10);
+                        const reference = references[number - 1];
+                        if (index > 0) {
+                            citation.appendChild(document.createTextNode(', '));
+                        }
+                        let marker;
+                        if (reference) {
+                            marker = document.createElement('a');
+                            marker.href = '#source-' + number;
+                            marker.title = reference.url || reference.tool || reference.service;
+                        } else {
+                            marker = document.createElement('span');
+                            marker.className = 'citation-invalid';
+                            marker.title = 'No gathered source has this number';
+                        }
+                        marker.textContent = number;
+                        citation.appendChild(marker);
+                    });
+                    citation.appendChild(document.createTextNode(']'));
+                    fragment.appendChild(citation);
+                    last = offset + match.length;
+                    return match;
+                });
+                fragment.appendChild(document.createTextNode(text.slice(last)));
+                node.parentNode.replaceChild(fragment, node);
+            });
+        }
+
         // Render markdown in research results
         document.addEventListener('DOMContentLoaded', function() {
             const resultElement = document.getElementById('research-result-content');
@@ -658,6 +990,7 @@ const researchStatusTemplate = `
                 // Render markdown to HTML
                 const htmlContent = marked.parse(markdownText);
                 resultElement.innerHTML = htmlContent;
+                linkCitations(resultElement, jobReferences);
                 
                 // Add some custom styling to the rendered content
                 resultElement.style.lineHeight = '1.6';
@@ -691,103 +1024,349 @@ const researchStatusTemplate = `
             }
         });
 
-        // Auto-refresh job status via AJAX every 3 seconds
+        // Keep job status live via Server-Sent Events, polling every 3 seconds as a fallback
         if (window.location.pathname.includes('/status/')) {
             const jobId = window.location.pathname.split('/status/')[1];
-            
+            let statusRefreshInterval = null;
+            let statusEvents = null;
+
+            function stopStatusUpdates() {
+                if (statusRefreshInterval) {
+                    clearInterval(statusRefreshInterval);
+                    statusRefreshInterval = null;
+                }
+                if (statusEvents) {
+                    statusEvents.close();
+                    statusEvents = null;
+                }
+            }
+
             function refreshJobStatus() {
                 fetch('/api/jobs/' + jobId)
                     .then(response => response.json())
-                    .then(job => {
-                        // Update status badge
-                        const statusBadge = document.querySelector('.badge');
-                        if (statusBadge) {
-                            statusBadge.className = 'badge bg-' + getStatusColor(job.status);
-                            statusBadge.textContent = job.status.charAt(0).toUpperCase() + job.status.slice(1);
-                        }
-                        
-                        // Update confidence if present
-                        const confidenceElement = document.querySelector('.progress-bar');
-                        if (confidenceElement && job.confidence) {
-                            confidenceElement.style.width = (job.confidence * 100) + '%';
-                        }
-                        
-                        // Update result if completed
-                        const resultContainer = document.getElementById('research-result-content');
-                        if (job.result && resultContainer) {
-                            // Only update if content has changed
-                            if (resultContainer.textContent !== job.result) {
-                                resultContainer.textContent = job.result;
-                                // Re-render markdown
-                                if (typeof marked !== 'undefined') {
-                                    resultContainer.innerHTML = marked.parse(job.result);
-                                }
-                            }
-                        }
-                        
-                        // Update sources
-                        const sourcesContainer = document.querySelector('.sources-list');
-                        if (job.sources && sourcesContainer && job.sources.length > 0) {
-                            // Update sources count in header
-                            const sourcesHeader = document.querySelector('.card-header h6');
-                            if (sourcesHeader) {
-                                sourcesHeader.textContent = 'Sources (' + job.sources.length + ')';
-                            }
-                            
-                            // Update sources list
-                            let sourcesList = '';
-                            job.sources.forEach(function(source, index) {
-                                sourcesList += '<div class="mb-2"><strong>' + (index + 1) + '.</strong> <a href="' + source + '" target="_blank" class="text-decoration-none">' + source + '</a></div>';
-                            });
-                            sourcesContainer.innerHTML = sourcesList;
+                    .then(renderJobStatus)
+                    .catch(function(error) {
+                        console.error('Error refreshing job status:', error);
+                    });
+            }
+
+            // Render the report as it streams in, at most once per animation frame
+            const liveReportContainer = document.getElementById('live-report-card') ?
+                document.getElementById('research-result-content') : null;
+            let liveReportText = liveReportContainer ? liveReportContainer.textContent : '';
+            let liveReportScheduled = false;
+
+            function renderLiveReport(text) {
+                if (!liveReportContainer) {
+                    return;
+                }
+                document.getElementById('live-report-card').style.display = '';
+                liveReportText = text;
+                if (liveReportScheduled) {
+                    return;
+                }
+                liveReportScheduled = true;
+                requestAnimationFrame(function() {
+                    liveReportScheduled = false;
+                    if (typeof marked !== 'undefined') {
+                        liveReportContainer.innerHTML = marked.parse(liveReportText);
+                    } else {
+                        liveReportContainer.textContent = liveReportText;
+                    }
+                });
+            }
+
+            function renderJobStatus(job) {
+                // Show streamed output until the final report arrives
+                if (!job.result && job.partial_result) {
+                    renderLiveReport(job.partial_result);
+                }
+                if (job.result && liveReportContainer) {
+                    document.getElementById('live-report-card').style.display = '';
+                    const liveBadge = document.getElementById('live-report-badge');
+                    if (liveBadge) {
+                        liveBadge.style.display = 'none';
+                    }
+                }
+
+                // Update status badge
+                const statusBadge = document.querySelector('.badge');
+                if (statusBadge) {
+                    statusBadge.className = 'badge bg-' + getStatusColor(job.status);
+                    statusBadge.textContent = job.status.charAt(0).toUpperCase() + job.status.slice(1);
+                }
+                
+                // Update confidence if present
+                const confidenceElement = document.querySelector('.progress-bar');
+                if (confidenceElement && job.confidence) {
+                    confidenceElement.style.width = (job.confidence * 100) + '%';
+                }
+                
+                // Update result if completed
+                const resultContainer = document.getElementById('research-result-content');
+                if (job.result && resultContainer) {
+                    // Only update if content has changed
+                    if (resultContainer.textContent !== job.result) {
+                        resultContainer.textContent = job.result;
+                        // Re-render markdown
+                        if (typeof marked !== 'undefined') {
+                            resultContainer.innerHTML = marked.parse(job.result);
                         }
-                        
-                        // Update completion time if job is done
-                        if (job.completed_at) {
-                            const durationElements = document.querySelectorAll('strong');
-                            durationElements.forEach(function(el) {
-                                if (el.textContent === 'Duration:') {
-                                    const nextElement = el.parentNode.querySelector('span, br + *');
-                                    if (nextElement && nextElement.textContent.includes('In progress')) {
-                                        const completedTime = new Date(job.completed_at).toLocaleString();
-                                        el.textContent = 'Completed:';
-                                        el.nextSibling.textContent = '\n' + completedTime;
-                                    }
-                                }
-                            });
+                        linkCitations(resultContainer, job.references);
+                    }
+                }
+                
+                // Update the agent trace as steps are recorded
+                if (job.trace && job.trace.length > 0) {
+                    renderTrace(job.trace);
+                }
+
+                // Update sources
+                const sourcesContainer = document.getElementById('sources-list');
+                const references = job.references || (job.sources || []).map(function(url, index) {
+                    return {number: index + 1, url: url, cited: true};
+                });
+                if (sourcesContainer && references.length > 0) {
+                    document.getElementById('sources-header').textContent = 'Sources (' + references.length + ')';
+
+                    sourcesContainer.innerHTML = '';
+                    references.forEach(function(reference) {
+                        const item = document.createElement('div');
+                        item.className = 'mb-2 source-reference' + (reference.cited ? '' : ' text-muted');
+                        item.id = 'source-' + reference.number;
+                        const number = document.createElement('strong');
+                        number.textContent = '[' + reference.number + '] ';
+                        item.appendChild(number);
+                        if (isWebURL(reference.url)) {
+                            const link = document.createElement('a');
+                            link.href = reference.url;
+                            link.target = '_blank';
+                            link.rel = 'noopener';
+                            link.className = 'text-decoration-none';
+                            link.textContent = reference.url;
+                            item.appendChild(link);
+                        } else if (reference.url) {
+                            // Sources come from tool output, so anything but a web page
+                            // such as a javascript: URL is shown as text
+                            const text = document.createElement('span');
+                            text.textContent = reference.url;
+                            item.appendChild(text);
+                        } else {
+                            const label = document.createElement('code');
+                            label.textContent = reference.tool ? reference.tool + ' (' + reference.service + ')' : reference.service;
+                            item.appendChild(label);
                         }
-                        
-                        // Stop refreshing if job is completed or failed
-                        if (job.status === 'completed' || job.status === 'failed') {
-                            clearInterval(statusRefreshInterval);
-                            
-                            // Update auto-refresh message
-                            const autoRefreshMsg = document.querySelector('.auto-refresh');
-                            if (autoRefreshMsg) {
-                                autoRefreshMsg.innerHTML = '<small class="text-muted"><em>Research completed. Auto-refresh stopped.</em></small>';
+                        sourcesContainer.appendChild(item);
+                    });
+                }
+                
+                // Update completion time if job is done
+                if (job.completed_at) {
+                    const durationElements = document.querySelectorAll('strong');
+                    durationElements.forEach(function(el) {
+                        if (el.textContent === 'Duration:') {
+                            const nextElement = el.parentNode.querySelector('span, br + *');
+                            if (nextElement && nextElement.textContent.includes('In progress')) {
+                                const completedTime = new Date(job.completed_at).toLocaleString();
+                                el.textContent = 'Completed:';
+                                el.nextSibling.textContent = '\n' + completedTime;
                             }
                         }
-                    })
-                    .catch(function(error) {
-                        console.error('Error refreshing job status:', error);
                     });
+                }
+                
+                // Stop refreshing if job is completed, failed or cancelled
+                if (job.status === 'completed' || job.status === 'failed' || job.status === 'cancelled') {
+                    stopStatusUpdates();
+
+                    // Hide cancel button once the research has finished
+                    const cancelBtn = document.getElementById('cancelBtn');
+                    if (cancelBtn) {
+                        cancelBtn.style.display = 'none';
+                    }
+                    
+                    // Update auto-refresh message
+                    const autoRefreshMsg = document.querySelector('.auto-refresh');
+                    if (autoRefreshMsg) {
+                        autoRefreshMsg.innerHTML = '<small class="text-muted"><em>Research completed. Auto-refresh stopped.</em></small>';
+                    }
+                }
             }
             
+            function isWebURL(value) {
+                try {
+                    const protocol = new URL(value).protocol;
+                    return protocol === 'http:' || protocol === 'https:';
+                } catch (e) {
+                    return false;
+                }
+            }
+
             function getStatusColor(status) {
                 switch (status) {
                     case 'pending': return 'warning';
                     case 'processing': return 'info';
                     case 'completed': return 'success';
                     case 'failed': return 'danger';
+                    case 'cancelled': return 'dark';
                     default: return 'secondary';
                 }
             }
+
+            function getTraceStepColor(type) {
+                switch (type) {
+                    case 'plan': return 'primary';
+                    case 'tool_call': return 'info';
+                    case 'report': return 'success';
+                    default: return 'secondary';
+                }
+            }
+
+            // renderTrace rebuilds the trace list; text is set with textContent
+            // because tool results come from external servers
+            function renderTrace(trace) {
+                const list = document.getElementById('trace-list');
+                if (!list) {
+                    return;
+                }
+                document.getElementById('trace-card').style.display = '';
+                document.getElementById('trace-count').textContent = trace.length;
+
+                function element(tag, className, text) {
+                    const el = document.createElement(tag);
+                    if (className) {
+                        el.className = className;
+                    }
+                    if (text) {
+                        el.textContent = text;
+                    }
+                    return el;
+                }
+
+                list.innerHTML = '';
+                trace.forEach(function(step) {
+                    const item = element('li', 'list-group-item');
+                    item.appendChild(element('span', 'badge bg-' + getTraceStepColor(step.type), step.type));
+                    item.appendChild(document.createTextNode(' '));
+                    item.appendChild(element('strong', '', 'Step ' + step.step));
+                    if (step.tool) {
+                        item.appendChild(document.createTextNode(' '));
+                        item.appendChild(element('code', '', (step.service ? step.service + '/' : '') + step.tool));
+                    }
+                    if (step.cached) {
+                        item.appendChild(document.createTextNode(' '));
+                        const cached = element('span', 'badge bg-info', 'cached');
+                        cached.title = 'Answered from the MCP result cache';
+                        item.appendChild(cached);
+                    }
+                    let meta = step.duration_ms ? step.duration_ms + ' ms' : '';
+                    if (step.tokens) {
+                        meta += ' • ' + step.tokens + ' tokens';
+                    }
+                    item.appendChild(document.createTextNode(' '));
+                    item.appendChild(element('small', 'text-muted', meta));
+                    if (step.content) {
+                        item.appendChild(element('div', 'mt-1', step.content));
+                    }
+                    if (step.arguments) {
+                        item.appendChild(element('pre', 'trace-detail mt-1 mb-1', JSON.stringify(step.arguments, null, 2)));
+                    }
+                    if (step.error) {
+                        item.appendChild(element('div', 'text-danger small', step.error));
+                    } else if (step.result) {
+                        item.appendChild(element('pre', 'trace-detail mb-0', step.result));
+                    }
+                    list.appendChild(item);
+                });
+            }
             
-            // Start auto-refresh interval
-            const statusRefreshInterval = setInterval(refreshJobStatus, 3000);
-            
-            // Initial refresh
-            refreshJobStatus();
+            // Cancel the research when the user clicks the cancel button
+            const cancelBtn = document.getElementById('cancelBtn');
+            if (cancelBtn) {
+                cancelBtn.addEventListener('click', function() {
+                    if (!confirm('Cancel this research?')) {
+                        return;
+                    }
+                    cancelBtn.disabled = true;
+                    cancelBtn.innerHTML = '<span class="spinner-border spinner-border-sm me-2"></span>Cancelling...';
+
+                    fetch('/api/jobs/' + jobId + '/cancel', { method: 'POST' })
+                        .then(function(response) {
+                            if (!response.ok) {
+                                return response.json().then(function(data) {
+                                    throw new Error(data.error || 'Failed to cancel research');
+                                });
+                            }
+                            window.location.reload();
+                        })
+                        .catch(function(error) {
+                            alert(error.message);
+                            cancelBtn.disabled = false;
+                            cancelBtn.innerHTML = 'Cancel Research';
+                        });
+                });
+            }
+
+            // Re-run the research as a new attempt and open its status page
+            const retryBtn = document.getElementById('retryBtn');
+            if (retryBtn) {
+                retryBtn.addEventListener('click', function() {
+                    retryBtn.disabled = true;
+                    retryBtn.innerHTML = '<span class="spinner-border spinner-border-sm me-2"></span>Retrying...';
+
+                    fetch('/api/jobs/' + jobId + '/retry', { method: 'POST' })
+                        .then(function(response) {
+                            return response.json().then(function(data) {
+                                if (!response.ok) {
+                                    throw new Error(data.error || 'Failed to retry research');
+                                }
+                                window.location.href = '/status/' + data.id;
+                            });
+                        })
+                        .catch(function(error) {
+                            alert(error.message);
+                            retryBtn.disabled = false;
+                            retryBtn.innerHTML = 'Retry Research';
+                        });
+                });
+            }
+
+            function startStatusPolling() {
+                if (!statusRefreshInterval) {
+                    statusRefreshInterval = setInterval(refreshJobStatus, 3000);
+                }
+            }
+
+            // Prefer the event stream; it sends the current state first, then every change
+            if (window.EventSource) {
+                statusEvents = new EventSource('/api/jobs/' + jobId + '/events');
+                let statusStreamOpened = false;
+                statusEvents.onopen = function() {
+                    statusStreamOpened = true;
+                };
+                statusEvents.addEventListener('job', function(event) {
+                    renderJobStatus(JSON.parse(event.data));
+                });
+                statusEvents.addEventListener('partial', function(event) {
+                    renderLiveReport(liveReportText + JSON.parse(event.data).chunk);
+                });
+                statusEvents.onerror = function() {
+                    if (!statusEvents) {
+                        return;
+                    }
+                    // The server closes the stream once the job finishes; only poll
+                    // if the stream never worked or was closed for good
+                    if (!statusStreamOpened || statusEvents.readyState === EventSource.CLOSED) {
+                        statusEvents.close();
+                        statusEvents = null;
+                        startStatusPolling();
+                        refreshJobStatus();
+                    }
+                };
+            } else {
+                startStatusPolling();
+                refreshJobStatus();
+            }
         }
     </script>
 </body>
@@ -6,6 +6,7 @@ require (
 	github.com/gin-gonic/gin v1.9.1
 	github.com/google/uuid v1.3.0
 	github.com/rabbitmq/amqp091-go v1.9.0
+	go.etcd.io/bbolt v1.3.8
 )
 
 require (
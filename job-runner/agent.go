@@ -0,0 +1,596 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"microservices-demo/shared"
+)
+
+// Defaults for the research loop budget, overridable with AGENT_MAX_STEPS
+// and AGENT_TOKEN_BUDGET
+const (
+	defaultAgentMaxSteps    = 6
+	defaultAgentTokenBudget = 16000
+)
+
+// traceResultLimit truncates tool output before it is stored in the trace.
+// The model sees all of it, summarized if the conversation outgrows the
+// model's context.
+const traceResultLimit = 1000
+
+// gatherError marks a failure to gather any information, which is worth
+// retrying because MCP servers may come back
+type gatherError struct {
+	err error
+}
+
+func (e *gatherError) Error() string { return e.err.Error() }
+func (e *gatherError) Unwrap() error { return e.err }
+
+// researchTool is an MCP tool offered to the model
+type researchTool struct {
+	service shared.MCPService
+	tool    MCPTool
+	// simulate answers the call in test mode, or in place of a server that
+	// failed if the service's fallback policy allows it
+	simulate mcpSimulator
+}
+
+// toolNamePattern matches characters not allowed in function names
+var toolNamePattern = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// functionName is the name the model calls the tool by; it is prefixed with
+// the service so tools of different servers cannot clash
+func (t researchTool) functionName() string {
+	return toolNamePattern.ReplaceAllString(string(t.service)+"__"+t.tool.Name, "_")
+}
+
+// provenance is where the tool's results come from
+func (t researchTool) provenance() shared.Provenance {
+	if t.simulate != nil {
+		return shared.ProvenanceSimulated
+	}
+	return shared.ProvenanceReal
+}
+
+// simulatedToolSchema is the input schema of the simulated search tool
+var simulatedToolSchema = json.RawMessage(`{"type":"object","properties":{"query":{"type":"string","description":"What to search for"}},"required":["query"]}`)
+
+// simulatedTool is the search tool offered for a service whose data is
+// simulated
+func simulatedTool(config mcpServerConfig, simulate mcpSimulator) researchTool {
+	return researchTool{
+		service: config.Name,
+		tool: MCPTool{
+			Name:        "search",
+			Description: fmt.Sprintf("Search %s (simulated data)", config.Title),
+			InputSchema: simulatedToolSchema,
+		},
+		simulate: simulate,
+	}
+}
+
+// researchTools discovers the tools of the services a job selected,
+// querying the servers concurrently. Services that cannot be reached are
+// returned with how they failed, and offer a simulated search tool instead
+// if their fallback policy allows it.
+func (ra *ResearchAgent) researchTools(ctx context.Context, services []shared.MCPService) ([]researchTool, []shared.ServiceOutcome) {
+	discovered := make([][]researchTool, len(services))
+	failures := make([]*shared.ServiceOutcome, len(services))
+
+	var wg sync.WaitGroup
+	for i, service := range services {
+		config, ok := ra.mcpHandler.servers[service]
+		if !ok {
+			log.Printf("MCP service %s not configured, skipping", service)
+			failures[i] = &shared.ServiceOutcome{Service: service, Status: shared.ServiceStatusError, Error: "not configured"}
+			continue
+		}
+
+		if ra.mcpHandler.testMode {
+			simulate, ok := mcpSimulators[service]
+			if !ok {
+				continue
+			}
+			discovered[i] = []researchTool{simulatedTool(config, simulate)}
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, service shared.MCPService) {
+			defer wg.Done()
+
+			ctx, cancel := ra.mcpHandler.withCallTimeout(ctx, service)
+			defer cancel()
+
+			started := time.Now()
+			tools, err := ra.mcpHandler.discoverTools(ctx, service)
+			if err != nil {
+				log.Printf("Error discovering tools of MCP service %s: %v", service, err)
+				outcome := serviceCallOutcome(ctx, service, time.Since(started), 0, err)
+				// Listing tools is not a research call
+				outcome.Calls = 0
+				failures[i] = &outcome
+				if simulate, ok := ra.mcpHandler.fallbackSimulator(service); ok {
+					log.Printf("Offering simulated data for MCP service %s as its fallback policy allows", service)
+					discovered[i] = []researchTool{simulatedTool(config, simulate)}
+				}
+				return
+			}
+			for _, tool := range tools {
+				discovered[i] = append(discovered[i], researchTool{service: service, tool: tool})
+			}
+		}(i, service)
+	}
+	wg.Wait()
+
+	var tools []researchTool
+	var outcomes []shared.ServiceOutcome
+	for i := range services {
+		tools = append(tools, discovered[i]...)
+		if failures[i] != nil {
+			outcomes = append(outcomes, *failures[i])
+		}
+	}
+	return tools, outcomes
+}
+
+// researchOutcome is what a research run produced
+type researchOutcome struct {
+	report string
+	// structured is the report as the model wrote it, if it followed the schema
+	structured *shared.Report
+	sources    []string
+	// references number the sources for citation; invalidCitations are
+	// the numbers the report cites that match none of them
+	references       []shared.Reference
+	invalidCitations []int
+	// services records how each MCP service answered
+	services []shared.ServiceOutcome
+	gathered string
+	usage    Usage
+	trace    []shared.TraceStep
+}
+
+// researchRun holds the state of one agentic research loop
+type researchRun struct {
+	ra       *ResearchAgent
+	job      shared.JobMessage
+	prompt   *promptTemplate
+	tools    map[string]researchTool
+	offered  []ChatTool
+	messages []ChatMessage
+	// window is the model's context in tokens, looked up on the first step,
+	// and summarized marks the tool messages already summarized to fit it
+	window     int
+	summarized map[int]bool
+	gathered   []string
+	citations  *citationIndex
+	services   []shared.ServiceOutcome
+	usage      Usage
+	trace      []shared.TraceStep
+	onProgress func([]shared.TraceStep)
+}
+
+// record appends a step to the trace and reports the trace so far
+func (r *researchRun) record(step shared.TraceStep) {
+	step.Step = len(r.trace) + 1
+	r.trace = append(r.trace, step)
+	if r.onProgress != nil {
+		r.onProgress(r.trace)
+	}
+}
+
+// runResearchAgent researches a job by letting the model plan and call the
+// discovered MCP tools with arguments of its choosing, until it has enough
+// information or the step or token budget runs out, and then write the
+// report, with the prompts of the given templates. onProgress is called with
+// the trace after every step.
+func (ra *ResearchAgent) runResearchAgent(ctx context.Context, jobMessage shared.JobMessage, prompt *promptTemplate, onProgress func([]shared.TraceStep)) (researchOutcome, error) {
+	tools, unavailable := ra.researchTools(ctx, jobMessage.MCPServices)
+	if err := ra.mcpHandler.requiredServiceError(unavailable); err != nil {
+		return researchOutcome{services: unavailable}, &gatherError{err}
+	}
+	if len(tools) == 0 {
+		return researchOutcome{services: unavailable}, &gatherError{errors.New("no MCP tools are available for the selected services")}
+	}
+
+	data := ra.promptData(jobMessage)
+	systemPrompt, err := prompt.render("agent_system", data)
+	if err != nil {
+		return researchOutcome{}, err
+	}
+	task, err := prompt.render("agent_task", data)
+	if err != nil {
+		return researchOutcome{}, err
+	}
+
+	run := &researchRun{
+		ra:         ra,
+		job:        jobMessage,
+		prompt:     prompt,
+		tools:      make(map[string]researchTool),
+		summarized: make(map[int]bool),
+		citations:  newCitationIndex(),
+		services:   unavailable,
+		onProgress: onProgress,
+	}
+	for _, tool := range tools {
+		name := tool.functionName()
+		run.tools[name] = tool
+
+		parameters := tool.tool.InputSchema
+		if len(parameters) == 0 {
+			parameters = json.RawMessage(`{"type":"object","properties":{}}`)
+		}
+		run.offered = append(run.offered, ChatTool{
+			Type: "function",
+			Function: ToolFunction{
+				Name:        name,
+				Description: tool.tool.Description,
+				Parameters:  parameters,
+			},
+		})
+	}
+
+	run.messages = []ChatMessage{
+		{Role: "system", Content: systemPrompt},
+		{Role: "user", Content: task},
+	}
+
+	if err := run.gather(ctx); err != nil {
+		return run.outcome(""), err
+	}
+	if err := ra.mcpHandler.requiredServiceError(run.services); err != nil {
+		return run.outcome(""), &gatherError{err}
+	}
+	if len(run.gathered) == 0 {
+		return run.outcome(""), &gatherError{errors.New("no data could be gathered from MCP services")}
+	}
+
+	report, structured, err := run.writeReport(ctx)
+	outcome := run.outcome(report)
+	outcome.structured = structured
+	return outcome, err
+}
+
+// gather runs the plan and tool-call steps until the model stops calling
+// tools or the budget is spent
+func (r *researchRun) gather(ctx context.Context) error {
+	maxSteps := getEnvInt("AGENT_MAX_STEPS", defaultAgentMaxSteps)
+	tokenBudget := getEnvInt("AGENT_TOKEN_BUDGET", defaultAgentTokenBudget)
+
+	for step := 1; ; step++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if step > maxSteps {
+			r.record(shared.TraceStep{Type: shared.TraceStepNote, Content: fmt.Sprintf("Stopped gathering after %d steps", maxSteps)})
+			return nil
+		}
+		if r.usage.Total() >= tokenBudget {
+			r.record(shared.TraceStep{Type: shared.TraceStepNote, Content: fmt.Sprintf("Stopped gathering after using %d of %d tokens", r.usage.Total(), tokenBudget)})
+			return nil
+		}
+
+		if err := r.fitContext(ctx); err != nil {
+			return err
+		}
+
+		started := time.Now()
+		reply, usage, err := r.ra.llm.Chat(ctx, ChatRequest{Model: r.job.Model, Messages: r.messages, Tools: r.offered, Options: r.job.Options}, nil)
+		if err != nil {
+			return err
+		}
+		r.usage.Add(usage)
+		r.messages = append(r.messages, reply)
+		r.record(shared.TraceStep{
+			Type:       shared.TraceStepPlan,
+			Content:    reply.Content,
+			Tokens:     usage.Total(),
+			DurationMs: time.Since(started).Milliseconds(),
+		})
+
+		if len(reply.ToolCalls) == 0 {
+			return nil
+		}
+		for _, result := range r.callTools(ctx, reply.ToolCalls) {
+			r.recordToolCall(result)
+		}
+	}
+}
+
+// toolCallResult is how one tool call of a step ended
+type toolCallResult struct {
+	call       ToolCall
+	tool       researchTool
+	step       shared.TraceStep
+	data       string
+	sources    []string
+	provenance shared.Provenance
+	// outcome is nil for calls of unknown tools
+	outcome *shared.ServiceOutcome
+	err     error
+}
+
+// callTools runs the tool calls of one step concurrently, each within its
+// server's call timeout and all within MCP_GATHER_BUDGET, and returns their
+// results in the order the model made the calls
+func (r *researchRun) callTools(ctx context.Context, calls []ToolCall) []toolCallResult {
+	ctx, cancel := context.WithTimeout(ctx, getEnvDuration("MCP_GATHER_BUDGET", defaultMCPGatherBudget))
+	defer cancel()
+
+	results := make([]toolCallResult, len(calls))
+	var wg sync.WaitGroup
+	for i, call := range calls {
+		wg.Add(1)
+		go func(result *toolCallResult, call ToolCall) {
+			defer wg.Done()
+			*result = r.runToolCall(ctx, call)
+		}(&results[i], call)
+	}
+	wg.Wait()
+	return results
+}
+
+// runToolCall makes one tool call, falling back to simulated data if the
+// server failed and its fallback policy allows it. It leaves the run's state
+// alone so calls can run concurrently.
+func (r *researchRun) runToolCall(ctx context.Context, call ToolCall) toolCallResult {
+	started := time.Now()
+	name := call.Function.Name
+	result := toolCallResult{
+		call: call,
+		step: shared.TraceStep{
+			Type:      shared.TraceStepToolCall,
+			Tool:      name,
+			Arguments: call.Function.Arguments,
+		},
+	}
+
+	tool, ok := r.tools[name]
+	if !ok {
+		result.err = fmt.Errorf("unknown tool %q", name)
+		result.step.DurationMs = time.Since(started).Milliseconds()
+		return result
+	}
+
+	result.tool = tool
+	result.step.Service = tool.service
+	result.step.Tool = tool.tool.Name
+	data, sources, cached, err := r.ra.runResearchTool(ctx, tool, call.Function.Arguments, r.job.NoCache)
+	outcome := serviceCallOutcome(ctx, tool.service, time.Since(started), len(data), err)
+	if cached {
+		result.step.Cached = true
+		outcome.CacheHits = 1
+	}
+	if err == nil {
+		result.provenance = tool.provenance()
+	} else if simulate, ok := r.ra.mcpHandler.fallbackSimulator(tool.service); ok && tool.simulate == nil {
+		result.step.Content = fmt.Sprintf("The server failed (%v), so simulated data was used as the fallback policy allows", err)
+		data, sources, err = simulate(r.ra, r.job.Query)
+		result.provenance = shared.ProvenanceSimulated
+	}
+	outcome.Provenance = result.provenance
+	result.outcome = &outcome
+	result.data, result.sources, result.err = data, sources, err
+	result.step.DurationMs = time.Since(started).Milliseconds()
+	return result
+}
+
+// recordToolCall feeds the result of a tool call, or its error, back to the
+// model and records it
+func (r *researchRun) recordToolCall(result toolCallResult) {
+	if result.outcome != nil {
+		r.services = addServiceOutcome(r.services, *result.outcome)
+	}
+
+	step := result.step
+	var message string
+	if result.err != nil {
+		step.Error = result.err.Error()
+		message = "Error: " + result.err.Error()
+	} else {
+		step.Result = truncate(result.data, traceResultLimit)
+		step.Sources = result.sources
+		message = r.citations.add(result.tool.service, result.tool.tool.Name, result.data, result.sources, result.provenance)
+
+		r.gathered = append(r.gathered, result.data)
+	}
+
+	r.messages = append(r.messages, ChatMessage{Role: "tool", Content: message, ToolCallID: result.call.ID})
+	r.record(step)
+}
+
+// fitContext summarizes tool results, the largest first, until the
+// conversation and the offered tools fit the model's context next to the
+// reply, so neither the model's next step nor the report is cut off
+func (r *researchRun) fitContext(ctx context.Context) error {
+	if r.window == 0 {
+		r.window = r.ra.contextWindow(ctx, r.job)
+	}
+	limit := r.window - reportReserve(r.job, r.window)
+	if tools, err := json.Marshal(r.offered); err == nil {
+		limit -= estimateTokens(string(tools))
+	}
+
+	for {
+		used := messageTokens(r.messages)
+		if used <= limit {
+			return nil
+		}
+
+		largest := -1
+		for i, message := range r.messages {
+			if message.Role == "tool" && !r.summarized[i] && (largest < 0 || len(message.Content) > len(r.messages[largest].Content)) {
+				largest = i
+			}
+		}
+		if largest < 0 {
+			log.Printf("The conversation of job %s still exceeds the context with every tool result summarized", r.job.JobID)
+			return nil
+		}
+
+		started := time.Now()
+		result := r.messages[largest].Content
+		budget := max(estimateTokens(result)-(used-limit), minChunkTokens)
+		summary, chunks, rounds, usage, err := r.ra.summarizeToFit(ctx, r.job, r.prompt, r.ra.promptData(r.job), r.window, result, budget)
+		r.usage.Add(usage)
+		if err != nil {
+			return fmt.Errorf("failed to summarize a tool result: %w", err)
+		}
+		r.messages[largest].Content = summary
+		r.summarized[largest] = true
+		r.record(shared.TraceStep{
+			Type: shared.TraceStepNote,
+			Content: fmt.Sprintf("The conversation (about %d tokens) did not fit the model's %d-token context; summarized a tool result of about %d tokens in %d chunks and %d rounds to about %d tokens",
+				used, r.window, estimateTokens(result), chunks, rounds, estimateTokens(summary)),
+			Tokens:     usage.Total(),
+			DurationMs: time.Since(started).Milliseconds(),
+		})
+	}
+}
+
+// writeReport asks the model for the final report in the report schema,
+// streaming it to the status page as markdown
+func (r *researchRun) writeReport(ctx context.Context) (string, *shared.Report, error) {
+	data := r.ra.promptData(r.job)
+	data.Missing = missingServices(r.services)
+	instructions, err := r.prompt.render("report", data)
+	if err != nil {
+		return "", nil, err
+	}
+	r.messages = append(r.messages, ChatMessage{Role: "user", Content: instructions})
+	if err := r.fitContext(ctx); err != nil {
+		return "", nil, err
+	}
+
+	started := time.Now()
+	partials := r.ra.newPartialResultPublisher(r.job.JobID)
+	stream := newReportStream(partials.Write)
+	reply, usage, err := r.ra.llm.Chat(ctx, ChatRequest{Model: r.job.Model, Messages: r.messages, Format: reportSchema, Options: r.job.Options}, stream.Write)
+	stream.Close()
+	partials.Flush()
+	if err != nil {
+		return "", nil, err
+	}
+
+	r.usage.Add(usage)
+	r.record(shared.TraceStep{
+		Type:       shared.TraceStepReport,
+		Tokens:     usage.Total(),
+		DurationMs: time.Since(started).Milliseconds(),
+	})
+	report, structured := parseReport(reply.Content)
+	return report, structured, nil
+}
+
+// outcome collects what the run produced
+func (r *researchRun) outcome(report string) researchOutcome {
+	return researchOutcome{
+		report:     report,
+		sources:    r.citations.urls(),
+		references: r.citations.references,
+		gathered:   strings.Join(r.gathered, "\n\n"),
+		usage:      r.usage,
+		services:   r.services,
+		trace:      r.trace,
+	}
+}
+
+// runResearchTool calls a tool with model-chosen arguments and returns its
+// text and sources, and whether they came from the result cache. Simulated
+// data is never cached.
+func (ra *ResearchAgent) runResearchTool(ctx context.Context, tool researchTool, arguments map[string]interface{}, noCache bool) (string, []string, bool, error) {
+	if tool.simulate != nil {
+		query, _ := arguments["query"].(string)
+		data, sources, err := tool.simulate(ra, query)
+		return data, sources, false, err
+	}
+
+	return ra.cachedMCPCall(tool.service, tool.tool.Name, arguments, noCache, func() (string, []string, error) {
+		ctx, cancel := ra.mcpHandler.withCallTimeout(ctx, tool.service)
+		defer cancel()
+
+		result, err := ra.mcpHandler.callNamedTool(ctx, tool.service, tool.tool.Name, arguments)
+		if errors.Is(err, errMCPSessionExpired) {
+			// The server restarted or dropped the session; start a new one
+			result, err = ra.mcpHandler.callNamedTool(ctx, tool.service, tool.tool.Name, arguments)
+		}
+		if err != nil {
+			return "", nil, err
+		}
+
+		data := result.Text()
+		if data == "" {
+			return "", nil, fmt.Errorf("tool %s returned no text", tool.tool.Name)
+		}
+		return data, result.Sources(), nil
+	})
+}
+
+// runResearchPipeline is the fixed pipeline used for models without tool
+// support: query every selected service with the raw query, summarize what
+// came back if it is too large for the model's context, then ask the model
+// for the report
+func (ra *ResearchAgent) runResearchPipeline(ctx context.Context, jobMessage shared.JobMessage, prompt *promptTemplate) (researchOutcome, error) {
+	outcome := researchOutcome{
+		trace: []shared.TraceStep{{
+			Step:    1,
+			Type:    shared.TraceStepNote,
+			Content: "The model cannot call tools; queried every selected service with the research query",
+		}},
+	}
+
+	mcpData, citations, services, err := ra.gatherInformationWithMCP(ctx, jobMessage)
+	outcome.services = services
+	if err != nil {
+		return outcome, &gatherError{err}
+	}
+	outcome.gathered = mcpData
+	outcome.sources = citations.urls()
+	outcome.references = citations.references
+
+	mcpData, summarized, summaryUsage, err := ra.condenseGathered(ctx, jobMessage, prompt, mcpData, missingServices(services))
+	outcome.usage = summaryUsage
+	if err != nil {
+		return outcome, err
+	}
+	if summarized != nil {
+		summarized.Step = len(outcome.trace) + 1
+		outcome.trace = append(outcome.trace, *summarized)
+	}
+
+	started := time.Now()
+	research, structured, _, usage, err := ra.analyzeWithLLM(ctx, jobMessage, prompt, mcpData, missingServices(services))
+	if err != nil {
+		return outcome, err
+	}
+	outcome.report = research
+	outcome.structured = structured
+	outcome.usage.Add(usage)
+	outcome.trace = append(outcome.trace, shared.TraceStep{
+		Step:       len(outcome.trace) + 1,
+		Type:       shared.TraceStepReport,
+		Tokens:     usage.Total(),
+		DurationMs: time.Since(started).Milliseconds(),
+	})
+	return outcome, nil
+}
+
+// truncate shortens s to at most limit bytes, marking the cut. It does not
+// split a UTF-8 character.
+func truncate(s string, limit int) string {
+	if len(s) <= limit {
+		return s
+	}
+	return cutText(s, limit)[0] + "\n[truncated]"
+}
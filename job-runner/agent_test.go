@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"microservices-demo/shared"
+)
+
+// newFakeOllamaChat serves /api/chat. While tools are offered, plan decides
+// the reply to the conversation so far; the final report is always streamed.
+func newFakeOllamaChat(t *testing.T, plan func(messages []ChatMessage) ChatMessage) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			http.NotFound(w, r)
+			return
+		}
+
+		var req OllamaChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+
+		encoder := json.NewEncoder(w)
+		if len(req.Tools) > 0 {
+			if req.Stream {
+				t.Error("Expected planning requests not to stream")
+			}
+			encoder.Encode(OllamaChatResponse{Message: plan(req.Messages), Done: true})
+			return
+		}
+
+		for _, chunk := range []string{"# Report", "\n\nGo is great."} {
+			encoder.Encode(OllamaChatResponse{Message: ChatMessage{Role: "assistant", Content: chunk}})
+		}
+		encoder.Encode(OllamaChatResponse{Done: true})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func toolCall(name string, arguments map[string]interface{}) ChatMessage {
+	var call ToolCall
+	call.Function.Name = name
+	call.Function.Arguments = arguments
+	return ChatMessage{Role: "assistant", ToolCalls: []ToolCall{call}}
+}
+
+func traceTypes(trace []shared.TraceStep) string {
+	var types []string
+	for _, step := range trace {
+		types = append(types, string(step.Type))
+	}
+	return strings.Join(types, ",")
+}
+
+func TestResearchAgentCallsTools(t *testing.T) {
+	mcp := newFakeMCPServer(t)
+	ollama := newFakeOllamaChat(t, func(messages []ChatMessage) ChatMessage {
+		switch len(messages) {
+		case 2:
+			return toolCall("web__web_search", map[string]interface{}{"q": "go generics"})
+		case 4:
+			return toolCall("web__missing", nil)
+		default:
+			return ChatMessage{Role: "assistant", Content: "I have enough information."}
+		}
+	})
+
+	agent := NewResearchAgent()
+	agent.llm = newOllamaProvider(ollama.URL, ollama.Client(), "llama3.2")
+	agent.mcpHandler = NewMCPServiceHandler([]mcpServerConfig{
+		{Name: shared.MCPServiceWeb, Title: "Web Search", URL: mcp.URL, Transport: MCPTransportStreamableHTTP},
+		{Name: shared.MCPServiceGitHub, Title: "GitHub", URL: "http://127.0.0.1:1", Transport: MCPTransportStreamableHTTP},
+	}, false)
+	defer agent.mcpHandler.Close()
+
+	var progress int
+	outcome, err := agent.runResearchAgent(context.Background(), shared.JobMessage{
+		JobID:       "job-5",
+		Query:       "How do Go generics work?",
+		MCPServices: []shared.MCPService{shared.MCPServiceWeb, shared.MCPServiceGitHub},
+	}, agent.prompts.forType(shared.ResearchTypeGeneral), func([]shared.TraceStep) { progress++ })
+	if err != nil {
+		t.Fatalf("runResearchAgent failed: %v", err)
+	}
+
+	if outcome.report != "# Report\n\nGo is great." {
+		t.Errorf("Unexpected report: %q", outcome.report)
+	}
+	if got := traceTypes(outcome.trace); got != "plan,tool_call,plan,tool_call,plan,report" {
+		t.Errorf("Unexpected trace: %s", got)
+	}
+	if progress != len(outcome.trace) {
+		t.Errorf("Expected progress after each of %d steps, got %d", len(outcome.trace), progress)
+	}
+
+	search := outcome.trace[1]
+	if search.Service != shared.MCPServiceWeb || search.Tool != "web_search" || search.Arguments["q"] != "go generics" {
+		t.Errorf("Unexpected tool call step: %+v", search)
+	}
+	if !strings.Contains(search.Result, "Results for go generics") || len(search.Sources) != 3 {
+		t.Errorf("Expected the tool result in the trace, got %+v", search)
+	}
+	if outcome.trace[3].Error == "" {
+		t.Error("Expected an error for an unknown tool")
+	}
+	if len(outcome.sources) != 3 || !strings.Contains(outcome.gathered, "Results for go generics") {
+		t.Errorf("Unexpected gathered data: %v %q", outcome.sources, outcome.gathered)
+	}
+
+	// The unreachable server is reported along with the one that answered
+	if len(outcome.services) != 2 {
+		t.Fatalf("Expected an outcome per service, got %+v", outcome.services)
+	}
+	if github := outcome.services[0]; github.Service != shared.MCPServiceGitHub || github.Status != shared.ServiceStatusError || github.Calls != 0 {
+		t.Errorf("Expected the GitHub server to be unreachable, got %+v", github)
+	}
+	if web := outcome.services[1]; web.Status != shared.ServiceStatusOK || web.Calls != 1 || web.Bytes == 0 {
+		t.Errorf("Expected one successful web search, got %+v", web)
+	}
+}
+
+func TestResearchAgentStepBudget(t *testing.T) {
+	t.Setenv("AGENT_MAX_STEPS", "2")
+
+	ollama := newFakeOllamaChat(t, func([]ChatMessage) ChatMessage {
+		return toolCall("web__search", map[string]interface{}{"query": "go"})
+	})
+
+	agent := NewResearchAgent()
+	agent.initMCPServices()
+	agent.mcpHandler.testMode = true
+	agent.llm = newOllamaProvider(ollama.URL, ollama.Client(), "llama3.2")
+
+	outcome, err := agent.runResearchAgent(context.Background(), shared.JobMessage{
+		JobID:       "job-6",
+		Query:       "Go",
+		MCPServices: []shared.MCPService{shared.MCPServiceWeb},
+	}, agent.prompts.forType(shared.ResearchTypeGeneral), nil)
+	if err != nil {
+		t.Fatalf("runResearchAgent failed: %v", err)
+	}
+
+	if got := traceTypes(outcome.trace); got != "plan,tool_call,plan,tool_call,note,report" {
+		t.Errorf("Unexpected trace: %s", got)
+	}
+}
+
+func TestResearchAgentSummarizesToolResultsToFitContext(t *testing.T) {
+	var summaries int
+	var planned []ChatMessage
+	ollama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoder := json.NewEncoder(w)
+		switch r.URL.Path {
+		case "/api/show":
+			w.Write([]byte(`{"parameters":"num_ctx 2048"}`))
+		case "/api/generate":
+			summaries++
+			encoder.Encode(OllamaResponse{Response: "Generics use type parameters [1].", Done: true})
+		case "/api/chat":
+			var req OllamaChatRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			if messageTokens(req.Messages) > 2048 {
+				t.Errorf("Expected every request to fit the context, got about %d tokens", messageTokens(req.Messages))
+			}
+			if len(req.Tools) == 0 {
+				encoder.Encode(OllamaChatResponse{Message: ChatMessage{Role: "assistant", Content: "# Report"}, Done: true})
+				return
+			}
+			planned = req.Messages
+			if len(req.Messages) == 2 {
+				encoder.Encode(OllamaChatResponse{Message: toolCall("web__web_search", map[string]interface{}{"q": "large"}), Done: true})
+				return
+			}
+			encoder.Encode(OllamaChatResponse{Message: ChatMessage{Role: "assistant", Content: "I have enough information."}, Done: true})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ollama.Close()
+	mcp := newFakeMCPServer(t)
+
+	agent := NewResearchAgent()
+	agent.llm = newOllamaProvider(ollama.URL, ollama.Client(), "llama3.2")
+	agent.mcpHandler = NewMCPServiceHandler([]mcpServerConfig{
+		{Name: shared.MCPServiceWeb, Title: "Web Search", URL: mcp.URL, Transport: MCPTransportStreamableHTTP},
+	}, false)
+	defer agent.mcpHandler.Close()
+
+	outcome, err := agent.runResearchAgent(context.Background(), shared.JobMessage{
+		JobID:       "job-10",
+		Query:       "How do Go generics work?",
+		MCPServices: []shared.MCPService{shared.MCPServiceWeb},
+	}, agent.prompts.forType(shared.ResearchTypeGeneral), nil)
+	if err != nil {
+		t.Fatalf("runResearchAgent failed: %v", err)
+	}
+
+	if got := traceTypes(outcome.trace); got != "plan,tool_call,note,plan,report" {
+		t.Errorf("Unexpected trace: %s", got)
+	}
+	if summaries < 2 || !strings.Contains(outcome.trace[2].Content, "2048-token context") {
+		t.Errorf("Expected the tool result to be summarized in chunks, got %d summaries and %+v", summaries, outcome.trace[2])
+	}
+	if len(planned) != 4 || planned[3].Content != strings.TrimSpace(strings.Repeat("Generics use type parameters [1].\n\n", summaries)) {
+		t.Errorf("Expected the model to see the summaries in place of the tool result, got %+v", planned)
+	}
+	if strings.Count(outcome.gathered, "Go generics use type parameters") != 600 {
+		t.Error("Expected the full tool result to be kept as gathered information")
+	}
+}
+
+func TestProcessResearchRequestWithoutToolSupport(t *testing.T) {
+	ollama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/chat" {
+			http.Error(w, `{"error":"llama2 does not support tools"}`, http.StatusBadRequest)
+			return
+		}
+		if r.URL.Path == "/api/show" {
+			http.NotFound(w, r)
+			return
+		}
+		var req OllamaRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if !strings.Contains(req.System, "software developer") {
+			t.Errorf("Expected the code research prompt, got %q", req.System)
+		}
+		if len(req.Format) == 0 {
+			t.Error("Expected the report schema to be requested")
+		}
+		if !strings.Contains(req.Prompt, "Sources: [1] https://example.com/research-1, [2] https://example.com/research-2") {
+			t.Errorf("Expected the gathered data to be labelled with numbered sources, got %q", req.Prompt)
+		}
+		json.NewEncoder(w).Encode(OllamaResponse{Response: `{"summary":"Pipeline report [2] [9]","key_findings":[],"sections":[],"recommendations":[],"limitations":[]}`, Done: true})
+	}))
+	defer ollama.Close()
+
+	agent := NewResearchAgent()
+	agent.initMCPServices()
+	agent.mcpHandler.testMode = true
+	agent.llm = newOllamaProvider(ollama.URL, ollama.Client(), "llama3.2")
+
+	result, _ := agent.processResearchRequest(context.Background(), shared.JobMessage{
+		JobID:        "job-7",
+		Query:        "Research about Go microservices",
+		ResearchType: shared.ResearchTypeCode,
+		MCPServices:  []shared.MCPService{shared.MCPServiceWeb},
+	})
+	if result.Status != shared.JobStatusCompleted {
+		t.Fatalf("Expected status %s, got %s (%s)", shared.JobStatusCompleted, result.Status, result.Error)
+	}
+	if !strings.HasPrefix(result.Result, "## Summary\n\nPipeline report [2] [9]\n\n## Sources\n\n1. <https://example.com/research-1>") {
+		t.Errorf("Unexpected result: %q", result.Result)
+	}
+	if result.Report == nil || len(result.Report.Citations) != 3 {
+		t.Errorf("Expected the structured report to list the sources, got %+v", result.Report)
+	}
+	if len(result.References) != 3 || result.References[0].Cited || !result.References[1].Cited || result.References[1].Service != shared.MCPServiceWeb {
+		t.Errorf("Expected the second reference to be cited, got %+v", result.References)
+	}
+	if len(result.InvalidCitations) != 1 || result.InvalidCitations[0] != 9 {
+		t.Errorf("Expected [9] to be flagged, got %v", result.InvalidCitations)
+	}
+	if len(result.ServiceOutcomes) != 1 || result.ServiceOutcomes[0].Status != shared.ServiceStatusOK {
+		t.Errorf("Expected the web service to have answered, got %+v", result.ServiceOutcomes)
+	}
+	if result.Provenance != shared.ProvenanceSimulated || result.References[0].Provenance != shared.ProvenanceSimulated {
+		t.Errorf("Expected the test mode data to be marked simulated, got %q %+v", result.Provenance, result.References[0])
+	}
+	if got := traceTypes(result.Trace); got != "note,report,note" {
+		t.Errorf("Unexpected trace: %s", got)
+	}
+	if result.PromptTemplate != "code" || result.PromptVersion != 1 {
+		t.Errorf("Expected the prompt template to be recorded, got %s v%d", result.PromptTemplate, result.PromptVersion)
+	}
+}
+
+func TestResearchAgentWithoutTools(t *testing.T) {
+	agent := NewResearchAgent()
+	agent.mcpHandler = NewMCPServiceHandler(nil, true)
+
+	result, retryable := agent.processResearchRequest(context.Background(), shared.JobMessage{
+		JobID:       "job-8",
+		Query:       "Go",
+		MCPServices: []shared.MCPService{shared.MCPServiceWeb},
+	})
+	if result.Status != shared.JobStatusFailed || !retryable {
+		t.Errorf("Expected a retryable failure, got %s (retryable %v)", result.Status, retryable)
+	}
+	if !strings.HasPrefix(result.Error, "Failed to gather information") {
+		t.Errorf("Unexpected error: %q", result.Error)
+	}
+	if len(result.ServiceOutcomes) != 1 || result.ServiceOutcomes[0].Error != "not configured" {
+		t.Errorf("Expected the unconfigured service to be reported, got %+v", result.ServiceOutcomes)
+	}
+}
+
+func TestTruncateKeepsCharactersWhole(t *testing.T) {
+	if got := truncate("short", 10); got != "short" {
+		t.Errorf("Expected short text unchanged, got %q", got)
+	}
+	got := truncate("日本語のテキスト", 7)
+	if got != "日本\n[truncated]" || !utf8.ValidString(got) {
+		t.Errorf("Expected the cut before the third character, got %q", got)
+	}
+}
+
+func TestResearchRunCallsToolsConcurrently(t *testing.T) {
+	// Each call waits for the other, so calls made one after another time out
+	var arrived int32
+	both := make(chan struct{})
+	simulate := func(ra *ResearchAgent, query string) (string, []string, error) {
+		if atomic.AddInt32(&arrived, 1) == 2 {
+			close(both)
+		}
+		select {
+		case <-both:
+			return "Results for " + query, nil, nil
+		case <-time.After(2 * time.Second):
+			return "", nil, errors.New("the other call did not start")
+		}
+	}
+
+	agent := NewResearchAgent()
+	agent.initMCPServices()
+	run := &researchRun{ra: agent, tools: map[string]researchTool{
+		"web__search":    {service: shared.MCPServiceWeb, tool: MCPTool{Name: "search"}, simulate: simulate},
+		"github__search": {service: shared.MCPServiceGitHub, tool: MCPTool{Name: "search"}, simulate: simulate},
+	}}
+
+	web := toolCall("web__search", map[string]interface{}{"query": "go"}).ToolCalls[0]
+	github := toolCall("github__search", map[string]interface{}{"query": "go"}).ToolCalls[0]
+	results := run.callTools(context.Background(), []ToolCall{web, github})
+	if len(results) != 2 || results[0].step.Service != shared.MCPServiceWeb || results[1].step.Service != shared.MCPServiceGitHub {
+		t.Fatalf("Expected the results in call order, got %+v", results)
+	}
+	for _, result := range results {
+		if result.err != nil {
+			t.Errorf("Expected the calls to run concurrently, got %v", result.err)
+		}
+	}
+}
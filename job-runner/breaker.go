@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"microservices-demo/shared"
+)
+
+// Defaults of the circuit breakers guarding the MCP servers, overridable with
+// MCP_BREAKER_FAILURES, MCP_BREAKER_COOLDOWN and MCP_BREAKER_SUCCESSES
+const (
+	defaultBreakerFailures  = 3
+	defaultBreakerCooldown  = 30 * time.Second
+	defaultBreakerSuccesses = 1
+)
+
+// breakerSettings are the thresholds of a circuit breaker
+type breakerSettings struct {
+	// failures in a row open the breaker
+	failures int
+	// cooldown is how long an open breaker fails requests before it lets a
+	// trial request through
+	cooldown time.Duration
+	// successes of trial requests in a row close a half-open breaker
+	successes int
+}
+
+func breakerSettingsFromEnv() breakerSettings {
+	return breakerSettings{
+		failures:  getEnvInt("MCP_BREAKER_FAILURES", defaultBreakerFailures),
+		cooldown:  getEnvDuration("MCP_BREAKER_COOLDOWN", defaultBreakerCooldown),
+		successes: getEnvInt("MCP_BREAKER_SUCCESSES", defaultBreakerSuccesses),
+	}
+}
+
+// circuitOpenError is returned for requests an open breaker turns away
+type circuitOpenError struct {
+	service   shared.MCPService
+	failures  int
+	lastError string
+	retryAt   time.Time
+}
+
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("MCP service %s is unavailable: circuit breaker open after %d failures (last: %s), next attempt at %s",
+		e.service, e.failures, e.lastError, e.retryAt.Format(time.TimeOnly))
+}
+
+// errMCPCallTimeout is the cause of a call's context ending when the server
+// did not answer within the call timeout
+var errMCPCallTimeout = errors.New("MCP call timed out")
+
+// callerGaveUp reports whether ctx ended for another reason than the call
+// timeout, such as the job being cancelled or its deadline passing
+func callerGaveUp(ctx context.Context) bool {
+	return ctx.Err() != nil && !errors.Is(context.Cause(ctx), errMCPCallTimeout)
+}
+
+// isServerFailure tells whether an error means the server could not be
+// reached or did not answer in time, rather than that it answered with an
+// error. Cancelled jobs say nothing about the server.
+func isServerFailure(err error) bool {
+	var rpcErr *jsonrpcError
+	var toolErr *mcpToolError
+	switch {
+	case err == nil, errors.As(err, &rpcErr), errors.As(err, &toolErr):
+		return false
+	case errors.Is(err, errMCPSessionExpired), errors.Is(err, context.Canceled):
+		return false
+	}
+	return true
+}
+
+// circuitBreaker stops sending requests to an MCP server that keeps failing,
+// so jobs fail fast instead of each waiting out the call timeout. It also
+// keeps the result of the latest health probe.
+type circuitBreaker struct {
+	service  shared.MCPService
+	settings breakerSettings
+	now      func() time.Time
+
+	mu    sync.Mutex
+	state shared.BreakerState
+	// failures counts server failures in a row and successes trial
+	// requests in a row that got an answer while half-open
+	failures  int
+	successes int
+	// trial is set while the trial request of a half-open breaker runs
+	trial     bool
+	openedAt  time.Time
+	lastError string
+
+	healthy      bool
+	lastProbe    time.Time
+	probeLatency time.Duration
+}
+
+func newCircuitBreaker(service shared.MCPService, settings breakerSettings) *circuitBreaker {
+	return &circuitBreaker{
+		service:  service,
+		settings: settings,
+		now:      time.Now,
+		state:    shared.BreakerClosed,
+	}
+}
+
+// allow reports whether a request may go to the server, and whether it is
+// the trial request of a half-open breaker. An open breaker turns half-open
+// once its cooldown has passed.
+func (b *circuitBreaker) allow() (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == shared.BreakerOpen {
+		if b.now().Before(b.openedAt.Add(b.settings.cooldown)) {
+			return false, b.openError()
+		}
+		b.halfOpen()
+	}
+	if b.state == shared.BreakerHalfOpen {
+		if b.trial {
+			return false, b.openError()
+		}
+		b.trial = true
+		return true, nil
+	}
+	return false, nil
+}
+
+// record notes how a request that allow let through ended
+func (b *circuitBreaker) record(trial bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if trial {
+		b.trial = false
+	}
+	if isServerFailure(err) {
+		b.fail(err)
+		return
+	}
+
+	b.failures = 0
+	if b.state == shared.BreakerHalfOpen {
+		b.successes++
+		if b.successes >= b.settings.successes {
+			b.state = shared.BreakerClosed
+		}
+	}
+}
+
+// release ends a request that allow let through without judging the server
+func (b *circuitBreaker) release(trial bool) {
+	if !trial {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+// probed records a health probe. A server that answers a probe gets a trial
+// request at once; one that does not counts as failing, so the breaker can
+// open before any job waits for the server.
+func (b *circuitBreaker) probed(err error, latency time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.healthy = !isServerFailure(err)
+	b.lastProbe = b.now()
+	b.probeLatency = latency
+
+	switch {
+	case !b.healthy:
+		b.fail(err)
+	case b.state == shared.BreakerOpen:
+		b.halfOpen()
+	}
+}
+
+// fail counts a server failure; callers hold mu
+func (b *circuitBreaker) fail(err error) {
+	b.failures++
+	b.lastError = err.Error()
+
+	if b.state == shared.BreakerClosed && b.failures < b.settings.failures {
+		return
+	}
+	// A failed trial reopens the breaker and a failed probe of an open one
+	// starts another cooldown
+	b.state = shared.BreakerOpen
+	b.openedAt = b.now()
+}
+
+// halfOpen lets trial requests through; callers hold mu
+func (b *circuitBreaker) halfOpen() {
+	b.state = shared.BreakerHalfOpen
+	b.successes = 0
+}
+
+// openError describes why a request was turned away; callers hold mu
+func (b *circuitBreaker) openError() error {
+	return &circuitOpenError{
+		service:   b.service,
+		failures:  b.failures,
+		lastError: b.lastError,
+		retryAt:   b.openedAt.Add(b.settings.cooldown),
+	}
+}
+
+// health describes the breaker and the latest probe
+func (b *circuitBreaker) health() shared.MCPServiceHealth {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	health := shared.MCPServiceHealth{
+		Name:                b.service,
+		Breaker:             b.state,
+		ConsecutiveFailures: b.failures,
+		LastError:           b.lastError,
+		Healthy:             b.healthy,
+	}
+	if b.state == shared.BreakerOpen {
+		retryAt := b.openedAt.Add(b.settings.cooldown)
+		health.RetryAt = &retryAt
+	}
+	if !b.lastProbe.IsZero() {
+		lastProbe := b.lastProbe
+		health.LastProbe = &lastProbe
+		health.ProbeLatencyMs = b.probeLatency.Milliseconds()
+	}
+	return health
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"microservices-demo/shared"
+)
+
+// newTestBreaker returns a breaker whose clock the test moves
+func newTestBreaker(settings breakerSettings) (*circuitBreaker, *time.Time) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	breaker := newCircuitBreaker(shared.MCPServiceWeb, settings)
+	breaker.now = func() time.Time { return now }
+	return breaker, &now
+}
+
+// request runs one request through the breaker ending with err
+func request(breaker *circuitBreaker, err error) error {
+	trial, allowErr := breaker.allow()
+	if allowErr != nil {
+		return allowErr
+	}
+	breaker.record(trial, err)
+	return nil
+}
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	breaker, now := newTestBreaker(breakerSettings{failures: 2, cooldown: 30 * time.Second, successes: 2})
+	down := errors.New("connection refused")
+
+	request(breaker, down)
+	if breaker.state != shared.BreakerClosed {
+		t.Fatalf("Expected one failure to keep the breaker closed, got %s", breaker.state)
+	}
+	request(breaker, down)
+	if breaker.state != shared.BreakerOpen {
+		t.Fatalf("Expected two failures to open the breaker, got %s", breaker.state)
+	}
+
+	var openErr *circuitOpenError
+	if err := request(breaker, nil); !errors.As(err, &openErr) || openErr.failures != 2 || openErr.lastError != "connection refused" {
+		t.Fatalf("Expected the open breaker to turn requests away, got %v", err)
+	}
+	health := breaker.health()
+	if health.RetryAt == nil || !health.RetryAt.Equal(now.Add(30*time.Second)) || health.ConsecutiveFailures != 2 {
+		t.Errorf("Unexpected health: %+v", health)
+	}
+
+	// After the cooldown a failing trial reopens the breaker
+	*now = now.Add(30 * time.Second)
+	if err := request(breaker, down); err != nil {
+		t.Fatalf("Expected a trial request after the cooldown, got %v", err)
+	}
+	if breaker.state != shared.BreakerOpen {
+		t.Fatalf("Expected a failed trial to reopen the breaker, got %s", breaker.state)
+	}
+
+	// Two successful trials close it again
+	*now = now.Add(30 * time.Second)
+	request(breaker, nil)
+	if breaker.state != shared.BreakerHalfOpen {
+		t.Fatalf("Expected one success to keep the breaker half-open, got %s", breaker.state)
+	}
+	request(breaker, nil)
+	if breaker.state != shared.BreakerClosed || breaker.failures != 0 {
+		t.Errorf("Expected two successes to close the breaker, got %s after %d failures", breaker.state, breaker.failures)
+	}
+}
+
+func TestCircuitBreakerSingleTrial(t *testing.T) {
+	breaker, now := newTestBreaker(breakerSettings{failures: 1, cooldown: time.Second, successes: 1})
+	request(breaker, errors.New("timeout"))
+	*now = now.Add(time.Second)
+
+	trial, err := breaker.allow()
+	if err != nil || !trial {
+		t.Fatalf("Expected a trial request, got %v %v", trial, err)
+	}
+	if _, err := breaker.allow(); err == nil {
+		t.Error("Expected a second request to wait for the trial")
+	}
+	breaker.record(trial, nil)
+	if _, err := breaker.allow(); err != nil || breaker.state != shared.BreakerClosed {
+		t.Errorf("Expected the breaker to close after the trial, got %s %v", breaker.state, err)
+	}
+}
+
+func TestCircuitBreakerIgnoresAnswers(t *testing.T) {
+	breaker, _ := newTestBreaker(breakerSettings{failures: 1, cooldown: time.Minute, successes: 1})
+
+	// The server answered these, so it is up
+	for _, err := range []error{
+		&jsonrpcError{Code: -32602, Message: "unknown tool"},
+		&mcpToolError{tool: "web_search", message: "rate limited"},
+		errMCPSessionExpired,
+		context.Canceled,
+	} {
+		request(breaker, err)
+		if breaker.state != shared.BreakerClosed {
+			t.Fatalf("Expected %v not to open the breaker", err)
+		}
+	}
+
+	request(breaker, context.DeadlineExceeded)
+	if breaker.state != shared.BreakerOpen {
+		t.Errorf("Expected a timeout to open the breaker, got %s", breaker.state)
+	}
+}
+
+func TestCircuitBreakerProbes(t *testing.T) {
+	breaker, now := newTestBreaker(breakerSettings{failures: 2, cooldown: time.Minute, successes: 1})
+
+	breaker.probed(errors.New("connection refused"), time.Millisecond)
+	breaker.probed(errors.New("connection refused"), time.Millisecond)
+	if breaker.state != shared.BreakerOpen {
+		t.Fatalf("Expected failed probes to open the breaker, got %s", breaker.state)
+	}
+
+	// A failed probe while open starts another cooldown
+	*now = now.Add(time.Minute)
+	breaker.probed(errors.New("connection refused"), time.Millisecond)
+	if _, err := breaker.allow(); err == nil {
+		t.Fatal("Expected the breaker to stay open")
+	}
+
+	// A server that answers gets a trial request without waiting
+	breaker.probed(nil, 3*time.Millisecond)
+	if breaker.state != shared.BreakerHalfOpen {
+		t.Fatalf("Expected a healthy probe to half-open the breaker, got %s", breaker.state)
+	}
+	health := breaker.health()
+	if !health.Healthy || health.LastProbe == nil || health.ProbeLatencyMs != 3 || health.RetryAt != nil {
+		t.Errorf("Unexpected health: %+v", health)
+	}
+}
+
+func TestGuardIgnoresCallerContext(t *testing.T) {
+	handler := NewMCPServiceHandler([]mcpServerConfig{{Name: shared.MCPServiceWeb, URL: "http://127.0.0.1:1"}}, false)
+	handler.setBreakerSettings(breakerSettings{failures: 1, cooldown: time.Minute, successes: 1})
+	breaker := handler.breakers[shared.MCPServiceWeb]
+	wait := func(ctx context.Context) func() error {
+		return func() error {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+	}
+
+	// The job's own deadline passing says nothing about the server
+	jobCtx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	callCtx, cancelCall := handler.withCallTimeout(jobCtx, shared.MCPServiceWeb)
+	defer cancelCall()
+	handler.guard(callCtx, shared.MCPServiceWeb, wait(callCtx))
+	if breaker.state != shared.BreakerClosed || breaker.failures != 0 {
+		t.Fatalf("Expected the job's deadline not to count, got %s after %d failures", breaker.state, breaker.failures)
+	}
+
+	// The server missing its call timeout does
+	handler.servers[shared.MCPServiceWeb] = mcpServerConfig{Name: shared.MCPServiceWeb, timeout: time.Millisecond}
+	callCtx, cancelCall = handler.withCallTimeout(context.Background(), shared.MCPServiceWeb)
+	defer cancelCall()
+	handler.guard(callCtx, shared.MCPServiceWeb, wait(callCtx))
+	if breaker.state != shared.BreakerOpen {
+		t.Errorf("Expected the call timeout to open the breaker, got %s", breaker.state)
+	}
+}
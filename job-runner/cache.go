@@ -0,0 +1,313 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"microservices-demo/shared"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// defaultMCPCacheTTL is how long MCP results are cached for servers without
+// a cache TTL of their own, overridable with MCP_CACHE_TTL
+const defaultMCPCacheTTL = time.Hour
+
+// mcpCachePruneInterval is how often expired results are removed
+const mcpCachePruneInterval = 10 * time.Minute
+
+// mcpResearchMethod keys the fixed pipeline's call of a service's research
+// tool, which the registry chooses per server
+const mcpResearchMethod = "research"
+
+// mcpCacheEntry is a cached MCP tool result
+type mcpCacheEntry struct {
+	Service   shared.MCPService `json:"service"`
+	Method    string            `json:"method"`
+	Data      string            `json:"data"`
+	Sources   []string          `json:"sources,omitempty"`
+	StoredAt  time.Time         `json:"stored_at"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// MCPResultCache stores MCP tool results by content-addressed key
+type MCPResultCache interface {
+	// Get returns the entry stored under key unless it expired before now
+	Get(key string, now time.Time) (*mcpCacheEntry, bool, error)
+	// Put stores an entry under key, replacing any earlier one
+	Put(key string, entry mcpCacheEntry) error
+	// Prune removes the entries that expired before now and returns how
+	// many it removed
+	Prune(now time.Time) (int, error)
+	// Close releases any resources held by the cache
+	Close() error
+}
+
+// newMCPCacheFromEnv creates the result cache selected by MCP_CACHE, or nil
+// if caching is off
+func newMCPCacheFromEnv() (MCPResultCache, error) {
+	switch backend := getEnvOrDefault("MCP_CACHE", "memory"); backend {
+	case "off":
+		return nil, nil
+	case "memory":
+		return NewMemoryMCPCache(), nil
+	case "bolt":
+		return NewBoltMCPCache(getEnvOrDefault("MCP_CACHE_PATH", "data/mcp-cache.db"))
+	default:
+		return nil, fmt.Errorf("unknown MCP_CACHE backend: %s", backend)
+	}
+}
+
+// mcpCacheKey addresses a tool call by its service, method and normalized
+// params. Map keys are sorted by the JSON encoding, so the order the
+// arguments were given in does not matter.
+func mcpCacheKey(service shared.MCPService, method string, params map[string]interface{}) string {
+	raw, _ := json.Marshal(struct {
+		Service shared.MCPService `json:"service"`
+		Method  string            `json:"method"`
+		Params  interface{}       `json:"params"`
+	}{service, method, normalizeCacheParam(params)})
+
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeCacheParam collapses runs of whitespace in every string so
+// queries that differ only in spacing share a key
+func normalizeCacheParam(value interface{}) interface{} {
+	switch value := value.(type) {
+	case string:
+		return strings.Join(strings.Fields(value), " ")
+	case map[string]interface{}:
+		normalized := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			normalized[key] = normalizeCacheParam(item)
+		}
+		return normalized
+	case []interface{}:
+		normalized := make([]interface{}, len(value))
+		for i, item := range value {
+			normalized[i] = normalizeCacheParam(item)
+		}
+		return normalized
+	}
+	return value
+}
+
+// researchCacheParams are the params of the fixed pipeline's research call.
+// The query is case-folded as the search tools behind it are not case
+// sensitive.
+func researchCacheParams(query string, arguments map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"query":     strings.ToLower(query),
+		"arguments": arguments,
+	}
+}
+
+// cachedMCPCall answers a tool call from the result cache, or makes it with
+// call and caches what it returned. It reports whether the result came from
+// the cache. noCache skips the lookup but still caches the fresh result.
+// Test mode and services with caching disabled always make the call.
+func (ra *ResearchAgent) cachedMCPCall(service shared.MCPService, method string, params map[string]interface{}, noCache bool, call func() (string, []string, error)) (string, []string, bool, error) {
+	ttl := ra.mcpHandler.cacheTTL(service)
+	if ra.mcpCache == nil || ra.mcpHandler.testMode || ttl <= 0 {
+		data, sources, err := call()
+		return data, sources, false, err
+	}
+
+	key := mcpCacheKey(service, method, params)
+	if !noCache {
+		entry, ok, err := ra.mcpCache.Get(key, time.Now())
+		if err != nil {
+			log.Printf("Failed to read cached result of MCP service %s: %v", service, err)
+		}
+		if ok {
+			return entry.Data, entry.Sources, true, nil
+		}
+	}
+
+	data, sources, err := call()
+	if err != nil {
+		return "", nil, false, err
+	}
+
+	now := time.Now()
+	entry := mcpCacheEntry{
+		Service:   service,
+		Method:    method,
+		Data:      data,
+		Sources:   sources,
+		StoredAt:  now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if err := ra.mcpCache.Put(key, entry); err != nil {
+		log.Printf("Failed to cache result of MCP service %s: %v", service, err)
+	}
+	return data, sources, false, nil
+}
+
+// pruneMCPCache periodically removes expired results from the cache
+func (ra *ResearchAgent) pruneMCPCache() {
+	ticker := time.NewTicker(mcpCachePruneInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		removed, err := ra.mcpCache.Prune(time.Now())
+		if err != nil {
+			log.Printf("Failed to prune MCP result cache: %v", err)
+			continue
+		}
+		if removed > 0 {
+			log.Printf("Pruned %d expired MCP results from the cache", removed)
+		}
+	}
+}
+
+// MemoryMCPCache keeps results in memory; they are lost on restart
+type MemoryMCPCache struct {
+	mu      sync.RWMutex
+	entries map[string]mcpCacheEntry
+}
+
+// NewMemoryMCPCache creates an empty in-memory result cache
+func NewMemoryMCPCache() *MemoryMCPCache {
+	return &MemoryMCPCache{entries: make(map[string]mcpCacheEntry)}
+}
+
+func (m *MemoryMCPCache) Get(key string, now time.Time) (*mcpCacheEntry, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entry, ok := m.entries[key]
+	if !ok || !now.Before(entry.ExpiresAt) {
+		return nil, false, nil
+	}
+	return &entry, true, nil
+}
+
+func (m *MemoryMCPCache) Put(key string, entry mcpCacheEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[key] = entry
+	return nil
+}
+
+func (m *MemoryMCPCache) Prune(now time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	removed := 0
+	for key, entry := range m.entries {
+		if !now.Before(entry.ExpiresAt) {
+			delete(m.entries, key)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+func (m *MemoryMCPCache) Close() error {
+	return nil
+}
+
+var boltMCPResultsBucket = []byte("mcp_results")
+
+// BoltMCPCache keeps results in an embedded BoltDB file, so they survive
+// job runner restarts
+type BoltMCPCache struct {
+	db *bolt.DB
+}
+
+// NewBoltMCPCache opens (or creates) the cache database at path
+func NewBoltMCPCache(path string) (*BoltMCPCache, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create MCP cache directory: %w", err)
+		}
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open MCP cache: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltMCPResultsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize MCP cache: %w", err)
+	}
+
+	return &BoltMCPCache{db: db}, nil
+}
+
+func (b *BoltMCPCache) Get(key string, now time.Time) (*mcpCacheEntry, bool, error) {
+	var entry mcpCacheEntry
+	var found bool
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltMCPResultsBucket).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(data, &entry)
+	})
+	if err != nil || !found || !now.Before(entry.ExpiresAt) {
+		return nil, false, err
+	}
+	return &entry, true, nil
+}
+
+func (b *BoltMCPCache) Put(key string, entry mcpCacheEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltMCPResultsBucket).Put([]byte(key), data)
+	})
+}
+
+func (b *BoltMCPCache) Prune(now time.Time) (int, error) {
+	removed := 0
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltMCPResultsBucket)
+
+		// Collect first; deleting while iterating skips keys
+		var expired [][]byte
+		err := bucket.ForEach(func(key, data []byte) error {
+			var entry mcpCacheEntry
+			if err := json.Unmarshal(data, &entry); err != nil || !now.Before(entry.ExpiresAt) {
+				expired = append(expired, append([]byte(nil), key...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, key := range expired {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		removed = len(expired)
+		return nil
+	})
+	return removed, err
+}
+
+func (b *BoltMCPCache) Close() error {
+	return b.db.Close()
+}
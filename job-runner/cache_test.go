@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"microservices-demo/shared"
+)
+
+func TestMCPCacheKey(t *testing.T) {
+	key := mcpCacheKey(shared.MCPServiceWeb, "web_search", map[string]interface{}{"q": "go  generics ", "count": 10})
+
+	// Spacing and argument order do not matter
+	if same := mcpCacheKey(shared.MCPServiceWeb, "web_search", map[string]interface{}{"count": 10.0, "q": " go generics"}); same != key {
+		t.Error("Expected equivalent params to share a key")
+	}
+
+	for name, other := range map[string]string{
+		"service": mcpCacheKey(shared.MCPServiceGitHub, "web_search", map[string]interface{}{"q": "go generics", "count": 10}),
+		"method":  mcpCacheKey(shared.MCPServiceWeb, "fetch", map[string]interface{}{"q": "go generics", "count": 10}),
+		"params":  mcpCacheKey(shared.MCPServiceWeb, "web_search", map[string]interface{}{"q": "go generics", "count": 5}),
+	} {
+		if other == key {
+			t.Errorf("Expected a different %s to change the key", name)
+		}
+	}
+
+	if researchCacheParams("Go Generics", nil)["query"] != "go generics" {
+		t.Error("Expected the research query to be case-folded")
+	}
+}
+
+func TestMCPResultCacheBackends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache", "mcp.db")
+	bolt, err := NewBoltMCPCache(path)
+	if err != nil {
+		t.Fatalf("NewBoltMCPCache failed: %v", err)
+	}
+
+	backends := map[string]MCPResultCache{
+		"memory": NewMemoryMCPCache(),
+		"bolt":   bolt,
+	}
+	for name, cache := range backends {
+		t.Run(name, func(t *testing.T) {
+			now := time.Now()
+			cache.Put("fresh", mcpCacheEntry{Service: shared.MCPServiceWeb, Data: "results", Sources: []string{"https://go.dev"}, ExpiresAt: now.Add(time.Hour)})
+			cache.Put("stale", mcpCacheEntry{Service: shared.MCPServiceWeb, Data: "old", ExpiresAt: now.Add(-time.Second)})
+
+			entry, ok, err := cache.Get("fresh", now)
+			if err != nil || !ok || entry.Data != "results" || len(entry.Sources) != 1 {
+				t.Errorf("Expected the fresh entry, got %+v %v %v", entry, ok, err)
+			}
+			if _, ok, _ := cache.Get("stale", now); ok {
+				t.Error("Expected the expired entry to be a miss")
+			}
+			if _, ok, _ := cache.Get("missing", now); ok {
+				t.Error("Expected an unknown key to be a miss")
+			}
+
+			if removed, err := cache.Prune(now); err != nil || removed != 1 {
+				t.Errorf("Expected one expired entry to be pruned, got %d %v", removed, err)
+			}
+		})
+	}
+
+	// The bolt cache survives a restart
+	bolt.Close()
+	reopened, err := NewBoltMCPCache(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen the cache: %v", err)
+	}
+	defer reopened.Close()
+	if _, ok, _ := reopened.Get("fresh", time.Now()); !ok {
+		t.Error("Expected the cached result to persist")
+	}
+}
+
+func TestGatherUsesMCPCache(t *testing.T) {
+	search := newFakeMCPServer(t)
+	agent := NewResearchAgent()
+	agent.mcpCache = NewMemoryMCPCache()
+	agent.mcpHandler = NewMCPServiceHandler([]mcpServerConfig{
+		{Name: shared.MCPServiceWeb, URL: search.URL, Transport: MCPTransportStreamableHTTP, Tools: []string{"web_search"}},
+	}, false)
+	defer agent.mcpHandler.Close()
+
+	job := shared.JobMessage{Query: "Go generics", MCPServices: []shared.MCPService{shared.MCPServiceWeb}}
+	if _, _, outcomes, err := agent.gatherInformationWithMCP(context.Background(), job); err != nil || outcomes[0].CacheHits != 0 {
+		t.Fatalf("Expected the first query to reach the server, got %+v %v", outcomes, err)
+	}
+
+	// A near-identical query is answered without the server
+	search.Close()
+	job.Query = "go  generics"
+	data, _, outcomes, err := agent.gatherInformationWithMCP(context.Background(), job)
+	if err != nil {
+		t.Fatalf("Expected a cached answer, got %v", err)
+	}
+	if !strings.Contains(data, "Results for Go generics") || outcomes[0].CacheHits != 1 || outcomes[0].Provenance != shared.ProvenanceReal {
+		t.Errorf("Expected the cached result, got %+v %q", outcomes[0], data)
+	}
+	if hits := cacheHits(outcomes); hits != 1 {
+		t.Errorf("Expected one cache hit for the job, got %d", hits)
+	}
+
+	job.NoCache = true
+	if _, _, _, err := agent.gatherInformationWithMCP(context.Background(), job); err == nil {
+		t.Error("Expected no_cache to query the stopped server")
+	}
+}
+
+func TestResearchToolUsesMCPCache(t *testing.T) {
+	search := newFakeMCPServer(t)
+	agent := NewResearchAgent()
+	agent.mcpCache = NewMemoryMCPCache()
+	agent.mcpHandler = NewMCPServiceHandler([]mcpServerConfig{
+		{Name: shared.MCPServiceWeb, URL: search.URL, Transport: MCPTransportStreamableHTTP},
+	}, false)
+	defer agent.mcpHandler.Close()
+
+	tool := researchTool{service: shared.MCPServiceWeb, tool: MCPTool{Name: "web_search"}}
+	arguments := map[string]interface{}{"q": "go"}
+
+	// Failed calls are not cached
+	if _, _, _, err := agent.runResearchTool(context.Background(), tool, map[string]interface{}{"q": "fail"}, false); err == nil {
+		t.Fatal("Expected the tool error")
+	}
+	if _, _, cached, err := agent.runResearchTool(context.Background(), tool, arguments, false); err != nil || cached {
+		t.Fatalf("Expected the first call to reach the server, got %v %v", cached, err)
+	}
+
+	search.Close()
+	data, sources, cached, err := agent.runResearchTool(context.Background(), tool, arguments, false)
+	if err != nil || !cached || !strings.Contains(data, "Results for go") || len(sources) != 3 {
+		t.Errorf("Expected the cached result, got %q %v %v %v", data, sources, cached, err)
+	}
+	if _, _, _, err := agent.runResearchTool(context.Background(), tool, map[string]interface{}{"q": "fail"}, false); err == nil {
+		t.Error("Expected the failed call to miss the cache")
+	}
+
+	// Caching can be turned off per service
+	agent.mcpHandler.defaultCacheTTL = 0
+	if _, _, _, err := agent.runResearchTool(context.Background(), tool, arguments, false); err == nil {
+		t.Error("Expected the call to skip the cache")
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"microservices-demo/shared"
+)
+
+// citationPattern matches citation markers such as [3] or [1, 4]
+var citationPattern = regexp.MustCompile(`\[(\d+(?:\s*,\s*\d+)*)\]`)
+
+// citationIndex numbers the sources of the gathered information so the
+// report can cite them as [n]
+type citationIndex struct {
+	references []shared.Reference
+	// byURL finds the number of a source gathered before
+	byURL map[string]int
+}
+
+func newCitationIndex() *citationIndex {
+	return &citationIndex{byURL: make(map[string]int)}
+}
+
+// add numbers the sources of a tool result and returns its text labelled
+// with their numbers for the model. A source seen before keeps its number;
+// a result without sources is numbered by its tool and service. Simulated
+// results are labelled as such.
+func (c *citationIndex) add(service shared.MCPService, tool, text string, sources []string, provenance shared.Provenance) string {
+	var labels []string
+	for _, source := range sources {
+		number, ok := c.byURL[source]
+		if !ok {
+			number = c.append(shared.Reference{Service: service, Tool: tool, URL: source, Provenance: provenance})
+			c.byURL[source] = number
+		}
+		labels = append(labels, fmt.Sprintf("[%d] %s", number, source))
+	}
+	if len(labels) == 0 {
+		reference := shared.Reference{Service: service, Tool: tool, Provenance: provenance}
+		reference.Number = c.append(reference)
+		labels = append(labels, fmt.Sprintf("[%d] %s", reference.Number, reference.Label()))
+	}
+
+	label := "Sources: " + strings.Join(labels, ", ")
+	if provenance == shared.ProvenanceSimulated {
+		label += "\nSimulated data: placeholder findings, not from a real source"
+	}
+	return label + "\n\n" + text
+}
+
+func (c *citationIndex) append(reference shared.Reference) int {
+	reference.Number = len(c.references) + 1
+	c.references = append(c.references, reference)
+	return reference.Number
+}
+
+// urls lists the URLs of the sources in the order they were numbered
+func (c *citationIndex) urls() []string {
+	var urls []string
+	for _, reference := range c.references {
+		if reference.URL != "" {
+			urls = append(urls, reference.URL)
+		}
+	}
+	return urls
+}
+
+// checkCitations marks the references the report cites and returns the
+// cited numbers that match no reference, in ascending order
+func checkCitations(report string, references []shared.Reference) []int {
+	invalid := make(map[int]bool)
+	for _, match := range citationPattern.FindAllStringSubmatchIndex(report, -1) {
+		// A marker followed by ( is the text of a markdown link
+		if match[1] < len(report) && report[match[1]] == '(' {
+			continue
+		}
+		for _, field := range strings.Split(report[match[2]:match[3]], ",") {
+			number, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil {
+				continue
+			}
+			if number < 1 || number > len(references) {
+				invalid[number] = true
+				continue
+			}
+			references[number-1].Cited = true
+		}
+	}
+
+	numbers := make([]int, 0, len(invalid))
+	for number := range invalid {
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+	return numbers
+}
+
+// reportCitations lists the references as the citations of a structured
+// report, so [n] in its text refers to Citations[n-1]
+func reportCitations(references []shared.Reference) []shared.Citation {
+	citations := make([]shared.Citation, 0, len(references))
+	for _, reference := range references {
+		citation := shared.Citation{URL: reference.URL}
+		if reference.URL == "" {
+			citation.Title = reference.Label()
+		}
+		citations = append(citations, citation)
+	}
+	return citations
+}
+
+// linkCitations checks the report's citations against the gathered sources
+// and lists the sources in the structured report. Made-up citations are
+// recorded in the trace; the report is kept, since its other claims may
+// still be sound.
+func (o *researchOutcome) linkCitations() {
+	if o.report == "" {
+		return
+	}
+	o.invalidCitations = checkCitations(o.report, o.references)
+
+	if o.structured != nil {
+		o.structured.Citations = reportCitations(o.references)
+		o.report = o.structured.Markdown()
+	}
+
+	if len(o.invalidCitations) > 0 {
+		var markers []string
+		for _, number := range o.invalidCitations {
+			markers = append(markers, fmt.Sprintf("[%d]", number))
+		}
+		o.trace = append(o.trace, shared.TraceStep{
+			Step:    len(o.trace) + 1,
+			Type:    shared.TraceStepNote,
+			Content: fmt.Sprintf("The report cites %s, which match none of the %d gathered sources", strings.Join(markers, ", "), len(o.references)),
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"microservices-demo/shared"
+)
+
+func TestCitationIndex(t *testing.T) {
+	citations := newCitationIndex()
+
+	first := citations.add(shared.MCPServiceWeb, "web_search", "Go 1.22 routing", []string{"https://go.dev/blog", "https://go.dev/doc"}, shared.ProvenanceReal)
+	if !strings.HasPrefix(first, "Sources: [1] https://go.dev/blog, [2] https://go.dev/doc\n\nGo 1.22 routing") {
+		t.Errorf("Unexpected labelled text: %q", first)
+	}
+
+	// A source found again keeps its number
+	second := citations.add(shared.MCPServiceGitHub, "search_code", "net/http mux", []string{"https://go.dev/doc", "https://github.com/golang/go"}, shared.ProvenanceReal)
+	if !strings.HasPrefix(second, "Sources: [2] https://go.dev/doc, [3] https://github.com/golang/go\n\n") {
+		t.Errorf("Unexpected labelled text: %q", second)
+	}
+
+	third := citations.add(shared.MCPServiceFiles, "read_file", "notes", nil, shared.ProvenanceSimulated)
+	if !strings.HasPrefix(third, "Sources: [4] read_file (files)\nSimulated data: ") {
+		t.Errorf("Expected a simulated result without sources to be numbered by its tool and labelled, got %q", third)
+	}
+
+	if len(citations.references) != 4 || citations.references[2].Service != shared.MCPServiceGitHub || citations.references[3].Number != 4 || citations.references[3].Provenance != shared.ProvenanceSimulated {
+		t.Errorf("Unexpected references: %+v", citations.references)
+	}
+	if urls := citations.urls(); len(urls) != 3 || urls[2] != "https://github.com/golang/go" {
+		t.Errorf("Unexpected URLs: %v", urls)
+	}
+}
+
+func TestCheckCitations(t *testing.T) {
+	references := make([]shared.Reference, 5)
+	for i := range references {
+		references[i].Number = i + 1
+	}
+
+	report := "Routing got faster [1][3]. Both agree [2, 12]. See [the docs](https://go.dev) and [4](https://example.com). Also [0] and [7]."
+	invalid := checkCitations(report, references)
+
+	if !reflect.DeepEqual(invalid, []int{0, 7, 12}) {
+		t.Errorf("Expected [0], [7] and [12] to be flagged, got %v", invalid)
+	}
+	var cited []int
+	for _, reference := range references {
+		if reference.Cited {
+			cited = append(cited, reference.Number)
+		}
+	}
+	if !reflect.DeepEqual(cited, []int{1, 2, 3}) {
+		t.Errorf("Expected references 1 to 3 to be cited, got %v", cited)
+	}
+}
+
+func TestLinkCitations(t *testing.T) {
+	citations := newCitationIndex()
+	citations.add(shared.MCPServiceWeb, "web_search", "text", []string{"https://go.dev/blog"}, shared.ProvenanceReal)
+	citations.add(shared.MCPServiceFiles, "read_file", "text", nil, shared.ProvenanceReal)
+
+	_, structured := parseReport(`{"summary":"Fast [1].","key_findings":[],"sections":[],"recommendations":[],"limitations":[]}`)
+	outcome := researchOutcome{report: structured.Markdown(), structured: structured, references: citations.references}
+	outcome.linkCitations()
+
+	if len(outcome.invalidCitations) != 0 || len(outcome.trace) != 0 {
+		t.Errorf("Expected no invalid citations, got %v %+v", outcome.invalidCitations, outcome.trace)
+	}
+	expected := []shared.Citation{{URL: "https://go.dev/blog"}, {Title: "read_file (files)"}}
+	if !reflect.DeepEqual(structured.Citations, expected) {
+		t.Errorf("Unexpected citations: %+v", structured.Citations)
+	}
+	if !strings.HasSuffix(outcome.report, "## Sources\n\n1. <https://go.dev/blog>\n2. read_file (files)") {
+		t.Errorf("Expected the sources to be listed in the report, got %q", outcome.report)
+	}
+}
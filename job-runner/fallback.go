@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+
+	"microservices-demo/shared"
+)
+
+// Fallback policies decide what happens to a job when a real MCP server
+// fails: fail the job, skip the service, or substitute simulated data
+const (
+	mcpFallbackFail     = "fail"
+	mcpFallbackSkip     = "skip"
+	mcpFallbackSimulate = "simulate"
+)
+
+// mcpSimulator produces canned data for a query
+type mcpSimulator func(ra *ResearchAgent, query string) (string, []string, error)
+
+func validFallback(policy string) bool {
+	switch policy {
+	case mcpFallbackFail, mcpFallbackSkip, mcpFallbackSimulate:
+		return true
+	}
+	return false
+}
+
+// fallbackPolicy is the policy of a service's server, or MCP_FALLBACK if its
+// registry entry sets none
+func (h *MCPServiceHandler) fallbackPolicy(service shared.MCPService) string {
+	if policy := h.servers[service].Fallback; policy != "" {
+		return policy
+	}
+	return h.fallback
+}
+
+// fallbackSimulator returns the simulator standing in for a failed server,
+// if the service's policy allows simulated data and there is one
+func (h *MCPServiceHandler) fallbackSimulator(service shared.MCPService) (mcpSimulator, bool) {
+	if h.fallbackPolicy(service) != mcpFallbackSimulate {
+		return nil, false
+	}
+	simulate, ok := mcpSimulators[service]
+	return simulate, ok
+}
+
+// provenance is where data returned by the services comes from
+func (h *MCPServiceHandler) provenance() shared.Provenance {
+	if h.testMode {
+		return shared.ProvenanceSimulated
+	}
+	return shared.ProvenanceReal
+}
+
+// requiredServiceError reports the first registered service that returned
+// nothing although its policy is to fail the job
+func (h *MCPServiceHandler) requiredServiceError(outcomes []shared.ServiceOutcome) error {
+	for _, outcome := range outcomes {
+		if outcome.Status == shared.ServiceStatusOK || !h.has(outcome.Service) {
+			continue
+		}
+		if h.fallbackPolicy(outcome.Service) == mcpFallbackFail {
+			return fmt.Errorf("MCP service %s is required by its fallback policy but failed: %s", outcome.Service, outcome.Error)
+		}
+	}
+	return nil
+}
+
+// jobProvenance is simulated if any service's data was simulated
+func jobProvenance(outcomes []shared.ServiceOutcome) shared.Provenance {
+	var provenance shared.Provenance
+	for _, outcome := range outcomes {
+		switch outcome.Provenance {
+		case shared.ProvenanceSimulated:
+			return shared.ProvenanceSimulated
+		case shared.ProvenanceReal:
+			provenance = shared.ProvenanceReal
+		}
+	}
+	return provenance
+}
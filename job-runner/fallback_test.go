@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"microservices-demo/shared"
+)
+
+// newOfflineAgent registers the built-in web service at an address nothing
+// listens on, with the given fallback policy
+func newOfflineAgent(policy string) *ResearchAgent {
+	agent := NewResearchAgent()
+	agent.mcpHandler = NewMCPServiceHandler([]mcpServerConfig{
+		{Name: shared.MCPServiceWeb, Title: "Web Search", URL: "http://127.0.0.1:1", Transport: MCPTransportStreamableHTTP, Fallback: policy},
+	}, false)
+	return agent
+}
+
+func TestGatherFallbackPolicies(t *testing.T) {
+	job := shared.JobMessage{Query: "go", MCPServices: []shared.MCPService{shared.MCPServiceWeb}}
+
+	t.Run("skip", func(t *testing.T) {
+		agent := newOfflineAgent("")
+		_, _, outcomes, err := agent.gatherInformationWithMCP(context.Background(), job)
+		if err == nil || strings.Contains(err.Error(), "required") {
+			t.Errorf("Expected no data to be gathered, got %v", err)
+		}
+		if outcomes[0].Status != shared.ServiceStatusError || outcomes[0].Provenance != "" {
+			t.Errorf("Expected the service to fail without data, got %+v", outcomes[0])
+		}
+	})
+
+	t.Run("simulate", func(t *testing.T) {
+		agent := newOfflineAgent(mcpFallbackSimulate)
+		data, citations, outcomes, err := agent.gatherInformationWithMCP(context.Background(), job)
+		if err != nil {
+			t.Fatalf("gatherInformationWithMCP failed: %v", err)
+		}
+		if !strings.Contains(data, "Simulated data: ") || !strings.Contains(data, "Web Search Results") {
+			t.Errorf("Expected labelled simulated data, got %q", data)
+		}
+		// The server still counts as missing
+		if outcomes[0].Status != shared.ServiceStatusError || outcomes[0].Provenance != shared.ProvenanceSimulated {
+			t.Errorf("Expected a failed service with simulated data, got %+v", outcomes[0])
+		}
+		if citations.references[0].Provenance != shared.ProvenanceSimulated {
+			t.Errorf("Expected simulated references, got %+v", citations.references)
+		}
+	})
+
+	t.Run("fail", func(t *testing.T) {
+		search := newFakeMCPServer(t)
+		agent := newOfflineAgent(mcpFallbackFail)
+		agent.mcpHandler.servers["search"] = mcpServerConfig{Name: "search", URL: search.URL, Transport: MCPTransportStreamableHTTP, Tools: []string{"web_search"}}
+
+		_, _, outcomes, err := agent.gatherInformationWithMCP(context.Background(), shared.JobMessage{Query: "go", MCPServices: []shared.MCPService{shared.MCPServiceWeb, "search"}})
+		if err == nil || !strings.Contains(err.Error(), "MCP service web is required") {
+			t.Errorf("Expected the job to fail despite the other service, got %v", err)
+		}
+		if len(outcomes) != 2 || outcomes[1].Provenance != shared.ProvenanceReal {
+			t.Errorf("Expected the outcomes of both services, got %+v", outcomes)
+		}
+	})
+}
+
+func TestResearchAgentFallsBackToSimulatedTool(t *testing.T) {
+	ollama := newFakeOllamaChat(t, func(messages []ChatMessage) ChatMessage {
+		if len(messages) == 2 {
+			return toolCall("web__search", map[string]interface{}{"query": "go"})
+		}
+		return ChatMessage{Role: "assistant", Content: "Done."}
+	})
+
+	agent := newOfflineAgent(mcpFallbackSimulate)
+	agent.llm = newOllamaProvider(ollama.URL, ollama.Client(), "llama3.2")
+
+	result, _ := agent.processResearchRequest(context.Background(), shared.JobMessage{
+		JobID:       "job-9",
+		Query:       "Go",
+		MCPServices: []shared.MCPService{shared.MCPServiceWeb},
+	})
+	if result.Status != shared.JobStatusCompleted {
+		t.Fatalf("Expected status %s, got %s (%s)", shared.JobStatusCompleted, result.Status, result.Error)
+	}
+	if result.Provenance != shared.ProvenanceSimulated {
+		t.Errorf("Expected the job to be marked simulated, got %q", result.Provenance)
+	}
+	if len(result.References) == 0 || result.References[0].Provenance != shared.ProvenanceSimulated {
+		t.Errorf("Expected simulated references, got %+v", result.References)
+	}
+}
+
+func TestResearchAgentFallsBackAfterFailedCall(t *testing.T) {
+	mcp := newFakeMCPServer(t)
+	ollama := newFakeOllamaChat(t, func(messages []ChatMessage) ChatMessage {
+		if len(messages) == 2 {
+			// The fake server reports an error for this query
+			return toolCall("web__web_search", map[string]interface{}{"q": "fail"})
+		}
+		return ChatMessage{Role: "assistant", Content: "Done."}
+	})
+
+	agent := NewResearchAgent()
+	agent.llm = newOllamaProvider(ollama.URL, ollama.Client(), "llama3.2")
+	agent.mcpHandler = NewMCPServiceHandler([]mcpServerConfig{
+		{Name: shared.MCPServiceWeb, Title: "Web Search", URL: mcp.URL, Transport: MCPTransportStreamableHTTP, Fallback: mcpFallbackSimulate},
+	}, false)
+	defer agent.mcpHandler.Close()
+
+	outcome, err := agent.runResearchAgent(context.Background(), shared.JobMessage{
+		JobID:       "job-10",
+		Query:       "Go",
+		MCPServices: []shared.MCPService{shared.MCPServiceWeb},
+	}, agent.prompts.forType(shared.ResearchTypeGeneral), nil)
+	if err != nil {
+		t.Fatalf("runResearchAgent failed: %v", err)
+	}
+
+	call := outcome.trace[1]
+	if call.Error != "" || !strings.Contains(call.Content, "simulated data was used") || !strings.Contains(call.Result, "Web Search Results") {
+		t.Errorf("Expected the failed call to be answered with simulated data, got %+v", call)
+	}
+	if web := outcome.services[0]; web.Status != shared.ServiceStatusError || web.Provenance != shared.ProvenanceSimulated {
+		t.Errorf("Expected a failed service with simulated data, got %+v", web)
+	}
+}
+
+func TestResearchAgentRequiredService(t *testing.T) {
+	agent := newOfflineAgent(mcpFallbackFail)
+
+	result, retryable := agent.processResearchRequest(context.Background(), shared.JobMessage{
+		JobID:       "job-11",
+		Query:       "Go",
+		MCPServices: []shared.MCPService{shared.MCPServiceWeb},
+	})
+	if result.Status != shared.JobStatusFailed || !retryable || !strings.Contains(result.Error, "required by its fallback policy") {
+		t.Errorf("Expected a retryable failure naming the required service, got %s %q", result.Status, result.Error)
+	}
+}
+
+func TestJobProvenance(t *testing.T) {
+	tests := []struct {
+		outcomes []shared.ServiceOutcome
+		expected shared.Provenance
+	}{
+		{nil, ""},
+		{[]shared.ServiceOutcome{{Provenance: shared.ProvenanceReal}, {}}, shared.ProvenanceReal},
+		{[]shared.ServiceOutcome{{Provenance: shared.ProvenanceReal}, {Provenance: shared.ProvenanceSimulated}}, shared.ProvenanceSimulated},
+	}
+	for _, test := range tests {
+		if got := jobProvenance(test.outcomes); got != test.expected {
+			t.Errorf("jobProvenance(%+v) = %q, want %q", test.outcomes, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"microservices-demo/shared"
+)
+
+// defaultMCPGatherBudget bounds querying all services of a job in the fixed
+// pipeline, overridable with MCP_GATHER_BUDGET
+const defaultMCPGatherBudget = 45 * time.Second
+
+// serviceAnswer is what one service returned during the fan-out
+type serviceAnswer struct {
+	data    string
+	sources []string
+	outcome shared.ServiceOutcome
+}
+
+// gatherInformationWithMCP queries the job's services concurrently, each
+// within its own call timeout and all within MCP_GATHER_BUDGET, and keeps
+// whatever arrived in time. Sources are numbered in the order the services
+// were selected, whichever answered first. It fails if a service whose
+// fallback policy is fail returned nothing.
+func (ra *ResearchAgent) gatherInformationWithMCP(ctx context.Context, jobMessage shared.JobMessage) (string, *citationIndex, []shared.ServiceOutcome, error) {
+	log.Printf("Gathering information for: %s", jobMessage.Query)
+
+	ctx, cancel := context.WithTimeout(ctx, getEnvDuration("MCP_GATHER_BUDGET", defaultMCPGatherBudget))
+	defer cancel()
+
+	answers := make([]serviceAnswer, len(jobMessage.MCPServices))
+	var wg sync.WaitGroup
+	for i, service := range jobMessage.MCPServices {
+		if !ra.mcpHandler.has(service) {
+			log.Printf("MCP service %s not configured, skipping", service)
+			answers[i].outcome = shared.ServiceOutcome{Service: service, Status: shared.ServiceStatusError, Error: "not configured"}
+			continue
+		}
+
+		wg.Add(1)
+		go func(answer *serviceAnswer, service shared.MCPService) {
+			defer wg.Done()
+			*answer = ra.queryServiceOutcome(ctx, service, jobMessage)
+		}(&answers[i], service)
+	}
+	wg.Wait()
+
+	citations := newCitationIndex()
+	var allData []string
+	outcomes := make([]shared.ServiceOutcome, 0, len(answers))
+	for _, answer := range answers {
+		outcomes = append(outcomes, answer.outcome)
+		if answer.outcome.Provenance == "" {
+			continue
+		}
+		// Label the data with the numbers the report cites its sources by
+		allData = append(allData, citations.add(answer.outcome.Service, "", answer.data, answer.sources, answer.outcome.Provenance))
+	}
+
+	if err := ra.mcpHandler.requiredServiceError(outcomes); err != nil {
+		return "", citations, outcomes, err
+	}
+	if len(allData) == 0 {
+		return "", citations, outcomes, fmt.Errorf("no data could be gathered from MCP services")
+	}
+
+	return strings.Join(allData, "\n\n"), citations, outcomes, nil
+}
+
+// queryServiceOutcome queries one service within its call timeout, unless
+// the result cache can answer, and records how it answered. If the server
+// fails, simulated data stands in when the service's fallback policy allows
+// it.
+func (ra *ResearchAgent) queryServiceOutcome(ctx context.Context, service shared.MCPService, jobMessage shared.JobMessage) serviceAnswer {
+	ctx, cancel := ra.mcpHandler.withCallTimeout(ctx, service)
+	defer cancel()
+
+	started := time.Now()
+	params := researchCacheParams(jobMessage.Query, ra.mcpHandler.servers[service].Arguments)
+	data, sources, cached, err := ra.cachedMCPCall(service, mcpResearchMethod, params, jobMessage.NoCache, func() (string, []string, error) {
+		return ra.queryMCPService(ctx, service, jobMessage)
+	})
+	answer := serviceAnswer{
+		data:    data,
+		sources: sources,
+		outcome: serviceCallOutcome(ctx, service, time.Since(started), len(data), err),
+	}
+	if cached {
+		answer.outcome.CacheHits = 1
+	}
+	if err == nil {
+		answer.outcome.Provenance = ra.mcpHandler.provenance()
+		return answer
+	}
+	log.Printf("Error querying MCP service %s: %v", service, err)
+
+	if simulate, ok := ra.mcpHandler.fallbackSimulator(service); ok {
+		log.Printf("Using simulated data for MCP service %s as its fallback policy allows", service)
+		answer.data, answer.sources, err = simulate(ra, jobMessage.Query)
+		if err == nil {
+			answer.outcome.Provenance = shared.ProvenanceSimulated
+		}
+	}
+	return answer
+}
+
+// serviceCallOutcome records one call to a service made with ctx
+func serviceCallOutcome(ctx context.Context, service shared.MCPService, latency time.Duration, bytes int, err error) shared.ServiceOutcome {
+	outcome := shared.ServiceOutcome{
+		Service:   service,
+		Status:    shared.ServiceStatusOK,
+		Calls:     1,
+		LatencyMs: latency.Milliseconds(),
+		Bytes:     bytes,
+	}
+	switch {
+	case err == nil:
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded):
+		outcome.Status = shared.ServiceStatusTimeout
+		outcome.Error = fmt.Sprintf("no answer within %v", latency.Round(time.Millisecond))
+	default:
+		outcome.Status = shared.ServiceStatusError
+		outcome.Error = err.Error()
+	}
+	return outcome
+}
+
+// addServiceOutcome merges a call into the outcome of its service. A
+// service is ok once any of its calls succeeded, and simulated once any of
+// its data was simulated.
+func addServiceOutcome(outcomes []shared.ServiceOutcome, call shared.ServiceOutcome) []shared.ServiceOutcome {
+	for i := range outcomes {
+		outcome := &outcomes[i]
+		if outcome.Service != call.Service {
+			continue
+		}
+		outcome.Calls += call.Calls
+		outcome.LatencyMs += call.LatencyMs
+		outcome.Bytes += call.Bytes
+		outcome.CacheHits += call.CacheHits
+		if outcome.Status != shared.ServiceStatusOK {
+			outcome.Status = call.Status
+			outcome.Error = call.Error
+		}
+		if outcome.Provenance != shared.ProvenanceSimulated && call.Provenance != "" {
+			outcome.Provenance = call.Provenance
+		}
+		return outcomes
+	}
+	return append(outcomes, call)
+}
+
+// cacheHits counts the calls of a job answered from the result cache
+func cacheHits(outcomes []shared.ServiceOutcome) int {
+	hits := 0
+	for _, outcome := range outcomes {
+		hits += outcome.CacheHits
+	}
+	return hits
+}
+
+// missingServices lists the services that did not answer, for the report
+// prompts to mention
+func missingServices(outcomes []shared.ServiceOutcome) []shared.ServiceOutcome {
+	var missing []shared.ServiceOutcome
+	for _, outcome := range outcomes {
+		if outcome.Status != shared.ServiceStatusOK {
+			missing = append(missing, outcome)
+		}
+	}
+	return missing
+}
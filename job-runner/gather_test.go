@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"microservices-demo/shared"
+)
+
+// newStalledMCPServer accepts requests but does not answer them before the
+// test ends
+func newStalledMCPServer(t *testing.T) *httptest.Server {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(done) })
+	return server
+}
+
+func TestGatherInformationConcurrently(t *testing.T) {
+	search := newFakeMCPServer(t)
+	stalled := newStalledMCPServer(t)
+
+	agent := NewResearchAgent()
+	agent.mcpHandler = NewMCPServiceHandler([]mcpServerConfig{
+		{Name: "search", URL: search.URL, Transport: MCPTransportStreamableHTTP, Tools: []string{"web_search"}},
+		{Name: "stalled", URL: stalled.URL, Transport: MCPTransportStreamableHTTP, timeout: 200 * time.Millisecond},
+		{Name: "stalled-too", URL: stalled.URL, Transport: MCPTransportStreamableHTTP, timeout: 200 * time.Millisecond},
+		{Name: "offline", URL: "http://127.0.0.1:1", Transport: MCPTransportStreamableHTTP},
+	}, false)
+	defer agent.mcpHandler.Close()
+
+	job := shared.JobMessage{Query: "go", MCPServices: []shared.MCPService{"stalled", "search", "stalled-too", "offline", "unknown"}}
+	started := time.Now()
+	data, citations, outcomes, err := agent.gatherInformationWithMCP(context.Background(), job)
+	if err != nil {
+		t.Fatalf("gatherInformationWithMCP failed: %v", err)
+	}
+	// The stalled services wait out their timeouts side by side
+	if elapsed := time.Since(started); elapsed > 390*time.Millisecond {
+		t.Errorf("Expected the services to be queried concurrently, took %v", elapsed)
+	}
+
+	if !strings.Contains(data, "Results for go") || strings.Count(data, "Sources:") != 1 {
+		t.Errorf("Expected only the answer of search, got %q", data)
+	}
+	if citations.references[0].Number != 1 || citations.references[0].Service != "search" {
+		t.Errorf("Expected the sources of search to be numbered from 1, got %+v", citations.references)
+	}
+
+	expected := map[shared.MCPService]shared.ServiceStatus{
+		"stalled":     shared.ServiceStatusTimeout,
+		"search":      shared.ServiceStatusOK,
+		"stalled-too": shared.ServiceStatusTimeout,
+		"offline":     shared.ServiceStatusError,
+		"unknown":     shared.ServiceStatusError,
+	}
+	if len(outcomes) != len(job.MCPServices) {
+		t.Fatalf("Expected an outcome per service, got %+v", outcomes)
+	}
+	for i, outcome := range outcomes {
+		if outcome.Service != job.MCPServices[i] || outcome.Status != expected[outcome.Service] {
+			t.Errorf("Unexpected outcome: %+v", outcome)
+		}
+	}
+	if outcomes[1].Bytes != len(strings.SplitN(data, "\n\n", 2)[1]) || outcomes[1].Error != "" {
+		t.Errorf("Expected the size of the answer of search, got %+v", outcomes[1])
+	}
+	if !strings.HasPrefix(outcomes[0].Error, "no answer within") || outcomes[0].LatencyMs < 200 {
+		t.Errorf("Expected the timeout to be reported, got %+v", outcomes[0])
+	}
+	if missing := missingServices(outcomes); len(missing) != 4 {
+		t.Errorf("Expected 4 missing services, got %+v", missing)
+	}
+}
+
+func TestGatherInformationBudget(t *testing.T) {
+	t.Setenv("MCP_GATHER_BUDGET", "100ms")
+	stalled := newStalledMCPServer(t)
+
+	agent := NewResearchAgent()
+	agent.mcpHandler = NewMCPServiceHandler([]mcpServerConfig{
+		{Name: "stalled", URL: stalled.URL, Transport: MCPTransportStreamableHTTP},
+	}, false)
+	defer agent.mcpHandler.Close()
+
+	started := time.Now()
+	_, _, outcomes, err := agent.gatherInformationWithMCP(context.Background(), shared.JobMessage{Query: "go", MCPServices: []shared.MCPService{"stalled"}})
+	if err == nil {
+		t.Fatal("Expected an error when no service answered")
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("Expected the budget to cut the call short, took %v", elapsed)
+	}
+	if len(outcomes) != 1 || outcomes[0].Status != shared.ServiceStatusTimeout {
+		t.Errorf("Expected the service to time out, got %+v", outcomes)
+	}
+}
+
+func TestAddServiceOutcome(t *testing.T) {
+	var outcomes []shared.ServiceOutcome
+	outcomes = addServiceOutcome(outcomes, shared.ServiceOutcome{Service: "web", Status: shared.ServiceStatusTimeout, Calls: 1, LatencyMs: 30, Error: "no answer within 30ms"})
+	outcomes = addServiceOutcome(outcomes, shared.ServiceOutcome{Service: "files", Status: shared.ServiceStatusError, Calls: 1, LatencyMs: 5, Error: "no such file"})
+	outcomes = addServiceOutcome(outcomes, shared.ServiceOutcome{Service: "web", Status: shared.ServiceStatusOK, Calls: 1, LatencyMs: 20, Bytes: 100})
+	// A later failure does not undo an answer
+	outcomes = addServiceOutcome(outcomes, shared.ServiceOutcome{Service: "web", Status: shared.ServiceStatusError, Calls: 1, LatencyMs: 10, Error: "rate limited"})
+
+	expected := []shared.ServiceOutcome{
+		{Service: "web", Status: shared.ServiceStatusOK, Calls: 3, LatencyMs: 60, Bytes: 100},
+		{Service: "files", Status: shared.ServiceStatusError, Calls: 1, LatencyMs: 5, Error: "no such file"},
+	}
+	if len(outcomes) != 2 || outcomes[0] != expected[0] || outcomes[1] != expected[1] {
+		t.Errorf("Unexpected outcomes: %+v", outcomes)
+	}
+}
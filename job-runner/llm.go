@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"microservices-demo/shared"
+)
+
+// Inference servers the agent can talk to, selected with LLM_PROVIDER
+const (
+	LLMProviderOllama = "ollama"
+	// LLMProviderOpenAI is any server implementing the OpenAI API, such as
+	// the llama.cpp server, vLLM or LocalAI
+	LLMProviderOpenAI = "openai"
+)
+
+// llmRequestTimeout bounds a single request, including a streamed report
+const llmRequestTimeout = 5 * time.Minute
+
+// defaultContextTokens is the context window assumed for models whose server
+// does not report one, overridable with LLM_CONTEXT_TOKENS. It matches the
+// window Ollama loads models with unless told otherwise.
+const defaultContextTokens = 4096
+
+// LLMProvider is an inference server. Generate and Chat stream the reply to
+// onChunk as it is produced when onChunk is set; both return the complete
+// reply and the tokens used. An empty model selects the provider's default
+// model.
+type LLMProvider interface {
+	// Name identifies the provider in logs
+	Name() string
+
+	// DefaultModel is the model used when a request names none
+	DefaultModel() string
+
+	// Generate completes a single prompt
+	Generate(ctx context.Context, req GenerateRequest, onChunk func(string)) (string, Usage, error)
+
+	// Chat continues a conversation, offering tools if any are given. It
+	// fails with errToolsNotSupported if the model cannot call tools.
+	Chat(ctx context.Context, req ChatRequest, onChunk func(string)) (ChatMessage, Usage, error)
+
+	// Embed returns one embedding vector per input
+	Embed(ctx context.Context, model string, input []string) ([][]float64, error)
+
+	// ListModels returns the models the server can run
+	ListModels(ctx context.Context) ([]shared.ModelInfo, error)
+
+	// ContextLength is the context window in tokens a request for model
+	// with options gets, or 0 if the server does not say
+	ContextLength(ctx context.Context, model string, options *shared.ModelOptions) (int, error)
+}
+
+// Usage is the token accounting of one or more model calls. Counts come from
+// the inference server, or are approximated from word counts when a server
+// did not report them.
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+	// EvalDuration is the time spent generating the completion tokens
+	EvalDuration time.Duration
+	// TotalDuration includes loading the model and reading the prompt. Both
+	// are zero for calls whose counts were estimated.
+	TotalDuration time.Duration
+	// EstimatedPromptTokens and EstimatedCompletionTokens are the part of the
+	// counts that was estimated
+	EstimatedPromptTokens     int
+	EstimatedCompletionTokens int
+}
+
+// Total is the number of tokens read and generated
+func (u Usage) Total() int {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+// Estimated reports whether any of the counts were estimated
+func (u Usage) Estimated() bool {
+	return u.EstimatedPromptTokens > 0 || u.EstimatedCompletionTokens > 0
+}
+
+// Add accumulates the usage of another call
+func (u *Usage) Add(other Usage) {
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.EvalDuration += other.EvalDuration
+	u.TotalDuration += other.TotalDuration
+	u.EstimatedPromptTokens += other.EstimatedPromptTokens
+	u.EstimatedCompletionTokens += other.EstimatedCompletionTokens
+}
+
+// TokensPerSecond is the generation speed of the calls whose counts were
+// reported. Servers that do not report how long generation took are
+// measured over the whole request.
+func (u Usage) TokensPerSecond() float64 {
+	duration := u.EvalDuration
+	if duration <= 0 {
+		duration = u.TotalDuration
+	}
+	generated := u.CompletionTokens - u.EstimatedCompletionTokens
+	if duration <= 0 || generated <= 0 {
+		return 0
+	}
+	return float64(generated) / duration.Seconds()
+}
+
+// estimateUsage approximates the tokens of a conversation and its reply by
+// counting words, for servers that report no usage
+func estimateUsage(messages []ChatMessage, reply string) Usage {
+	usage := Usage{CompletionTokens: len(strings.Fields(reply))}
+	for _, message := range messages {
+		usage.PromptTokens += len(strings.Fields(message.Content))
+	}
+	usage.EstimatedPromptTokens = usage.PromptTokens
+	usage.EstimatedCompletionTokens = usage.CompletionTokens
+	return usage
+}
+
+// GenerateRequest is a single prompt with its system instructions
+type GenerateRequest struct {
+	Model   string
+	System  string
+	Prompt  string
+	Options *shared.ModelOptions
+	// Format is a JSON schema the reply must follow; nil allows free text
+	Format json.RawMessage
+}
+
+// ChatRequest is a conversation and the tools the model may call
+type ChatRequest struct {
+	Model    string
+	Messages []ChatMessage
+	Tools    []ChatTool
+	Options  *shared.ModelOptions
+	// Format is a JSON schema the reply must follow; nil allows free text
+	Format json.RawMessage
+}
+
+// ChatMessage is one message of a chat conversation
+type ChatMessage struct {
+	Role      string     `json:"role"`
+	Content   string     `json:"content"`
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// ToolCallID links a tool message to the call it answers, for providers
+	// that identify calls
+	ToolCallID string `json:"tool_call_id,omitempty"`
+}
+
+// ToolCall is a tool invocation requested by the model
+type ToolCall struct {
+	ID       string `json:"id,omitempty"`
+	Function struct {
+		Name      string                 `json:"name"`
+		Arguments map[string]interface{} `json:"arguments"`
+	} `json:"function"`
+}
+
+// ChatTool declares a function the model may call
+type ChatTool struct {
+	Type     string       `json:"type"`
+	Function ToolFunction `json:"function"`
+}
+
+// ToolFunction describes a callable function with a JSON schema
+type ToolFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters"`
+}
+
+// errToolsNotSupported is returned when the model cannot call tools, in
+// which case the fixed pipeline is used instead
+var errToolsNotSupported = errors.New("model does not support tool calling")
+
+// llmAPIError is an error reported by the inference server itself, as
+// opposed to a failure to reach it. StatusCode is zero for errors sent
+// mid-stream.
+type llmAPIError struct {
+	Provider   string
+	StatusCode int
+	Message    string
+}
+
+func (e *llmAPIError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("%s API error: %s", e.Provider, e.Message)
+	}
+	return fmt.Sprintf("%s API error: %d - %s", e.Provider, e.StatusCode, e.Message)
+}
+
+// isTransientError reports whether an LLM failure is likely to succeed on
+// retry: network errors, overload and server errors. Once ctx, the job's own
+// context, has timed out or been cancelled a retry would only start over, and
+// anything else, such as an unknown model or a broken prompt template, fails
+// the same way every time.
+func isTransientError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var apiErr *llmAPIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
+	}
+	// url.Error satisfies net.Error whatever it wraps, so look inside it
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// newLLMProviderFromEnv creates the provider configured for this deployment:
+// LLM_PROVIDER picks the server type, LLM_BASE_URL its address and LLM_MODEL
+// the default model. OLLAMA_URL and OLLAMA_MODEL are still honoured for Ollama.
+func newLLMProviderFromEnv() (LLMProvider, error) {
+	client := &http.Client{Timeout: llmRequestTimeout}
+	model := getEnvOrDefault("LLM_MODEL", getEnvOrDefault("OLLAMA_MODEL", "llama3.2"))
+
+	switch provider := getEnvOrDefault("LLM_PROVIDER", LLMProviderOllama); provider {
+	case LLMProviderOllama:
+		baseURL := getEnvOrDefault("LLM_BASE_URL", getEnvOrDefault("OLLAMA_URL", "http://localhost:11434"))
+		provider := newOllamaProvider(baseURL, client, model)
+		provider.serverContext = getEnvInt("LLM_CONTEXT_TOKENS", defaultContextTokens)
+		return provider, nil
+	case LLMProviderOpenAI:
+		baseURL := getEnvOrDefault("LLM_BASE_URL", "http://localhost:8080/v1")
+		return newOpenAIProvider(baseURL, getEnvOrDefault("LLM_API_KEY", ""), client, model), nil
+	default:
+		return nil, fmt.Errorf("unknown LLM_PROVIDER %q (expected %s or %s)", provider, LLMProviderOllama, LLMProviderOpenAI)
+	}
+}
+
+// modelCatalogInterval is how often the job runner advertises its models
+const modelCatalogInterval = 30 * time.Second
+
+// advertiseModels periodically broadcasts the models of the inference server
+// until ctx ends so API servers can offer them and validate the model a job
+// asks for
+func (ra *ResearchAgent) advertiseModels(ctx context.Context) {
+	id := runnerID()
+	interval := getEnvDuration("MODEL_CATALOG_INTERVAL", modelCatalogInterval)
+
+	broadcast(ctx, "model catalog", interval, func(ctx context.Context) error {
+		listCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		models, err := ra.llm.ListModels(listCtx)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to list %s models: %w", ra.llm.Name(), err)
+		}
+
+		return ra.rabbitmq.PublishModelCatalog(ctx, shared.ModelCatalog{
+			RunnerID:     id,
+			Provider:     ra.llm.Name(),
+			DefaultModel: ra.llm.DefaultModel(),
+			Models:       models,
+			PublishedAt:  time.Now(),
+		})
+	})
+}
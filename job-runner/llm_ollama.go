@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"microservices-demo/shared"
+)
+
+// ollamaProvider talks to an Ollama server through its native API
+type ollamaProvider struct {
+	baseURL      string
+	client       *http.Client
+	defaultModel string
+	// serverContext is the window the server loads models with when neither
+	// the request nor the model sets num_ctx (OLLAMA_CONTEXT_LENGTH)
+	serverContext int
+}
+
+func newOllamaProvider(baseURL string, client *http.Client, defaultModel string) *ollamaProvider {
+	return &ollamaProvider{
+		baseURL:       strings.TrimRight(baseURL, "/"),
+		client:        client,
+		defaultModel:  defaultModel,
+		serverContext: defaultContextTokens,
+	}
+}
+
+// OllamaRequest represents a request to Ollama API
+type OllamaRequest struct {
+	Model    string                 `json:"model"`
+	Prompt   string                 `json:"prompt"`
+	Stream   bool                   `json:"stream"`
+	System   string                 `json:"system,omitempty"`
+	Template string                 `json:"template,omitempty"`
+	Options  map[string]interface{} `json:"options,omitempty"`
+	// Format constrains the response to a JSON schema
+	Format json.RawMessage `json:"format,omitempty"`
+}
+
+// OllamaResponse represents response from Ollama API. When streaming, each
+// line of the response body is one OllamaResponse carrying the next chunk.
+type OllamaResponse struct {
+	Response string `json:"response"`
+	Done     bool   `json:"done"`
+	Context  []int  `json:"context,omitempty"`
+	Error    string `json:"error,omitempty"`
+	ollamaMetrics
+}
+
+// ollamaMetrics are the token counts and timings Ollama reports in the final
+// chunk of a response. Durations are in nanoseconds.
+type ollamaMetrics struct {
+	TotalDuration   int64 `json:"total_duration,omitempty"`
+	PromptEvalCount int   `json:"prompt_eval_count,omitempty"`
+	EvalCount       int   `json:"eval_count,omitempty"`
+	EvalDuration    int64 `json:"eval_duration,omitempty"`
+}
+
+// usage converts the metrics, or reports false if Ollama sent none
+func (m ollamaMetrics) usage() (Usage, bool) {
+	if m.PromptEvalCount == 0 && m.EvalCount == 0 {
+		return Usage{}, false
+	}
+	return Usage{
+		PromptTokens:     m.PromptEvalCount,
+		CompletionTokens: m.EvalCount,
+		EvalDuration:     time.Duration(m.EvalDuration),
+		TotalDuration:    time.Duration(m.TotalDuration),
+	}, true
+}
+
+// OllamaChatRequest represents a request to the Ollama chat API
+type OllamaChatRequest struct {
+	Model    string                 `json:"model"`
+	Messages []ChatMessage          `json:"messages"`
+	Tools    []ChatTool             `json:"tools,omitempty"`
+	Stream   bool                   `json:"stream"`
+	Options  map[string]interface{} `json:"options,omitempty"`
+	Format   json.RawMessage        `json:"format,omitempty"`
+}
+
+// OllamaChatResponse represents a response, or one streamed chunk, of the
+// Ollama chat API
+type OllamaChatResponse struct {
+	Message ChatMessage `json:"message"`
+	Done    bool        `json:"done"`
+	Error   string      `json:"error,omitempty"`
+	ollamaMetrics
+}
+
+func (p *ollamaProvider) Name() string {
+	return LLMProviderOllama
+}
+
+func (p *ollamaProvider) DefaultModel() string {
+	return p.defaultModel
+}
+
+func (p *ollamaProvider) model(model string) string {
+	// Use the job's model if one was requested, otherwise the deployment default
+	if model == "" {
+		return p.defaultModel
+	}
+	return model
+}
+
+// Generate completes a prompt in streaming mode, passing each chunk to
+// onChunk as it arrives and returning the full response
+func (p *ollamaProvider) Generate(ctx context.Context, req GenerateRequest, onChunk func(string)) (string, Usage, error) {
+	resp, err := p.post(ctx, "/api/generate", OllamaRequest{
+		Model:   p.model(req.Model),
+		Prompt:  req.Prompt,
+		System:  req.System,
+		Stream:  true,
+		Options: ollamaOptions(req.Options),
+		Format:  req.Format,
+	})
+	if err != nil {
+		return "", Usage{}, err
+	}
+	defer resp.Body.Close()
+
+	var response strings.Builder
+	var metrics ollamaMetrics
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var chunk OllamaResponse
+		if err := decoder.Decode(&chunk); err == io.EOF {
+			break
+		} else if err != nil {
+			return "", Usage{}, fmt.Errorf("failed to decode ollama stream: %w", err)
+		}
+
+		if chunk.Error != "" {
+			return "", Usage{}, &llmAPIError{Provider: LLMProviderOllama, Message: chunk.Error}
+		}
+
+		if chunk.Response != "" {
+			response.WriteString(chunk.Response)
+			if onChunk != nil {
+				onChunk(chunk.Response)
+			}
+		}
+
+		if chunk.Done {
+			metrics = chunk.ollamaMetrics
+			break
+		}
+	}
+
+	usage, ok := metrics.usage()
+	if !ok {
+		usage = estimateUsage([]ChatMessage{{Content: req.System}, {Content: req.Prompt}}, response.String())
+	}
+	return response.String(), usage, nil
+}
+
+// Chat sends a conversation to /api/chat. With onChunk set the reply is
+// streamed and each chunk passed to it.
+func (p *ollamaProvider) Chat(ctx context.Context, req ChatRequest, onChunk func(string)) (ChatMessage, Usage, error) {
+	resp, err := p.post(ctx, "/api/chat", OllamaChatRequest{
+		Model:    p.model(req.Model),
+		Messages: req.Messages,
+		Tools:    req.Tools,
+		Stream:   onChunk != nil,
+		Options:  ollamaOptions(req.Options),
+		Format:   req.Format,
+	})
+	if err != nil {
+		return ChatMessage{}, Usage{}, err
+	}
+	defer resp.Body.Close()
+
+	reply := ChatMessage{Role: "assistant"}
+	var content strings.Builder
+	var metrics ollamaMetrics
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var chunk OllamaChatResponse
+		if err := decoder.Decode(&chunk); err == io.EOF {
+			break
+		} else if err != nil {
+			return ChatMessage{}, Usage{}, fmt.Errorf("failed to decode ollama chat response: %w", err)
+		}
+
+		if chunk.Error != "" {
+			return ChatMessage{}, Usage{}, &llmAPIError{Provider: LLMProviderOllama, Message: chunk.Error}
+		}
+
+		if chunk.Message.Content != "" {
+			content.WriteString(chunk.Message.Content)
+			if onChunk != nil {
+				onChunk(chunk.Message.Content)
+			}
+		}
+		reply.ToolCalls = append(reply.ToolCalls, chunk.Message.ToolCalls...)
+
+		if chunk.Done {
+			metrics = chunk.ollamaMetrics
+			break
+		}
+	}
+	reply.Content = content.String()
+
+	usage, ok := metrics.usage()
+	if !ok {
+		usage = estimateUsage(req.Messages, reply.Content)
+	}
+	return reply, usage, nil
+}
+
+// Embed returns embeddings from /api/embed
+func (p *ollamaProvider) Embed(ctx context.Context, model string, input []string) ([][]float64, error) {
+	resp, err := p.post(ctx, "/api/embed", map[string]interface{}{
+		"model": p.model(model),
+		"input": input,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		Embeddings [][]float64 `json:"embeddings"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode ollama embeddings: %w", err)
+	}
+	return response.Embeddings, nil
+}
+
+// ListModels returns the models pulled into the server, from /api/tags
+func (p *ollamaProvider) ListModels(ctx context.Context) ([]shared.ModelInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.baseURL+"/api/tags", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("ollama server returned status: %d", resp.StatusCode)
+	}
+
+	var response struct {
+		Models []struct {
+			Name    string `json:"name"`
+			Size    int64  `json:"size"`
+			Details struct {
+				Family        string `json:"family"`
+				ParameterSize string `json:"parameter_size"`
+			} `json:"details"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode ollama models: %w", err)
+	}
+
+	models := make([]shared.ModelInfo, 0, len(response.Models))
+	for _, model := range response.Models {
+		models = append(models, shared.ModelInfo{
+			Name:          model.Name,
+			Family:        model.Details.Family,
+			ParameterSize: model.Details.ParameterSize,
+			Size:          model.Size,
+		})
+	}
+	return models, nil
+}
+
+// ContextLength returns the window a request gets: the num_ctx of the
+// options or else of the model's Modelfile, or the server default capped by
+// the length the model was trained for, from /api/show
+func (p *ollamaProvider) ContextLength(ctx context.Context, model string, options *shared.ModelOptions) (int, error) {
+	if options != nil && options.NumCtx > 0 {
+		return options.NumCtx, nil
+	}
+
+	resp, err := p.post(ctx, "/api/show", map[string]interface{}{"model": p.model(model)})
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		Parameters string                 `json:"parameters"`
+		ModelInfo  map[string]interface{} `json:"model_info"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return 0, fmt.Errorf("failed to decode ollama model: %w", err)
+	}
+
+	for _, line := range strings.Split(response.Parameters, "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 && fields[0] == "num_ctx" {
+			if numCtx, err := strconv.Atoi(fields[1]); err == nil && numCtx > 0 {
+				return numCtx, nil
+			}
+		}
+	}
+
+	// The trained length is keyed by architecture, e.g. llama.context_length
+	architecture, _ := response.ModelInfo["general.architecture"].(string)
+	trained, _ := response.ModelInfo[architecture+".context_length"].(float64)
+	if trained > 0 && (p.serverContext <= 0 || int(trained) < p.serverContext) {
+		return int(trained), nil
+	}
+	return p.serverContext, nil
+}
+
+// ollamaOptions maps job options to Ollama's model parameters
+func ollamaOptions(options *shared.ModelOptions) map[string]interface{} {
+	if options == nil {
+		return nil
+	}
+
+	params := make(map[string]interface{})
+	if options.Temperature != nil {
+		params["temperature"] = *options.Temperature
+	}
+	if options.NumCtx > 0 {
+		params["num_ctx"] = options.NumCtx
+	}
+	if options.MaxTokens > 0 {
+		params["num_predict"] = options.MaxTokens
+	}
+	if len(params) == 0 {
+		return nil
+	}
+	return params
+}
+
+// post sends a JSON request and returns the response if it succeeded
+func (p *ollamaProvider) post(ctx context.Context, path string, body interface{}) (*http.Response, error) {
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+path, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != 200 {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode == http.StatusBadRequest && strings.Contains(string(message), "does not support tools") {
+			return nil, fmt.Errorf("%w: %s", errToolsNotSupported, strings.TrimSpace(string(message)))
+		}
+		return nil, &llmAPIError{Provider: LLMProviderOllama, StatusCode: resp.StatusCode, Message: string(message)}
+	}
+	return resp, nil
+}
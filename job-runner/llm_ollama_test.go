@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"microservices-demo/shared"
+)
+
+func TestOllamaGenerateStreaming(t *testing.T) {
+	ollama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req OllamaRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		if !req.Stream {
+			t.Error("Expected streaming request")
+		}
+
+		encoder := json.NewEncoder(w)
+		for _, chunk := range []string{"# Report", "\n\nGo ", "is great."} {
+			encoder.Encode(OllamaResponse{Response: chunk})
+		}
+		// The final chunk carries the counts and timings, in nanoseconds
+		fmt.Fprintln(w, `{"done":true,"total_duration":3000000000,"prompt_eval_count":42,"eval_count":8,"eval_duration":2000000000}`)
+	}))
+	defer ollama.Close()
+
+	provider := newOllamaProvider(ollama.URL, ollama.Client(), "llama3.2")
+
+	var chunks []string
+	response, usage, err := provider.Generate(context.Background(), GenerateRequest{System: "system", Prompt: "user"}, func(chunk string) {
+		chunks = append(chunks, chunk)
+	})
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	if response != "# Report\n\nGo is great." {
+		t.Errorf("Unexpected response: %q", response)
+	}
+	if len(chunks) != 3 {
+		t.Errorf("Expected 3 chunks, got %d", len(chunks))
+	}
+	if usage.PromptTokens != 42 || usage.CompletionTokens != 8 || usage.Estimated() {
+		t.Errorf("Expected the reported token counts, got %+v", usage)
+	}
+	if usage.TokensPerSecond() != 4 {
+		t.Errorf("Expected 8 tokens in 2s of generation, got %v tokens/s", usage.TokensPerSecond())
+	}
+}
+
+func TestOllamaGenerateStreamError(t *testing.T) {
+	ollama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(OllamaResponse{Error: "model not found"})
+	}))
+	defer ollama.Close()
+
+	provider := newOllamaProvider(ollama.URL, ollama.Client(), "llama3.2")
+
+	if _, _, err := provider.Generate(context.Background(), GenerateRequest{Model: "missing", Prompt: "user"}, nil); err == nil {
+		t.Error("Expected error from streamed error message")
+	}
+}
+
+func TestOllamaListModels(t *testing.T) {
+	ollama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/tags" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"models":[{"name":"llama3.2:latest","size":2019393189,"details":{"family":"llama","parameter_size":"3.2B"}}]}`))
+	}))
+	defer ollama.Close()
+
+	models, err := newOllamaProvider(ollama.URL, ollama.Client(), "llama3.2").ListModels(context.Background())
+	if err != nil {
+		t.Fatalf("ListModels failed: %v", err)
+	}
+	if len(models) != 1 || models[0].Name != "llama3.2:latest" || models[0].ParameterSize != "3.2B" {
+		t.Errorf("Unexpected models: %+v", models)
+	}
+}
+
+func TestOllamaGenerateOptions(t *testing.T) {
+	ollama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req OllamaRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Model != "qwen2.5:7b" {
+			t.Errorf("Expected the job's model, got %q", req.Model)
+		}
+		if req.Options["temperature"] != 0.2 || req.Options["num_ctx"] != float64(8192) || req.Options["num_predict"] != float64(512) {
+			t.Errorf("Unexpected options: %v", req.Options)
+		}
+		if !strings.Contains(string(req.Format), `"key_findings"`) {
+			t.Errorf("Expected the report schema as format, got %s", req.Format)
+		}
+		json.NewEncoder(w).Encode(OllamaResponse{Response: "ok", Done: true})
+	}))
+	defer ollama.Close()
+
+	provider := newOllamaProvider(ollama.URL, ollama.Client(), "llama3.2")
+
+	temperature := 0.2
+	options := &shared.ModelOptions{Temperature: &temperature, NumCtx: 8192, MaxTokens: 512}
+	if _, _, err := provider.Generate(context.Background(), GenerateRequest{Model: "qwen2.5:7b", Prompt: "user", Format: reportSchema, Options: options}, nil); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	if ollamaOptions(&shared.ModelOptions{}) != nil {
+		t.Error("Expected no options when none are set")
+	}
+}
+
+func TestUsageAdd(t *testing.T) {
+	var total Usage
+	total.Add(Usage{PromptTokens: 100, CompletionTokens: 20, EvalDuration: time.Second})
+	total.Add(Usage{PromptTokens: 10, CompletionTokens: 40, EvalDuration: time.Second})
+	total.Add(estimateUsage([]ChatMessage{{Content: "three word prompt"}}, "a five word long reply"))
+
+	if total.Total() != 178 || total.EstimatedPromptTokens != 3 || total.EstimatedCompletionTokens != 5 {
+		t.Errorf("Unexpected total: %+v", total)
+	}
+	// The estimated call does not count towards the speed
+	if total.TokensPerSecond() != 30 {
+		t.Errorf("Expected 60 tokens in 2s, got %v tokens/s", total.TokensPerSecond())
+	}
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"microservices-demo/shared"
+)
+
+// openAIProvider talks to any server implementing the OpenAI chat
+// completions API. baseURL includes the version prefix, e.g.
+// http://llama-cpp:8080/v1.
+type openAIProvider struct {
+	baseURL      string
+	apiKey       string
+	client       *http.Client
+	defaultModel string
+}
+
+func newOpenAIProvider(baseURL, apiKey string, client *http.Client, defaultModel string) *openAIProvider {
+	return &openAIProvider{
+		baseURL:      strings.TrimRight(baseURL, "/"),
+		apiKey:       apiKey,
+		client:       client,
+		defaultModel: defaultModel,
+	}
+}
+
+// openAIMessage is a chat message in the OpenAI wire format, where tool
+// call arguments are a JSON-encoded string
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    string           `json:"content"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAIToolCall struct {
+	// Index identifies the call a streamed fragment belongs to
+	Index    *int   `json:"index,omitempty"`
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Function struct {
+		Name      string `json:"name,omitempty"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type openAIChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []openAIMessage `json:"messages"`
+	Tools    []ChatTool      `json:"tools,omitempty"`
+	Stream   bool            `json:"stream"`
+	// StreamOptions asks for the usage in the last chunk of a stream
+	StreamOptions *openAIStreamOptions `json:"stream_options,omitempty"`
+	Temperature   *float64             `json:"temperature,omitempty"`
+	MaxTokens     int                  `json:"max_tokens,omitempty"`
+	// ResponseFormat constrains the reply to a JSON schema
+	ResponseFormat *openAIResponseFormat `json:"response_format,omitempty"`
+}
+
+type openAIResponseFormat struct {
+	Type       string           `json:"type"`
+	JSONSchema openAIJSONSchema `json:"json_schema"`
+}
+
+type openAIJSONSchema struct {
+	Name   string          `json:"name"`
+	Schema json.RawMessage `json:"schema"`
+	Strict bool            `json:"strict"`
+}
+
+type openAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// openAIUsage is the token usage a server reports for a completion
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// openAIChatResponse is a completion, or one streamed chunk of it, in which
+// case the choices carry a delta instead of a message
+type openAIChatResponse struct {
+	Choices []struct {
+		Message openAIMessage `json:"message"`
+		Delta   openAIMessage `json:"delta"`
+	} `json:"choices"`
+	Usage *openAIUsage `json:"usage,omitempty"`
+	Error *openAIError `json:"error,omitempty"`
+}
+
+type openAIError struct {
+	Message string `json:"message"`
+}
+
+// openAIToolErrors are fragments of the errors servers return when the
+// model or server is not set up for tool calling
+var openAIToolErrors = []string{
+	"does not support tools",
+	"tools param requires",
+	"enable-auto-tool-choice",
+	"tool calling is not supported",
+}
+
+func (p *openAIProvider) Name() string {
+	return LLMProviderOpenAI
+}
+
+func (p *openAIProvider) DefaultModel() string {
+	return p.defaultModel
+}
+
+func (p *openAIProvider) model(model string) string {
+	// Use the job's model if one was requested, otherwise the deployment default
+	if model == "" {
+		return p.defaultModel
+	}
+	return model
+}
+
+// Generate completes a prompt as a two-message chat, since most servers only
+// apply the model's chat template on the chat endpoint
+func (p *openAIProvider) Generate(ctx context.Context, req GenerateRequest, onChunk func(string)) (string, Usage, error) {
+	var messages []ChatMessage
+	if req.System != "" {
+		messages = append(messages, ChatMessage{Role: "system", Content: req.System})
+	}
+	messages = append(messages, ChatMessage{Role: "user", Content: req.Prompt})
+
+	reply, usage, err := p.Chat(ctx, ChatRequest{Model: req.Model, Messages: messages, Options: req.Options, Format: req.Format}, onChunk)
+	if err != nil {
+		return "", Usage{}, err
+	}
+	return reply.Content, usage, nil
+}
+
+// Chat sends a conversation to /chat/completions. With onChunk set the reply
+// is streamed as server-sent events and each chunk passed to it. The API
+// reports no timings, so generation speed is measured over the request.
+func (p *openAIProvider) Chat(ctx context.Context, req ChatRequest, onChunk func(string)) (ChatMessage, Usage, error) {
+	body := openAIChatRequest{
+		Model:  p.model(req.Model),
+		Tools:  req.Tools,
+		Stream: onChunk != nil,
+	}
+	if body.Stream {
+		body.StreamOptions = &openAIStreamOptions{IncludeUsage: true}
+	}
+	if req.Format != nil {
+		body.ResponseFormat = &openAIResponseFormat{
+			Type:       "json_schema",
+			JSONSchema: openAIJSONSchema{Name: "response", Schema: req.Format, Strict: true},
+		}
+	}
+	// The context window is fixed when the server loads the model, so
+	// num_ctx has no equivalent here
+	if req.Options != nil {
+		body.Temperature = req.Options.Temperature
+		body.MaxTokens = req.Options.MaxTokens
+	}
+	for _, message := range req.Messages {
+		body.Messages = append(body.Messages, toOpenAIMessage(message))
+	}
+
+	started := time.Now()
+	resp, err := p.do(ctx, "POST", "/chat/completions", body)
+	if err != nil {
+		return ChatMessage{}, Usage{}, err
+	}
+	defer resp.Body.Close()
+
+	var reply openAIMessage
+	var reported *openAIUsage
+	if onChunk == nil {
+		var response openAIChatResponse
+		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+			return ChatMessage{}, Usage{}, fmt.Errorf("failed to decode openai chat response: %w", err)
+		}
+		if response.Error != nil {
+			return ChatMessage{}, Usage{}, &llmAPIError{Provider: LLMProviderOpenAI, Message: response.Error.Message}
+		}
+		if len(response.Choices) == 0 {
+			return ChatMessage{}, Usage{}, fmt.Errorf("openai chat response has no choices")
+		}
+		reply = response.Choices[0].Message
+		reported = response.Usage
+	} else {
+		reply, reported, err = readOpenAIStream(resp.Body, onChunk)
+		if err != nil {
+			return ChatMessage{}, Usage{}, err
+		}
+	}
+
+	message, err := fromOpenAIMessage(reply)
+	if err != nil {
+		return ChatMessage{}, Usage{}, err
+	}
+
+	var usage Usage
+	if reported != nil && (reported.PromptTokens > 0 || reported.CompletionTokens > 0) {
+		usage = Usage{PromptTokens: reported.PromptTokens, CompletionTokens: reported.CompletionTokens, TotalDuration: time.Since(started)}
+	} else {
+		usage = estimateUsage(req.Messages, message.Content)
+	}
+	return message, usage, nil
+}
+
+// readOpenAIStream assembles a streamed reply. Tool calls arrive in
+// fragments keyed by index, with the arguments split across chunks.
+func readOpenAIStream(r io.Reader, onChunk func(string)) (openAIMessage, *openAIUsage, error) {
+	reply := openAIMessage{Role: "assistant"}
+	var content strings.Builder
+	calls := make(map[int]*openAIToolCall)
+	var order []int
+	var usage *openAIUsage
+	var streamErr error
+
+	err := readSSE(r, func(event, data string) bool {
+		if data == "[DONE]" {
+			return false
+		}
+
+		var chunk openAIChatResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			streamErr = fmt.Errorf("failed to decode openai stream: %w", err)
+			return false
+		}
+		if chunk.Error != nil {
+			streamErr = &llmAPIError{Provider: LLMProviderOpenAI, Message: chunk.Error.Message}
+			return false
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content != "" {
+				content.WriteString(choice.Delta.Content)
+				onChunk(choice.Delta.Content)
+			}
+			for _, fragment := range choice.Delta.ToolCalls {
+				index := len(order)
+				if fragment.Index != nil {
+					index = *fragment.Index
+				}
+				call, ok := calls[index]
+				if !ok {
+					call = &openAIToolCall{}
+					calls[index] = call
+					order = append(order, index)
+				}
+				if fragment.ID != "" {
+					call.ID = fragment.ID
+				}
+				call.Function.Name += fragment.Function.Name
+				call.Function.Arguments += fragment.Function.Arguments
+			}
+		}
+		return true
+	})
+	if streamErr != nil {
+		return openAIMessage{}, nil, streamErr
+	}
+	if err != nil {
+		return openAIMessage{}, nil, fmt.Errorf("openai stream failed: %w", err)
+	}
+
+	reply.Content = content.String()
+	sort.Ints(order)
+	for _, index := range order {
+		reply.ToolCalls = append(reply.ToolCalls, *calls[index])
+	}
+	return reply, usage, nil
+}
+
+// Embed returns embeddings from /embeddings
+func (p *openAIProvider) Embed(ctx context.Context, model string, input []string) ([][]float64, error) {
+	resp, err := p.do(ctx, "POST", "/embeddings", map[string]interface{}{
+		"model": p.model(model),
+		"input": input,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float64 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode openai embeddings: %w", err)
+	}
+
+	embeddings := make([][]float64, len(input))
+	for _, item := range response.Data {
+		if item.Index >= 0 && item.Index < len(embeddings) {
+			embeddings[item.Index] = item.Embedding
+		}
+	}
+	return embeddings, nil
+}
+
+// ListModels returns the models served, from /models
+func (p *openAIProvider) ListModels(ctx context.Context) ([]shared.ModelInfo, error) {
+	resp, err := p.do(ctx, "GET", "/models", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode openai models: %w", err)
+	}
+
+	models := make([]shared.ModelInfo, 0, len(response.Data))
+	for _, model := range response.Data {
+		models = append(models, shared.ModelInfo{Name: model.ID})
+	}
+	return models, nil
+}
+
+// ContextLength returns the window the server reports for a model in
+// /models: max_model_len from vLLM or n_ctx_train from the llama.cpp server.
+// Other servers report none.
+func (p *openAIProvider) ContextLength(ctx context.Context, model string, options *shared.ModelOptions) (int, error) {
+	resp, err := p.do(ctx, "GET", "/models", nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		Data []struct {
+			ID          string `json:"id"`
+			MaxModelLen int    `json:"max_model_len"`
+			Meta        struct {
+				NCtxTrain int `json:"n_ctx_train"`
+			} `json:"meta"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return 0, fmt.Errorf("failed to decode openai models: %w", err)
+	}
+
+	for _, entry := range response.Data {
+		if entry.ID != p.model(model) {
+			continue
+		}
+		if entry.MaxModelLen > 0 {
+			return entry.MaxModelLen, nil
+		}
+		return entry.Meta.NCtxTrain, nil
+	}
+	return 0, nil
+}
+
+// do sends a request with an optional JSON body and returns the response if
+// it succeeded
+func (p *openAIProvider) do(ctx context.Context, method, path string, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		jsonData, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(jsonData)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, p.baseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if p.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != 200 {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		message := string(data)
+		var response openAIChatResponse
+		if json.Unmarshal(data, &response) == nil && response.Error != nil {
+			message = response.Error.Message
+		}
+
+		if resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusInternalServerError {
+			for _, fragment := range openAIToolErrors {
+				if strings.Contains(message, fragment) {
+					return nil, fmt.Errorf("%w: %s", errToolsNotSupported, message)
+				}
+			}
+		}
+		return nil, &llmAPIError{Provider: LLMProviderOpenAI, StatusCode: resp.StatusCode, Message: message}
+	}
+	return resp, nil
+}
+
+// toOpenAIMessage encodes tool call arguments as the JSON strings OpenAI expects
+func toOpenAIMessage(message ChatMessage) openAIMessage {
+	converted := openAIMessage{
+		Role:       message.Role,
+		Content:    message.Content,
+		ToolCallID: message.ToolCallID,
+	}
+	for _, call := range message.ToolCalls {
+		arguments, _ := json.Marshal(call.Function.Arguments)
+		if call.Function.Arguments == nil {
+			arguments = []byte("{}")
+		}
+
+		var wire openAIToolCall
+		wire.ID = call.ID
+		wire.Type = "function"
+		wire.Function.Name = call.Function.Name
+		wire.Function.Arguments = string(arguments)
+		converted.ToolCalls = append(converted.ToolCalls, wire)
+	}
+	return converted
+}
+
+// fromOpenAIMessage decodes the JSON-encoded tool call arguments of a reply
+func fromOpenAIMessage(message openAIMessage) (ChatMessage, error) {
+	converted := ChatMessage{Role: "assistant", Content: message.Content}
+	for _, wire := range message.ToolCalls {
+		var call ToolCall
+		call.ID = wire.ID
+		call.Function.Name = wire.Function.Name
+		if strings.TrimSpace(wire.Function.Arguments) != "" {
+			if err := json.Unmarshal([]byte(wire.Function.Arguments), &call.Function.Arguments); err != nil {
+				return ChatMessage{}, fmt.Errorf("model sent invalid arguments for %s: %w", wire.Function.Name, err)
+			}
+		}
+		converted.ToolCalls = append(converted.ToolCalls, call)
+	}
+	return converted, nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newFakeOpenAIServer(t *testing.T, handler http.HandlerFunc) *openAIProvider {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("Expected the API key, got %q", r.Header.Get("Authorization"))
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return newOpenAIProvider(server.URL+"/v1/", "secret", server.Client(), "qwen2.5")
+}
+
+func TestOpenAIChatToolCalls(t *testing.T) {
+	provider := newFakeOpenAIServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+
+		var req openAIChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Model != "qwen2.5" || req.Stream || len(req.Tools) != 1 {
+			t.Errorf("Unexpected request: %+v", req)
+		}
+		// The previous tool call must be sent back with encoded arguments
+		if previous := req.Messages[1]; len(previous.ToolCalls) != 1 || previous.ToolCalls[0].Function.Arguments != `{"q":"go"}` {
+			t.Errorf("Unexpected assistant message: %+v", previous)
+		}
+		if req.Messages[2].ToolCallID != "call_1" {
+			t.Errorf("Expected the tool result to reference call_1, got %+v", req.Messages[2])
+		}
+
+		fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"","tool_calls":[
+			{"id":"call_2","type":"function","function":{"name":"web__search","arguments":"{\"q\":\"go generics\"}"}}
+		]}}],"usage":{"prompt_tokens":300,"completion_tokens":21,"total_tokens":321}}`)
+	})
+
+	previous := toolCall("web__search", map[string]interface{}{"q": "go"})
+	previous.ToolCalls[0].ID = "call_1"
+	reply, usage, err := provider.Chat(context.Background(), ChatRequest{
+		Messages: []ChatMessage{
+			{Role: "user", Content: "Research Go"},
+			previous,
+			{Role: "tool", Content: "results", ToolCallID: "call_1"},
+		},
+		Tools: []ChatTool{{Type: "function", Function: ToolFunction{Name: "web__search", Parameters: json.RawMessage(`{"type":"object"}`)}}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+
+	if len(reply.ToolCalls) != 1 || reply.ToolCalls[0].ID != "call_2" || reply.ToolCalls[0].Function.Arguments["q"] != "go generics" {
+		t.Errorf("Unexpected tool calls: %+v", reply.ToolCalls)
+	}
+	if usage.PromptTokens != 300 || usage.CompletionTokens != 21 || usage.Estimated() {
+		t.Errorf("Expected the reported usage, got %+v", usage)
+	}
+}
+
+func TestOpenAIChatStreaming(t *testing.T) {
+	provider := newFakeOpenAIServer(t, func(w http.ResponseWriter, r *http.Request) {
+		var req openAIChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.StreamOptions == nil || !req.StreamOptions.IncludeUsage {
+			t.Error("Expected a streamed request to ask for usage")
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range []string{
+			`{"choices":[{"delta":{"role":"assistant","content":"# Report"}}]}`,
+			`{"choices":[{"delta":{"content":"\n\nGo is great."}}]}`,
+			`{"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","function":{"name":"web__search","arguments":"{\"q\":"}}]}}]}`,
+			`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"go\"}"}}]}}]}`,
+			`[DONE]`,
+		} {
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+		}
+	})
+
+	var chunks []string
+	reply, usage, err := provider.Chat(context.Background(), ChatRequest{
+		Messages: []ChatMessage{{Role: "user", Content: "Research Go"}},
+	}, func(chunk string) { chunks = append(chunks, chunk) })
+	if err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+
+	if reply.Content != "# Report\n\nGo is great." || len(chunks) != 2 {
+		t.Errorf("Unexpected content %q from chunks %q", reply.Content, chunks)
+	}
+	if len(reply.ToolCalls) != 1 || reply.ToolCalls[0].Function.Arguments["q"] != "go" {
+		t.Errorf("Expected the fragmented tool call to be assembled, got %+v", reply.ToolCalls)
+	}
+	if !usage.Estimated() || usage.PromptTokens != 2 || usage.CompletionTokens != 5 || usage.TokensPerSecond() != 0 {
+		t.Errorf("Expected a word count estimate without reported usage, got %+v", usage)
+	}
+}
+
+func TestOpenAIResponseFormat(t *testing.T) {
+	provider := newFakeOpenAIServer(t, func(w http.ResponseWriter, r *http.Request) {
+		var req openAIChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		format := req.ResponseFormat
+		if format == nil || format.Type != "json_schema" || !format.JSONSchema.Strict || !strings.Contains(string(format.JSONSchema.Schema), `"key_findings"`) {
+			t.Errorf("Expected the report schema as a strict response format, got %+v", format)
+		}
+		fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"{}"}}]}`)
+	})
+
+	if _, _, err := provider.Generate(context.Background(), GenerateRequest{Prompt: "Research Go", Format: reportSchema}, nil); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+}
+
+func TestOpenAIErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		body      string
+		noTools   bool
+		transient bool
+	}{
+		{"tools unsupported", http.StatusInternalServerError, `{"error":{"message":"tools param requires --jinja flag"}}`, true, false},
+		{"overloaded", http.StatusServiceUnavailable, `{"error":{"message":"loading model"}}`, false, true},
+		{"unknown model", http.StatusNotFound, `{"error":{"message":"model not found"}}`, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newFakeOpenAIServer(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			})
+
+			_, _, err := provider.Chat(context.Background(), ChatRequest{Messages: []ChatMessage{{Role: "user", Content: "hi"}}}, nil)
+			if err == nil {
+				t.Fatal("Expected an error")
+			}
+			if errors.Is(err, errToolsNotSupported) != tt.noTools {
+				t.Errorf("Expected tools unsupported %v, got %v", tt.noTools, err)
+			}
+			if !tt.noTools && isTransientError(context.Background(), err) != tt.transient {
+				t.Errorf("Expected transient %v, got %v", tt.transient, err)
+			}
+		})
+	}
+}
+
+func TestOpenAIModelsAndEmbeddings(t *testing.T) {
+	provider := newFakeOpenAIServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/models":
+			fmt.Fprint(w, `{"object":"list","data":[{"id":"qwen2.5","max_model_len":32768},{"id":"nomic-embed-text"}]}`)
+		case "/v1/embeddings":
+			fmt.Fprint(w, `{"data":[{"index":1,"embedding":[0.3,0.4]},{"index":0,"embedding":[0.1,0.2]}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	models, err := provider.ListModels(context.Background())
+	if err != nil {
+		t.Fatalf("ListModels failed: %v", err)
+	}
+	if len(models) != 2 || models[0].Name != "qwen2.5" {
+		t.Errorf("Unexpected models: %+v", models)
+	}
+	if tokens, err := provider.ContextLength(context.Background(), "qwen2.5", nil); err != nil || tokens != 32768 {
+		t.Errorf("Expected the served context length, got %d (%v)", tokens, err)
+	}
+
+	embeddings, err := provider.Embed(context.Background(), "nomic-embed-text", []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+	if len(embeddings) != 2 || embeddings[0][0] != 0.1 || embeddings[1][0] != 0.3 {
+		t.Errorf("Expected embeddings in input order, got %v", embeddings)
+	}
+}
+
+func TestNewLLMProviderFromEnv(t *testing.T) {
+	t.Setenv("LLM_PROVIDER", LLMProviderOpenAI)
+	t.Setenv("LLM_BASE_URL", "http://vllm:8000/v1")
+	t.Setenv("LLM_MODEL", "qwen2.5")
+
+	provider, err := newLLMProviderFromEnv()
+	if err != nil {
+		t.Fatalf("newLLMProviderFromEnv failed: %v", err)
+	}
+	openai, ok := provider.(*openAIProvider)
+	if !ok || openai.baseURL != "http://vllm:8000/v1" || openai.defaultModel != "qwen2.5" {
+		t.Errorf("Unexpected provider: %+v", provider)
+	}
+
+	t.Setenv("LLM_PROVIDER", "tgi")
+	if _, err := newLLMProviderFromEnv(); err == nil || !strings.Contains(err.Error(), "tgi") {
+		t.Errorf("Expected an error for an unknown provider, got %v", err)
+	}
+}
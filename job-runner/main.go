@@ -1,15 +1,19 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"microservices-demo/shared"
@@ -17,109 +21,260 @@ import (
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-// OllamaClient represents connection to local Ollama server
-type OllamaClient struct {
-	baseURL string
-	client  *http.Client
+// partialFlushInterval throttles how often streamed output is published
+const partialFlushInterval = 500 * time.Millisecond
+
+// partialResultPublisher batches streamed LLM output into partial-result messages
+type partialResultPublisher struct {
+	rabbitmq  *shared.RabbitMQClient
+	jobID     string
+	sequence  int
+	buffer    strings.Builder
+	lastFlush time.Time
 }
 
-// OllamaRequest represents a request to Ollama API
-type OllamaRequest struct {
-	Model    string `json:"model"`
-	Prompt   string `json:"prompt"`
-	Stream   bool   `json:"stream"`
-	System   string `json:"system,omitempty"`
-	Template string `json:"template,omitempty"`
+func (ra *ResearchAgent) newPartialResultPublisher(jobID string) *partialResultPublisher {
+	return &partialResultPublisher{
+		rabbitmq:  ra.rabbitmq,
+		jobID:     jobID,
+		lastFlush: time.Now(),
+	}
 }
 
-// OllamaResponse represents response from Ollama API
-type OllamaResponse struct {
-	Response string `json:"response"`
-	Done     bool   `json:"done"`
-	Context  []int  `json:"context,omitempty"`
+// Write buffers a chunk and publishes the buffer once the flush interval has passed
+func (p *partialResultPublisher) Write(chunk string) {
+	p.buffer.WriteString(chunk)
+	if time.Since(p.lastFlush) >= partialFlushInterval {
+		p.Flush()
+	}
 }
 
-// MCPServiceHandler handles different MCP service integrations
-type MCPServiceHandler struct {
-	// In a real implementation, these would be actual MCP client connections
-	availableServices map[shared.MCPService]bool
-	testMode          bool
+// Flush publishes any buffered output
+func (p *partialResultPublisher) Flush() {
+	p.lastFlush = time.Now()
+	if p.buffer.Len() == 0 {
+		return
+	}
+
+	partial := shared.JobPartialResult{
+		JobID:     p.jobID,
+		Sequence:  p.sequence,
+		Chunk:     p.buffer.String(),
+		Timestamp: p.lastFlush,
+	}
+	p.buffer.Reset()
+	p.sequence++
+
+	if p.rabbitmq == nil {
+		return
+	}
+	if err := p.rabbitmq.PublishPartialResult(context.Background(), partial); err != nil {
+		log.Printf("Failed to publish partial result for research %s: %v", p.jobID, err)
+	}
 }
 
+// cancelledJobRetention is how long a cancellation is remembered for jobs
+// that have not been picked up yet
+const cancelledJobRetention = time.Hour
+
 // ResearchAgent is the AI-powered research agent
 type ResearchAgent struct {
 	rabbitmq   *shared.RabbitMQClient
-	ollama     *OllamaClient
+	llm        LLMProvider
 	mcpHandler *MCPServiceHandler
 	daprURL    string
+	// mcpCache answers repeated MCP tool calls; nil disables caching
+	mcpCache MCPResultCache
+	// prompts holds the prompt templates of each research type
+	prompts *promptLibrary
+
+	// inflight holds the cancel function of every job being processed, and
+	// cancelled remembers cancellations that arrived before their job
+	inflight  map[string]context.CancelFunc
+	cancelled map[string]time.Time
+	jobsMutex sync.Mutex
+
+	// maxAttempts bounds how often a transiently failing job is tried
+	// before it is dead-lettered; retryDelay is the wait between attempts
+	maxAttempts int
+	retryDelay  time.Duration
+
+	// workers is how many jobs run at once and prefetch how many unacked
+	// jobs the broker hands this runner; busyWorkers counts running jobs
+	workers     int
+	prefetch    int
+	busyWorkers atomic.Int32
 }
 
 func NewResearchAgent() *ResearchAgent {
+	workers := getEnvInt("MAX_CONCURRENT_JOBS", 4)
+
+	// The built-in templates are checked by the tests, so they always load
+	prompts, err := newPromptLibrary("")
+	if err != nil {
+		panic(err)
+	}
+
 	return &ResearchAgent{
-		daprURL: getEnvOrDefault("DAPR_HTTP_ENDPOINT", "http://localhost:3500"),
+		daprURL:   getEnvOrDefault("DAPR_HTTP_ENDPOINT", "http://localhost:3500"),
+		prompts:   prompts,
+		inflight:  make(map[string]context.CancelFunc),
+		cancelled: make(map[string]time.Time),
+
+		maxAttempts: getEnvInt("JOB_MAX_ATTEMPTS", 3),
+		retryDelay:  getEnvDuration("JOB_RETRY_DELAY", 10*time.Second),
+
+		workers:  workers,
+		prefetch: getEnvInt("JOB_PREFETCH", workers),
 	}
 }
 
-func (ra *ResearchAgent) initOllama() error {
-	ollamaURL := getEnvOrDefault("OLLAMA_URL", "http://localhost:11434")
+// beginJob registers a cancellable context for a job. It returns false if
+// the job was cancelled before this runner picked it up.
+func (ra *ResearchAgent) beginJob(jobID string) (context.Context, func(), bool) {
+	ra.jobsMutex.Lock()
+	defer ra.jobsMutex.Unlock()
 
-	ra.ollama = &OllamaClient{
-		baseURL: ollamaURL,
-		client:  &http.Client{Timeout: 5 * time.Minute},
+	if _, cancelled := ra.cancelled[jobID]; cancelled {
+		delete(ra.cancelled, jobID)
+		return nil, nil, false
 	}
 
-	// Test connection to Ollama
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	ctx, cancel := context.WithCancel(context.Background())
+	ra.inflight[jobID] = cancel
 
-	if err := ra.testOllamaConnection(ctx); err != nil {
-		return fmt.Errorf("failed to connect to Ollama: %w", err)
+	done := func() {
+		ra.jobsMutex.Lock()
+		delete(ra.inflight, jobID)
+		ra.jobsMutex.Unlock()
+		cancel()
 	}
+	return ctx, done, true
+}
 
-	log.Println("Connected to Ollama server successfully")
-	return nil
+// cancelJob aborts an in-flight job, or remembers the cancellation so the job
+// is skipped when it is delivered. It returns true if the job was in flight.
+func (ra *ResearchAgent) cancelJob(jobID string) bool {
+	ra.jobsMutex.Lock()
+	defer ra.jobsMutex.Unlock()
+
+	if cancel, exists := ra.inflight[jobID]; exists {
+		cancel()
+		return true
+	}
+
+	// Forget stale cancellations for jobs another replica processed
+	now := time.Now()
+	for id, cancelledAt := range ra.cancelled {
+		if now.Sub(cancelledAt) > cancelledJobRetention {
+			delete(ra.cancelled, id)
+		}
+	}
+	ra.cancelled[jobID] = now
+	return false
+}
+
+func (ra *ResearchAgent) consumeControlMessages(messages <-chan amqp.Delivery) {
+	for delivery := range messages {
+		var msg shared.ControlMessage
+		if err := json.Unmarshal(delivery.Body, &msg); err != nil {
+			log.Printf("Failed to unmarshal control message: %v", err)
+			continue
+		}
+
+		switch msg.Type {
+		case shared.ControlMessageCancel:
+			if ra.cancelJob(msg.JobID) {
+				log.Printf("Cancelling in-flight research %s", msg.JobID)
+			} else {
+				log.Printf("Research %s cancelled before processing", msg.JobID)
+			}
+		default:
+			log.Printf("Ignoring unknown control message type: %s", msg.Type)
+		}
+	}
 }
 
-func (ra *ResearchAgent) testOllamaConnection(ctx context.Context) error {
-	req, err := http.NewRequestWithContext(ctx, "GET", ra.ollama.baseURL+"/api/tags", nil)
+// initLLM connects to the inference server configured for this deployment
+func (ra *ResearchAgent) initLLM() error {
+	provider, err := newLLMProviderFromEnv()
 	if err != nil {
 		return err
 	}
+	ra.llm = provider
+
+	// Test the connection by listing the models
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-	resp, err := ra.ollama.client.Do(req)
+	models, err := ra.llm.ListModels(ctx)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to connect to %s server: %w", ra.llm.Name(), err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("ollama server returned status: %d", resp.StatusCode)
+	log.Printf("Connected to %s server successfully (%d models available)", ra.llm.Name(), len(models))
+	return nil
+}
+
+// initPrompts loads the prompt templates, replacing the built-in ones with
+// those in PROMPT_TEMPLATES_DIR if it is set
+func (ra *ResearchAgent) initPrompts() error {
+	dir := getEnvOrDefault("PROMPT_TEMPLATES_DIR", "")
+	if dir == "" {
+		return nil
 	}
 
+	prompts, err := newPromptLibrary(dir)
+	if err != nil {
+		return err
+	}
+	ra.prompts = prompts
+	log.Printf("Prompt templates loaded from %s", dir)
 	return nil
 }
 
-func (ra *ResearchAgent) initMCPServices() {
+// initMCPServices loads the MCP server registry and spawns the servers that
+// run as local processes
+func (ra *ResearchAgent) initMCPServices() error {
 	// Check if we're in test mode
 	testMode := getEnvOrDefault("MCP_TEST_MODE", "false") == "true"
 
-	ra.mcpHandler = &MCPServiceHandler{
-		availableServices: map[shared.MCPService]bool{
-			shared.MCPServiceWeb:      true,  // Web search/scraping
-			shared.MCPServiceGitHub:   true,  // GitHub API
-			shared.MCPServiceDatabase: false, // Database queries (disabled for demo)
-			shared.MCPServiceFiles:    true,  // File system access
-			shared.MCPServiceCalendar: false, // Calendar integration (disabled)
-			shared.MCPServiceSlack:    false, // Slack integration (disabled)
-		},
-		testMode: testMode,
+	servers, err := loadMCPServers(getEnvOrDefault("MCP_SERVERS_CONFIG", ""))
+	if err != nil {
+		return err
+	}
+	ra.mcpHandler = NewMCPServiceHandler(servers, testMode)
+
+	// Servers without a fallback policy of their own are skipped when they
+	// fail, so simulated data is never used unless asked for
+	fallback := getEnvOrDefault("MCP_FALLBACK", mcpFallbackSkip)
+	if !validFallback(fallback) {
+		return fmt.Errorf("invalid MCP_FALLBACK %q, expected fail, skip or simulate", fallback)
 	}
+	ra.mcpHandler.fallback = fallback
+	ra.mcpHandler.setBreakerSettings(breakerSettingsFromEnv())
+	ra.mcpHandler.defaultCacheTTL = getEnvDuration("MCP_CACHE_TTL", defaultMCPCacheTTL)
 
 	if testMode {
-		log.Println("MCP services initialized in TEST MODE (using simulated data)")
-	} else {
-		log.Println("MCP services initialized in PRODUCTION MODE (using real MCP servers)")
+		log.Printf("MCP services initialized in TEST MODE (using simulated data): %d servers configured", len(servers))
+		return nil
+	}
+
+	ra.mcpHandler.startProcesses()
+	log.Printf("MCP services initialized in PRODUCTION MODE (using real MCP servers): %d servers configured", len(servers))
+	return nil
+}
+
+// initMCPCache opens the MCP result cache selected by MCP_CACHE
+func (ra *ResearchAgent) initMCPCache() error {
+	cache, err := newMCPCacheFromEnv()
+	if err != nil {
+		return err
 	}
+
+	ra.mcpCache = cache
+	log.Printf("MCP result cache initialized (%s)", getEnvOrDefault("MCP_CACHE", "memory"))
+	return nil
 }
 
 func (ra *ResearchAgent) initRabbitMQ() error {
@@ -138,158 +293,341 @@ func (ra *ResearchAgent) initRabbitMQ() error {
 	return nil
 }
 
-func (ra *ResearchAgent) start() error {
-	jobs, err := ra.rabbitmq.ConsumeJobs()
+func (ra *ResearchAgent) start(ctx context.Context) error {
+	// The prefetch limit keeps excess jobs in the queue for other replicas
+	jobs, err := ra.rabbitmq.ConsumeJobs(ctx, ra.prefetch)
 	if err != nil {
 		return err
 	}
 
-	log.Println("Research Agent started. Waiting for research requests...")
+	controlMessages, err := ra.rabbitmq.ConsumeControl()
+	if err != nil {
+		return err
+	}
+	go ra.consumeControlMessages(controlMessages)
 
-	for delivery := range jobs {
-		var jobMessage shared.JobMessage
-		if err := json.Unmarshal(delivery.Body, &jobMessage); err != nil {
-			log.Printf("Failed to unmarshal job message: %v", err)
-			if err := delivery.Nack(false, false); err != nil {
-				log.Printf("Failed to nack message: %v", err)
+	go ra.advertiseMCPServices(ctx)
+	go ra.monitorMCPServices(ctx)
+	if ra.mcpCache != nil {
+		go ra.pruneMCPCache()
+	}
+	go ra.advertiseModels(ctx)
+	if ra.prompts.dir != "" {
+		go ra.prompts.watch(getEnvDuration("PROMPT_RELOAD_INTERVAL", promptReloadInterval))
+	}
+
+	log.Printf("Research Agent started with %d workers (prefetch %d). Waiting for research requests...",
+		ra.workers, ra.prefetch)
+
+	runWorkerPool(ra.workers, jobs, ra.handleDelivery)
+	return nil
+}
+
+// runWorkerPool processes deliveries with a fixed number of workers until the
+// delivery channel is closed. While every worker is busy no further
+// deliveries are taken, which pushes back on the broker.
+func runWorkerPool(workers int, deliveries <-chan amqp.Delivery, handle func(amqp.Delivery)) {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for delivery := range deliveries {
+				handle(delivery)
 			}
-			continue
+		}()
+	}
+	wg.Wait()
+}
+
+// runnerID identifies this job runner in the reports it broadcasts
+func runnerID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "job-runner"
+	}
+	return hostname
+}
+
+// broadcast runs report right away and then every interval until ctx ends.
+// report builds and publishes one report; its error is logged as failing to
+// publish what, and the next round tries again.
+func broadcast(ctx context.Context, what string, interval time.Duration, report func(ctx context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := report(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to publish %s: %v", what, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
+	}
+}
 
-		log.Printf("Received research request: %s - %s", jobMessage.JobID, jobMessage.Title)
+// handleDelivery decodes a job delivery and processes it on the calling worker
+func (ra *ResearchAgent) handleDelivery(delivery amqp.Delivery) {
+	var jobMessage shared.JobMessage
+	if err := json.Unmarshal(delivery.Body, &jobMessage); err != nil {
+		log.Printf("Failed to unmarshal job message: %v", err)
+		ra.deadLetter(delivery, fmt.Sprintf("malformed job message: %v", err))
+		return
+	}
 
-		// Process the research request in a goroutine
-		go func(msg shared.JobMessage, d amqp.Delivery) {
-			// Send processing status update
-			processingUpdate := shared.JobResult{
-				JobID:       msg.JobID,
-				Status:      shared.JobStatusProcessing,
-				CompletedAt: time.Now(), // Use this as "started at" timestamp
-			}
+	log.Printf("Received research request: %s - %s (attempt %d)",
+		jobMessage.JobID, jobMessage.Title, shared.DeliveryAttempt(delivery))
 
-			if err := ra.rabbitmq.PublishResult(processingUpdate); err != nil {
-				log.Printf("Failed to publish processing status for research %s: %v", msg.JobID, err)
-			} else {
-				log.Printf("Research %s marked as processing", msg.JobID)
-			}
+	ra.busyWorkers.Add(1)
+	defer ra.busyWorkers.Add(-1)
+	ra.handleJob(jobMessage, delivery)
+}
+
+// handleJob processes one job delivery, retrying transient failures and
+// dead-lettering jobs that run out of attempts
+func (ra *ResearchAgent) handleJob(msg shared.JobMessage, d amqp.Delivery) {
+	ctx, done, ok := ra.beginJob(msg.JobID)
+	if !ok {
+		log.Printf("Skipping cancelled research %s", msg.JobID)
+		if err := d.Ack(false); err != nil {
+			log.Printf("Failed to ack message: %v", err)
+		}
+		return
+	}
+	defer done()
 
-			// Process the research request and get final result
-			result := ra.processResearchRequest(msg)
+	// Send processing status update
+	processingUpdate := shared.JobResult{
+		JobID:       msg.JobID,
+		Status:      shared.JobStatusProcessing,
+		CompletedAt: time.Now(), // Use this as "started at" timestamp
+	}
 
-			// Publish the final result
-			if err := ra.rabbitmq.PublishResult(result); err != nil {
-				log.Printf("Failed to publish result for research %s: %v", msg.JobID, err)
-			} else {
-				log.Printf("Published final result for research %s", msg.JobID)
-			}
+	if err := ra.rabbitmq.PublishResult(context.Background(), processingUpdate); err != nil {
+		log.Printf("Failed to publish processing status for research %s: %v", msg.JobID, err)
+	} else {
+		log.Printf("Research %s marked as processing", msg.JobID)
+	}
+
+	// Process the research request and get final result
+	result, retryable := ra.processResearchRequest(ctx, msg)
 
-			// Acknowledge the message
-			if err := d.Ack(false); err != nil {
-				log.Printf("Failed to ack message: %v", err)
+	if result.Status == shared.JobStatusFailed && retryable {
+		attempt := shared.DeliveryAttempt(d)
+		if attempt < ra.maxAttempts {
+			if ra.scheduleRetry(d, result, attempt) {
+				return
 			}
-		}(jobMessage, delivery)
+		} else {
+			result.Error = fmt.Sprintf("%s (gave up after %d attempts)", result.Error, attempt)
+			ra.publishResult(result)
+			ra.deadLetter(d, result.Error)
+			return
+		}
 	}
 
-	return nil
+	// Without a confirmed result the api-server would show the job as running
+	// forever, so hand the job back to the queue to be processed again
+	if !ra.publishResult(result) {
+		if err := d.Nack(false, true); err != nil {
+			log.Printf("Failed to nack message: %v", err)
+		}
+		return
+	}
+
+	// Acknowledge the message
+	if err := d.Ack(false); err != nil {
+		log.Printf("Failed to ack message: %v", err)
+	}
+}
+
+// scheduleRetry requeues a failed job through the retry queue and reports it
+// as pending again. It returns false if the retry could not be scheduled.
+func (ra *ResearchAgent) scheduleRetry(d amqp.Delivery, result shared.JobResult, attempt int) bool {
+	if err := ra.rabbitmq.RetryJob(context.Background(), d, attempt+1, ra.retryDelay); err != nil {
+		log.Printf("Failed to schedule retry for research %s: %v", result.JobID, err)
+		return false
+	}
+
+	log.Printf("Research %s attempt %d failed, retrying in %v: %s", result.JobID, attempt, ra.retryDelay, result.Error)
+	ra.publishResult(shared.JobResult{
+		JobID:       result.JobID,
+		Status:      shared.JobStatusPending,
+		Error:       fmt.Sprintf("Attempt %d of %d failed, retrying in %v: %s", attempt, ra.maxAttempts, ra.retryDelay, result.Error),
+		CompletedAt: time.Now(),
+	})
+
+	if err := d.Ack(false); err != nil {
+		log.Printf("Failed to ack message: %v", err)
+	}
+	return true
 }
-func (ra *ResearchAgent) processResearchRequest(jobMessage shared.JobMessage) shared.JobResult {
+
+// deadLetter parks a delivery in the dead-letter queue. If that fails the
+// delivery is requeued rather than lost.
+func (ra *ResearchAgent) deadLetter(d amqp.Delivery, reason string) {
+	if err := ra.rabbitmq.DeadLetterJob(context.Background(), d, reason); err != nil {
+		log.Printf("Failed to dead-letter message: %v", err)
+		if err := d.Nack(false, true); err != nil {
+			log.Printf("Failed to nack message: %v", err)
+		}
+		return
+	}
+
+	log.Printf("Message dead-lettered: %s", reason)
+	if err := d.Ack(false); err != nil {
+		log.Printf("Failed to ack message: %v", err)
+	}
+}
+
+// publishResult publishes a job status update to the api-server and reports
+// whether the broker confirmed it. The job's own context is not used so that
+// results of cancelled jobs are still delivered.
+func (ra *ResearchAgent) publishResult(result shared.JobResult) bool {
+	if err := ra.rabbitmq.PublishResult(context.Background(), result); err != nil {
+		log.Printf("Failed to publish %s result for research %s: %v", result.Status, result.JobID, err)
+		return false
+	}
+	log.Printf("Published %s result for research %s", result.Status, result.JobID)
+	return true
+}
+
+// processResearchRequest runs a research job. The returned bool reports
+// whether a failure is transient and worth retrying.
+func (ra *ResearchAgent) processResearchRequest(parent context.Context, jobMessage shared.JobMessage) (shared.JobResult, bool) {
 	log.Printf("Starting research: %s - %s", jobMessage.JobID, jobMessage.Query)
 	startTime := time.Now()
 
-	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	// Create context with timeout; parent is cancelled when the user cancels the job
+	ctx, cancel := context.WithTimeout(parent, 5*time.Minute)
 	defer cancel()
 
 	var result shared.JobResult
 	result.JobID = jobMessage.JobID
 	result.CompletedAt = time.Now()
+	result.Model = jobMessage.Model
+	if result.Model == "" && ra.llm != nil {
+		result.Model = ra.llm.DefaultModel()
+	}
 
-	// Step 1: Gather information using MCP services
-	mcpData, sources, err := ra.gatherInformationWithMCP(ctx, jobMessage)
-	if err != nil {
+	// Step 1: Let the model research with the MCP tools and write the report,
+	// publishing the trace as it grows
+	onProgress := func(trace []shared.TraceStep) {
+		if ra.rabbitmq == nil {
+			return
+		}
+		ra.publishResult(shared.JobResult{
+			JobID:       jobMessage.JobID,
+			Status:      shared.JobStatusProcessing,
+			Trace:       trace,
+			CompletedAt: time.Now(),
+		})
+	}
+	// The same templates are used throughout, even if they are reloaded meanwhile
+	prompt := ra.prompts.forType(jobMessage.ResearchType)
+	result.PromptTemplate = prompt.name
+	result.PromptVersion = prompt.version
+
+	outcome, err := ra.runResearchAgent(ctx, jobMessage, prompt, onProgress)
+	if errors.Is(err, errToolsNotSupported) {
+		log.Printf("Model cannot call tools, using the fixed pipeline for research %s", jobMessage.JobID)
+		outcome, err = ra.runResearchPipeline(ctx, jobMessage, prompt)
+	}
+	if parent.Err() != nil {
+		cancelled := cancelledResult(jobMessage.JobID)
+		cancelled.Model = result.Model
+		recordUsage(&cancelled, outcome.usage)
+		return cancelled, false
+	}
+	recordUsage(&result, outcome.usage)
+	result.Trace = outcome.trace
+	result.ServiceOutcomes = outcome.services
+	result.Provenance = jobProvenance(outcome.services)
+	result.CacheHits = cacheHits(outcome.services)
+	var gatherErr *gatherError
+	if errors.As(err, &gatherErr) {
 		result.Status = shared.JobStatusFailed
 		result.Error = fmt.Sprintf("Failed to gather information: %v", err)
-		return result
+		// MCP servers may come back, so gathering failures are always retried
+		return result, true
 	}
-
-	// Step 2: Use Ollama to process and analyze the gathered information
-	research, confidence, tokens, err := ra.analyzeWithOllama(ctx, jobMessage, mcpData)
 	if err != nil {
 		result.Status = shared.JobStatusFailed
 		result.Error = fmt.Sprintf("Failed to analyze with AI: %v", err)
-		return result
+		return result, isTransientError(ctx, err)
 	}
 
-	// Step 3: Create comprehensive result
+	// Flag citations the model made up and list the cited sources
+	outcome.linkCitations()
+	result.Trace = outcome.trace
+
+	// Step 2: Create comprehensive result, rating confidence by response
+	// quality and data availability
 	duration := time.Since(startTime)
+	confidence := ra.calculateConfidence(outcome.report, outcome.gathered, len(jobMessage.MCPServices))
 	result.Status = shared.JobStatusCompleted
-	result.Result = research
-	result.Sources = sources
+	result.Result = outcome.report
+	result.Report = outcome.structured
+	result.Sources = outcome.sources
+	result.References = outcome.references
+	result.InvalidCitations = outcome.invalidCitations
 	result.Confidence = confidence
-	result.TokensUsed = tokens
 
-	log.Printf("Research %s completed in %v with confidence %.2f",
-		jobMessage.JobID, duration, confidence)
+	log.Printf("Research %s completed in %v with confidence %.2f using %d prompt and %d completion tokens",
+		jobMessage.JobID, duration, confidence, outcome.usage.PromptTokens, outcome.usage.CompletionTokens)
 
-	return result
+	return result, false
 }
 
-func (ra *ResearchAgent) gatherInformationWithMCP(ctx context.Context, jobMessage shared.JobMessage) (string, []string, error) {
-	var allData []string
-	var sources []string
-
-	log.Printf("Gathering information for: %s", jobMessage.Query)
-
-	// Process each requested MCP service
-	for _, service := range jobMessage.MCPServices {
-		if !ra.mcpHandler.availableServices[service] {
-			log.Printf("MCP service %s not available, skipping", service)
-			continue
-		}
-
-		data, serviceSources, err := ra.queryMCPService(ctx, service, jobMessage)
-		if err != nil {
-			log.Printf("Error querying MCP service %s: %v", service, err)
-			continue
-		}
-
-		allData = append(allData, data)
-		sources = append(sources, serviceSources...)
-	}
+// recordUsage sets the token accounting of a result, which failed and
+// cancelled jobs report too for the tokens spent until they stopped
+func recordUsage(result *shared.JobResult, usage Usage) {
+	result.TokensUsed = usage.Total()
+	result.PromptTokens = usage.PromptTokens
+	result.CompletionTokens = usage.CompletionTokens
+	result.EstimatedPromptTokens = usage.EstimatedPromptTokens
+	result.EstimatedCompletionTokens = usage.EstimatedCompletionTokens
+	result.TokensPerSecond = usage.TokensPerSecond()
+}
 
-	if len(allData) == 0 {
-		return "", sources, fmt.Errorf("no data could be gathered from MCP services")
+// cancelledResult builds the result reported when the user cancels a job
+func cancelledResult(jobID string) shared.JobResult {
+	log.Printf("Research %s cancelled", jobID)
+	return shared.JobResult{
+		JobID:       jobID,
+		Status:      shared.JobStatusCancelled,
+		Error:       "Research cancelled by user",
+		CompletedAt: time.Now(),
 	}
-
-	return strings.Join(allData, "\n\n"), sources, nil
 }
 
 func (ra *ResearchAgent) queryMCPService(ctx context.Context, service shared.MCPService, jobMessage shared.JobMessage) (string, []string, error) {
-	// Use simulation if in test mode, otherwise use real MCP servers
+	config, ok := ra.mcpHandler.servers[service]
+	if !ok {
+		return "", nil, fmt.Errorf("unsupported MCP service: %s", service)
+	}
+
+	// Use simulation if in test mode, otherwise use real MCP servers. A
+	// failing server is left to the service's fallback policy.
 	if ra.mcpHandler.testMode {
-		switch service {
-		case shared.MCPServiceWeb:
-			return ra.simulateWebSearch(jobMessage.Query)
-		case shared.MCPServiceGitHub:
-			return ra.simulateGitHubSearch(jobMessage.Query)
-		case shared.MCPServiceFiles:
-			return ra.simulateFileSearch(jobMessage.Query)
-		default:
-			return "", nil, fmt.Errorf("unsupported MCP service: %s", service)
+		simulate, ok := mcpSimulators[service]
+		if !ok {
+			return "", nil, fmt.Errorf("no simulated data for MCP service: %s", service)
 		}
+		return simulate(ra, jobMessage.Query)
 	}
 
-	// Real MCP server implementations
-	switch service {
-	case shared.MCPServiceWeb:
-		return ra.queryWebSearchMCP(ctx, jobMessage.Query)
-	case shared.MCPServiceGitHub:
-		return ra.queryGitHubMCP(ctx, jobMessage.Query)
-	case shared.MCPServiceFiles:
-		return ra.queryFilesMCP(ctx, jobMessage.Query)
-	default:
-		return "", nil, fmt.Errorf("unsupported MCP service: %s", service)
-	}
+	return ra.callMCPTool(ctx, service, jobMessage.Query, config.Arguments)
+}
+
+// mcpSimulators produce canned data for the built-in services in test mode
+var mcpSimulators = map[shared.MCPService]mcpSimulator{
+	shared.MCPServiceWeb:    (*ResearchAgent).simulateWebSearch,
+	shared.MCPServiceGitHub: (*ResearchAgent).simulateGitHubSearch,
+	shared.MCPServiceFiles:  (*ResearchAgent).simulateFileSearch,
 }
 
 func (ra *ResearchAgent) simulateWebSearch(query string) (string, []string, error) {
@@ -365,234 +703,41 @@ Analysis summary:
 	return data, sources, nil
 }
 
-// Real MCP Server Implementations
-// These functions call actual MCP servers instead of simulations
-
-func (ra *ResearchAgent) queryWebSearchMCP(ctx context.Context, query string) (string, []string, error) {
-	// Real web search MCP server implementation
-	// This would typically use a search engine API like Google, Bing, or DuckDuckGo
-	// through an MCP server
-
-	mcpServerURL := getEnvOrDefault("MCP_WEB_SERVER_URL", "http://localhost:3001")
-
-	requestBody := map[string]interface{}{
-		"method": "search",
-		"params": map[string]interface{}{
-			"query": query,
-			"limit": 10,
-		},
-	}
-
-	data, sources, err := ra.callMCPServer(ctx, mcpServerURL, requestBody)
-	if err != nil {
-		log.Printf("Web search MCP server error: %v", err)
-		// Fallback to simulation if MCP server fails
-		return ra.simulateWebSearch(query)
-	}
-
-	return data, sources, nil
-}
-
-func (ra *ResearchAgent) queryGitHubMCP(ctx context.Context, query string) (string, []string, error) {
-	// Real GitHub MCP server implementation
-	// This would use the GitHub API through an MCP server
-
-	mcpServerURL := getEnvOrDefault("MCP_GITHUB_SERVER_URL", "http://localhost:3002")
-
-	requestBody := map[string]interface{}{
-		"method": "search_repositories",
-		"params": map[string]interface{}{
-			"query": query,
-			"sort":  "stars",
-			"order": "desc",
-			"limit": 10,
-		},
-	}
-
-	data, sources, err := ra.callMCPServer(ctx, mcpServerURL, requestBody)
-	if err != nil {
-		log.Printf("GitHub MCP server error: %v", err)
-		// Fallback to simulation if MCP server fails
-		return ra.simulateGitHubSearch(query)
-	}
-
-	return data, sources, nil
-}
-
-func (ra *ResearchAgent) queryFilesMCP(ctx context.Context, query string) (string, []string, error) {
-	// Real file system MCP server implementation
-	// This would search local or networked file systems through an MCP server
-
-	mcpServerURL := getEnvOrDefault("MCP_FILES_SERVER_URL", "http://localhost:3003")
-
-	requestBody := map[string]interface{}{
-		"method": "search_files",
-		"params": map[string]interface{}{
-			"query":     query,
-			"file_type": []string{".md", ".txt", ".go", ".js", ".py"},
-			"limit":     20,
-		},
-	}
-
-	data, sources, err := ra.callMCPServer(ctx, mcpServerURL, requestBody)
-	if err != nil {
-		log.Printf("Files MCP server error: %v", err)
-		// Fallback to simulation if MCP server fails
-		return ra.simulateFileSearch(query)
-	}
-
-	return data, sources, nil
-}
-
-// Generic MCP server call function
-func (ra *ResearchAgent) callMCPServer(ctx context.Context, serverURL string, requestBody map[string]interface{}) (string, []string, error) {
-	// Marshal request
-	jsonData, err := json.Marshal(requestBody)
+func (ra *ResearchAgent) analyzeWithLLM(ctx context.Context, jobMessage shared.JobMessage, prompt *promptTemplate, mcpData string, missing []shared.ServiceOutcome) (string, *shared.Report, float64, Usage, error) {
+	// Build the prompts from the research type's templates
+	data := ra.promptData(jobMessage)
+	data.Data = mcpData
+	data.Missing = missing
+	systemPrompt, err := prompt.render("pipeline_system", data)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to marshal MCP request: %w", err)
+		return "", nil, 0.0, Usage{}, err
 	}
-
-	// Create HTTP request
-	req, err := http.NewRequestWithContext(ctx, "POST", serverURL+"/api/mcp", bytes.NewBuffer(jsonData))
+	userPrompt, err := prompt.render("pipeline_prompt", data)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to create MCP request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	// Make HTTP request with timeout
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+		return "", nil, 0.0, Usage{}, err
+	}
+
+	// Make request to the model, streaming the report to the status page as it is written
+	partials := ra.newPartialResultPublisher(jobMessage.JobID)
+	stream := newReportStream(partials.Write)
+	response, usage, err := ra.llm.Generate(ctx, GenerateRequest{
+		Model:   jobMessage.Model,
+		System:  systemPrompt,
+		Prompt:  userPrompt,
+		Format:  reportSchema,
+		Options: jobMessage.Options,
+	}, stream.Write)
+	stream.Close()
+	partials.Flush()
 	if err != nil {
-		return "", nil, fmt.Errorf("MCP server request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		body, _ := io.ReadAll(resp.Body)
-		return "", nil, fmt.Errorf("MCP server returned status %d: %s", resp.StatusCode, string(body))
-	}
-
-	// Parse response
-	var mcpResponse struct {
-		Data    string   `json:"data"`
-		Sources []string `json:"sources"`
-		Error   string   `json:"error,omitempty"`
-	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&mcpResponse); err != nil {
-		return "", nil, fmt.Errorf("failed to decode MCP response: %w", err)
-	}
-
-	if mcpResponse.Error != "" {
-		return "", nil, fmt.Errorf("MCP server error: %s", mcpResponse.Error)
-	}
-
-	return mcpResponse.Data, mcpResponse.Sources, nil
-}
-
-func (ra *ResearchAgent) analyzeWithOllama(ctx context.Context, jobMessage shared.JobMessage, mcpData string) (string, float64, int, error) {
-	// Create a comprehensive prompt for the AI
-	systemPrompt := `You are a professional research agent. Your task is to analyze the provided information and create a comprehensive, well-structured research report. 
-
-Guidelines:
-- Provide accurate, fact-based analysis
-- Structure your response with clear sections using markdown formatting (headers, lists, tables, etc.)
-- Include key findings and insights
-- Mention any limitations or areas needing further research
-- Rate your confidence in the findings (0.0 to 1.0)
-- Be concise but thorough
-- Use markdown formatting for better readability (# headers, **bold**, *italic*, lists, tables, code blocks)`
-
-	var userPrompt string
-	if ra.mcpHandler.testMode {
-		// In test mode, inform about placeholder sources
-		systemPrompt += `
-
-IMPORTANT: The provided "sources" are placeholder examples. In your response, you should reference realistic, relevant sources that would actually exist for this research topic. Generate appropriate URLs, documentation links, academic papers, or industry resources that would be credible sources for this type of research, even though you cannot actually access them.`
-
-		userPrompt = fmt.Sprintf(`Research Request: %s
-
-Query: %s
-Research Type: %s
-
-Gathered Information:
-%s
-
-NOTE: The sources listed above are placeholder examples. Please provide a comprehensive research report and suggest realistic, relevant sources that would be appropriate for this research topic. Include references to actual websites, documentation, academic papers, or industry resources that would credibly support this type of research.
-
-Please structure your response using markdown formatting and provide a professional research report.`,
-			jobMessage.Title, jobMessage.Query, jobMessage.ResearchType, mcpData)
-	} else {
-		// In production mode, sources are real
-		systemPrompt += `
-
-The sources provided are from real data gathering services. Reference them appropriately in your analysis.`
-
-		userPrompt = fmt.Sprintf(`Research Request: %s
-
-Query: %s
-Research Type: %s
-
-Gathered Information from MCP Services:
-%s
-
-Please provide a comprehensive research report based on this real data. Structure your response using markdown formatting and provide a professional analysis.`,
-			jobMessage.Title, jobMessage.Query, jobMessage.ResearchType, mcpData)
-	}
-
-	// Make request to Ollama
-	response, tokens, err := ra.callOllama(ctx, systemPrompt, userPrompt)
-	if err != nil {
-		return "", 0.0, 0, err
+		return "", nil, 0.0, Usage{}, err
 	}
+	report, structured := parseReport(response)
 
 	// Calculate confidence based on response quality and data availability
-	confidence := ra.calculateConfidence(response, mcpData, len(jobMessage.MCPServices))
+	confidence := ra.calculateConfidence(report, mcpData, len(jobMessage.MCPServices))
 
-	return response, confidence, tokens, nil
-}
-
-func (ra *ResearchAgent) callOllama(ctx context.Context, systemPrompt, userPrompt string) (string, int, error) {
-	model := getEnvOrDefault("OLLAMA_MODEL", "llama3.2")
-
-	reqBody := OllamaRequest{
-		Model:  model,
-		Prompt: userPrompt,
-		System: systemPrompt,
-		Stream: false,
-	}
-
-	jsonData, err := json.Marshal(reqBody)
-	if err != nil {
-		return "", 0, err
-	}
-
-	req, err := http.NewRequestWithContext(ctx, "POST", ra.ollama.baseURL+"/api/generate", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", 0, err
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := ra.ollama.client.Do(req)
-	if err != nil {
-		return "", 0, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		body, _ := io.ReadAll(resp.Body)
-		return "", 0, fmt.Errorf("ollama API error: %d - %s", resp.StatusCode, string(body))
-	}
-
-	var ollamaResp OllamaResponse
-	if err := json.NewDecoder(resp.Body).Decode(&ollamaResp); err != nil {
-		return "", 0, err
-	}
-
-	// Estimate token usage (rough approximation)
-	tokens := len(strings.Fields(userPrompt + systemPrompt + ollamaResp.Response))
-
-	return ollamaResp.Response, tokens, nil
+	return report, structured, confidence, usage, nil
 }
 
 func (ra *ResearchAgent) calculateConfidence(response, mcpData string, mcpServiceCount int) float64 {
@@ -624,6 +769,59 @@ func (ra *ResearchAgent) calculateConfidence(response, mcpData string, mcpServic
 	return baseConfidence
 }
 
+// healthHandler reports whether the agent can currently receive work
+func (ra *ResearchAgent) healthHandler(w http.ResponseWriter, r *http.Request) {
+	status := map[string]interface{}{
+		"status":    "healthy",
+		"timestamp": time.Now(),
+		"service":   "job-runner",
+	}
+
+	status["workers"] = map[string]int{
+		"busy": int(ra.busyWorkers.Load()),
+		"max":  ra.workers,
+	}
+	// Unavailable MCP servers degrade reports but do not stop work
+	if ra.mcpHandler != nil {
+		status["mcp"] = ra.mcpHandler.Health()
+	}
+
+	code := http.StatusOK
+	if ra.rabbitmq == nil {
+		status["status"] = "unhealthy"
+		status["rabbitmq"] = "disconnected"
+		code = http.StatusServiceUnavailable
+	} else {
+		// The client reconnects on its own; report its state while it does
+		connStatus := ra.rabbitmq.Status()
+		status["rabbitmq_connection"] = connStatus
+		status["rabbitmq"] = "connected"
+		if connStatus.State != shared.ConnectionStateConnected {
+			status["status"] = "unhealthy"
+			status["rabbitmq"] = "disconnected"
+			code = http.StatusServiceUnavailable
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
+
+// startHealthServer serves the health endpoint used by liveness and readiness probes
+func (ra *ResearchAgent) startHealthServer() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", ra.healthHandler)
+
+	addr := ":" + getEnvOrDefault("HEALTH_PORT", "8082")
+	log.Printf("Health endpoint listening on %s", addr)
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("Health server stopped: %v", err)
+		}
+	}()
+}
+
 // Utility function to get environment variable with default
 func getEnvOrDefault(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
@@ -632,6 +830,28 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getEnvInt reads a positive integer environment variable, falling back to
+// defaultValue if it is unset or invalid
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(getEnvOrDefault(key, strconv.Itoa(defaultValue)))
+	if err != nil || value < 1 {
+		log.Printf("Invalid %s, using default %d", key, defaultValue)
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvDuration reads a duration environment variable such as "10s",
+// falling back to defaultValue if it is unset or invalid
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(getEnvOrDefault(key, defaultValue.String()))
+	if err != nil || value <= 0 {
+		log.Printf("Invalid %s, using default %v", key, defaultValue)
+		return defaultValue
+	}
+	return value
+}
+
 func main() {
 	agent := NewResearchAgent()
 
@@ -641,16 +861,42 @@ func main() {
 	}
 	defer agent.rabbitmq.Close()
 
-	if err := agent.initOllama(); err != nil {
-		log.Fatalf("Failed to initialize Ollama: %v", err)
+	if err := agent.initLLM(); err != nil {
+		log.Fatalf("Failed to initialize LLM provider: %v", err)
 	}
 
-	agent.initMCPServices()
+	if err := agent.initMCPServices(); err != nil {
+		log.Fatalf("Failed to initialize MCP services: %v", err)
+	}
+	defer agent.mcpHandler.Close()
+
+	if err := agent.initMCPCache(); err != nil {
+		log.Fatalf("Failed to initialize MCP result cache: %v", err)
+	}
+	if agent.mcpCache != nil {
+		defer agent.mcpCache.Close()
+	}
+
+	if err := agent.initPrompts(); err != nil {
+		log.Fatalf("Failed to load prompt templates: %v", err)
+	}
+
+	// Stop consuming jobs on SIGINT/SIGTERM; start returns once in-flight
+	// jobs finish and have published their results, and the deferred calls
+	// then shut down MCP server processes and close the connection last
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	ctx, stop := context.WithCancel(context.Background())
+	go func() {
+		sig := <-signals
+		log.Printf("Received %v, shutting down...", sig)
+		stop()
+	}()
 
 	log.Println("AI Research Agent is starting...")
 	log.Println("Components initialized:")
 	log.Println("  ✓ RabbitMQ connection")
-	log.Println("  ✓ Ollama AI model")
+	log.Printf("  ✓ AI model (%s)", agent.llm.Name())
 	if agent.mcpHandler.testMode {
 		log.Println("  ✓ MCP services (TEST MODE)")
 	} else {
@@ -658,7 +904,9 @@ func main() {
 	}
 	log.Printf("  ✓ Dapr endpoint: %s", agent.daprURL)
 
-	if err := agent.start(); err != nil {
+	agent.startHealthServer()
+
+	if err := agent.start(ctx); err != nil {
 		log.Fatalf("Failed to start research agent: %v", err)
 	}
 }
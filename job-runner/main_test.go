@@ -1,9 +1,23 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
 	"microservices-demo/shared"
+
+	amqp "github.com/rabbitmq/amqp091-go"
 )
 
 func TestResearchAgentCreation(t *testing.T) {
@@ -39,15 +53,279 @@ func TestResearchRequestHandling(t *testing.T) {
 
 func TestMCPServiceMock(t *testing.T) {
 	agent := NewResearchAgent()
-	agent.initMCPServices()
+	if err := agent.initMCPServices(); err != nil {
+		t.Fatalf("initMCPServices failed: %v", err)
+	}
 
 	if agent.mcpHandler == nil {
 		t.Error("Expected MCP handler to be initialized")
 	}
 
-	// Test that web service is available
-	if !agent.mcpHandler.availableServices[shared.MCPServiceWeb] {
-		t.Error("Expected web service to be available")
+	// Without a config file the built-in servers are registered
+	if !agent.mcpHandler.has(shared.MCPServiceWeb) {
+		t.Error("Expected web service to be registered")
+	}
+	if agent.mcpHandler.has(shared.MCPServiceSlack) {
+		t.Error("Expected slack service not to be registered")
+	}
+}
+
+func TestCancelInFlightJob(t *testing.T) {
+	agent := NewResearchAgent()
+
+	ctx, done, ok := agent.beginJob("job-1")
+	if !ok {
+		t.Fatal("Expected job to start")
+	}
+	defer done()
+
+	if !agent.cancelJob("job-1") {
+		t.Error("Expected cancelJob to report an in-flight job")
+	}
+	if ctx.Err() == nil {
+		t.Error("Expected job context to be cancelled")
+	}
+}
+
+func TestCancelBeforeDelivery(t *testing.T) {
+	agent := NewResearchAgent()
+
+	if agent.cancelJob("job-2") {
+		t.Error("Expected cancelJob to report job was not in flight")
+	}
+	if _, _, ok := agent.beginJob("job-2"); ok {
+		t.Error("Expected cancelled job to be skipped")
+	}
+}
+
+func TestProcessResearchRequestCancelled(t *testing.T) {
+	agent := NewResearchAgent()
+	agent.initMCPServices()
+	agent.mcpHandler.testMode = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result, _ := agent.processResearchRequest(ctx, shared.JobMessage{
+		JobID:       "job-3",
+		Query:       "Research about Go microservices",
+		MCPServices: []shared.MCPService{shared.MCPServiceWeb},
+	})
+	if result.Status != shared.JobStatusCancelled {
+		t.Errorf("Expected status %s, got %s", shared.JobStatusCancelled, result.Status)
+	}
+}
+
+func TestProcessResearchRequestRecordsUsageWhenStopped(t *testing.T) {
+	tests := []struct {
+		name   string
+		cancel bool
+		status shared.JobStatus
+	}{
+		{"failed", false, shared.JobStatusFailed},
+		{"cancelled", true, shared.JobStatusCancelled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// Both planning steps report their tokens, then the report fails
+			ollama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/api/chat" {
+					http.NotFound(w, r)
+					return
+				}
+				var req OllamaChatRequest
+				json.NewDecoder(r.Body).Decode(&req)
+				if len(req.Tools) == 0 {
+					if tt.cancel {
+						cancel()
+					}
+					http.Error(w, "model crashed", http.StatusInternalServerError)
+					return
+				}
+				reply := ChatMessage{Role: "assistant", Content: "I have enough information."}
+				if len(req.Messages) == 2 {
+					reply = toolCall("web__search", map[string]interface{}{"query": "go"})
+				}
+				json.NewEncoder(w).Encode(OllamaChatResponse{
+					Message:       reply,
+					Done:          true,
+					ollamaMetrics: ollamaMetrics{PromptEvalCount: 20, EvalCount: 3, EvalDuration: int64(time.Second)},
+				})
+			}))
+			defer ollama.Close()
+
+			agent := NewResearchAgent()
+			agent.initMCPServices()
+			agent.mcpHandler.testMode = true
+			agent.llm = newOllamaProvider(ollama.URL, ollama.Client(), "llama3.2")
+
+			result, _ := agent.processResearchRequest(ctx, shared.JobMessage{
+				JobID:       "job-9",
+				Query:       "Research about Go microservices",
+				MCPServices: []shared.MCPService{shared.MCPServiceWeb},
+			})
+			if result.Status != tt.status {
+				t.Fatalf("Expected status %s, got %s (%s)", tt.status, result.Status, result.Error)
+			}
+			if result.PromptTokens != 40 || result.CompletionTokens != 6 || result.TokensUsed != 46 || result.TokensPerSecond != 3 {
+				t.Errorf("Expected the tokens spent before stopping, got %d prompt, %d completion, %v tokens/s",
+					result.PromptTokens, result.CompletionTokens, result.TokensPerSecond)
+			}
+			if result.Model != "llama3.2" {
+				t.Errorf("Expected the model to be reported, got %q", result.Model)
+			}
+		})
+	}
+}
+
+func TestProcessResearchRequestRetryable(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		retryable  bool
+	}{
+		{"server error", http.StatusServiceUnavailable, true},
+		{"rate limited", http.StatusTooManyRequests, true},
+		{"model not found", http.StatusNotFound, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ollama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, tt.name, tt.statusCode)
+			}))
+			defer ollama.Close()
+
+			agent := NewResearchAgent()
+			agent.initMCPServices()
+			agent.mcpHandler.testMode = true
+			agent.llm = newOllamaProvider(ollama.URL, ollama.Client(), "llama3.2")
+
+			result, retryable := agent.processResearchRequest(context.Background(), shared.JobMessage{
+				JobID:       "job-4",
+				Query:       "Research about Go microservices",
+				MCPServices: []shared.MCPService{shared.MCPServiceWeb},
+			})
+			if result.Status != shared.JobStatusFailed {
+				t.Errorf("Expected status %s, got %s", shared.JobStatusFailed, result.Status)
+			}
+			if retryable != tt.retryable {
+				t.Errorf("Expected retryable %v, got %v", tt.retryable, retryable)
+			}
+		})
+	}
+}
+
+func TestIsTransientError(t *testing.T) {
+	ctx := context.Background()
+	refused := &url.Error{Op: "Post", URL: "http://localhost:11434/api/chat", Err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}}
+	if !isTransientError(ctx, refused) {
+		t.Error("Expected network errors to be transient")
+	}
+	if !isTransientError(ctx, fmt.Errorf("failed to read stream: %w", io.ErrUnexpectedEOF)) {
+		t.Error("Expected cut off responses to be transient")
+	}
+	if isTransientError(ctx, &llmAPIError{Provider: LLMProviderOllama, Message: "model not found"}) {
+		t.Error("Expected streamed API errors to be permanent")
+	}
+	if !isTransientError(ctx, &llmAPIError{Provider: LLMProviderOllama, StatusCode: http.StatusBadGateway}) {
+		t.Error("Expected server errors to be transient")
+	}
+	if isTransientError(ctx, errors.New("failed to render prompt template")) {
+		t.Error("Expected other errors to be permanent")
+	}
+	if isTransientError(ctx, &url.Error{Op: "Post", URL: "ftp://ollama", Err: errors.New("unsupported protocol scheme")}) {
+		t.Error("Expected a misconfigured URL to be permanent")
+	}
+
+	expired, cancel := context.WithTimeout(ctx, 0)
+	defer cancel()
+	<-expired.Done()
+	if isTransientError(expired, &url.Error{Op: "Post", URL: "http://localhost:11434/api/chat", Err: context.DeadlineExceeded}) {
+		t.Error("Expected errors after the job ran out of time to be permanent")
+	}
+}
+
+func TestRunWorkerPoolBoundsConcurrency(t *testing.T) {
+	deliveries := make(chan amqp.Delivery)
+	go func() {
+		for i := 0; i < 20; i++ {
+			deliveries <- amqp.Delivery{}
+		}
+		close(deliveries)
+	}()
+
+	var running, maxRunning, handled int32
+	runWorkerPool(3, deliveries, func(amqp.Delivery) {
+		current := atomic.AddInt32(&running, 1)
+		for {
+			observed := atomic.LoadInt32(&maxRunning)
+			if current <= observed || atomic.CompareAndSwapInt32(&maxRunning, observed, current) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		atomic.AddInt32(&handled, 1)
+	})
+
+	if handled != 20 {
+		t.Errorf("Expected 20 deliveries handled, got %d", handled)
+	}
+	if maxRunning > 3 {
+		t.Errorf("Expected at most 3 concurrent jobs, got %d", maxRunning)
+	}
+}
+
+func TestBroadcastStopsOnShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var rounds int32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		broadcast(ctx, "test report", time.Millisecond, func(context.Context) error {
+			if atomic.AddInt32(&rounds, 1) == 3 {
+				cancel()
+			}
+			return nil
+		})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected broadcast to return once its context ended")
+	}
+	if rounds != 3 {
+		t.Errorf("Expected 3 rounds before shutdown, got %d", rounds)
+	}
+}
+
+func TestHealthHandlerWithoutRabbitMQ(t *testing.T) {
+	agent := NewResearchAgent()
+	agent.mcpHandler = NewMCPServiceHandler([]mcpServerConfig{
+		{Name: shared.MCPServiceWeb, URL: "http://127.0.0.1:1", Transport: MCPTransportStreamableHTTP},
+	}, false)
+
+	w := httptest.NewRecorder()
+	agent.healthHandler(w, httptest.NewRequest("GET", "/health", nil))
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+
+	var response map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if response["service"] != "job-runner" {
+		t.Errorf("Expected service job-runner, got %v", response["service"])
+	}
+	mcp, _ := response["mcp"].([]interface{})
+	if len(mcp) != 1 || mcp[0].(map[string]interface{})["breaker"] != "closed" {
+		t.Errorf("Expected the MCP breaker states, got %v", response["mcp"])
 	}
 }
 
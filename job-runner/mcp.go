@@ -0,0 +1,725 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// mcpProtocolVersion is the MCP revision this client speaks; servers may
+// answer with an older revision they support
+const mcpProtocolVersion = "2025-03-26"
+
+// MCP transports supported by the client
+const (
+	MCPTransportStreamableHTTP = "http"
+	MCPTransportSSE            = "sse"
+)
+
+// errMCPSessionExpired is returned when a Streamable HTTP server no longer
+// knows the session; the client must initialize again
+var errMCPSessionExpired = errors.New("mcp session expired")
+
+// jsonrpcMessage is a JSON-RPC 2.0 request, notification or response
+type jsonrpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *jsonrpcError   `json:"error,omitempty"`
+}
+
+// isResponse reports whether the message answers a request
+func (m *jsonrpcMessage) isResponse() bool {
+	return m.ID != nil && m.Method == ""
+}
+
+// jsonrpcError is the error member of a JSON-RPC response
+type jsonrpcError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *jsonrpcError) Error() string {
+	return fmt.Sprintf("mcp error %d: %s", e.Code, e.Message)
+}
+
+// mcpToolError is a tool result with isError set: the server answered, but
+// the tool failed
+type mcpToolError struct {
+	tool    string
+	message string
+}
+
+func (e *mcpToolError) Error() string {
+	return fmt.Sprintf("mcp tool %s failed: %s", e.tool, e.message)
+}
+
+// mcpTransport carries JSON-RPC messages to an MCP server. Send returns the
+// response for requests and nil for notifications.
+type mcpTransport interface {
+	Send(ctx context.Context, msg *jsonrpcMessage) (*jsonrpcMessage, error)
+	Close() error
+}
+
+// MCPImplementation identifies an MCP client or server
+type MCPImplementation struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// MCPTool describes a tool offered by an MCP server
+type MCPTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"inputSchema,omitempty"`
+}
+
+// MCPResourceContents is a resource embedded in a tool result
+type MCPResourceContents struct {
+	URI      string `json:"uri"`
+	MimeType string `json:"mimeType,omitempty"`
+	Text     string `json:"text,omitempty"`
+}
+
+// MCPContent is one item of a tool result: text, an embedded resource or a
+// link to a resource
+type MCPContent struct {
+	Type     string               `json:"type"`
+	Text     string               `json:"text,omitempty"`
+	URI      string               `json:"uri,omitempty"`
+	Name     string               `json:"name,omitempty"`
+	MimeType string               `json:"mimeType,omitempty"`
+	Resource *MCPResourceContents `json:"resource,omitempty"`
+}
+
+// MCPToolResult is the result of tools/call
+type MCPToolResult struct {
+	Content           []MCPContent    `json:"content"`
+	StructuredContent json.RawMessage `json:"structuredContent,omitempty"`
+	IsError           bool            `json:"isError,omitempty"`
+}
+
+// urlPattern finds links in tool output that did not come as resources
+var urlPattern = regexp.MustCompile(`https?://[^\s<>"'\)\]]+`)
+
+// Text joins the textual content of the result
+func (r *MCPToolResult) Text() string {
+	var parts []string
+	for _, content := range r.Content {
+		switch {
+		case content.Type == "text" && content.Text != "":
+			parts = append(parts, content.Text)
+		case content.Type == "resource" && content.Resource != nil && content.Resource.Text != "":
+			parts = append(parts, content.Resource.Text)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// Sources lists the resource URIs referenced by the result, followed by any
+// URLs mentioned in its text, without duplicates
+func (r *MCPToolResult) Sources() []string {
+	var sources []string
+	seen := make(map[string]bool)
+	add := func(source string) {
+		source = strings.TrimRight(source, ".,;:")
+		if source != "" && !seen[source] {
+			seen[source] = true
+			sources = append(sources, source)
+		}
+	}
+
+	for _, content := range r.Content {
+		switch {
+		case content.Type == "resource_link":
+			add(content.URI)
+		case content.Type == "resource" && content.Resource != nil:
+			add(content.Resource.URI)
+		}
+	}
+	for _, match := range urlPattern.FindAllString(r.Text(), -1) {
+		add(match)
+	}
+	return sources
+}
+
+// MCPClient is a Model Context Protocol client. Initialize must be called
+// before any other method.
+type MCPClient struct {
+	transport mcpTransport
+	nextID    atomic.Int64
+
+	ServerInfo      MCPImplementation
+	ProtocolVersion string
+	Instructions    string
+}
+
+// NewMCPClient creates a client that talks over the given transport
+func NewMCPClient(transport mcpTransport) *MCPClient {
+	return &MCPClient{transport: transport}
+}
+
+// DialMCP connects to the MCP server at serverURL using the named transport
+// and performs the initialization handshake. A URL without a path gets the
+// conventional endpoint for the transport: /mcp or /sse.
+func DialMCP(ctx context.Context, serverURL, transport string, httpClient *http.Client) (*MCPClient, error) {
+	endpoint, err := mcpEndpoint(serverURL, transport)
+	if err != nil {
+		return nil, err
+	}
+
+	var t mcpTransport
+	switch transport {
+	case MCPTransportStreamableHTTP, "":
+		t = newStreamableHTTPTransport(endpoint, httpClient)
+	case MCPTransportSSE:
+		t, err = dialSSETransport(ctx, endpoint, httpClient)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported MCP transport: %s", transport)
+	}
+
+	client := NewMCPClient(t)
+	if err := client.Initialize(ctx); err != nil {
+		t.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// mcpEndpoint appends the default endpoint path for a transport to a bare server URL
+func mcpEndpoint(serverURL, transport string) (string, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid MCP server URL %q: %w", serverURL, err)
+	}
+	if u.Path == "" || u.Path == "/" {
+		if transport == MCPTransportSSE {
+			u.Path = "/sse"
+		} else {
+			u.Path = "/mcp"
+		}
+	}
+	return u.String(), nil
+}
+
+// Initialize performs the MCP handshake: the initialize request followed by
+// the notifications/initialized notification
+func (c *MCPClient) Initialize(ctx context.Context) error {
+	params := map[string]interface{}{
+		"protocolVersion": mcpProtocolVersion,
+		"capabilities":    map[string]interface{}{},
+		"clientInfo": MCPImplementation{
+			Name:    "research-agent",
+			Version: "1.0.0",
+		},
+	}
+
+	var result struct {
+		ProtocolVersion string            `json:"protocolVersion"`
+		ServerInfo      MCPImplementation `json:"serverInfo"`
+		Instructions    string            `json:"instructions,omitempty"`
+	}
+	if err := c.call(ctx, "initialize", params, &result); err != nil {
+		return fmt.Errorf("mcp initialize failed: %w", err)
+	}
+
+	c.ServerInfo = result.ServerInfo
+	c.ProtocolVersion = result.ProtocolVersion
+	c.Instructions = result.Instructions
+
+	// Streamable HTTP must announce the negotiated version on later requests
+	if versioned, ok := c.transport.(interface{ setProtocolVersion(string) }); ok {
+		versioned.setProtocolVersion(result.ProtocolVersion)
+	}
+
+	return c.Notify(ctx, "notifications/initialized", nil)
+}
+
+// ListTools returns every tool the server offers, following pagination
+func (c *MCPClient) ListTools(ctx context.Context) ([]MCPTool, error) {
+	var tools []MCPTool
+	cursor := ""
+	for {
+		params := map[string]interface{}{}
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+
+		var result struct {
+			Tools      []MCPTool `json:"tools"`
+			NextCursor string    `json:"nextCursor,omitempty"`
+		}
+		if err := c.call(ctx, "tools/list", params, &result); err != nil {
+			return nil, err
+		}
+
+		tools = append(tools, result.Tools...)
+		if result.NextCursor == "" {
+			return tools, nil
+		}
+		cursor = result.NextCursor
+	}
+}
+
+// CallTool invokes a tool. A result with IsError set is returned as an error
+// carrying the tool's message.
+func (c *MCPClient) CallTool(ctx context.Context, name string, arguments map[string]interface{}) (*MCPToolResult, error) {
+	params := map[string]interface{}{
+		"name":      name,
+		"arguments": arguments,
+	}
+
+	var result MCPToolResult
+	if err := c.call(ctx, "tools/call", params, &result); err != nil {
+		return nil, err
+	}
+	if result.IsError {
+		return &result, &mcpToolError{tool: name, message: result.Text()}
+	}
+	return &result, nil
+}
+
+// Ping checks the server answers requests
+func (c *MCPClient) Ping(ctx context.Context) error {
+	return c.call(ctx, "ping", nil, nil)
+}
+
+// Notify sends a notification, which has no response
+func (c *MCPClient) Notify(ctx context.Context, method string, params interface{}) error {
+	msg, err := newJSONRPCMessage(nil, method, params)
+	if err != nil {
+		return err
+	}
+	_, err = c.transport.Send(ctx, msg)
+	return err
+}
+
+// Close releases the transport and, for Streamable HTTP, ends the session
+func (c *MCPClient) Close() error {
+	return c.transport.Close()
+}
+
+// call sends a request and decodes its result into out
+func (c *MCPClient) call(ctx context.Context, method string, params interface{}, out interface{}) error {
+	id := json.RawMessage(strconv.FormatInt(c.nextID.Add(1), 10))
+	msg, err := newJSONRPCMessage(id, method, params)
+	if err != nil {
+		return err
+	}
+
+	response, err := c.transport.Send(ctx, msg)
+	if err != nil {
+		return err
+	}
+	if response == nil {
+		return fmt.Errorf("no response to %s", method)
+	}
+	if response.Error != nil {
+		return response.Error
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(response.Result, out); err != nil {
+		return fmt.Errorf("failed to decode %s result: %w", method, err)
+	}
+	return nil
+}
+
+// newJSONRPCMessage builds a request, or a notification when id is nil
+func newJSONRPCMessage(id json.RawMessage, method string, params interface{}) (*jsonrpcMessage, error) {
+	msg := &jsonrpcMessage{JSONRPC: "2.0", ID: id, Method: method}
+	if params != nil {
+		raw, err := json.Marshal(params)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal %s params: %w", method, err)
+		}
+		msg.Params = raw
+	}
+	return msg, nil
+}
+
+// sameID compares JSON-RPC ids by their JSON encoding
+func sameID(a, b json.RawMessage) bool {
+	return bytes.Equal(bytes.TrimSpace(a), bytes.TrimSpace(b))
+}
+
+// readSSE parses a Server-Sent Events stream, calling onEvent for every
+// event until it returns false or the stream ends
+func readSSE(r io.Reader, onEvent func(event, data string) bool) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	event := ""
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if len(data) > 0 {
+				if event == "" {
+					event = "message"
+				}
+				if !onEvent(event, strings.Join(data, "\n")) {
+					return nil
+				}
+			}
+			event, data = "", nil
+		case strings.HasPrefix(line, ":"):
+			// Comment, used as keepalive
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	return scanner.Err()
+}
+
+// streamableHTTPTransport implements the Streamable HTTP transport: every
+// message is POSTed to one endpoint, and the server answers with either a
+// JSON body or an SSE stream that carries the response
+type streamableHTTPTransport struct {
+	endpoint string
+	client   *http.Client
+
+	mu              sync.Mutex
+	sessionID       string
+	protocolVersion string
+}
+
+func newStreamableHTTPTransport(endpoint string, client *http.Client) *streamableHTTPTransport {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &streamableHTTPTransport{endpoint: endpoint, client: client}
+}
+
+func (t *streamableHTTPTransport) setProtocolVersion(version string) {
+	t.mu.Lock()
+	t.protocolVersion = version
+	t.mu.Unlock()
+}
+
+// newRequest builds a request carrying the session headers
+func (t *streamableHTTPTransport) newRequest(ctx context.Context, method string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, t.endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	if t.sessionID != "" {
+		req.Header.Set("Mcp-Session-Id", t.sessionID)
+	}
+	if t.protocolVersion != "" {
+		req.Header.Set("MCP-Protocol-Version", t.protocolVersion)
+	}
+	t.mu.Unlock()
+
+	return req, nil
+}
+
+func (t *streamableHTTPTransport) Send(ctx context.Context, msg *jsonrpcMessage) (*jsonrpcMessage, error) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := t.newRequest(ctx, "POST", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("mcp request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if sessionID := resp.Header.Get("Mcp-Session-Id"); sessionID != "" {
+		t.mu.Lock()
+		t.sessionID = sessionID
+		t.mu.Unlock()
+	}
+
+	if resp.StatusCode == http.StatusNotFound && req.Header.Get("Mcp-Session-Id") != "" {
+		return nil, errMCPSessionExpired
+	}
+
+	// Notifications are acknowledged without a body
+	if msg.ID == nil {
+		if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+			return nil, mcpStatusError(resp)
+		}
+		return nil, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, mcpStatusError(resp)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		var response jsonrpcMessage
+		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+			return nil, fmt.Errorf("failed to decode mcp response: %w", err)
+		}
+		return &response, nil
+	case "text/event-stream":
+		return readSSEResponse(resp.Body, msg.ID)
+	default:
+		return nil, fmt.Errorf("unexpected mcp response content type %q", resp.Header.Get("Content-Type"))
+	}
+}
+
+// readSSEResponse reads an SSE stream until the response to id arrives.
+// Server notifications and requests sent on the same stream are skipped.
+func readSSEResponse(r io.Reader, id json.RawMessage) (*jsonrpcMessage, error) {
+	var response *jsonrpcMessage
+	var decodeErr error
+	err := readSSE(r, func(event, data string) bool {
+		if event != "message" {
+			return true
+		}
+		var msg jsonrpcMessage
+		if err := json.Unmarshal([]byte(data), &msg); err != nil {
+			decodeErr = fmt.Errorf("failed to decode mcp message: %w", err)
+			return false
+		}
+		if msg.isResponse() && sameID(msg.ID, id) {
+			response = &msg
+			return false
+		}
+		return true
+	})
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("mcp event stream failed: %w", err)
+	}
+	if response == nil {
+		return nil, fmt.Errorf("mcp event stream ended without a response")
+	}
+	return response, nil
+}
+
+// Close ends the session so the server can release it
+func (t *streamableHTTPTransport) Close() error {
+	t.mu.Lock()
+	sessionID := t.sessionID
+	t.mu.Unlock()
+	if sessionID == "" {
+		return nil
+	}
+
+	req, err := t.newRequest(context.Background(), "DELETE", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// sseTransport implements the HTTP+SSE transport: responses arrive on a
+// long-lived event stream and messages are POSTed to the endpoint the server
+// announces on that stream
+type sseTransport struct {
+	client   *http.Client
+	postURL  string
+	cancel   context.CancelFunc
+	closed   chan struct{}
+	closeErr error
+
+	mu      sync.Mutex
+	pending map[string]chan *jsonrpcMessage
+}
+
+// dialSSETransport opens the event stream and waits for the endpoint event
+func dialSSETransport(ctx context.Context, streamURL string, client *http.Client) (*sseTransport, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	// The stream outlives ctx, which only bounds the handshake
+	streamCtx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(streamCtx, "GET", streamURL, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	type dialResult struct {
+		resp *http.Response
+		err  error
+	}
+	dialed := make(chan dialResult, 1)
+	go func() {
+		resp, err := client.Do(req)
+		dialed <- dialResult{resp, err}
+	}()
+
+	var resp *http.Response
+	select {
+	case <-ctx.Done():
+		cancel()
+		return nil, ctx.Err()
+	case result := <-dialed:
+		if result.err != nil {
+			cancel()
+			return nil, fmt.Errorf("mcp event stream failed: %w", result.err)
+		}
+		resp = result.resp
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		cancel()
+		return nil, mcpStatusError(resp)
+	}
+
+	t := &sseTransport{
+		client:  client,
+		cancel:  cancel,
+		closed:  make(chan struct{}),
+		pending: make(map[string]chan *jsonrpcMessage),
+	}
+
+	endpoint := make(chan string, 1)
+	go t.readStream(resp, endpoint)
+
+	select {
+	case <-ctx.Done():
+		t.Close()
+		return nil, ctx.Err()
+	case <-t.closed:
+		return nil, fmt.Errorf("mcp event stream closed before endpoint event: %v", t.closeErr)
+	case postPath := <-endpoint:
+		postURL, err := resp.Request.URL.Parse(postPath)
+		if err != nil {
+			t.Close()
+			return nil, fmt.Errorf("invalid mcp endpoint %q: %w", postPath, err)
+		}
+		t.postURL = postURL.String()
+		return t, nil
+	}
+}
+
+// readStream dispatches events from the server until the stream ends
+func (t *sseTransport) readStream(resp *http.Response, endpoint chan<- string) {
+	defer resp.Body.Close()
+
+	err := readSSE(resp.Body, func(event, data string) bool {
+		switch event {
+		case "endpoint":
+			select {
+			case endpoint <- data:
+			default:
+			}
+		case "message":
+			var msg jsonrpcMessage
+			if err := json.Unmarshal([]byte(data), &msg); err != nil || !msg.isResponse() {
+				return true
+			}
+			t.mu.Lock()
+			waiter, ok := t.pending[string(bytes.TrimSpace(msg.ID))]
+			t.mu.Unlock()
+			if ok {
+				waiter <- &msg
+			}
+		}
+		return true
+	})
+	if err == nil {
+		err = io.EOF
+	}
+	t.closeErr = err
+	close(t.closed)
+}
+
+func (t *sseTransport) Send(ctx context.Context, msg *jsonrpcMessage) (*jsonrpcMessage, error) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var waiter chan *jsonrpcMessage
+	if msg.ID != nil {
+		key := string(bytes.TrimSpace(msg.ID))
+		waiter = make(chan *jsonrpcMessage, 1)
+		t.mu.Lock()
+		t.pending[key] = waiter
+		t.mu.Unlock()
+		defer func() {
+			t.mu.Lock()
+			delete(t.pending, key)
+			t.mu.Unlock()
+		}()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", t.postURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("mcp request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		return nil, mcpStatusError(resp)
+	}
+
+	if waiter == nil {
+		return nil, nil
+	}
+
+	select {
+	case response := <-waiter:
+		return response, nil
+	case <-t.closed:
+		return nil, fmt.Errorf("mcp event stream closed: %v", t.closeErr)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close ends the event stream
+func (t *sseTransport) Close() error {
+	t.cancel()
+	return nil
+}
+
+// mcpStatusError describes an unexpected HTTP status from an MCP server
+func mcpStatusError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("MCP server returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+}
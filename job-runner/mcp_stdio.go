@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// MCPTransportStdio runs the MCP server as a child process that speaks
+// newline-delimited JSON-RPC on stdin and stdout
+const MCPTransportStdio = "stdio"
+
+const (
+	// stdioRestartInitialDelay and stdioRestartMaxDelay bound the backoff
+	// between restarts of a crashing server process
+	stdioRestartInitialDelay = 1 * time.Second
+	stdioRestartMaxDelay     = 30 * time.Second
+
+	// stdioStableRuntime is how long a process must run before a crash no
+	// longer counts towards the restart backoff
+	stdioStableRuntime = time.Minute
+
+	// stdioShutdownTimeout is how long each shutdown step waits for the
+	// process to exit: first after closing stdin, then after SIGTERM
+	stdioShutdownTimeout = 5 * time.Second
+)
+
+// errMCPProcessStopped is returned once a supervised process has been shut down
+var errMCPProcessStopped = errors.New("mcp server process stopped")
+
+// mcpProcess supervises an MCP server running as a child process. The process
+// is started immediately and restarted with backoff whenever it exits, until
+// Close is called. Its stderr is copied to the log.
+type mcpProcess struct {
+	name    string
+	command []string
+
+	mu       sync.Mutex
+	current  *stdioConn
+	ready    chan struct{}
+	restarts int
+
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+// startMCPProcess starts supervising the server run by command. name
+// identifies the server in logs.
+func startMCPProcess(name string, command []string) (*mcpProcess, error) {
+	if len(command) == 0 {
+		return nil, fmt.Errorf("no command configured for MCP server %s", name)
+	}
+
+	p := &mcpProcess{
+		name:    name,
+		command: command,
+		ready:   make(chan struct{}),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go p.supervise()
+	return p, nil
+}
+
+// supervise runs the process and restarts it whenever it exits
+func (p *mcpProcess) supervise() {
+	defer close(p.stopped)
+
+	delay := stdioRestartInitialDelay
+	for {
+		started := time.Now()
+		conn, err := spawnStdioConn(p.name, p.command, p.exited)
+		if err != nil {
+			log.Printf("Failed to start MCP server %s: %v", p.name, err)
+		} else {
+			log.Printf("Started MCP server %s (pid %d)", p.name, conn.cmd.Process.Pid)
+			p.mu.Lock()
+			p.current = conn
+			close(p.ready)
+			p.mu.Unlock()
+
+			select {
+			case <-p.done:
+				conn.shutdown()
+				return
+			case <-conn.exited:
+				// Covers a process that exited before it was published
+				p.exited(conn)
+			}
+
+			if time.Since(started) > stdioStableRuntime {
+				delay = stdioRestartInitialDelay
+			}
+			log.Printf("MCP server %s exited (%v), restarting in %v", p.name, conn.exitErr, delay)
+		}
+
+		select {
+		case <-p.done:
+			return
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > stdioRestartMaxDelay {
+			delay = stdioRestartMaxDelay
+		}
+	}
+}
+
+// exited forgets a process that is no longer running, before its callers
+// can observe the exit
+func (p *mcpProcess) exited(conn *stdioConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.current == conn {
+		p.current = nil
+		p.ready = make(chan struct{})
+		p.restarts++
+	}
+}
+
+// conn returns the running process, waiting for a restart if necessary
+func (p *mcpProcess) conn(ctx context.Context) (*stdioConn, error) {
+	for {
+		p.mu.Lock()
+		current, ready := p.current, p.ready
+		p.mu.Unlock()
+
+		if current != nil {
+			return current, nil
+		}
+
+		select {
+		case <-ready:
+		case <-p.done:
+			return nil, errMCPProcessStopped
+		case <-ctx.Done():
+			return nil, fmt.Errorf("MCP server %s is not running: %w", p.name, ctx.Err())
+		}
+	}
+}
+
+// Restarts reports how often the process has exited and been restarted
+func (p *mcpProcess) Restarts() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.restarts
+}
+
+// Close stops supervising and shuts the process down gracefully
+func (p *mcpProcess) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.done)
+	})
+	<-p.stopped
+	return nil
+}
+
+// DialMCPProcess performs the initialization handshake with a supervised
+// server process. The returned client fails with errMCPSessionExpired once
+// the process exits; dialing again reaches the restarted process.
+func DialMCPProcess(ctx context.Context, p *mcpProcess) (*MCPClient, error) {
+	conn, err := p.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	client := NewMCPClient(&stdioTransport{conn: conn})
+	if err := client.Initialize(ctx); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// stdioConn is one run of a server process
+type stdioConn struct {
+	name  string
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[string]chan *jsonrpcMessage
+
+	exited  chan struct{}
+	exitErr error
+}
+
+// spawnStdioConn starts the process and the goroutines that read its output.
+// onExit is called when the process exits, before exited is closed.
+func spawnStdioConn(name string, command []string, onExit func(*stdioConn)) (*stdioConn, error) {
+	cmd := exec.Command(command[0], command[1:]...)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	// Wait returns only after stdout has been fully read, so every response
+	// is dispatched before the process counts as exited. WaitDelay keeps a
+	// grandchild holding the pipes open from blocking Wait forever.
+	stdout, stdoutWriter := io.Pipe()
+	cmd.Stdout = stdoutWriter
+	cmd.Stderr = &logLineWriter{prefix: fmt.Sprintf("[mcp %s] ", name)}
+	cmd.WaitDelay = stdioShutdownTimeout
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	c := &stdioConn{
+		name:    name,
+		cmd:     cmd,
+		stdin:   stdin,
+		pending: make(map[string]chan *jsonrpcMessage),
+		exited:  make(chan struct{}),
+	}
+
+	go c.readMessages(stdout)
+	go func() {
+		err := cmd.Wait()
+		stdoutWriter.Close()
+		if err == nil {
+			err = io.EOF
+		}
+		c.exitErr = err
+		onExit(c)
+		close(c.exited)
+	}()
+
+	return c, nil
+}
+
+// readMessages dispatches responses to their callers and answers requests
+// the server sends to the client
+func (c *stdioConn) readMessages(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var msg jsonrpcMessage
+		if err := json.Unmarshal(line, &msg); err != nil {
+			log.Printf("[mcp %s] ignoring malformed message: %v", c.name, err)
+			continue
+		}
+
+		switch {
+		case msg.isResponse():
+			c.mu.Lock()
+			waiter, ok := c.pending[string(bytes.TrimSpace(msg.ID))]
+			c.mu.Unlock()
+			if ok {
+				waiter <- &msg
+			}
+		case msg.ID != nil:
+			c.answerRequest(&msg)
+		}
+	}
+
+	// Drain whatever is left so the process never blocks writing stdout
+	io.Copy(io.Discard, stdout)
+}
+
+// answerRequest replies to a request from the server. Only ping is
+// supported, since the client declares no capabilities.
+func (c *stdioConn) answerRequest(msg *jsonrpcMessage) {
+	response := &jsonrpcMessage{JSONRPC: "2.0", ID: msg.ID}
+	if msg.Method == "ping" {
+		response.Result = json.RawMessage("{}")
+	} else {
+		response.Error = &jsonrpcError{Code: -32601, Message: "method not found: " + msg.Method}
+	}
+
+	if err := c.write(response); err != nil {
+		log.Printf("[mcp %s] failed to answer %s: %v", c.name, msg.Method, err)
+	}
+}
+
+// write sends one message as a line on stdin
+func (c *stdioConn) write(msg *jsonrpcMessage) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err = c.stdin.Write(append(body, '\n'))
+	return err
+}
+
+// shutdown stops the process the way MCP recommends: close stdin, then
+// SIGTERM, then kill, waiting stdioShutdownTimeout between the steps
+func (c *stdioConn) shutdown() {
+	c.writeMu.Lock()
+	c.stdin.Close()
+	c.writeMu.Unlock()
+
+	select {
+	case <-c.exited:
+		return
+	case <-time.After(stdioShutdownTimeout):
+	}
+
+	if err := c.cmd.Process.Signal(syscall.SIGTERM); err == nil {
+		select {
+		case <-c.exited:
+			return
+		case <-time.After(stdioShutdownTimeout):
+		}
+	}
+
+	log.Printf("MCP server %s did not exit, killing it", c.name)
+	c.cmd.Process.Kill()
+	<-c.exited
+}
+
+// stdioTransport sends messages to one run of a server process
+type stdioTransport struct {
+	conn *stdioConn
+}
+
+func (t *stdioTransport) Send(ctx context.Context, msg *jsonrpcMessage) (*jsonrpcMessage, error) {
+	select {
+	case <-t.conn.exited:
+		return nil, errMCPSessionExpired
+	default:
+	}
+
+	var waiter chan *jsonrpcMessage
+	if msg.ID != nil {
+		key := string(bytes.TrimSpace(msg.ID))
+		waiter = make(chan *jsonrpcMessage, 1)
+		t.conn.mu.Lock()
+		t.conn.pending[key] = waiter
+		t.conn.mu.Unlock()
+		defer func() {
+			t.conn.mu.Lock()
+			delete(t.conn.pending, key)
+			t.conn.mu.Unlock()
+		}()
+	}
+
+	if err := t.conn.write(msg); err != nil {
+		return nil, fmt.Errorf("mcp request failed: %w", err)
+	}
+
+	if waiter == nil {
+		return nil, nil
+	}
+
+	select {
+	case response := <-waiter:
+		return response, nil
+	case <-t.conn.exited:
+		// The supervisor restarts the process; the caller must initialize again
+		return nil, errMCPSessionExpired
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close does nothing: the process belongs to its supervisor and outlives
+// individual sessions
+func (t *stdioTransport) Close() error {
+	return nil
+}
+
+// logLineWriter logs everything written to it line by line
+type logLineWriter struct {
+	prefix string
+	buf    []byte
+}
+
+func (w *logLineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if line := bytes.TrimRight(w.buf[:i], "\r"); len(line) > 0 {
+			log.Printf("%s%s", w.prefix, line)
+		}
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"microservices-demo/shared"
+)
+
+// TestHelperMCPStdioServer is not a real test: it is the stdio MCP server
+// spawned by the tests below, re-running the test binary
+func TestHelperMCPStdioServer(t *testing.T) {
+	if os.Getenv("GO_WANT_MCP_STDIO_SERVER") != "1" {
+		return
+	}
+
+	fmt.Fprintln(os.Stderr, "fake stdio server ready")
+
+	fake := &fakeMCPServer{t: t}
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var msg jsonrpcMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			continue
+		}
+		if msg.Method == "tools/call" && strings.Contains(string(msg.Params), `"crash"`) {
+			os.Exit(3)
+		}
+		if response := fake.handle(msg); response != nil {
+			body, _ := json.Marshal(response)
+			fmt.Printf("%s\n", body)
+		}
+	}
+	// stdin closed: exit gracefully
+	os.Exit(0)
+}
+
+// startHelperMCPProcess supervises the helper server
+func startHelperMCPProcess(t *testing.T) *mcpProcess {
+	t.Setenv("GO_WANT_MCP_STDIO_SERVER", "1")
+	process, err := startMCPProcess("helper", []string{os.Args[0], "-test.run=^TestHelperMCPStdioServer$"})
+	if err != nil {
+		t.Fatalf("startMCPProcess failed: %v", err)
+	}
+	t.Cleanup(func() { process.Close() })
+	return process
+}
+
+// syncBuffer collects log output written from several goroutines
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestMCPStdioTransport(t *testing.T) {
+	logs := &syncBuffer{}
+	log.SetOutput(logs)
+	defer log.SetOutput(os.Stderr)
+
+	process := startHelperMCPProcess(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := DialMCPProcess(ctx, process)
+	if err != nil {
+		t.Fatalf("DialMCPProcess failed: %v", err)
+	}
+	if client.ServerInfo.Name != "fake-search" {
+		t.Errorf("Expected server fake-search, got %q", client.ServerInfo.Name)
+	}
+
+	tools, err := client.ListTools(ctx)
+	if err != nil {
+		t.Fatalf("ListTools failed: %v", err)
+	}
+	if len(tools) != 2 {
+		t.Fatalf("Expected 2 tools across pages, got %d", len(tools))
+	}
+
+	result, err := client.CallTool(ctx, "web_search", map[string]interface{}{"q": "golang"})
+	if err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	if !strings.Contains(result.Text(), "Results for golang") {
+		t.Errorf("Unexpected text: %q", result.Text())
+	}
+
+	// A crash expires the session, and the supervisor restarts the process
+	if _, err := client.CallTool(ctx, "web_search", map[string]interface{}{"q": "crash"}); !errors.Is(err, errMCPSessionExpired) {
+		t.Fatalf("Expected errMCPSessionExpired after crash, got %v", err)
+	}
+
+	client, err = DialMCPProcess(ctx, process)
+	if err != nil {
+		t.Fatalf("DialMCPProcess after restart failed: %v", err)
+	}
+	if _, err := client.CallTool(ctx, "web_search", map[string]interface{}{"q": "again"}); err != nil {
+		t.Fatalf("CallTool after restart failed: %v", err)
+	}
+	if process.Restarts() != 1 {
+		t.Errorf("Expected 1 restart, got %d", process.Restarts())
+	}
+
+	// Closing stdin lets the server exit on its own
+	process.Close()
+	if _, err := process.conn(ctx); !errors.Is(err, errMCPProcessStopped) {
+		t.Errorf("Expected errMCPProcessStopped after Close, got %v", err)
+	}
+	if strings.Contains(logs.String(), "killing") {
+		t.Error("Expected graceful shutdown without killing the process")
+	}
+	if !strings.Contains(logs.String(), "[mcp helper] fake stdio server ready") {
+		t.Errorf("Expected server stderr in the log, got:\n%s", logs.String())
+	}
+}
+
+func TestQueryMCPServiceWithStdioServer(t *testing.T) {
+	agent := NewResearchAgent()
+	agent.initMCPServices()
+	defer agent.mcpHandler.Close()
+	agent.mcpHandler.testMode = false
+	agent.mcpHandler.servers[shared.MCPServiceWeb] = mcpServerConfig{
+		Transport: MCPTransportStdio,
+		Tools:     []string{"web_search"},
+	}
+	agent.mcpHandler.processes[shared.MCPServiceWeb] = startHelperMCPProcess(t)
+
+	data, _, err := agent.queryMCPService(context.Background(), shared.MCPServiceWeb, shared.JobMessage{Query: "go concurrency"})
+	if err != nil {
+		t.Fatalf("queryMCPService failed: %v", err)
+	}
+	if !strings.Contains(data, "Results for go concurrency") {
+		t.Errorf("Expected data from the stdio server, got %q", data)
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"microservices-demo/shared"
+)
+
+// fakeMCPServer is an in-process MCP server offering a single search tool
+// over both the Streamable HTTP (/mcp) and HTTP+SSE (/sse) transports
+type fakeMCPServer struct {
+	t *testing.T
+
+	mu            sync.Mutex
+	initialized   bool
+	notifications []string
+	calls         []map[string]interface{}
+	sseStreams    map[string]chan []byte
+}
+
+func newFakeMCPServer(t *testing.T) *httptest.Server {
+	fake := &fakeMCPServer{t: t, sseStreams: make(map[string]chan []byte)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/mcp", fake.serveStreamableHTTP)
+	mux.HandleFunc("/sse", fake.serveSSE)
+	mux.HandleFunc("/messages", fake.serveSSEMessage)
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// handle answers one JSON-RPC message; notifications return nil
+func (f *fakeMCPServer) handle(msg jsonrpcMessage) *jsonrpcMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if msg.ID == nil {
+		f.notifications = append(f.notifications, msg.Method)
+		return nil
+	}
+
+	var result interface{}
+	switch msg.Method {
+	case "initialize":
+		f.initialized = true
+		result = map[string]interface{}{
+			"protocolVersion": mcpProtocolVersion,
+			"capabilities":    map[string]interface{}{"tools": map[string]interface{}{}},
+			"serverInfo":      MCPImplementation{Name: "fake-search", Version: "0.1.0"},
+		}
+	case "ping":
+		result = map[string]interface{}{}
+	case "tools/list":
+		var params struct {
+			Cursor string `json:"cursor"`
+		}
+		json.Unmarshal(msg.Params, &params)
+
+		// Two pages so pagination is exercised
+		if params.Cursor == "" {
+			result = map[string]interface{}{
+				"tools":      []MCPTool{{Name: "fetch", InputSchema: json.RawMessage(`{"type":"object","properties":{"url":{"type":"string"}}}`)}},
+				"nextCursor": "page-2",
+			}
+		} else {
+			result = map[string]interface{}{
+				"tools": []MCPTool{{
+					Name:        "web_search",
+					InputSchema: json.RawMessage(`{"type":"object","properties":{"q":{"type":"string"},"count":{"type":"integer"}},"required":["q"]}`),
+				}},
+			}
+		}
+	case "tools/call":
+		var params struct {
+			Name      string                 `json:"name"`
+			Arguments map[string]interface{} `json:"arguments"`
+		}
+		json.Unmarshal(msg.Params, &params)
+		f.calls = append(f.calls, params.Arguments)
+
+		if params.Name != "web_search" {
+			return &jsonrpcMessage{JSONRPC: "2.0", ID: msg.ID, Error: &jsonrpcError{Code: -32602, Message: "unknown tool"}}
+		}
+		if params.Arguments["q"] == "fail" {
+			result = MCPToolResult{IsError: true, Content: []MCPContent{{Type: "text", Text: "search backend down"}}}
+			break
+		}
+		if params.Arguments["q"] == "large" {
+			result = MCPToolResult{Content: []MCPContent{{Type: "text", Text: strings.Repeat("Go generics use type parameters with constraints.\n", 600)}}}
+			break
+		}
+		result = MCPToolResult{Content: []MCPContent{
+			{Type: "text", Text: fmt.Sprintf("Results for %v: see https://go.dev/doc/ and https://example.com/a.", params.Arguments["q"])},
+			{Type: "resource_link", URI: "https://pkg.go.dev/net/http", Name: "net/http"},
+		}}
+	default:
+		return &jsonrpcMessage{JSONRPC: "2.0", ID: msg.ID, Error: &jsonrpcError{Code: -32601, Message: "method not found"}}
+	}
+
+	raw, _ := json.Marshal(result)
+	return &jsonrpcMessage{JSONRPC: "2.0", ID: msg.ID, Result: raw}
+}
+
+func (f *fakeMCPServer) serveStreamableHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "DELETE" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var msg jsonrpcMessage
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if msg.Method == "initialize" {
+		w.Header().Set("Mcp-Session-Id", "session-1")
+	} else if r.Header.Get("Mcp-Session-Id") != "session-1" {
+		http.Error(w, "missing session", http.StatusBadRequest)
+		return
+	} else if r.Header.Get("MCP-Protocol-Version") != mcpProtocolVersion {
+		http.Error(w, "missing protocol version", http.StatusBadRequest)
+		return
+	}
+
+	response := f.handle(msg)
+	if response == nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	body, _ := json.Marshal(response)
+
+	// Answer tool calls over SSE, preceded by a progress notification
+	if msg.Method == "tools/call" {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "event: message\ndata: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/progress\",\"params\":{\"progress\":1}}\n\n")
+		fmt.Fprintf(w, "event: message\ndata: %s\n\n", body)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+func (f *fakeMCPServer) serveSSE(w http.ResponseWriter, r *http.Request) {
+	flusher := w.(http.Flusher)
+	stream := make(chan []byte, 8)
+
+	f.mu.Lock()
+	sessionID := fmt.Sprintf("sse-%d", len(f.sseStreams)+1)
+	f.sseStreams[sessionID] = stream
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	fmt.Fprintf(w, "event: endpoint\ndata: /messages?sessionId=%s\n\n", sessionID)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case body := <-stream:
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", body)
+			flusher.Flush()
+		}
+	}
+}
+
+func (f *fakeMCPServer) serveSSEMessage(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	stream, ok := f.sseStreams[r.URL.Query().Get("sessionId")]
+	f.mu.Unlock()
+	if !ok {
+		http.Error(w, "unknown session", http.StatusNotFound)
+		return
+	}
+
+	var msg jsonrpcMessage
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	if response := f.handle(msg); response != nil {
+		body, _ := json.Marshal(response)
+		stream <- body
+	}
+}
+
+func TestMCPClientTransports(t *testing.T) {
+	server := newFakeMCPServer(t)
+
+	for _, transport := range []string{MCPTransportStreamableHTTP, MCPTransportSSE} {
+		t.Run(transport, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			client, err := DialMCP(ctx, server.URL, transport, server.Client())
+			if err != nil {
+				t.Fatalf("DialMCP failed: %v", err)
+			}
+			defer client.Close()
+
+			if client.ServerInfo.Name != "fake-search" {
+				t.Errorf("Expected server fake-search, got %q", client.ServerInfo.Name)
+			}
+
+			tools, err := client.ListTools(ctx)
+			if err != nil {
+				t.Fatalf("ListTools failed: %v", err)
+			}
+			if len(tools) != 2 {
+				t.Fatalf("Expected 2 tools across pages, got %d", len(tools))
+			}
+
+			result, err := client.CallTool(ctx, "web_search", map[string]interface{}{"q": "golang"})
+			if err != nil {
+				t.Fatalf("CallTool failed: %v", err)
+			}
+			if !strings.Contains(result.Text(), "Results for golang") {
+				t.Errorf("Unexpected text: %q", result.Text())
+			}
+
+			sources := result.Sources()
+			expected := []string{"https://pkg.go.dev/net/http", "https://go.dev/doc/", "https://example.com/a"}
+			if strings.Join(sources, " ") != strings.Join(expected, " ") {
+				t.Errorf("Expected sources %v, got %v", expected, sources)
+			}
+
+			if _, err := client.CallTool(ctx, "web_search", map[string]interface{}{"q": "fail"}); err == nil {
+				t.Error("Expected tool error to be returned")
+			}
+
+			var rpcErr *jsonrpcError
+			if _, err := client.CallTool(ctx, "missing", nil); err == nil || !errors.As(err, &rpcErr) || rpcErr.Code != -32602 {
+				t.Errorf("Expected JSON-RPC error -32602, got %v", err)
+			}
+		})
+	}
+}
+
+func TestMCPSessionExpired(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "session not found", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	transport := newStreamableHTTPTransport(server.URL+"/mcp", server.Client())
+	transport.sessionID = "stale"
+
+	msg, _ := newJSONRPCMessage(json.RawMessage("1"), "tools/list", nil)
+	if _, err := transport.Send(context.Background(), msg); err != errMCPSessionExpired {
+		t.Errorf("Expected errMCPSessionExpired, got %v", err)
+	}
+}
+
+func TestSSETransportErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "message queue full", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	transport := &sseTransport{client: server.Client(), postURL: server.URL, closed: make(chan struct{}), pending: make(map[string]chan *jsonrpcMessage)}
+	msg, _ := newJSONRPCMessage(json.RawMessage("1"), "tools/list", nil)
+	if _, err := transport.Send(context.Background(), msg); err == nil || !strings.Contains(err.Error(), "503: message queue full") {
+		t.Errorf("Expected the server's error text, got %v", err)
+	}
+}
+
+func TestQueryMCPServiceWithRealServer(t *testing.T) {
+	server := newFakeMCPServer(t)
+
+	agent := NewResearchAgent()
+	agent.initMCPServices()
+	agent.mcpHandler.testMode = false
+	agent.mcpHandler.httpClient = server.Client()
+	agent.mcpHandler.servers[shared.MCPServiceWeb] = mcpServerConfig{
+		URL:       server.URL,
+		Transport: MCPTransportStreamableHTTP,
+		Tools:     []string{"search", "web_search"},
+	}
+
+	data, sources, err := agent.queryMCPService(context.Background(), shared.MCPServiceWeb, shared.JobMessage{Query: "go concurrency"})
+	if err != nil {
+		t.Fatalf("queryMCPService failed: %v", err)
+	}
+	if !strings.Contains(data, "Results for go concurrency") {
+		t.Errorf("Expected data from the MCP server, got %q", data)
+	}
+	if len(sources) != 3 {
+		t.Errorf("Expected 3 sources, got %v", sources)
+	}
+}
+
+func TestToolArguments(t *testing.T) {
+	tool := MCPTool{InputSchema: json.RawMessage(`{"properties":{"path":{"type":"string"},"pattern":{"type":"string"}},"required":["path","pattern"]}`)}
+
+	arguments := toolArguments(tool, "handler", map[string]interface{}{"path": "/data", "limit": 10})
+	if arguments["pattern"] != "handler" || arguments["path"] != "/data" {
+		t.Errorf("Unexpected arguments: %v", arguments)
+	}
+	if _, ok := arguments["limit"]; ok {
+		t.Error("Expected undeclared extras to be dropped")
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"microservices-demo/shared"
+)
+
+// Health probing of the MCP servers, overridable with MCP_PROBE_INTERVAL and
+// MCP_PROBE_TIMEOUT
+const (
+	defaultMCPProbeInterval = 15 * time.Second
+	defaultMCPProbeTimeout  = 5 * time.Second
+)
+
+// ping checks a service's server answers, connecting if needed. Sessions
+// that fail are dropped so the next request reconnects.
+func (h *MCPServiceHandler) ping(ctx context.Context, service shared.MCPService) error {
+	session, err := h.session(ctx, service)
+	if err != nil {
+		return err
+	}
+
+	err = session.client.Ping(ctx)
+	if isServerFailure(err) || errors.Is(err, errMCPSessionExpired) {
+		h.dropSession(service, session)
+	}
+	return err
+}
+
+// probe pings a service's server within timeout and tells its breaker
+func (h *MCPServiceHandler) probe(ctx context.Context, service shared.MCPService, timeout time.Duration) {
+	breaker, ok := h.breakers[service]
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeoutCause(ctx, timeout, errMCPCallTimeout)
+	defer cancel()
+
+	started := time.Now()
+	err := h.ping(ctx, service)
+	if err != nil && callerGaveUp(ctx) {
+		return
+	}
+	breaker.probed(err, time.Since(started))
+	if isServerFailure(err) {
+		log.Printf("Health probe of MCP service %s failed: %v", service, err)
+	}
+}
+
+// probeAll probes every registered server concurrently
+func (h *MCPServiceHandler) probeAll(ctx context.Context, timeout time.Duration) {
+	var wg sync.WaitGroup
+	for _, service := range h.order {
+		wg.Add(1)
+		go func(service shared.MCPService) {
+			defer wg.Done()
+			h.probe(ctx, service, timeout)
+		}(service)
+	}
+	wg.Wait()
+}
+
+// Health describes the circuit breaker and latest probe of every registered
+// server. In test mode a service is healthy if it has simulated data.
+func (h *MCPServiceHandler) Health() []shared.MCPServiceHealth {
+	services := make([]shared.MCPServiceHealth, 0, len(h.order))
+	for _, service := range h.order {
+		health := h.breakers[service].health()
+		if h.testMode {
+			_, health.Healthy = mcpSimulators[service]
+		}
+		services = append(services, health)
+	}
+	return services
+}
+
+// monitorMCPServices periodically probes the MCP servers and broadcasts
+// their health until ctx ends so API servers can report it
+func (ra *ResearchAgent) monitorMCPServices(ctx context.Context) {
+	id := runnerID()
+	interval := getEnvDuration("MCP_PROBE_INTERVAL", defaultMCPProbeInterval)
+	timeout := getEnvDuration("MCP_PROBE_TIMEOUT", defaultMCPProbeTimeout)
+
+	broadcast(ctx, "MCP status", interval, func(ctx context.Context) error {
+		// Test mode never talks to the servers
+		if !ra.mcpHandler.testMode {
+			ra.mcpHandler.probeAll(ctx, timeout)
+		}
+
+		return ra.rabbitmq.PublishMCPStatus(ctx, shared.MCPStatusReport{
+			RunnerID:    id,
+			TestMode:    ra.mcpHandler.testMode,
+			Services:    ra.mcpHandler.Health(),
+			PublishedAt: time.Now(),
+		})
+	})
+}
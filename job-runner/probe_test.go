@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"microservices-demo/shared"
+)
+
+func TestProbeMCPServices(t *testing.T) {
+	search := newFakeMCPServer(t)
+	h := NewMCPServiceHandler([]mcpServerConfig{
+		{Name: shared.MCPServiceWeb, URL: search.URL, Transport: MCPTransportStreamableHTTP, Tools: []string{"web_search"}},
+		{Name: shared.MCPServiceGitHub, URL: "http://127.0.0.1:1", Transport: MCPTransportStreamableHTTP},
+	}, false)
+	defer h.Close()
+	h.setBreakerSettings(breakerSettings{failures: 1, cooldown: time.Minute, successes: 1})
+
+	h.probeAll(context.Background(), time.Second)
+
+	health := h.Health()
+	if len(health) != 2 {
+		t.Fatalf("Expected the health of both services, got %+v", health)
+	}
+	if web := health[0]; !web.Healthy || web.Breaker != shared.BreakerClosed || web.LastProbe == nil {
+		t.Errorf("Expected the web server to be healthy, got %+v", web)
+	}
+	if github := health[1]; github.Healthy || github.Breaker != shared.BreakerOpen || github.LastError == "" {
+		t.Errorf("Expected the GitHub server to be down, got %+v", github)
+	}
+
+	// Calls to the server that is down fail at once
+	started := time.Now()
+	_, err := h.callTool(context.Background(), shared.MCPServiceGitHub, "go", nil)
+	var openErr *circuitOpenError
+	if !errors.As(err, &openErr) || time.Since(started) > 100*time.Millisecond {
+		t.Errorf("Expected the open breaker to fail the call at once, got %v", err)
+	}
+	if _, err := h.callTool(context.Background(), shared.MCPServiceWeb, "go", nil); err != nil {
+		t.Errorf("Expected the web server to answer, got %v", err)
+	}
+}
+
+func TestGatherSkipsOpenCircuit(t *testing.T) {
+	agent := newOfflineAgent(mcpFallbackSimulate)
+	agent.mcpHandler.setBreakerSettings(breakerSettings{failures: 1, cooldown: time.Minute, successes: 1})
+	agent.mcpHandler.probeAll(context.Background(), time.Second)
+
+	data, _, outcomes, err := agent.gatherInformationWithMCP(context.Background(), shared.JobMessage{Query: "go", MCPServices: []shared.MCPService{shared.MCPServiceWeb}})
+	if err != nil {
+		t.Fatalf("gatherInformationWithMCP failed: %v", err)
+	}
+	if !strings.Contains(outcomes[0].Error, "circuit breaker open") || outcomes[0].Provenance != shared.ProvenanceSimulated {
+		t.Errorf("Expected the open breaker to be reported, got %+v", outcomes[0])
+	}
+	if !strings.Contains(data, "Simulated data: ") {
+		t.Errorf("Expected the fallback policy to still apply, got %q", data)
+	}
+}
+
+func TestHealthTestMode(t *testing.T) {
+	h := NewMCPServiceHandler([]mcpServerConfig{
+		{Name: shared.MCPServiceWeb, URL: "http://127.0.0.1:1", Transport: MCPTransportStreamableHTTP},
+		{Name: "custom", URL: "http://127.0.0.1:1", Transport: MCPTransportStreamableHTTP},
+	}, true)
+
+	health := h.Health()
+	if !health[0].Healthy || health[1].Healthy {
+		t.Errorf("Expected only services with simulated data to be healthy, got %+v", health)
+	}
+}
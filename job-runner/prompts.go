@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"microservices-demo/shared"
+)
+
+// builtinPrompts are the prompt templates compiled into the job runner
+//
+//go:embed prompts/*.tmpl
+var builtinPrompts embed.FS
+
+const (
+	// promptBaseFile defines the entry points every research type shares
+	promptBaseFile = "base.tmpl"
+
+	// promptReloadInterval is how often PROMPT_TEMPLATES_DIR is checked for changes
+	promptReloadInterval = 30 * time.Second
+)
+
+// promptEntryPoints are the templates the job runner renders; each research
+// type's set must be able to execute all of them
+var promptEntryPoints = []string{"agent_system", "agent_task", "report", "pipeline_system", "pipeline_prompt", "summarize_system", "summarize_prompt"}
+
+// promptFilePattern matches the research type and version in a template
+// file name such as market.v2.tmpl
+var promptFilePattern = regexp.MustCompile(`^([a-z0-9_-]+)\.v([0-9]+)\.tmpl$`)
+
+// promptData is what prompt templates are executed with
+type promptData struct {
+	Title        string
+	Query        string
+	ResearchType shared.ResearchType
+	// Data is the gathered information, set for the fixed pipeline only
+	Data string
+	// Missing are the services that did not answer in time or failed
+	Missing []shared.ServiceOutcome
+	// Part and Parts number the chunk of Data being summarized
+	Part     int
+	Parts    int
+	TestMode bool
+}
+
+// promptData collects the fields of a job the templates may use
+func (ra *ResearchAgent) promptData(job shared.JobMessage) promptData {
+	return promptData{
+		Title:        job.Title,
+		Query:        job.Query,
+		ResearchType: job.ResearchType,
+		TestMode:     ra.mcpHandler != nil && ra.mcpHandler.testMode,
+	}
+}
+
+// promptTemplate is the template set of one research type at one version
+type promptTemplate struct {
+	name    string
+	version int
+	// source is "builtin" or the file the template was read from
+	source string
+	tmpl   *template.Template
+}
+
+// render executes one of the entry points
+func (p *promptTemplate) render(name string, data promptData) (string, error) {
+	var out bytes.Buffer
+	if err := p.tmpl.ExecuteTemplate(&out, name, data); err != nil {
+		return "", fmt.Errorf("failed to render prompt %s from %s.v%d: %w", name, p.name, p.version, err)
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// promptFile is a template file before it is parsed
+type promptFile struct {
+	version int
+	source  string
+	content string
+}
+
+// promptLibrary holds the prompt templates of every research type. The
+// built-in templates can be replaced per research type by files in an
+// override directory, which is reloaded when its files change.
+type promptLibrary struct {
+	dir string
+
+	mu        sync.RWMutex
+	templates map[shared.ResearchType]*promptTemplate
+	// signature identifies the state of dir at the last reload
+	signature string
+}
+
+// newPromptLibrary loads the built-in templates and those in dir, if set
+func newPromptLibrary(dir string) (*promptLibrary, error) {
+	library := &promptLibrary{dir: dir}
+	if err := library.reload(); err != nil {
+		return nil, err
+	}
+	return library, nil
+}
+
+// forType returns the templates of a research type, falling back to the
+// general templates for types without their own
+func (l *promptLibrary) forType(researchType shared.ResearchType) *promptTemplate {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if prompt, ok := l.templates[researchType]; ok {
+		return prompt
+	}
+	return l.templates[shared.ResearchTypeGeneral]
+}
+
+// reload parses all templates and replaces the current ones if every set is
+// valid. On error the previous templates stay in use.
+func (l *promptLibrary) reload() error {
+	signature, err := l.dirSignature()
+	if err != nil {
+		return err
+	}
+
+	base, builtin, err := readPromptFiles(builtinPrompts, "prompts", "builtin")
+	if err != nil {
+		return err
+	}
+	files := latestPromptFiles(builtin)
+
+	if l.dir != "" {
+		overrideBase, overrides, err := readPromptFiles(os.DirFS(l.dir), ".", l.dir)
+		if err != nil {
+			return err
+		}
+		if overrideBase != nil {
+			base = overrideBase
+		}
+		// A research type in the directory replaces the built-in one
+		// whatever the versions, so an operator can also pin an older prompt
+		for name, file := range latestPromptFiles(overrides) {
+			files[name] = file
+		}
+	}
+	if base == nil {
+		return fmt.Errorf("no %s prompt template found", promptBaseFile)
+	}
+
+	templates := make(map[shared.ResearchType]*promptTemplate)
+	for name, file := range files {
+		prompt, err := parsePromptTemplate(name, base, file)
+		if err != nil {
+			return err
+		}
+		templates[shared.ResearchType(name)] = prompt
+	}
+	if _, ok := templates[shared.ResearchTypeGeneral]; !ok {
+		return fmt.Errorf("no prompt template for research type %s", shared.ResearchTypeGeneral)
+	}
+
+	l.mu.Lock()
+	l.templates = templates
+	l.signature = signature
+	l.mu.Unlock()
+	return nil
+}
+
+// readPromptFiles reads the base template and all versions of the research
+// type templates in a directory. Other files are ignored.
+func readPromptFiles(fsys fs.FS, dir, source string) (*promptFile, map[string][]promptFile, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read prompt templates from %s: %w", source, err)
+	}
+
+	var base *promptFile
+	versions := make(map[string][]promptFile)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		match := promptFilePattern.FindStringSubmatch(name)
+		if name != promptBaseFile && match == nil {
+			continue
+		}
+
+		data, err := fs.ReadFile(fsys, filepath.ToSlash(filepath.Join(dir, name)))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read prompt template %s: %w", name, err)
+		}
+		file := promptFile{source: source, content: string(data)}
+		if source != "builtin" {
+			file.source = filepath.Join(source, name)
+		}
+
+		if name == promptBaseFile {
+			base = &file
+			continue
+		}
+		file.version, _ = strconv.Atoi(match[2])
+		versions[match[1]] = append(versions[match[1]], file)
+	}
+	return base, versions, nil
+}
+
+// latestPromptFiles picks the highest version of each research type
+func latestPromptFiles(versions map[string][]promptFile) map[string]promptFile {
+	latest := make(map[string]promptFile)
+	for name, files := range versions {
+		for _, file := range files {
+			if current, ok := latest[name]; !ok || file.version > current.version {
+				latest[name] = file
+			}
+		}
+	}
+	return latest
+}
+
+// parsePromptTemplate combines the base template with a research type's
+// blocks and checks that every entry point renders
+func parsePromptTemplate(name string, base *promptFile, file promptFile) (*promptTemplate, error) {
+	tmpl, err := template.New(name).Parse(base.content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse prompt template %s from %s: %w", promptBaseFile, base.source, err)
+	}
+	if _, err := tmpl.Parse(file.content); err != nil {
+		return nil, fmt.Errorf("failed to parse prompt template %s.v%d from %s: %w", name, file.version, file.source, err)
+	}
+
+	prompt := &promptTemplate{name: name, version: file.version, source: file.source, tmpl: tmpl}
+	sample := promptData{
+		Title:        "title",
+		Query:        "query",
+		ResearchType: shared.ResearchType(name),
+		Data:         "data",
+		Missing:      []shared.ServiceOutcome{{Service: shared.MCPServiceWeb, Status: shared.ServiceStatusTimeout, Error: "no answer within 30s"}},
+		Part:         1,
+		Parts:        2,
+	}
+	for _, entryPoint := range promptEntryPoints {
+		if tmpl.Lookup(entryPoint) == nil {
+			return nil, fmt.Errorf("prompt template %s.v%d from %s does not define %s", name, file.version, file.source, entryPoint)
+		}
+		if _, err := prompt.render(entryPoint, sample); err != nil {
+			return nil, err
+		}
+	}
+	return prompt, nil
+}
+
+// dirSignature summarises the names, sizes and modification times of the
+// template files in the override directory
+func (l *promptLibrary) dirSignature() (string, error) {
+	if l.dir == "" {
+		return "", nil
+	}
+
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to read prompt templates from %s: %w", l.dir, err)
+	}
+
+	var parts []string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".tmpl") {
+			continue
+		}
+		// Stat follows the symlinks Kubernetes mounts ConfigMap files as
+		info, err := os.Stat(filepath.Join(l.dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s:%d:%d", entry.Name(), info.Size(), info.ModTime().UnixNano()))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ","), nil
+}
+
+// watch reloads the templates whenever the files in the override directory
+// change. Kubernetes updates mounted ConfigMaps in place, so edits take
+// effect without a restart.
+func (l *promptLibrary) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		signature, err := l.dirSignature()
+		if err != nil {
+			log.Printf("Failed to check prompt templates: %v", err)
+			continue
+		}
+
+		l.mu.RLock()
+		changed := signature != l.signature
+		l.mu.RUnlock()
+		if !changed {
+			continue
+		}
+
+		if err := l.reload(); err != nil {
+			log.Printf("Keeping the previous prompt templates: %v", err)
+			// Do not retry until the files change again
+			l.mu.Lock()
+			l.signature = signature
+			l.mu.Unlock()
+			continue
+		}
+		log.Printf("Reloaded prompt templates from %s", l.dir)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"microservices-demo/shared"
+)
+
+func TestBuiltinPromptTemplates(t *testing.T) {
+	library, err := newPromptLibrary("")
+	if err != nil {
+		t.Fatalf("newPromptLibrary failed: %v", err)
+	}
+
+	data := promptData{Title: "Vector databases", Query: "Which vector database should we use?"}
+	systemPrompts := make(map[string]shared.ResearchType)
+	for _, researchType := range []shared.ResearchType{
+		shared.ResearchTypeGeneral, shared.ResearchTypeTechnical, shared.ResearchTypeMarket,
+		shared.ResearchTypeCompetitive, shared.ResearchTypeCode, shared.ResearchTypeData,
+	} {
+		prompt := library.forType(researchType)
+		if prompt.name != string(researchType) || prompt.version != 1 || prompt.source != "builtin" {
+			t.Errorf("Expected the built-in %s template, got %s.v%d from %s", researchType, prompt.name, prompt.version, prompt.source)
+		}
+
+		data.ResearchType = researchType
+		system, err := prompt.render("agent_system", data)
+		if err != nil {
+			t.Fatalf("Failed to render %s: %v", researchType, err)
+		}
+		if other, ok := systemPrompts[system]; ok {
+			t.Errorf("Expected %s and %s research to have different prompts", researchType, other)
+		}
+		systemPrompts[system] = researchType
+	}
+
+	if prompt := library.forType("astrology"); prompt.name != string(shared.ResearchTypeGeneral) {
+		t.Errorf("Expected unknown research types to use the general template, got %s", prompt.name)
+	}
+
+	task, _ := library.forType(shared.ResearchTypeMarket).render("pipeline_prompt", promptData{Title: "T", Query: "Q", Data: "gathered data", TestMode: true})
+	if !strings.Contains(task, "gathered data") || !strings.Contains(task, "placeholder examples") {
+		t.Errorf("Expected the gathered data and the test mode note, got %q", task)
+	}
+	chunk, _ := library.forType(shared.ResearchTypeCode).render("summarize_prompt", promptData{Query: "Q", Data: "chunk data", Part: 2, Parts: 3})
+	if !strings.Contains(chunk, "Part 2 of 3 of the Gathered Information:\nchunk data") {
+		t.Errorf("Expected the chunk to be numbered, got %q", chunk)
+	}
+
+	missing := []shared.ServiceOutcome{{Service: shared.MCPServiceGitHub, Status: shared.ServiceStatusTimeout, Error: "no answer within 30s"}}
+	report, _ := library.forType(shared.ResearchTypeGeneral).render("report", promptData{Missing: missing})
+	if !strings.Contains(report, "- github: timeout (no answer within 30s)") {
+		t.Errorf("Expected the report prompt to name the missing sources, got %q", report)
+	}
+	if report, _ := library.forType(shared.ResearchTypeGeneral).render("report", promptData{}); strings.Contains(report, "could not be consulted") {
+		t.Errorf("Expected no missing sources to be mentioned, got %q", report)
+	}
+}
+
+func writePromptTemplate(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+}
+
+func TestPromptTemplateOverrides(t *testing.T) {
+	dir := t.TempDir()
+	writePromptTemplate(t, dir, "market.v1.tmpl", `{{define "focus"}}- Old focus{{end}}`)
+	writePromptTemplate(t, dir, "market.v3.tmpl", `{{define "focus"}}- Pricing only{{end}}`)
+	writePromptTemplate(t, dir, "README.md", "ignored")
+
+	library, err := newPromptLibrary(dir)
+	if err != nil {
+		t.Fatalf("newPromptLibrary failed: %v", err)
+	}
+
+	market := library.forType(shared.ResearchTypeMarket)
+	if market.version != 3 || market.source != filepath.Join(dir, "market.v3.tmpl") {
+		t.Errorf("Expected the latest version from the directory, got v%d from %s", market.version, market.source)
+	}
+	system, _ := market.render("agent_system", promptData{})
+	// Blocks the override leaves out come from the base template
+	if !strings.Contains(system, "- Pricing only") || !strings.Contains(system, "You are a professional research agent.") {
+		t.Errorf("Expected the overridden focus with the base role, got %q", system)
+	}
+	if code := library.forType(shared.ResearchTypeCode); code.source != "builtin" {
+		t.Errorf("Expected types without an override to stay built-in, got %s", code.source)
+	}
+
+	// A broken edit is rejected and the previous templates stay in use
+	writePromptTemplate(t, dir, "market.v4.tmpl", `{{define "focus"}}{{.Audience}}{{end}}`)
+	if err := library.reload(); err == nil {
+		t.Fatal("Expected a template referring to an unknown field to be rejected")
+	}
+	if library.forType(shared.ResearchTypeMarket).version != 3 {
+		t.Error("Expected the previous templates to stay in use")
+	}
+
+	writePromptTemplate(t, dir, "market.v4.tmpl", `{{define "focus"}}- Customers only{{end}}`)
+	if err := library.reload(); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	if library.forType(shared.ResearchTypeMarket).version != 4 {
+		t.Error("Expected the fixed template to be loaded")
+	}
+
+	if _, err := newPromptLibrary(filepath.Join(dir, "missing")); err == nil {
+		t.Error("Expected an error for a missing directory")
+	}
+}
@@ -0,0 +1,661 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"microservices-demo/shared"
+)
+
+// mcpCallTimeout bounds a single MCP tool call, including connecting, for
+// servers without a timeout of their own
+const mcpCallTimeout = 30 * time.Second
+
+// mcpServerConfig describes an MCP server in the registry
+type mcpServerConfig struct {
+	// Name is the service name jobs use to select the server
+	Name        shared.MCPService `json:"name"`
+	Title       string            `json:"title,omitempty"`
+	Description string            `json:"description,omitempty"`
+
+	URL       string `json:"url,omitempty"`
+	Transport string `json:"transport,omitempty"`
+	// Command runs the server as a child process for the stdio transport
+	Command []string `json:"command,omitempty"`
+
+	// Tools lists preferred tool names, tried in order
+	Tools []string `json:"tools,omitempty"`
+	// Arguments are passed to the tool alongside the query if its input
+	// schema declares them
+	Arguments map[string]interface{} `json:"arguments,omitempty"`
+	// Timeout bounds each tool call to the server, such as "10s"
+	Timeout string `json:"timeout,omitempty"`
+	timeout time.Duration
+	// Fallback is the policy when the server fails: fail, skip or
+	// simulate. Empty uses MCP_FALLBACK.
+	Fallback string `json:"fallback,omitempty"`
+	// CacheTTL is how long the server's results are cached, such as "1h";
+	// "0" disables caching. Empty uses MCP_CACHE_TTL.
+	CacheTTL string `json:"cache_ttl,omitempty"`
+	cacheTTL time.Duration
+
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+// mcpRegistryFile is the format of the MCP_SERVERS_CONFIG file
+type mcpRegistryFile struct {
+	Servers []mcpServerConfig `json:"servers"`
+}
+
+// loadMCPServers reads the registry from the JSON file at path, or builds
+// the built-in servers from environment variables if path is empty.
+// Disabled servers are left out.
+func loadMCPServers(path string) ([]mcpServerConfig, error) {
+	if path == "" {
+		servers := mcpServersFromEnv()
+		for i := range servers {
+			if err := servers[i].validate(); err != nil {
+				return nil, fmt.Errorf("invalid MCP server %q: %w", servers[i].Name, err)
+			}
+		}
+		return servers, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read MCP servers config: %w", err)
+	}
+
+	var file mcpRegistryFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse MCP servers config %s: %w", path, err)
+	}
+
+	var servers []mcpServerConfig
+	seen := make(map[shared.MCPService]bool)
+	for _, server := range file.Servers {
+		if server.Disabled {
+			continue
+		}
+		if err := server.validate(); err != nil {
+			return nil, fmt.Errorf("invalid MCP server %q in %s: %w", server.Name, path, err)
+		}
+		if seen[server.Name] {
+			return nil, fmt.Errorf("duplicate MCP server %q in %s", server.Name, path)
+		}
+		seen[server.Name] = true
+		servers = append(servers, server)
+	}
+	return servers, nil
+}
+
+// validate checks the server can be reached and fills in the default transport
+func (c *mcpServerConfig) validate() error {
+	if c.Name == "" {
+		return errors.New("name is required")
+	}
+	if c.Title == "" {
+		c.Title = string(c.Name)
+	}
+	if c.Transport == "" {
+		c.Transport = MCPTransportStreamableHTTP
+		if len(c.Command) > 0 {
+			c.Transport = MCPTransportStdio
+		}
+	}
+
+	switch c.Transport {
+	case MCPTransportStdio:
+		if len(c.Command) == 0 {
+			return errors.New("command is required for the stdio transport")
+		}
+	case MCPTransportStreamableHTTP, MCPTransportSSE:
+		if c.URL == "" {
+			return fmt.Errorf("url is required for the %s transport", c.Transport)
+		}
+	default:
+		return fmt.Errorf("unsupported transport %q", c.Transport)
+	}
+
+	if c.Timeout != "" {
+		timeout, err := time.ParseDuration(c.Timeout)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid timeout %q", c.Timeout)
+		}
+		c.timeout = timeout
+	}
+	if c.Fallback != "" && !validFallback(c.Fallback) {
+		return fmt.Errorf("invalid fallback %q, expected fail, skip or simulate", c.Fallback)
+	}
+	if c.CacheTTL != "" {
+		ttl, err := time.ParseDuration(c.CacheTTL)
+		if err != nil || ttl < 0 {
+			return fmt.Errorf("invalid cache_ttl %q", c.CacheTTL)
+		}
+		c.cacheTTL = ttl
+	}
+	return nil
+}
+
+// mcpServersFromEnv builds the built-in web, GitHub and files servers from
+// environment variables. MCP_TRANSPORT sets the default transport;
+// MCP_<NAME>_TRANSPORT and MCP_<NAME>_TOOL override it per server,
+// MCP_<NAME>_TIMEOUT bounds its tool calls, MCP_<NAME>_FALLBACK sets its
+// fallback policy and MCP_<NAME>_CACHE_TTL how long its results are cached.
+// Setting MCP_<NAME>_COMMAND runs the server as a local process over stdio
+// instead.
+func mcpServersFromEnv() []mcpServerConfig {
+	transport := getEnvOrDefault("MCP_TRANSPORT", MCPTransportStreamableHTTP)
+
+	server := func(config mcpServerConfig, envName, defaultURL string) mcpServerConfig {
+		if tool := getEnvOrDefault("MCP_"+envName+"_TOOL", ""); tool != "" {
+			config.Tools = []string{tool}
+		}
+
+		config.Transport = transport
+		config.Command = strings.Fields(getEnvOrDefault("MCP_"+envName+"_COMMAND", ""))
+		if len(config.Command) > 0 {
+			config.Transport = MCPTransportStdio
+		}
+
+		config.URL = getEnvOrDefault("MCP_"+envName+"_SERVER_URL", defaultURL)
+		config.Transport = getEnvOrDefault("MCP_"+envName+"_TRANSPORT", config.Transport)
+		config.timeout = getEnvDuration("MCP_"+envName+"_TIMEOUT", mcpCallTimeout)
+		config.Fallback = getEnvOrDefault("MCP_"+envName+"_FALLBACK", "")
+		config.CacheTTL = getEnvOrDefault("MCP_"+envName+"_CACHE_TTL", "")
+		return config
+	}
+
+	return []mcpServerConfig{
+		server(mcpServerConfig{
+			Name:        shared.MCPServiceWeb,
+			Title:       "Web Search",
+			Description: "Search the web for current information",
+			Tools:       []string{"search", "web_search", "brave_web_search"},
+			Arguments:   map[string]interface{}{"limit": 10, "count": 10},
+		}, "WEB", "http://localhost:3001"),
+		server(mcpServerConfig{
+			Name:        shared.MCPServiceGitHub,
+			Title:       "GitHub",
+			Description: "Search GitHub repositories",
+			Tools:       []string{"search_repositories"},
+			Arguments:   map[string]interface{}{"sort": "stars", "order": "desc", "perPage": 10},
+		}, "GITHUB", "http://localhost:3002"),
+		server(mcpServerConfig{
+			Name:        shared.MCPServiceFiles,
+			Title:       "Local Files",
+			Description: "Search local and networked files",
+			Tools:       []string{"search_files"},
+			Arguments:   map[string]interface{}{"path": getEnvOrDefault("MCP_FILES_SEARCH_PATH", ".")},
+		}, "FILES", "http://localhost:3003"),
+	}
+}
+
+// mcpSession is an initialized connection and the tool chosen for research
+type mcpSession struct {
+	client *MCPClient
+	tool   MCPTool
+}
+
+// mcpSessionSlot serializes connecting to one server without blocking others
+type mcpSessionSlot struct {
+	mu      sync.Mutex
+	session *mcpSession
+}
+
+// MCPServiceHandler is the registry of MCP servers. It connects to servers
+// on first use, discovers their tools and caches the sessions.
+type MCPServiceHandler struct {
+	testMode bool
+	// fallback is the policy of servers without one of their own
+	fallback string
+	// defaultCacheTTL is how long results of servers without a cache TTL
+	// of their own are cached
+	defaultCacheTTL time.Duration
+
+	// servers holds the registry by service name; order keeps the config order
+	servers map[shared.MCPService]mcpServerConfig
+	order   []shared.MCPService
+
+	sessions   map[shared.MCPService]*mcpSessionSlot
+	sessionsMu sync.Mutex
+	httpClient *http.Client
+
+	// processes supervises the servers that use the stdio transport
+	processes map[shared.MCPService]*mcpProcess
+
+	// breakers stop requests to servers that keep failing
+	breakers map[shared.MCPService]*circuitBreaker
+}
+
+// NewMCPServiceHandler creates a registry of the given servers
+func NewMCPServiceHandler(servers []mcpServerConfig, testMode bool) *MCPServiceHandler {
+	h := &MCPServiceHandler{
+		testMode:        testMode,
+		fallback:        mcpFallbackSkip,
+		defaultCacheTTL: defaultMCPCacheTTL,
+		servers:         make(map[shared.MCPService]mcpServerConfig, len(servers)),
+		sessions:        make(map[shared.MCPService]*mcpSessionSlot),
+		httpClient:      &http.Client{},
+		processes:       make(map[shared.MCPService]*mcpProcess),
+		breakers:        make(map[shared.MCPService]*circuitBreaker, len(servers)),
+	}
+	for _, server := range servers {
+		h.servers[server.Name] = server
+		h.order = append(h.order, server.Name)
+		h.breakers[server.Name] = newCircuitBreaker(server.Name, breakerSettings{
+			failures:  defaultBreakerFailures,
+			cooldown:  defaultBreakerCooldown,
+			successes: defaultBreakerSuccesses,
+		})
+	}
+	return h
+}
+
+// setBreakerSettings changes the thresholds of every circuit breaker
+func (h *MCPServiceHandler) setBreakerSettings(settings breakerSettings) {
+	for _, breaker := range h.breakers {
+		breaker.settings = settings
+	}
+}
+
+// guard sends a request to a service's server unless its circuit breaker is
+// open, and tells the breaker how it went. Requests cut short by ctx ending
+// for any reason but the call timeout say nothing about the server.
+func (h *MCPServiceHandler) guard(ctx context.Context, service shared.MCPService, request func() error) error {
+	breaker, ok := h.breakers[service]
+	if !ok {
+		return request()
+	}
+
+	trial, err := breaker.allow()
+	if err != nil {
+		return err
+	}
+	err = request()
+	if err != nil && callerGaveUp(ctx) {
+		breaker.release(trial)
+		return err
+	}
+	breaker.record(trial, err)
+	return err
+}
+
+// has reports whether a service is in the registry
+func (h *MCPServiceHandler) has(service shared.MCPService) bool {
+	_, ok := h.servers[service]
+	return ok
+}
+
+// callTimeout is how long one tool call to a service's server may take
+func (h *MCPServiceHandler) callTimeout(service shared.MCPService) time.Duration {
+	if timeout := h.servers[service].timeout; timeout > 0 {
+		return timeout
+	}
+	return mcpCallTimeout
+}
+
+// withCallTimeout bounds a call to a service's server by its call timeout,
+// which the circuit breaker can tell apart from the caller giving up
+func (h *MCPServiceHandler) withCallTimeout(ctx context.Context, service shared.MCPService) (context.Context, context.CancelFunc) {
+	return context.WithTimeoutCause(ctx, h.callTimeout(service), errMCPCallTimeout)
+}
+
+// cacheTTL is how long results of a service's server are cached; zero
+// disables caching
+func (h *MCPServiceHandler) cacheTTL(service shared.MCPService) time.Duration {
+	if config := h.servers[service]; config.CacheTTL != "" {
+		return config.cacheTTL
+	}
+	return h.defaultCacheTTL
+}
+
+// startProcesses spawns the servers that use the stdio transport, so they
+// are ready for the first job
+func (h *MCPServiceHandler) startProcesses() {
+	for _, service := range h.order {
+		config := h.servers[service]
+		if config.Transport != MCPTransportStdio {
+			continue
+		}
+		process, err := startMCPProcess(string(service), config.Command)
+		if err != nil {
+			log.Printf("Failed to start MCP server for %s: %v", service, err)
+			continue
+		}
+		h.processes[service] = process
+	}
+}
+
+// Catalog describes every registered service. Outside test mode it connects
+// to each server and lists its tools, so the catalog reflects which servers
+// are reachable right now.
+func (h *MCPServiceHandler) Catalog(ctx context.Context) []shared.MCPServiceInfo {
+	services := make([]shared.MCPServiceInfo, len(h.order))
+
+	var wg sync.WaitGroup
+	for i, service := range h.order {
+		config := h.servers[service]
+		services[i] = shared.MCPServiceInfo{
+			Name:        config.Name,
+			Title:       config.Title,
+			Description: config.Description,
+			Transport:   config.Transport,
+		}
+
+		if h.testMode {
+			_, services[i].Available = mcpSimulators[service]
+			if !services[i].Available {
+				services[i].Error = "no simulated data in test mode"
+			}
+			continue
+		}
+
+		wg.Add(1)
+		go func(info *shared.MCPServiceInfo) {
+			defer wg.Done()
+
+			// Catalog refreshes bypass the circuit breakers; the health
+			// probes alone keep them up to date between jobs
+			ctx, cancel := h.withCallTimeout(ctx, info.Name)
+			defer cancel()
+			tools, err := h.listTools(ctx, info.Name)
+			if err != nil {
+				info.Error = err.Error()
+				return
+			}
+			info.Available = true
+			for _, tool := range tools {
+				info.Tools = append(info.Tools, shared.MCPToolInfo{Name: tool.Name, Description: tool.Description})
+			}
+		}(&services[i])
+	}
+	wg.Wait()
+
+	return services
+}
+
+// discoverTools lists the tools of a service's server for a job, unless its
+// circuit breaker is open
+func (h *MCPServiceHandler) discoverTools(ctx context.Context, service shared.MCPService) ([]MCPTool, error) {
+	ctx, cancel := h.withCallTimeout(ctx, service)
+	defer cancel()
+
+	var tools []MCPTool
+	err := h.guard(ctx, service, func() error {
+		var err error
+		tools, err = h.listTools(ctx, service)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tools, nil
+}
+
+// listTools lists the tools of a service's server, connecting if needed
+func (h *MCPServiceHandler) listTools(ctx context.Context, service shared.MCPService) ([]MCPTool, error) {
+	session, err := h.session(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+
+	tools, err := session.client.ListTools(ctx)
+	if err != nil {
+		h.dropSession(service, session)
+		return nil, err
+	}
+	return tools, nil
+}
+
+// mcpCatalogInterval is how often the job runner re-discovers tools and
+// advertises its MCP services
+const mcpCatalogInterval = 30 * time.Second
+
+// advertiseMCPServices periodically broadcasts the MCP catalog so API
+// servers can offer the services to users, until ctx ends
+func (ra *ResearchAgent) advertiseMCPServices(ctx context.Context) {
+	id := runnerID()
+	interval := getEnvDuration("MCP_CATALOG_INTERVAL", mcpCatalogInterval)
+
+	broadcast(ctx, "MCP catalog", interval, func(ctx context.Context) error {
+		return ra.rabbitmq.PublishMCPCatalog(ctx, shared.MCPCatalog{
+			RunnerID:    id,
+			TestMode:    ra.mcpHandler.testMode,
+			Services:    ra.mcpHandler.Catalog(ctx),
+			PublishedAt: time.Now(),
+		})
+	})
+}
+
+// callMCPTool runs the research tool of a service's MCP server for query and
+// returns its text and sources. extras are passed only if the tool accepts them.
+func (ra *ResearchAgent) callMCPTool(ctx context.Context, service shared.MCPService, query string, extras map[string]interface{}) (string, []string, error) {
+	ctx, cancel := ra.mcpHandler.withCallTimeout(ctx, service)
+	defer cancel()
+
+	result, err := ra.mcpHandler.callTool(ctx, service, query, extras)
+	if errors.Is(err, errMCPSessionExpired) {
+		// The server restarted or dropped the session; start a new one
+		result, err = ra.mcpHandler.callTool(ctx, service, query, extras)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	data := result.Text()
+	if data == "" {
+		return "", nil, fmt.Errorf("MCP server for %s returned no text", service)
+	}
+	return data, result.Sources(), nil
+}
+
+// callTool calls the research tool on a service's cached session
+func (h *MCPServiceHandler) callTool(ctx context.Context, service shared.MCPService, query string, extras map[string]interface{}) (result *MCPToolResult, err error) {
+	err = h.guard(ctx, service, func() error {
+		session, err := h.session(ctx, service)
+		if err != nil {
+			return err
+		}
+		result, err = h.callSessionTool(ctx, service, session, session.tool.Name, toolArguments(session.tool, query, extras))
+		return err
+	})
+	return result, err
+}
+
+// callNamedTool calls any tool of a service's MCP server with the given
+// arguments, as chosen by the research agent
+func (h *MCPServiceHandler) callNamedTool(ctx context.Context, service shared.MCPService, name string, arguments map[string]interface{}) (result *MCPToolResult, err error) {
+	err = h.guard(ctx, service, func() error {
+		session, err := h.session(ctx, service)
+		if err != nil {
+			return err
+		}
+		result, err = h.callSessionTool(ctx, service, session, name, arguments)
+		return err
+	})
+	return result, err
+}
+
+// callSessionTool calls a tool on a cached session, discarding the session
+// if it fails for reasons other than a tool error
+func (h *MCPServiceHandler) callSessionTool(ctx context.Context, service shared.MCPService, session *mcpSession, name string, arguments map[string]interface{}) (*MCPToolResult, error) {
+	result, err := session.client.CallTool(ctx, name, arguments)
+	if err != nil && result == nil {
+		h.dropSession(service, session)
+	}
+	return result, err
+}
+
+// session returns an initialized connection to a service's MCP server,
+// connecting and choosing a tool on first use
+func (h *MCPServiceHandler) session(ctx context.Context, service shared.MCPService) (*mcpSession, error) {
+	slot := h.slot(service)
+	slot.mu.Lock()
+	defer slot.mu.Unlock()
+
+	if slot.session != nil {
+		return slot.session, nil
+	}
+
+	config, ok := h.servers[service]
+	if !ok {
+		return nil, fmt.Errorf("no MCP server configured for %s", service)
+	}
+
+	client, err := h.dial(ctx, service, config)
+	if err != nil {
+		return nil, err
+	}
+
+	tools, err := client.ListTools(ctx)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	tool, ok := chooseTool(tools, config.Tools)
+	if !ok {
+		client.Close()
+		return nil, fmt.Errorf("MCP server %s (%s) offers no search tool", service, client.ServerInfo.Name)
+	}
+
+	log.Printf("Connected to MCP server %s %s for %s, using tool %s",
+		client.ServerInfo.Name, client.ServerInfo.Version, service, tool.Name)
+
+	slot.session = &mcpSession{client: client, tool: tool}
+	return slot.session, nil
+}
+
+// dial connects to a service's server: its supervised process for stdio,
+// otherwise its URL
+func (h *MCPServiceHandler) dial(ctx context.Context, service shared.MCPService, config mcpServerConfig) (*MCPClient, error) {
+	if config.Transport != MCPTransportStdio {
+		return DialMCP(ctx, config.URL, config.Transport, h.httpClient)
+	}
+
+	process, ok := h.processes[service]
+	if !ok {
+		return nil, fmt.Errorf("MCP server process for %s is not running", service)
+	}
+	return DialMCPProcess(ctx, process)
+}
+
+// Close ends every cached session and shuts down the server processes
+func (h *MCPServiceHandler) Close() {
+	h.sessionsMu.Lock()
+	slots := make([]*mcpSessionSlot, 0, len(h.sessions))
+	for _, slot := range h.sessions {
+		slots = append(slots, slot)
+	}
+	h.sessionsMu.Unlock()
+
+	for _, slot := range slots {
+		slot.mu.Lock()
+		if slot.session != nil {
+			slot.session.client.Close()
+			slot.session = nil
+		}
+		slot.mu.Unlock()
+	}
+
+	var wg sync.WaitGroup
+	for _, process := range h.processes {
+		wg.Add(1)
+		go func(process *mcpProcess) {
+			defer wg.Done()
+			process.Close()
+		}(process)
+	}
+	wg.Wait()
+}
+
+// slot returns the session slot of a service, creating it if needed
+func (h *MCPServiceHandler) slot(service shared.MCPService) *mcpSessionSlot {
+	h.sessionsMu.Lock()
+	defer h.sessionsMu.Unlock()
+
+	slot, ok := h.sessions[service]
+	if !ok {
+		slot = &mcpSessionSlot{}
+		h.sessions[service] = slot
+	}
+	return slot
+}
+
+// dropSession closes and forgets a session if it is still the cached one
+func (h *MCPServiceHandler) dropSession(service shared.MCPService, session *mcpSession) {
+	slot := h.slot(service)
+	slot.mu.Lock()
+	defer slot.mu.Unlock()
+
+	if slot.session == session {
+		slot.session = nil
+		session.client.Close()
+	}
+}
+
+// chooseTool picks the first preferred tool the server offers, falling back
+// to any tool with "search" in its name
+func chooseTool(tools []MCPTool, preferred []string) (MCPTool, bool) {
+	for _, name := range preferred {
+		for _, tool := range tools {
+			if tool.Name == name {
+				return tool, true
+			}
+		}
+	}
+	for _, tool := range tools {
+		if strings.Contains(tool.Name, "search") {
+			return tool, true
+		}
+	}
+	return MCPTool{}, false
+}
+
+// toolArguments puts query into the tool's query parameter and adds the
+// extras its input schema declares
+func toolArguments(tool MCPTool, query string, extras map[string]interface{}) map[string]interface{} {
+	var schema struct {
+		Properties map[string]struct {
+			Type string `json:"type"`
+		} `json:"properties"`
+		Required []string `json:"required"`
+	}
+	json.Unmarshal(tool.InputSchema, &schema)
+
+	queryParam := ""
+	for _, name := range []string{"query", "q", "pattern", "search"} {
+		if _, ok := schema.Properties[name]; ok {
+			queryParam = name
+			break
+		}
+	}
+	if queryParam == "" {
+		for _, name := range schema.Required {
+			if schema.Properties[name].Type == "string" {
+				queryParam = name
+				break
+			}
+		}
+	}
+	if queryParam == "" {
+		queryParam = "query"
+	}
+
+	arguments := map[string]interface{}{queryParam: query}
+	for name, value := range extras {
+		if _, ok := schema.Properties[name]; ok && name != queryParam {
+			arguments[name] = value
+		}
+	}
+	return arguments
+}
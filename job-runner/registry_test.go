@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"microservices-demo/shared"
+)
+
+func writeMCPServersConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "mcp-servers.json")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	return path
+}
+
+func TestLoadMCPServers(t *testing.T) {
+	path := writeMCPServersConfig(t, `{"servers": [
+		{"name": "web", "title": "Web Search", "url": "http://mcp-web:3001", "timeout": "5s", "cache_ttl": "0"},
+		{"name": "slack", "url": "http://mcp-slack:3004", "disabled": true},
+		{"name": "files", "command": ["mcp-server-filesystem", "/data"], "arguments": {"path": "/data"}}
+	]}`)
+
+	servers, err := loadMCPServers(path)
+	if err != nil {
+		t.Fatalf("loadMCPServers failed: %v", err)
+	}
+	if len(servers) != 2 {
+		t.Fatalf("Expected 2 enabled servers, got %d", len(servers))
+	}
+
+	if servers[0].Name != shared.MCPServiceWeb || servers[0].Transport != MCPTransportStreamableHTTP || servers[0].timeout != 5*time.Second {
+		t.Errorf("Unexpected web server: %+v", servers[0])
+	}
+	handler := NewMCPServiceHandler(servers, false)
+	if handler.callTimeout(shared.MCPServiceFiles) != mcpCallTimeout {
+		t.Errorf("Expected servers without a timeout to use the default, got %v", handler.callTimeout(shared.MCPServiceFiles))
+	}
+	if handler.cacheTTL(shared.MCPServiceWeb) != 0 || handler.cacheTTL(shared.MCPServiceFiles) != defaultMCPCacheTTL {
+		t.Errorf("Expected caching to be disabled for web only, got %v and %v", handler.cacheTTL(shared.MCPServiceWeb), handler.cacheTTL(shared.MCPServiceFiles))
+	}
+	files := servers[1]
+	if files.Transport != MCPTransportStdio || files.Title != "files" || files.Arguments["path"] != "/data" {
+		t.Errorf("Unexpected files server: %+v", files)
+	}
+}
+
+func TestLoadMCPServersInvalid(t *testing.T) {
+	tests := map[string]string{
+		"missing name":   `{"servers": [{"url": "http://mcp:3001"}]}`,
+		"missing url":    `{"servers": [{"name": "web"}]}`,
+		"missing cmd":    `{"servers": [{"name": "web", "transport": "stdio"}]}`,
+		"bad transport":  `{"servers": [{"name": "web", "url": "http://mcp:3001", "transport": "grpc"}]}`,
+		"bad timeout":    `{"servers": [{"name": "web", "url": "http://mcp:3001", "timeout": "soon"}]}`,
+		"bad fallback":   `{"servers": [{"name": "web", "url": "http://mcp:3001", "fallback": "retry"}]}`,
+		"bad cache ttl":  `{"servers": [{"name": "web", "url": "http://mcp:3001", "cache_ttl": "-1h"}]}`,
+		"duplicate name": `{"servers": [{"name": "web", "url": "http://a"}, {"name": "web", "url": "http://b"}]}`,
+		"malformed":      `{"servers": [`,
+	}
+
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := loadMCPServers(writeMCPServersConfig(t, content)); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+
+	if _, err := loadMCPServers(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+
+	t.Setenv("MCP_WEB_FALLBACK", "retry")
+	if _, err := loadMCPServers(""); err == nil {
+		t.Error("Expected an error for an invalid fallback in the environment")
+	}
+}
+
+func TestMCPCatalogDiscoversTools(t *testing.T) {
+	server := newFakeMCPServer(t)
+
+	handler := NewMCPServiceHandler([]mcpServerConfig{
+		{Name: "search", Title: "Search", URL: server.URL, Transport: MCPTransportStreamableHTTP},
+		{Name: "offline", Title: "Offline", URL: "http://127.0.0.1:1", Transport: MCPTransportStreamableHTTP},
+	}, false)
+	handler.httpClient = server.Client()
+	defer handler.Close()
+
+	catalog := handler.Catalog(context.Background())
+	if len(catalog) != 2 {
+		t.Fatalf("Expected 2 services, got %d", len(catalog))
+	}
+
+	search := catalog[0]
+	if search.Name != "search" || !search.Available {
+		t.Errorf("Expected search to be available, got %+v", search)
+	}
+	var toolNames []string
+	for _, tool := range search.Tools {
+		toolNames = append(toolNames, tool.Name)
+	}
+	if strings.Join(toolNames, ",") != "fetch,web_search" {
+		t.Errorf("Expected discovered tools fetch,web_search, got %v", toolNames)
+	}
+
+	offline := catalog[1]
+	if offline.Available || offline.Error == "" {
+		t.Errorf("Expected offline to be unavailable with an error, got %+v", offline)
+	}
+	if failures := handler.breakers["offline"].health().ConsecutiveFailures; failures != 0 {
+		t.Errorf("Expected catalog refreshes to leave the breaker alone, got %d failures", failures)
+	}
+}
+
+func TestMCPCatalogTestMode(t *testing.T) {
+	handler := NewMCPServiceHandler([]mcpServerConfig{
+		{Name: shared.MCPServiceWeb, Title: "Web Search", URL: "http://mcp-web:3001"},
+		{Name: shared.MCPServiceSlack, Title: "Slack", URL: "http://mcp-slack:3004"},
+	}, true)
+
+	catalog := handler.Catalog(context.Background())
+	if !catalog[0].Available {
+		t.Error("Expected simulated web service to be available")
+	}
+	if catalog[1].Available {
+		t.Error("Expected slack to be unavailable without simulated data")
+	}
+}
+
+func TestQueryMCPServiceUnregistered(t *testing.T) {
+	agent := NewResearchAgent()
+	agent.mcpHandler = NewMCPServiceHandler(nil, true)
+
+	if _, _, err := agent.queryMCPService(context.Background(), shared.MCPServiceWeb, shared.JobMessage{Query: "go"}); err == nil {
+		t.Error("Expected an error for a service missing from the registry")
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+
+	"microservices-demo/shared"
+)
+
+// reportSchema is the JSON schema of shared.Report the model is asked to
+// follow. Every property is required and no others are allowed, as strict
+// OpenAI-style structured output demands. Citations are left out: the model
+// cites the numbered sources inline and the job runner lists them.
+var reportSchema = json.RawMessage(`{
+  "type": "object",
+  "properties": {
+    "summary": {"type": "string"},
+    "key_findings": {"type": "array", "items": {"type": "string"}},
+    "sections": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "properties": {
+          "heading": {"type": "string"},
+          "content": {"type": "string"}
+        },
+        "required": ["heading", "content"],
+        "additionalProperties": false
+      }
+    },
+    "recommendations": {"type": "array", "items": {"type": "string"}},
+    "limitations": {"type": "array", "items": {"type": "string"}}
+  },
+  "required": ["summary", "key_findings", "sections", "recommendations", "limitations"],
+  "additionalProperties": false
+}`)
+
+// parseReport decodes the structured report the model wrote and renders it
+// as markdown. Models and servers that ignore the schema answer in
+// markdown, which is kept as it is with no structured report.
+func parseReport(reply string) (string, *shared.Report) {
+	var report shared.Report
+	if err := json.Unmarshal([]byte(reply), &report); err != nil || report.Summary == "" {
+		return reply, nil
+	}
+	return report.Markdown(), &report
+}
+
+// reportListHeadings are the headings of the report's list fields
+var reportListHeadings = map[string]string{
+	"key_findings":    "Key Findings",
+	"recommendations": "Recommendations",
+	"limitations":     "Limitations",
+}
+
+// reportStream renders the JSON report the model streams into markdown for
+// the status page, passing on each field as soon as it is complete. A reply
+// that does not start like a JSON object is passed through unchanged.
+type reportStream struct {
+	out     func(string)
+	started bool
+	plain   bool
+	pipe    *io.PipeWriter
+	done    chan struct{}
+}
+
+func newReportStream(out func(string)) *reportStream {
+	return &reportStream{out: out}
+}
+
+// Write takes the next chunk of the model's reply
+func (s *reportStream) Write(chunk string) {
+	if !s.started {
+		trimmed := strings.TrimLeftFunc(chunk, unicode.IsSpace)
+		if trimmed == "" {
+			return
+		}
+		s.started = true
+		if trimmed[0] != '{' {
+			s.plain = true
+		} else {
+			reader, writer := io.Pipe()
+			s.pipe = writer
+			s.done = make(chan struct{})
+			go s.render(reader)
+		}
+		chunk = trimmed
+	}
+
+	if s.plain {
+		s.out(chunk)
+		return
+	}
+	// Fails only once render has given up, which needs no handling
+	s.pipe.Write([]byte(chunk))
+}
+
+// Close waits until everything written has been rendered
+func (s *reportStream) Close() {
+	if s.pipe != nil {
+		s.pipe.Close()
+		<-s.done
+	}
+}
+
+// render decodes the report field by field. It stops at the first value
+// that does not fit the schema; the final result replaces the partial
+// output in any case.
+func (s *reportStream) render(r *io.PipeReader) {
+	defer close(s.done)
+	// Unblock Write if decoding stops early
+	defer r.CloseWithError(io.ErrClosedPipe)
+
+	decoder := json.NewDecoder(r)
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return
+	}
+
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return
+		}
+		key, _ := token.(string)
+
+		switch key {
+		case "summary":
+			var summary string
+			if decoder.Decode(&summary) != nil {
+				return
+			}
+			s.out(fmt.Sprintf("## Summary\n\n%s\n\n", strings.TrimSpace(summary)))
+		case "key_findings", "recommendations", "limitations":
+			count, ok := renderReportList(decoder, func(i int, item string) {
+				if i == 0 {
+					s.out(fmt.Sprintf("## %s\n\n", reportListHeadings[key]))
+				}
+				s.out(fmt.Sprintf("- %s\n", strings.TrimSpace(item)))
+			})
+			if !ok {
+				return
+			}
+			if count > 0 {
+				s.out("\n")
+			}
+		case "sections":
+			if _, ok := renderReportList(decoder, func(_ int, section shared.ReportSection) {
+				s.out(fmt.Sprintf("## %s\n\n%s\n\n", strings.TrimSpace(section.Heading), strings.TrimSpace(section.Content)))
+			}); !ok {
+				return
+			}
+		default:
+			var skipped json.RawMessage
+			if decoder.Decode(&skipped) != nil {
+				return
+			}
+		}
+	}
+}
+
+// renderReportList decodes a JSON array item by item, passing each to
+// render. It returns the number of items and whether the array was well
+// formed.
+func renderReportList[T any](decoder *json.Decoder, render func(int, T)) (int, bool) {
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		return 0, false
+	}
+
+	count := 0
+	for decoder.More() {
+		var item T
+		if decoder.Decode(&item) != nil {
+			return count, false
+		}
+		render(count, item)
+		count++
+	}
+	if _, err := decoder.Token(); err != nil {
+		return count, false
+	}
+	return count, true
+}
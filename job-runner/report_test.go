@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const testReportJSON = `{
+  "summary": "Go is great.",
+  "key_findings": ["Generics landed in 1.18", "Modules are the default"],
+  "sections": [{"heading": "Details", "content": "| Version | Year |\n| --- | --- |\n| 1.18 | 2022 |"}],
+  "recommendations": [],
+  "limitations": ["Only official sources were searched"]
+}`
+
+func TestParseReport(t *testing.T) {
+	markdown, report := parseReport(testReportJSON)
+	if report == nil {
+		t.Fatal("Expected a structured report")
+	}
+	if len(report.KeyFindings) != 2 || len(report.Sections) != 1 || len(report.Limitations) != 1 {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+	if markdown != report.Markdown() {
+		t.Errorf("Expected the rendered report, got %q", markdown)
+	}
+
+	// Models that ignore the schema answer in markdown
+	for _, reply := range []string{"# Report\n\nGo is great.", `{"title": "not a report"}`} {
+		markdown, report := parseReport(reply)
+		if report != nil || markdown != reply {
+			t.Errorf("Expected %q to be kept as markdown, got %q %+v", reply, markdown, report)
+		}
+	}
+}
+
+func TestReportStream(t *testing.T) {
+	var out strings.Builder
+	stream := newReportStream(func(chunk string) { out.WriteString(chunk) })
+	// Feed the reply in small pieces that split keys, strings and escapes
+	reply := "\n" + testReportJSON
+	for i := 0; i < len(reply); i += 7 {
+		stream.Write(reply[i:min(i+7, len(reply))])
+	}
+	stream.Close()
+
+	_, report := parseReport(testReportJSON)
+	if got := strings.TrimSpace(out.String()); got != report.Markdown() {
+		t.Errorf("Expected the streamed report to match the final one, got:\n%s\nwant:\n%s", got, report.Markdown())
+	}
+}
+
+func TestReportStreamPassesThroughMarkdown(t *testing.T) {
+	var out strings.Builder
+	stream := newReportStream(func(chunk string) { out.WriteString(chunk) })
+	for _, chunk := range []string{"  ", "# Report", "\n\nGo is great."} {
+		stream.Write(chunk)
+	}
+	stream.Close()
+
+	if out.String() != "# Report\n\nGo is great." {
+		t.Errorf("Unexpected output: %q", out.String())
+	}
+}
+
+func TestReportStreamStopsAtInvalidJSON(t *testing.T) {
+	var out strings.Builder
+	stream := newReportStream(func(chunk string) { out.WriteString(chunk) })
+	for _, chunk := range []string{`{"summary": "Go is great.", `, `"key_findings": "oops", `, `"limitations": ["none"]}`} {
+		stream.Write(chunk)
+	}
+	stream.Close()
+
+	if out.String() != "## Summary\n\nGo is great.\n\n" {
+		t.Errorf("Expected output up to the invalid field, got %q", out.String())
+	}
+}
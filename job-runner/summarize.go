@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"microservices-demo/shared"
+)
+
+// charsPerToken approximates how many bytes of text make one token. It is a
+// little below the usual four of English prose, so code and non-English text
+// are not underestimated.
+const charsPerToken = 3
+
+const (
+	// reportReserveTokens is the room left for the report when the job sets
+	// no max_tokens
+	reportReserveTokens = 1024
+
+	// chunkSummaryTokens bounds the summary of one chunk
+	chunkSummaryTokens = 512
+
+	// minChunkTokens keeps chunks useful when the prompts leave little room
+	minChunkTokens = 256
+
+	// maxSummaryRounds bounds how often summaries are summarized again
+	maxSummaryRounds = 3
+)
+
+// estimateTokens approximates the tokens of text for budgeting prompts
+func estimateTokens(text string) int {
+	return (len(text) + charsPerToken - 1) / charsPerToken
+}
+
+// messageTokens approximates the tokens of a conversation
+func messageTokens(messages []ChatMessage) int {
+	tokens := 0
+	for _, message := range messages {
+		tokens += estimateTokens(message.Content)
+		for _, call := range message.ToolCalls {
+			arguments, _ := json.Marshal(call.Function.Arguments)
+			tokens += estimateTokens(call.Function.Name) + estimateTokens(string(arguments))
+		}
+	}
+	return tokens
+}
+
+// contextWindow is the context in tokens the job's model gets, falling back
+// to LLM_CONTEXT_TOKENS if the inference server does not report it
+func (ra *ResearchAgent) contextWindow(ctx context.Context, jobMessage shared.JobMessage) int {
+	tokens, err := ra.llm.ContextLength(ctx, jobMessage.Model, jobMessage.Options)
+	if err != nil {
+		log.Printf("Failed to look up the context length of the model for job %s: %v", jobMessage.JobID, err)
+	}
+	if tokens <= 0 {
+		return getEnvInt("LLM_CONTEXT_TOKENS", defaultContextTokens)
+	}
+	return tokens
+}
+
+// reportReserve is the room in tokens kept for the report in a window of
+// the job's model: its max_tokens, at most half the window
+func reportReserve(jobMessage shared.JobMessage, window int) int {
+	reserve := reportReserveTokens
+	if jobMessage.Options != nil && jobMessage.Options.MaxTokens > 0 {
+		reserve = jobMessage.Options.MaxTokens
+	}
+	return min(reserve, window/2)
+}
+
+// condenseGathered makes the gathered information fit the model's context
+// next to the pipeline prompts and the report. Information that does not fit
+// is summarized with summarizeToFit. It returns the information to write the
+// report from and, if it was summarized, a trace step recording how.
+func (ra *ResearchAgent) condenseGathered(ctx context.Context, jobMessage shared.JobMessage, prompt *promptTemplate, mcpData string, missing []shared.ServiceOutcome) (string, *shared.TraceStep, Usage, error) {
+	window := ra.contextWindow(ctx, jobMessage)
+
+	data := ra.promptData(jobMessage)
+	data.Missing = missing
+	overhead, err := promptTokens(prompt, data, "pipeline_system", "pipeline_prompt")
+	if err != nil {
+		return "", nil, Usage{}, err
+	}
+	budget := max(window-overhead-reportReserve(jobMessage, window), minChunkTokens)
+	if estimateTokens(mcpData) <= budget {
+		return mcpData, nil, Usage{}, nil
+	}
+
+	started := time.Now()
+	condensed, chunks, rounds, usage, err := ra.summarizeToFit(ctx, jobMessage, prompt, data, window, mcpData, budget)
+	if err != nil {
+		return "", nil, usage, fmt.Errorf("failed to summarize gathered information: %w", err)
+	}
+
+	return condensed, &shared.TraceStep{
+		Type: shared.TraceStepNote,
+		Content: fmt.Sprintf("The gathered information (about %d tokens) did not fit the model's %d-token context; summarized %d chunks in %d rounds to about %d tokens",
+			estimateTokens(mcpData), window, chunks, rounds, estimateTokens(condensed)),
+		Tokens:     usage.Total(),
+		DurationMs: time.Since(started).Milliseconds(),
+	}, usage, nil
+}
+
+// summarizeToFit shrinks gathered information to budget tokens. It is split
+// into chunks that fit a window of the model next to the summarize prompts,
+// which are summarized one by one (map), and the summaries joined (reduce);
+// summaries that still do not fit are summarized again, for at most
+// maxSummaryRounds rounds. It returns the result and how many chunks and
+// rounds it took.
+func (ra *ResearchAgent) summarizeToFit(ctx context.Context, jobMessage shared.JobMessage, prompt *promptTemplate, data promptData, window int, text string, budget int) (string, int, int, Usage, error) {
+	data.Part, data.Parts = 1, 1
+	chunkOverhead, err := promptTokens(prompt, data, "summarize_system", "summarize_prompt")
+	if err != nil {
+		return "", 0, 0, Usage{}, err
+	}
+	chunkBudget := max(window-chunkOverhead-chunkSummaryTokens, minChunkTokens)
+
+	var usage Usage
+	condensed := text
+	chunks, rounds := 0, 0
+	for rounds < maxSummaryRounds && estimateTokens(condensed) > budget {
+		parts := chunkGathered(condensed, chunkBudget)
+		log.Printf("Summarizing %d chunks of gathered information for job %s to fit a %d-token context", len(parts), jobMessage.JobID, window)
+
+		summaries := make([]string, 0, len(parts))
+		for i, part := range parts {
+			data.Data, data.Part, data.Parts = part, i+1, len(parts)
+			summary, summaryUsage, err := ra.summarizeChunk(ctx, jobMessage, prompt, data)
+			if err != nil {
+				return "", chunks, rounds, usage, err
+			}
+			usage.Add(summaryUsage)
+			if summary != "" {
+				summaries = append(summaries, summary)
+			}
+		}
+		chunks += len(parts)
+		rounds++
+
+		summarized := strings.Join(summaries, "\n\n")
+		if len(summarized) >= len(condensed) {
+			// The model did not condense anything; another round would not either
+			break
+		}
+		condensed = summarized
+	}
+	if estimateTokens(condensed) > budget {
+		log.Printf("Gathered information for job %s still exceeds the context after %d rounds of summaries", jobMessage.JobID, rounds)
+	}
+	return condensed, chunks, rounds, usage, nil
+}
+
+// summarizeChunk asks the model for the summary of one chunk, in data
+func (ra *ResearchAgent) summarizeChunk(ctx context.Context, jobMessage shared.JobMessage, prompt *promptTemplate, data promptData) (string, Usage, error) {
+	systemPrompt, err := prompt.render("summarize_system", data)
+	if err != nil {
+		return "", Usage{}, err
+	}
+	userPrompt, err := prompt.render("summarize_prompt", data)
+	if err != nil {
+		return "", Usage{}, err
+	}
+
+	// Bound the summary so every round shrinks the information
+	options := shared.ModelOptions{MaxTokens: chunkSummaryTokens}
+	if jobMessage.Options != nil {
+		options = *jobMessage.Options
+		if options.MaxTokens <= 0 || options.MaxTokens > chunkSummaryTokens {
+			options.MaxTokens = chunkSummaryTokens
+		}
+	}
+
+	summary, usage, err := ra.llm.Generate(ctx, GenerateRequest{
+		Model:   jobMessage.Model,
+		System:  systemPrompt,
+		Prompt:  userPrompt,
+		Options: &options,
+	}, nil)
+	return strings.TrimSpace(summary), usage, err
+}
+
+// promptTokens estimates the tokens of the named entry points rendered with
+// data, which is what a prompt costs besides the data it carries
+func promptTokens(prompt *promptTemplate, data promptData, names ...string) (int, error) {
+	data.Data = ""
+	tokens := 0
+	for _, name := range names {
+		text, err := prompt.render(name, data)
+		if err != nil {
+			return 0, err
+		}
+		tokens += estimateTokens(text)
+	}
+	return tokens, nil
+}
+
+// gatheredBlock is the information one service returned, headed by the
+// "Sources:" label of its numbered sources
+type gatheredBlock struct {
+	header string
+	body   string
+}
+
+// gatheredBlocks splits gathered information at the source labels
+// citationIndex.add puts in front of each service's data. Text before the
+// first label, such as earlier summaries, forms a block without header.
+func gatheredBlocks(data string) []gatheredBlock {
+	var blocks []gatheredBlock
+	var current *gatheredBlock
+	for _, paragraph := range strings.Split(data, "\n\n") {
+		if strings.HasPrefix(paragraph, "Sources: ") {
+			blocks = append(blocks, gatheredBlock{header: paragraph})
+			current = &blocks[len(blocks)-1]
+			continue
+		}
+		if current == nil {
+			blocks = append(blocks, gatheredBlock{})
+			current = &blocks[len(blocks)-1]
+		}
+		if current.body != "" {
+			current.body += "\n\n"
+		}
+		current.body += paragraph
+	}
+	return blocks
+}
+
+// text joins the header and body back together
+func (b gatheredBlock) text() string {
+	if b.header == "" {
+		return b.body
+	}
+	if b.body == "" {
+		return b.header
+	}
+	return b.header + "\n\n" + b.body
+}
+
+// chunkGathered splits gathered information into chunks of at most budget
+// tokens. Small blocks share a chunk; a block too large for one is split at
+// paragraphs, lines or words, and every piece repeats the block's source
+// label so its citation numbers stay known.
+func chunkGathered(data string, budget int) []string {
+	var chunks, current []string
+	used := 0
+	add := func(text string) {
+		tokens := estimateTokens(text)
+		if used > 0 && used+tokens > budget {
+			chunks = append(chunks, strings.Join(current, "\n\n"))
+			current, used = nil, 0
+		}
+		current = append(current, text)
+		used += tokens
+	}
+
+	for _, block := range gatheredBlocks(data) {
+		if text := block.text(); estimateTokens(text) <= budget {
+			add(text)
+			continue
+		}
+		pieceBudget := max(budget-estimateTokens(block.header), minChunkTokens)
+		for _, piece := range splitText(block.body, pieceBudget, []string{"\n\n", "\n", " "}) {
+			add(gatheredBlock{header: block.header, body: piece}.text())
+		}
+	}
+	if len(current) > 0 {
+		chunks = append(chunks, strings.Join(current, "\n\n"))
+	}
+	return chunks
+}
+
+// splitText splits text into pieces of at most budget tokens at the first
+// of separators that allows it, cutting at the budget as a last resort
+func splitText(text string, budget int, separators []string) []string {
+	if estimateTokens(text) <= budget {
+		return []string{text}
+	}
+	if len(separators) == 0 {
+		return cutText(text, budget*charsPerToken)
+	}
+
+	separator := separators[0]
+	var pieces, current []string
+	size := 0
+	flush := func() {
+		if len(current) > 0 {
+			pieces = append(pieces, strings.Join(current, separator))
+			current, size = nil, 0
+		}
+	}
+	for _, part := range strings.Split(text, separator) {
+		if estimateTokens(part) > budget {
+			flush()
+			pieces = append(pieces, splitText(part, budget, separators[1:])...)
+			continue
+		}
+		if len(current) > 0 && size+len(separator)+len(part) > budget*charsPerToken {
+			flush()
+		}
+		if len(current) > 0 {
+			size += len(separator)
+		}
+		current = append(current, part)
+		size += len(part)
+	}
+	flush()
+	return pieces
+}
+
+// cutText cuts text into pieces of at most limit bytes without splitting a
+// UTF-8 character
+func cutText(text string, limit int) []string {
+	var pieces []string
+	for len(text) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		if cut == 0 {
+			cut = limit
+		}
+		pieces = append(pieces, text[:cut])
+		text = text[cut:]
+	}
+	if text != "" {
+		pieces = append(pieces, text)
+	}
+	return pieces
+}
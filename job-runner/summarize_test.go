@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"microservices-demo/shared"
+)
+
+func TestChunkGathered(t *testing.T) {
+	citations := newCitationIndex()
+	small := citations.add(shared.MCPServiceWeb, "", "Go 1.22 was released in February 2024.", []string{"https://go.dev/doc/go1.22"}, shared.ProvenanceReal)
+	var paragraphs []string
+	for i := 0; i < 40; i++ {
+		paragraphs = append(paragraphs, strings.Repeat("func handler() error { return nil } ", 10))
+	}
+	large := citations.add(shared.MCPServiceGitHub, "", strings.Join(paragraphs, "\n\n"), []string{"https://github.com/golang/go"}, shared.ProvenanceReal)
+
+	chunks := chunkGathered(small+"\n\n"+large, 400)
+	if len(chunks) < 2 {
+		t.Fatalf("Expected the large block to be split, got %d chunks", len(chunks))
+	}
+	if !strings.HasPrefix(chunks[0], "Sources: [1] https://go.dev/doc/go1.22\n\nGo 1.22") {
+		t.Errorf("Expected the small block to lead the first chunk, got %q", chunks[0])
+	}
+	words := 0
+	for i, chunk := range chunks {
+		if estimateTokens(chunk) > 400 {
+			t.Errorf("Chunk %d has %d tokens, over the budget", i, estimateTokens(chunk))
+		}
+		if i > 0 && !strings.HasPrefix(chunk, "Sources: [2] https://github.com/golang/go\n\n") {
+			t.Errorf("Expected chunk %d to repeat the source label, got %q", i, chunk[:40])
+		}
+		words += strings.Count(chunk, "handler()")
+	}
+	if words != 400 {
+		t.Errorf("Expected all gathered text in the chunks, found %d of 400 repetitions", words)
+	}
+
+	for _, piece := range splitText(strings.Repeat("日本語", 500), 100, []string{"\n"}) {
+		if !utf8.ValidString(piece) || estimateTokens(piece) > 100 {
+			t.Errorf("Expected valid pieces within the budget, got %d bytes", len(piece))
+		}
+	}
+}
+
+func TestCondenseGathered(t *testing.T) {
+	var summaries, reports int
+	ollama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/show" {
+			w.Write([]byte(`{"parameters":"stop \"<|eot_id|>\"\nnum_ctx 2048","model_info":{"general.architecture":"llama","llama.context_length":131072}}`))
+			return
+		}
+		var req OllamaRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if len(req.Format) > 0 {
+			reports++
+			json.NewEncoder(w).Encode(OllamaResponse{Response: `{"summary":"report"}`, Done: true})
+			return
+		}
+		summaries++
+		if !strings.Contains(req.Prompt, "of the Gathered Information:\nSources: [1] https://github.com/golang/go") {
+			t.Errorf("Expected a chunk headed by its sources, got %q", req.Prompt[:200])
+		}
+		if req.Options["num_predict"] != float64(chunkSummaryTokens) {
+			t.Errorf("Expected the summary length to be bounded, got %v", req.Options)
+		}
+		json.NewEncoder(w).Encode(OllamaResponse{Response: "Go handlers return errors [1].", Done: true})
+	}))
+	defer ollama.Close()
+
+	agent := NewResearchAgent()
+	agent.llm = newOllamaProvider(ollama.URL, ollama.Client(), "llama3.2")
+	prompt := agent.prompts.forType(shared.ResearchTypeCode)
+	job := shared.JobMessage{JobID: "job-9", Query: "How do Go handlers report errors?"}
+
+	small := newCitationIndex().add(shared.MCPServiceGitHub, "", "Handlers return errors.", []string{"https://github.com/golang/go"}, shared.ProvenanceReal)
+	data, step, usage, err := agent.condenseGathered(context.Background(), job, prompt, small, nil)
+	if err != nil || data != small || step != nil || usage.Total() != 0 {
+		t.Errorf("Expected data that fits to be left alone, got %q %+v %v", data, step, err)
+	}
+
+	large := newCitationIndex().add(shared.MCPServiceGitHub, "", strings.Repeat("func handler() error { return nil }\n", 1000), []string{"https://github.com/golang/go"}, shared.ProvenanceReal)
+	data, step, usage, err = agent.condenseGathered(context.Background(), job, prompt, large, nil)
+	if err != nil {
+		t.Fatalf("condenseGathered failed: %v", err)
+	}
+	if summaries < 2 || reports != 0 {
+		t.Errorf("Expected several chunk summaries and no report, got %d and %d", summaries, reports)
+	}
+	if data != strings.TrimSpace(strings.Repeat("Go handlers return errors [1].\n\n", summaries)) {
+		t.Errorf("Expected the joined summaries, got %q", data)
+	}
+	if step == nil || step.Type != shared.TraceStepNote || !strings.Contains(step.Content, "2048-token context") || !usage.Estimated() {
+		t.Errorf("Expected the summarization to be traced, got %+v %+v", step, usage)
+	}
+}
+
+func TestOllamaContextLength(t *testing.T) {
+	var show string
+	ollama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/show" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(show))
+	}))
+	defer ollama.Close()
+
+	provider := newOllamaProvider(ollama.URL, ollama.Client(), "llama3.2")
+	tests := []struct {
+		name    string
+		show    string
+		options *shared.ModelOptions
+		want    int
+	}{
+		{"job num_ctx", `{}`, &shared.ModelOptions{NumCtx: 16384}, 16384},
+		{"modelfile num_ctx", `{"parameters":"num_ctx 8192"}`, nil, 8192},
+		{"server default", `{"model_info":{"general.architecture":"llama","llama.context_length":131072}}`, nil, defaultContextTokens},
+		{"trained length", `{"model_info":{"general.architecture":"phi","phi.context_length":2048}}`, nil, 2048},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			show = tt.show
+			got, err := provider.ContextLength(context.Background(), "", tt.options)
+			if err != nil || got != tt.want {
+				t.Errorf("Expected %d, got %d (%v)", tt.want, got, err)
+			}
+		})
+	}
+}
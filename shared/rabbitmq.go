@@ -3,55 +3,302 @@ package shared
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"strconv"
+	"sync"
+	"time"
 
+	"github.com/google/uuid"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
 const (
-	JobQueueName    = "jobs"
-	ResultQueueName = "job_results"
+	JobQueueName           = "jobs"
+	ResultQueueName        = "job_results"
+	PartialResultQueueName = "job_partial_results"
+
+	// ControlExchangeName is a fanout exchange used to broadcast control
+	// messages (e.g. cancellation) to every job runner replica
+	ControlExchangeName = "job_control"
+
+	// MCPCatalogExchangeName is a fanout exchange on which job runners
+	// advertise the MCP services they can query
+	MCPCatalogExchangeName = "mcp_catalog"
+
+	// ModelCatalogExchangeName is a fanout exchange on which job runners
+	// advertise the models their inference server offers
+	ModelCatalogExchangeName = "model_catalog"
+
+	// MCPStatusExchangeName is a fanout exchange on which job runners report
+	// the circuit breaker state and health of their MCP servers
+	MCPStatusExchangeName = "mcp_status"
+
+	// RetryQueueName holds job messages waiting to be retried. Messages expire
+	// after their per-message TTL and are dead-lettered back onto the job queue.
+	RetryQueueName = "jobs.retry"
+
+	// DeadLetterExchangeName and DeadLetterQueueName park job messages that
+	// were malformed or exhausted their retries
+	DeadLetterExchangeName = "jobs.dlx"
+	DeadLetterQueueName    = "jobs.dead"
+)
+
+// Message headers used to track delivery attempts and dead-letter reasons
+const (
+	AttemptHeader          = "x-attempt"
+	DeadLetterReasonHeader = "x-dead-letter-reason"
+)
+
+// deadLetterScanLimit bounds how many dead letters are inspected when
+// looking a message up by ID
+const deadLetterScanLimit = 1000
+
+// Reconnect backoff bounds; the delay doubles after every failed attempt
+const (
+	reconnectInitialDelay = 1 * time.Second
+	reconnectMaxDelay     = 30 * time.Second
+)
+
+var (
+	// ErrNotConnected is returned when publishing while the broker connection is down
+	ErrNotConnected = errors.New("rabbitmq connection is not available")
+
+	// ErrDeadLetterNotFound is returned when no dead letter has the requested ID
+	ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+	// ErrPublishNacked is returned when the broker refuses to take responsibility for a message
+	ErrPublishNacked = errors.New("message was rejected by the broker")
+
+	// ErrMessageReturned is returned when a mandatory message matched no queue
+	ErrMessageReturned = errors.New("message could not be routed to a queue")
+
+	// errClientClosed stops a reconnect that raced with Close
+	errClientClosed = errors.New("rabbitmq client is closed")
 )
 
-// RabbitMQClient wraps the RabbitMQ connection and channel
+// DefaultPublishTimeout bounds how long a publish waits for the broker's
+// confirmation when the caller's context has no deadline
+const DefaultPublishTimeout = 5 * time.Second
+
+// ConnectionState describes the health of the supervised broker connection
+type ConnectionState string
+
+const (
+	ConnectionStateConnected    ConnectionState = "connected"
+	ConnectionStateReconnecting ConnectionState = "reconnecting"
+	ConnectionStateClosed       ConnectionState = "closed"
+)
+
+// ConnectionStatus is a snapshot of the broker connection for health endpoints
+type ConnectionStatus struct {
+	State      ConnectionState `json:"state"`
+	Since      time.Time       `json:"since"`
+	Reconnects int             `json:"reconnects"`
+	LastError  string          `json:"last_error,omitempty"`
+}
+
+// RabbitMQClient wraps the RabbitMQ connection and channel. The connection is
+// supervised: when the broker goes away the client reconnects with exponential
+// backoff, re-declares queues and re-subscribes every consumer.
 type RabbitMQClient struct {
+	url string
+
+	mu         sync.RWMutex
 	connection *amqp.Connection
 	channel    *amqp.Channel
+	returns    *returnDispatcher
+	status     ConnectionStatus
+	// ready is closed while connected and replaced when the connection drops
+	ready chan struct{}
+
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// returned holds a waiter per in-flight mandatory publish, keyed by
+	// message ID, so unroutable messages can be reported to the publisher
+	returnsMu sync.Mutex
+	returned  map[string]chan amqp.Return
 }
 
 // NewRabbitMQClient creates a new RabbitMQ client
 func NewRabbitMQClient(url string) (*RabbitMQClient, error) {
-	conn, err := amqp.Dial(url)
-	if err != nil {
+	client := &RabbitMQClient{
+		url:      url,
+		ready:    make(chan struct{}),
+		done:     make(chan struct{}),
+		returned: make(map[string]chan amqp.Return),
+	}
+
+	// The first connection must succeed so misconfiguration fails fast
+	if err := client.connect(); err != nil {
 		return nil, err
 	}
 
+	go client.supervise()
+
+	return client, nil
+}
+
+// connect dials the broker, opens a channel and declares the topology
+func (c *RabbitMQClient) connect() error {
+	conn, err := amqp.Dial(c.url)
+	if err != nil {
+		return err
+	}
+
 	ch, err := conn.Channel()
 	if err != nil {
 		conn.Close()
-		return nil, err
+		return err
 	}
 
-	client := &RabbitMQClient{
-		connection: conn,
-		channel:    ch,
+	// Declare queues
+	if err := declareQueues(ch); err != nil {
+		ch.Close()
+		conn.Close()
+		return err
 	}
 
-	// Declare queues
-	if err := client.declareQueues(); err != nil {
-		client.Close()
-		return nil, err
+	// Have the broker confirm every publish so callers know it was accepted
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		conn.Close()
+		return err
 	}
+	returns := &returnDispatcher{syncs: make(chan chan struct{}), stopped: make(chan struct{})}
+	go c.dispatchReturns(ch.NotifyReturn(make(chan amqp.Return)), returns)
 
-	return client, nil
+	c.mu.Lock()
+	// Close may have run while dialing; it must not be undone
+	select {
+	case <-c.done:
+		c.mu.Unlock()
+		ch.Close()
+		conn.Close()
+		return errClientClosed
+	default:
+	}
+	c.connection = conn
+	c.channel = ch
+	c.returns = returns
+	c.status.State = ConnectionStateConnected
+	c.status.Since = time.Now()
+	close(c.ready)
+	c.mu.Unlock()
+
+	return nil
+}
+
+// supervise waits for the connection or channel to close and reconnects
+func (c *RabbitMQClient) supervise() {
+	for {
+		c.mu.RLock()
+		conn, ch := c.connection, c.channel
+		c.mu.RUnlock()
+
+		connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+		chanClosed := ch.NotifyClose(make(chan *amqp.Error, 1))
+
+		var reason *amqp.Error
+		select {
+		case <-c.done:
+			return
+		case reason = <-connClosed:
+		case reason = <-chanClosed:
+		}
+
+		c.mu.Lock()
+		c.status.State = ConnectionStateReconnecting
+		c.status.Since = time.Now()
+		if reason != nil {
+			c.status.LastError = reason.Error()
+		}
+		c.ready = make(chan struct{})
+		c.mu.Unlock()
+
+		log.Printf("RabbitMQ connection lost (%v), reconnecting...", reason)
+		// Make sure a closed channel also drops its connection before redialing
+		conn.Close()
+
+		if !c.reconnect() {
+			return
+		}
+	}
+}
+
+// reconnect retries connect with exponential backoff until it succeeds or the
+// client is closed. It returns false if the client was closed.
+func (c *RabbitMQClient) reconnect() bool {
+	delay := reconnectInitialDelay
+	for {
+		select {
+		case <-c.done:
+			return false
+		case <-time.After(delay):
+		}
+
+		err := c.connect()
+		if errors.Is(err, errClientClosed) {
+			return false
+		}
+		if err == nil {
+			c.mu.Lock()
+			c.status.Reconnects++
+			c.mu.Unlock()
+			log.Println("RabbitMQ connection re-established")
+			return true
+		}
+
+		c.mu.Lock()
+		c.status.LastError = err.Error()
+		c.mu.Unlock()
+		log.Printf("RabbitMQ reconnect failed, retrying in %v: %v", delay, err)
+
+		delay *= 2
+		if delay > reconnectMaxDelay {
+			delay = reconnectMaxDelay
+		}
+	}
+}
+
+// currentChannel returns the channel if the client is connected
+func (c *RabbitMQClient) currentChannel() (*amqp.Channel, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.status.State != ConnectionStateConnected {
+		return nil, ErrNotConnected
+	}
+	return c.channel, nil
+}
+
+// waitForChannel blocks until the client is connected and returns the current
+// channel, or returns false once the client is closed
+func (c *RabbitMQClient) waitForChannel() (*amqp.Channel, bool) {
+	for {
+		c.mu.RLock()
+		ready := c.ready
+		c.mu.RUnlock()
+
+		select {
+		case <-c.done:
+			return nil, false
+		case <-ready:
+			if ch, err := c.currentChannel(); err == nil {
+				return ch, true
+			}
+		}
+	}
 }
 
 // declareQueues declares the required queues
-func (c *RabbitMQClient) declareQueues() error {
-	queues := []string{JobQueueName, ResultQueueName}
+func declareQueues(ch *amqp.Channel) error {
+	queues := []string{JobQueueName, ResultQueueName, PartialResultQueueName}
 
 	for _, queueName := range queues {
-		_, err := c.channel.QueueDeclare(
+		_, err := ch.QueueDeclare(
 			queueName, // name
 			true,      // durable
 			false,     // delete when unused
@@ -64,86 +311,688 @@ func (c *RabbitMQClient) declareQueues() error {
 		}
 	}
 
+	// Expired retries are routed back onto the job queue through the default exchange
+	_, err := ch.QueueDeclare(
+		RetryQueueName, // name
+		true,           // durable
+		false,          // delete when unused
+		false,          // exclusive
+		false,          // no-wait
+		amqp.Table{
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": JobQueueName,
+		}, // arguments
+	)
+	if err != nil {
+		return err
+	}
+
+	err = ch.ExchangeDeclare(
+		DeadLetterExchangeName, // name
+		"direct",               // type
+		true,                   // durable
+		false,                  // auto-deleted
+		false,                  // internal
+		false,                  // no-wait
+		nil,                    // arguments
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = ch.QueueDeclare(
+		DeadLetterQueueName, // name
+		true,                // durable
+		false,               // delete when unused
+		false,               // exclusive
+		false,               // no-wait
+		nil,                 // arguments
+	)
+	if err != nil {
+		return err
+	}
+
+	err = ch.QueueBind(DeadLetterQueueName, JobQueueName, DeadLetterExchangeName, false, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, exchangeName := range []string{ControlExchangeName, MCPCatalogExchangeName, ModelCatalogExchangeName, MCPStatusExchangeName} {
+		err = ch.ExchangeDeclare(
+			exchangeName, // name
+			"fanout",     // type
+			true,         // durable
+			false,        // auto-deleted
+			false,        // internal
+			false,        // no-wait
+			nil,          // arguments
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// returnDispatcher is the dispatchReturns goroutine of one channel
+type returnDispatcher struct {
+	// syncs are answered once every return received before has been handed on
+	syncs chan chan struct{}
+	// stopped is closed when the channel closed and all returns were handed on
+	stopped chan struct{}
+}
+
+// dispatchReturns hands unroutable messages to the publisher waiting on them.
+// It exits when the channel is closed.
+func (c *RabbitMQClient) dispatchReturns(returns <-chan amqp.Return, d *returnDispatcher) {
+	defer close(d.stopped)
+	for {
+		select {
+		case r, ok := <-returns:
+			if !ok {
+				return
+			}
+			c.returnsMu.Lock()
+			if waiter, ok := c.returned[r.MessageId]; ok {
+				waiter <- r
+			} else {
+				log.Printf("Message returned by broker: %d %s (exchange %q, routing key %q)",
+					r.ReplyCode, r.ReplyText, r.Exchange, r.RoutingKey)
+			}
+			c.returnsMu.Unlock()
+		case synced := <-d.syncs:
+			close(synced)
+		}
+	}
+}
+
+// sync waits until the returns received so far have been handed to their
+// waiters. The client library passes a basic.return on before it processes
+// the ack that follows it, so after a confirm the return of the same
+// message, if any, has reached its waiter once sync returns.
+func (d *returnDispatcher) sync(ctx context.Context) error {
+	synced := make(chan struct{})
+	select {
+	case d.syncs <- synced:
+	case <-d.stopped:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for returned messages: %w", ctx.Err())
+	}
+	<-synced
+	return nil
+}
+
+// publish sends msg and waits until the broker confirms it. Mandatory
+// messages that cannot be routed to any queue fail with ErrMessageReturned.
+// If ctx has no deadline, DefaultPublishTimeout applies.
+func (c *RabbitMQClient) publish(ctx context.Context, exchange, routingKey string, mandatory bool, msg amqp.Publishing) error {
+	// The returns of a channel are dispatched by its own goroutine
+	c.mu.RLock()
+	ch, returns, state := c.channel, c.returns, c.status.State
+	c.mu.RUnlock()
+	if state != ConnectionStateConnected {
+		return ErrNotConnected
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultPublishTimeout)
+		defer cancel()
+	}
+
+	var returned chan amqp.Return
+	if mandatory {
+		if msg.MessageId == "" {
+			msg.MessageId = uuid.New().String()
+		}
+		returned = make(chan amqp.Return, 1)
+
+		c.returnsMu.Lock()
+		c.returned[msg.MessageId] = returned
+		c.returnsMu.Unlock()
+
+		defer func() {
+			c.returnsMu.Lock()
+			delete(c.returned, msg.MessageId)
+			c.returnsMu.Unlock()
+		}()
+	}
+
+	confirmation, err := ch.PublishWithDeferredConfirmWithContext(
+		ctx,
+		exchange,   // exchange
+		routingKey, // routing key
+		mandatory,  // mandatory
+		false,      // immediate
+		msg,
+	)
+	if err != nil {
+		return err
+	}
+	if err := waitForConfirm(ctx, confirmation); err != nil {
+		return err
+	}
+
+	if !mandatory {
+		return nil
+	}
+	// The broker sends basic.return before the ack of an unroutable message
+	if err := returns.sync(ctx); err != nil {
+		return err
+	}
+	select {
+	case r := <-returned:
+		return fmt.Errorf("%w: %d %s", ErrMessageReturned, r.ReplyCode, r.ReplyText)
+	default:
+		return nil
+	}
+}
+
+// waitForConfirm waits for the broker to ack or nack a publish. A nil
+// confirmation means the channel is not in confirm mode.
+func waitForConfirm(ctx context.Context, confirmation *amqp.DeferredConfirmation) error {
+	if confirmation == nil {
+		return nil
+	}
+
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("waiting for publish confirmation: %w", err)
+	}
+	if !acked {
+		return ErrPublishNacked
+	}
 	return nil
 }
 
-// PublishJob publishes a job message to the job queue
-func (c *RabbitMQClient) PublishJob(job JobMessage) error {
-	body, err := json.Marshal(job)
+// publishJSON marshals v as JSON and publishes it as a persistent message
+func (c *RabbitMQClient) publishJSON(ctx context.Context, exchange, routingKey string, mandatory bool, v interface{}) error {
+	body, err := json.Marshal(v)
 	if err != nil {
 		return err
 	}
 
-	return c.channel.PublishWithContext(
-		context.TODO(),
-		"",           // exchange
-		JobQueueName, // routing key
-		false,        // mandatory
-		false,        // immediate
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        body,
-		})
+	return c.publish(ctx, exchange, routingKey, mandatory, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		Body:         body,
+	})
+}
+
+// PublishJob publishes a job message to the job queue and waits for the
+// broker to confirm it
+func (c *RabbitMQClient) PublishJob(ctx context.Context, job JobMessage) error {
+	return c.publishJSON(ctx, "", JobQueueName, true, job)
+}
+
+// PublishResult publishes a job result to the result queue and waits for the
+// broker to confirm it
+func (c *RabbitMQClient) PublishResult(ctx context.Context, result JobResult) error {
+	return c.publishJSON(ctx, "", ResultQueueName, true, result)
+}
+
+// PublishPartialResult publishes a chunk of streamed output for a running job
+func (c *RabbitMQClient) PublishPartialResult(ctx context.Context, partial JobPartialResult) error {
+	return c.publishJSON(ctx, "", PartialResultQueueName, true, partial)
+}
+
+// PublishControl broadcasts a control message to all job runners. It is not
+// mandatory: with no runners connected there is nothing to control.
+func (c *RabbitMQClient) PublishControl(ctx context.Context, msg ControlMessage) error {
+	return c.publishJSON(ctx, ControlExchangeName, "", false, msg)
+}
+
+// PublishMCPCatalog advertises a job runner's MCP services to every API
+// server. It is not mandatory: with no API server connected nobody listens.
+func (c *RabbitMQClient) PublishMCPCatalog(ctx context.Context, catalog MCPCatalog) error {
+	return c.publishJSON(ctx, MCPCatalogExchangeName, "", false, catalog)
+}
+
+// PublishModelCatalog advertises the models a job runner can use to every
+// API server. Like the MCP catalog it is not mandatory.
+func (c *RabbitMQClient) PublishModelCatalog(ctx context.Context, catalog ModelCatalog) error {
+	return c.publishJSON(ctx, ModelCatalogExchangeName, "", false, catalog)
+}
+
+// PublishMCPStatus reports the health of a job runner's MCP servers to every
+// API server. Like the catalogs it is not mandatory.
+func (c *RabbitMQClient) PublishMCPStatus(ctx context.Context, report MCPStatusReport) error {
+	return c.publishJSON(ctx, MCPStatusExchangeName, "", false, report)
+}
+
+// DeliveryAttempt returns which attempt a job delivery is, starting at 1
+func DeliveryAttempt(d amqp.Delivery) int {
+	switch attempt := d.Headers[AttemptHeader].(type) {
+	case int32:
+		return int(attempt)
+	case int64:
+		return int(attempt)
+	case int:
+		return attempt
+	}
+	return 1
+}
+
+// RetryJob schedules a job delivery to be redelivered after delay, recording
+// attempt in its headers. The caller must still ack the original delivery.
+func (c *RabbitMQClient) RetryJob(ctx context.Context, d amqp.Delivery, attempt int, delay time.Duration) error {
+	headers := copyHeaders(d.Headers)
+	headers[AttemptHeader] = int32(attempt)
+
+	return c.publish(ctx, "", RetryQueueName, true, amqp.Publishing{
+		ContentType:  d.ContentType,
+		DeliveryMode: amqp.Persistent,
+		Headers:      headers,
+		Expiration:   strconv.FormatInt(delay.Milliseconds(), 10),
+		Body:         d.Body,
+	})
+}
+
+// DeadLetterJob parks a job delivery in the dead-letter queue with the reason
+// it could not be processed. The caller must still ack the original delivery.
+func (c *RabbitMQClient) DeadLetterJob(ctx context.Context, d amqp.Delivery, reason string) error {
+	headers := copyHeaders(d.Headers)
+	headers[AttemptHeader] = int32(DeliveryAttempt(d))
+	headers[DeadLetterReasonHeader] = reason
+
+	return c.publish(ctx, DeadLetterExchangeName, JobQueueName, true, amqp.Publishing{
+		ContentType:  d.ContentType,
+		DeliveryMode: amqp.Persistent,
+		MessageId:    uuid.New().String(),
+		Timestamp:    time.Now(),
+		Headers:      headers,
+		Body:         d.Body,
+	})
 }
 
-// PublishResult publishes a job result to the result queue
-func (c *RabbitMQClient) PublishResult(result JobResult) error {
-	body, err := json.Marshal(result)
+// ListDeadLetters returns up to limit dead-lettered messages without removing
+// them from the queue
+func (c *RabbitMQClient) ListDeadLetters(limit int) ([]DeadLetter, error) {
+	deadLetters := []DeadLetter{}
+	err := c.scanDeadLetters(limit, func(ch *amqp.Channel, d amqp.Delivery) (bool, error) {
+		deadLetters = append(deadLetters, deadLetterFromDelivery(d))
+		return false, nil
+	})
+	return deadLetters, err
+}
+
+// GetDeadLetter returns the dead letter with the given ID without removing it
+func (c *RabbitMQClient) GetDeadLetter(id string) (*DeadLetter, error) {
+	var found *DeadLetter
+	err := c.scanDeadLetters(deadLetterScanLimit, func(ch *amqp.Channel, d amqp.Delivery) (bool, error) {
+		if d.MessageId != id {
+			return false, nil
+		}
+		deadLetter := deadLetterFromDelivery(d)
+		found = &deadLetter
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, ErrDeadLetterNotFound
+	}
+	return found, nil
+}
+
+// ReplayDeadLetter moves the dead letter with the given ID back onto the job
+// queue with a fresh attempt count. The dead letter is only removed once the
+// broker has confirmed the replayed message.
+func (c *RabbitMQClient) ReplayDeadLetter(ctx context.Context, id string) (*DeadLetter, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultPublishTimeout)
+		defer cancel()
+	}
+
+	var replayed *DeadLetter
+	err := c.scanDeadLetters(deadLetterScanLimit, func(ch *amqp.Channel, d amqp.Delivery) (bool, error) {
+		if d.MessageId != id {
+			return false, nil
+		}
+
+		headers := copyHeaders(d.Headers)
+		delete(headers, AttemptHeader)
+		delete(headers, DeadLetterReasonHeader)
+
+		if err := ch.Confirm(false); err != nil {
+			return true, err
+		}
+		confirmation, err := ch.PublishWithDeferredConfirmWithContext(
+			ctx,
+			"",           // exchange
+			JobQueueName, // routing key
+			false,        // mandatory
+			false,        // immediate
+			amqp.Publishing{
+				ContentType:  d.ContentType,
+				DeliveryMode: amqp.Persistent,
+				Headers:      headers,
+				Body:         d.Body,
+			})
+		if err != nil {
+			return true, err
+		}
+		if err := waitForConfirm(ctx, confirmation); err != nil {
+			return true, err
+		}
+		if err := d.Ack(false); err != nil {
+			return true, err
+		}
+
+		deadLetter := deadLetterFromDelivery(d)
+		replayed = &deadLetter
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if replayed == nil {
+		return nil, ErrDeadLetterNotFound
+	}
+	return replayed, nil
+}
+
+// PurgeDeadLetters discards every dead-lettered message and returns how many were removed
+func (c *RabbitMQClient) PurgeDeadLetters() (int, error) {
+	ch, err := c.currentChannel()
+	if err != nil {
+		return 0, err
+	}
+	return ch.QueuePurge(DeadLetterQueueName, false)
+}
+
+// scanDeadLetters fetches up to limit dead letters on a dedicated channel and
+// passes each to visit until it returns true. Messages that visit does not
+// ack are returned to the queue when the channel is closed.
+func (c *RabbitMQClient) scanDeadLetters(limit int, visit func(*amqp.Channel, amqp.Delivery) (bool, error)) error {
+	c.mu.RLock()
+	conn, state := c.connection, c.status.State
+	c.mu.RUnlock()
+	if state != ConnectionStateConnected {
+		return ErrNotConnected
+	}
+
+	ch, err := conn.Channel()
 	if err != nil {
 		return err
 	}
+	defer ch.Close()
 
-	return c.channel.PublishWithContext(
-		context.TODO(),
-		"",              // exchange
-		ResultQueueName, // routing key
-		false,           // mandatory
-		false,           // immediate
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        body,
-		})
-}
-
-// ConsumeJobs consumes job messages from the job queue
-func (c *RabbitMQClient) ConsumeJobs() (<-chan amqp.Delivery, error) {
-	return c.channel.Consume(
-		JobQueueName, // queue
-		"",           // consumer
-		false,        // auto-ack
-		false,        // exclusive
-		false,        // no-local
-		false,        // no-wait
-		nil,          // args
-	)
+	for i := 0; i < limit; i++ {
+		d, ok, err := ch.Get(DeadLetterQueueName, false)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+
+		stop, err := visit(ch, d)
+		if stop || err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deadLetterFromDelivery describes a dead-lettered delivery
+func deadLetterFromDelivery(d amqp.Delivery) DeadLetter {
+	deadLetter := DeadLetter{
+		ID:             d.MessageId,
+		Attempts:       DeliveryAttempt(d),
+		DeadLetteredAt: d.Timestamp,
+		Body:           string(d.Body),
+	}
+	if reason, ok := d.Headers[DeadLetterReasonHeader].(string); ok {
+		deadLetter.Reason = reason
+	}
+
+	// Malformed messages have no job ID to report
+	var job JobMessage
+	if err := json.Unmarshal(d.Body, &job); err == nil {
+		deadLetter.JobID = job.JobID
+	}
+
+	return deadLetter
+}
+
+// copyHeaders returns a copy of a delivery's headers that is safe to modify
+func copyHeaders(headers amqp.Table) amqp.Table {
+	copied := amqp.Table{}
+	for key, value := range headers {
+		copied[key] = value
+	}
+	return copied
+}
+
+// consume subscribes using setup and returns a delivery channel that survives
+// reconnects: whenever the underlying subscription ends because the connection
+// dropped, setup is re-run on the new channel with the same consumer tag. The
+// returned channel is closed when ctx is done, which cancels the subscription
+// while the connection stays open, or when the client is closed. Deliveries
+// not yet handed out are requeued by the broker.
+func (c *RabbitMQClient) consume(ctx context.Context, name string, setup func(ch *amqp.Channel, tag string) (<-chan amqp.Delivery, error)) (<-chan amqp.Delivery, error) {
+	ch, err := c.currentChannel()
+	if err != nil {
+		return nil, err
+	}
+
+	tag := name + "-" + uuid.New().String()
+	deliveries, err := setup(ch, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan amqp.Delivery)
+	go func() {
+		defer close(out)
+		for {
+			if !c.forward(ctx, ch, tag, deliveries, out) {
+				return
+			}
+
+			// The subscription ended; wait for the supervisor to reconnect
+			for {
+				var ok bool
+				ch, ok = c.waitForChannel()
+				if !ok || ctx.Err() != nil {
+					return
+				}
+				deliveries, err = setup(ch, tag)
+				if err == nil {
+					log.Printf("Re-subscribed %s consumer", name)
+					break
+				}
+				log.Printf("Failed to re-subscribe %s consumer: %v", name, err)
+
+				select {
+				case <-c.done:
+					return
+				case <-ctx.Done():
+					return
+				case <-time.After(reconnectInitialDelay):
+				}
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// forward passes deliveries on to out until the subscription ends, in which
+// case it returns true, or until ctx is done or the client is closed. A done
+// ctx cancels the subscription on ch.
+func (c *RabbitMQClient) forward(ctx context.Context, ch *amqp.Channel, tag string, deliveries <-chan amqp.Delivery, out chan<- amqp.Delivery) bool {
+	for {
+		select {
+		case delivery, ok := <-deliveries:
+			if !ok {
+				return true
+			}
+			select {
+			case out <- delivery:
+				continue
+			case <-c.done:
+				return false
+			case <-ctx.Done():
+			}
+		case <-c.done:
+			return false
+		case <-ctx.Done():
+		}
+
+		if err := ch.Cancel(tag, false); err != nil {
+			log.Printf("Failed to cancel consumer %s: %v", tag, err)
+		}
+		return false
+	}
+}
+
+// ConsumeJobs consumes job messages from the job queue until ctx is done.
+// prefetch limits how many unacknowledged jobs the broker delivers to this
+// consumer at once; zero means no limit.
+func (c *RabbitMQClient) ConsumeJobs(ctx context.Context, prefetch int) (<-chan amqp.Delivery, error) {
+	return c.consume(ctx, JobQueueName, func(ch *amqp.Channel, tag string) (<-chan amqp.Delivery, error) {
+		// QoS applies to consumers started after it, so set it on every re-subscribe
+		if err := ch.Qos(
+			prefetch, // prefetch count
+			0,        // prefetch size
+			false,    // global
+		); err != nil {
+			return nil, err
+		}
+
+		return ch.Consume(
+			JobQueueName, // queue
+			tag,          // consumer
+			false,        // auto-ack
+			false,        // exclusive
+			false,        // no-local
+			false,        // no-wait
+			nil,          // args
+		)
+	})
 }
 
 // ConsumeResults consumes job result messages from the result queue
 func (c *RabbitMQClient) ConsumeResults() (<-chan amqp.Delivery, error) {
-	return c.channel.Consume(
-		ResultQueueName, // queue
-		"",              // consumer
-		true,            // auto-ack
-		false,           // exclusive
-		false,           // no-local
-		false,           // no-wait
-		nil,             // args
-	)
+	return c.consume(context.Background(), ResultQueueName, func(ch *amqp.Channel, tag string) (<-chan amqp.Delivery, error) {
+		return ch.Consume(
+			ResultQueueName, // queue
+			tag,             // consumer
+			true,            // auto-ack
+			false,           // exclusive
+			false,           // no-local
+			false,           // no-wait
+			nil,             // args
+		)
+	})
+}
+
+// ConsumePartialResults consumes streamed output chunks from the partial result queue
+func (c *RabbitMQClient) ConsumePartialResults() (<-chan amqp.Delivery, error) {
+	return c.consume(context.Background(), PartialResultQueueName, func(ch *amqp.Channel, tag string) (<-chan amqp.Delivery, error) {
+		return ch.Consume(
+			PartialResultQueueName, // queue
+			tag,                    // consumer
+			true,                   // auto-ack
+			false,                  // exclusive
+			false,                  // no-local
+			false,                  // no-wait
+			nil,                    // args
+		)
+	})
+}
+
+// ConsumeControl consumes control messages broadcast to every job runner
+func (c *RabbitMQClient) ConsumeControl() (<-chan amqp.Delivery, error) {
+	return c.consumeBroadcast(ControlExchangeName)
+}
+
+// ConsumeMCPCatalogs consumes the MCP catalogs advertised by job runners
+func (c *RabbitMQClient) ConsumeMCPCatalogs() (<-chan amqp.Delivery, error) {
+	return c.consumeBroadcast(MCPCatalogExchangeName)
+}
+
+// ConsumeModelCatalogs consumes the model catalogs advertised by job runners
+func (c *RabbitMQClient) ConsumeModelCatalogs() (<-chan amqp.Delivery, error) {
+	return c.consumeBroadcast(ModelCatalogExchangeName)
 }
 
-// Close closes the RabbitMQ connection and channel
+// ConsumeMCPStatus consumes the MCP server health reported by job runners
+func (c *RabbitMQClient) ConsumeMCPStatus() (<-chan amqp.Delivery, error) {
+	return c.consumeBroadcast(MCPStatusExchangeName)
+}
+
+// consumeBroadcast consumes a fanout exchange through an exclusive queue
+// bound to it, so every consumer receives every message
+func (c *RabbitMQClient) consumeBroadcast(exchangeName string) (<-chan amqp.Delivery, error) {
+	return c.consume(context.Background(), exchangeName, func(ch *amqp.Channel, tag string) (<-chan amqp.Delivery, error) {
+		queue, err := ch.QueueDeclare(
+			"",    // name (server-generated)
+			false, // durable
+			true,  // delete when unused
+			true,  // exclusive
+			false, // no-wait
+			nil,   // arguments
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := ch.QueueBind(queue.Name, "", exchangeName, false, nil); err != nil {
+			return nil, err
+		}
+
+		return ch.Consume(
+			queue.Name, // queue
+			tag,        // consumer
+			true,       // auto-ack
+			true,       // exclusive
+			false,      // no-local
+			false,      // no-wait
+			nil,        // args
+		)
+	})
+}
+
+// Close closes the RabbitMQ connection and channel and stops reconnecting
 func (c *RabbitMQClient) Close() {
-	if c.channel != nil {
-		c.channel.Close()
-	}
-	if c.connection != nil {
-		c.connection.Close()
-	}
+	c.closeOnce.Do(func() {
+		close(c.done)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		c.status.State = ConnectionStateClosed
+		c.status.Since = time.Now()
+		if c.channel != nil {
+			c.channel.Close()
+		}
+		if c.connection != nil {
+			c.connection.Close()
+		}
+	})
 }
 
 // IsConnectionClosed checks if the connection is closed
 func (c *RabbitMQClient) IsConnectionClosed() bool {
-	return c.connection.IsClosed()
+	return c.State() != ConnectionStateConnected
+}
+
+// State returns the current connection state
+func (c *RabbitMQClient) State() ConnectionState {
+	return c.Status().State
+}
+
+// Status returns a snapshot of the connection state for health reporting
+func (c *RabbitMQClient) Status() ConnectionStatus {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.status
 }
 
 // LogError is a helper function to log errors
@@ -1,8 +1,11 @@
 package shared
 
 import (
+	"context"
 	"testing"
 	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
 )
 
 // TestRabbitMQClientCreation tests the creation of RabbitMQ client
@@ -24,6 +27,104 @@ func TestRabbitMQClientCreation(t *testing.T) {
 	}
 }
 
+func TestRabbitMQClientNotConnected(t *testing.T) {
+	client := &RabbitMQClient{ready: make(chan struct{}), done: make(chan struct{})}
+	client.status.State = ConnectionStateReconnecting
+
+	if !client.IsConnectionClosed() {
+		t.Error("Expected reconnecting client to report a closed connection")
+	}
+	if err := client.PublishJob(context.Background(), JobMessage{JobID: "test-123"}); err != ErrNotConnected {
+		t.Errorf("Expected ErrNotConnected, got %v", err)
+	}
+	if _, err := client.ConsumeResults(); err != ErrNotConnected {
+		t.Errorf("Expected ErrNotConnected, got %v", err)
+	}
+
+	client.Close()
+	if client.State() != ConnectionStateClosed {
+		t.Errorf("Expected state %s, got %s", ConnectionStateClosed, client.State())
+	}
+	if _, ok := client.waitForChannel(); ok {
+		t.Error("Expected waitForChannel to give up once the client is closed")
+	}
+}
+
+func TestDispatchReturns(t *testing.T) {
+	client := &RabbitMQClient{returned: make(map[string]chan amqp.Return)}
+	waiter := make(chan amqp.Return, 1)
+	client.returned["msg-1"] = waiter
+
+	// Unbuffered like the library's: a send completes once dispatched
+	returns := make(chan amqp.Return)
+	dispatcher := &returnDispatcher{syncs: make(chan chan struct{}), stopped: make(chan struct{})}
+	go client.dispatchReturns(returns, dispatcher)
+
+	returns <- amqp.Return{MessageId: "unknown", ReplyCode: 312, ReplyText: "NO_ROUTE"}
+	returns <- amqp.Return{MessageId: "msg-1", ReplyCode: 312, ReplyText: "NO_ROUTE"}
+	// The confirm arrives now; sync must see the return through
+	if err := dispatcher.sync(context.Background()); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	select {
+	case r := <-waiter:
+		if r.ReplyText != "NO_ROUTE" {
+			t.Errorf("Expected NO_ROUTE, got %s", r.ReplyText)
+		}
+	default:
+		t.Error("Expected returned message to be handed to its publisher before the confirm is reported")
+	}
+
+	close(returns)
+	<-dispatcher.stopped
+	if err := dispatcher.sync(context.Background()); err != nil {
+		t.Errorf("Expected sync to pass once the channel closed, got %v", err)
+	}
+}
+
+func TestWaitForConfirmWithoutConfirmMode(t *testing.T) {
+	if err := waitForConfirm(context.Background(), nil); err != nil {
+		t.Errorf("Expected no error without confirm mode, got %v", err)
+	}
+}
+
+func TestDeliveryAttempt(t *testing.T) {
+	if attempt := DeliveryAttempt(amqp.Delivery{}); attempt != 1 {
+		t.Errorf("Expected first delivery to be attempt 1, got %d", attempt)
+	}
+
+	d := amqp.Delivery{Headers: amqp.Table{AttemptHeader: int32(3)}}
+	if attempt := DeliveryAttempt(d); attempt != 3 {
+		t.Errorf("Expected attempt 3, got %d", attempt)
+	}
+}
+
+func TestDeadLetterFromDelivery(t *testing.T) {
+	now := time.Now()
+	d := amqp.Delivery{
+		MessageId: "dl-1",
+		Timestamp: now,
+		Headers: amqp.Table{
+			AttemptHeader:          int32(3),
+			DeadLetterReasonHeader: "ollama unavailable",
+		},
+		Body: []byte(`{"job_id":"job-1","title":"Test"}`),
+	}
+
+	deadLetter := deadLetterFromDelivery(d)
+	if deadLetter.ID != "dl-1" || deadLetter.JobID != "job-1" {
+		t.Errorf("Unexpected dead letter identity: %+v", deadLetter)
+	}
+	if deadLetter.Reason != "ollama unavailable" || deadLetter.Attempts != 3 {
+		t.Errorf("Unexpected dead letter details: %+v", deadLetter)
+	}
+
+	malformed := deadLetterFromDelivery(amqp.Delivery{Body: []byte("not json")})
+	if malformed.JobID != "" || malformed.Body != "not json" {
+		t.Errorf("Unexpected malformed dead letter: %+v", malformed)
+	}
+}
+
 func TestJobMessageAndResultSerialization(t *testing.T) {
 	// Test JobMessage
 	jobMsg := JobMessage{
@@ -73,6 +174,9 @@ func TestQueueConstants(t *testing.T) {
 	if ResultQueueName != expectedResultQueue {
 		t.Errorf("Expected ResultQueueName to be %s, got %s", expectedResultQueue, ResultQueueName)
 	}
+	if PartialResultQueueName != "job_partial_results" {
+		t.Errorf("Expected PartialResultQueueName to be job_partial_results, got %s", PartialResultQueueName)
+	}
 }
 
 // MockRabbitMQClient for testing without actual RabbitMQ connection
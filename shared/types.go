@@ -1,6 +1,11 @@
 package shared
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
 
 // JobStatus represents the current status of a job
 type JobStatus string
@@ -10,8 +15,19 @@ const (
 	JobStatusProcessing JobStatus = "processing"
 	JobStatusCompleted  JobStatus = "completed"
 	JobStatusFailed     JobStatus = "failed"
+	JobStatusCancelled  JobStatus = "cancelled"
 )
 
+// IsTerminal reports whether the status is final and will not change again
+func (s JobStatus) IsTerminal() bool {
+	return s == JobStatusCompleted || s == JobStatusFailed || s == JobStatusCancelled
+}
+
+// IsValid reports whether the status is one of the known statuses
+func (s JobStatus) IsValid() bool {
+	return s == JobStatusPending || s == JobStatusProcessing || s.IsTerminal()
+}
+
 // ResearchType represents different types of research requests
 type ResearchType string
 
@@ -24,6 +40,15 @@ const (
 	ResearchTypeCompetitive ResearchType = "competitive"
 )
 
+// IsValid reports whether the research type is one of the known types
+func (t ResearchType) IsValid() bool {
+	switch t {
+	case ResearchTypeGeneral, ResearchTypeCode, ResearchTypeData, ResearchTypeMarket, ResearchTypeTechnical, ResearchTypeCompetitive:
+		return true
+	}
+	return false
+}
+
 // MCPService represents available MCP services for research
 type MCPService string
 
@@ -36,6 +61,283 @@ const (
 	MCPServiceSlack    MCPService = "slack"
 )
 
+// MCPToolInfo describes a tool discovered on an MCP server
+type MCPToolInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// MCPServiceInfo describes a configured MCP service and the tools its
+// server offers
+type MCPServiceInfo struct {
+	Name        MCPService    `json:"name"`
+	Title       string        `json:"title"`
+	Description string        `json:"description,omitempty"`
+	Transport   string        `json:"transport,omitempty"`
+	Available   bool          `json:"available"`
+	Error       string        `json:"error,omitempty"`
+	Tools       []MCPToolInfo `json:"tools,omitempty"`
+}
+
+// MCPCatalog is broadcast periodically by every job runner to advertise the
+// MCP services it can query
+type MCPCatalog struct {
+	RunnerID    string           `json:"runner_id"`
+	TestMode    bool             `json:"test_mode"`
+	Services    []MCPServiceInfo `json:"services"`
+	PublishedAt time.Time        `json:"published_at"`
+}
+
+// BreakerState is the state of the circuit breaker guarding an MCP server
+type BreakerState string
+
+const (
+	// BreakerClosed lets requests through
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen fails requests at once after repeated failures
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen lets a trial request through to see if the server
+	// has recovered
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+// MCPServiceHealth is the circuit breaker state and latest health probe of
+// an MCP server as seen by one job runner
+type MCPServiceHealth struct {
+	Name                MCPService   `json:"name"`
+	Breaker             BreakerState `json:"breaker"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	LastError           string       `json:"last_error,omitempty"`
+	// RetryAt is when an open breaker lets a trial request through
+	RetryAt *time.Time `json:"retry_at,omitempty"`
+	// Healthy is set if the latest probe got an answer
+	Healthy        bool       `json:"healthy"`
+	LastProbe      *time.Time `json:"last_probe,omitempty"`
+	ProbeLatencyMs int64      `json:"probe_latency_ms,omitempty"`
+}
+
+// MCPStatusReport is broadcast by every job runner after probing its MCP
+// servers
+type MCPStatusReport struct {
+	RunnerID    string             `json:"runner_id"`
+	TestMode    bool               `json:"test_mode"`
+	Services    []MCPServiceHealth `json:"services"`
+	PublishedAt time.Time          `json:"published_at"`
+}
+
+// ModelInfo describes a model a job runner's inference server can run.
+// Fields other than Name are empty when the server does not report them.
+type ModelInfo struct {
+	Name          string `json:"name"`
+	Family        string `json:"family,omitempty"`
+	ParameterSize string `json:"parameter_size,omitempty"`
+	Size          int64  `json:"size,omitempty"`
+}
+
+// ModelCatalog is broadcast periodically by every job runner to advertise
+// the models its inference server offers
+type ModelCatalog struct {
+	RunnerID     string      `json:"runner_id"`
+	Provider     string      `json:"provider"`
+	DefaultModel string      `json:"default_model"`
+	Models       []ModelInfo `json:"models"`
+	PublishedAt  time.Time   `json:"published_at"`
+}
+
+// ModelOptions tunes how the model generates a job's report. Zero values
+// leave the server's defaults in place.
+type ModelOptions struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	// NumCtx is the context window in tokens; only Ollama honours it
+	NumCtx    int `json:"num_ctx,omitempty"`
+	MaxTokens int `json:"max_tokens,omitempty"`
+}
+
+// Validate checks that the options are within the ranges servers accept
+func (o *ModelOptions) Validate() error {
+	if o == nil {
+		return nil
+	}
+	if o.Temperature != nil && (*o.Temperature < 0 || *o.Temperature > 2) {
+		return errors.New("temperature must be between 0 and 2")
+	}
+	if o.NumCtx < 0 {
+		return errors.New("num_ctx must not be negative")
+	}
+	if o.MaxTokens < 0 {
+		return errors.New("max_tokens must not be negative")
+	}
+	return nil
+}
+
+// TraceStepType identifies what happened in one step of a research run
+type TraceStepType string
+
+const (
+	// TraceStepPlan is the model reasoning about what to do next
+	TraceStepPlan TraceStepType = "plan"
+	// TraceStepToolCall is an MCP tool called with model-chosen arguments
+	TraceStepToolCall TraceStepType = "tool_call"
+	// TraceStepReport is the model writing the final report
+	TraceStepReport TraceStepType = "report"
+	// TraceStepNote records a decision of the agent itself, such as
+	// stopping because the budget ran out
+	TraceStepNote TraceStepType = "note"
+)
+
+// TraceStep records one step of a research run
+type TraceStep struct {
+	Step       int                    `json:"step"`
+	Type       TraceStepType          `json:"type"`
+	Content    string                 `json:"content,omitempty"`
+	Service    MCPService             `json:"service,omitempty"`
+	Tool       string                 `json:"tool,omitempty"`
+	Arguments  map[string]interface{} `json:"arguments,omitempty"`
+	Result     string                 `json:"result,omitempty"`
+	Sources    []string               `json:"sources,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+	Tokens     int                    `json:"tokens,omitempty"`
+	DurationMs int64                  `json:"duration_ms"`
+	// Cached is set if the tool call was answered from the MCP result cache
+	Cached bool `json:"cached,omitempty"`
+}
+
+// ServiceStatus is how querying an MCP service for a job ended
+type ServiceStatus string
+
+const (
+	ServiceStatusOK      ServiceStatus = "ok"
+	ServiceStatusTimeout ServiceStatus = "timeout"
+	ServiceStatusError   ServiceStatus = "error"
+)
+
+// Provenance tells whether gathered data came from a real MCP server or
+// was simulated
+type Provenance string
+
+const (
+	ProvenanceReal      Provenance = "real"
+	ProvenanceSimulated Provenance = "simulated"
+)
+
+// ServiceOutcome records how an MCP service answered during a job, so the
+// report can say which sources are missing
+type ServiceOutcome struct {
+	Service MCPService    `json:"service"`
+	Status  ServiceStatus `json:"status"`
+	// Provenance is simulated if any of the service's data was simulated,
+	// including data substituted after the server failed, and empty if it
+	// returned nothing
+	Provenance Provenance `json:"provenance,omitempty"`
+	// Calls counts the tool calls made; the agent may call a service's
+	// tools several times, and LatencyMs and Bytes are summed over them
+	Calls     int    `json:"calls"`
+	LatencyMs int64  `json:"latency_ms"`
+	Bytes     int    `json:"bytes"`
+	Error     string `json:"error,omitempty"`
+	// CacheHits counts the calls answered from the MCP result cache
+	// instead of the server
+	CacheHits int `json:"cache_hits,omitempty"`
+}
+
+// Report is the structured form of a research report
+type Report struct {
+	Summary     string   `json:"summary"`
+	KeyFindings []string `json:"key_findings"`
+	// Sections hold the body of the report in the layout of the research type
+	Sections        []ReportSection `json:"sections"`
+	Recommendations []string        `json:"recommendations"`
+	Limitations     []string        `json:"limitations"`
+	// Citations are the gathered sources in order, so [n] in the text
+	// refers to Citations[n-1]
+	Citations []Citation `json:"citations"`
+}
+
+// ReportSection is a part of a report body; Content is markdown
+type ReportSection struct {
+	Heading string `json:"heading"`
+	Content string `json:"content"`
+}
+
+// Citation is a source a report refers to
+type Citation struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+// Reference is a numbered source of the information gathered for a job.
+// Reports cite it as [n], where n is its Number.
+type Reference struct {
+	Number  int        `json:"number"`
+	Service MCPService `json:"service,omitempty"`
+	Tool    string     `json:"tool,omitempty"`
+	// URL is empty for results that named no source; the service and
+	// tool are then the only origin known
+	URL string `json:"url,omitempty"`
+	// Cited is set if the report cites the reference
+	Cited      bool       `json:"cited,omitempty"`
+	Provenance Provenance `json:"provenance,omitempty"`
+}
+
+// Label names the reference by its URL, or by the tool and service that
+// returned it
+func (r Reference) Label() string {
+	switch {
+	case r.URL != "":
+		return r.URL
+	case r.Tool != "":
+		return fmt.Sprintf("%s (%s)", r.Tool, r.Service)
+	default:
+		return string(r.Service)
+	}
+}
+
+// Markdown renders the report as the markdown stored in Job.Result
+func (r *Report) Markdown() string {
+	var b strings.Builder
+	if r.Summary != "" {
+		fmt.Fprintf(&b, "## Summary\n\n%s\n\n", strings.TrimSpace(r.Summary))
+	}
+	writeMarkdownList(&b, "Key Findings", r.KeyFindings)
+	for _, section := range r.Sections {
+		fmt.Fprintf(&b, "## %s\n\n%s\n\n", strings.TrimSpace(section.Heading), strings.TrimSpace(section.Content))
+	}
+	writeMarkdownList(&b, "Recommendations", r.Recommendations)
+	writeMarkdownList(&b, "Limitations", r.Limitations)
+	if len(r.Citations) > 0 {
+		b.WriteString("## Sources\n\n")
+		for i, citation := range r.Citations {
+			fmt.Fprintf(&b, "%d. %s\n", i+1, citation.Markdown())
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// Markdown renders the citation as a link, or as its title or URL alone if
+// the other is missing
+func (c Citation) Markdown() string {
+	switch {
+	case c.URL == "":
+		return c.Title
+	case c.Title == "":
+		return fmt.Sprintf("<%s>", c.URL)
+	default:
+		return fmt.Sprintf("[%s](%s)", c.Title, c.URL)
+	}
+}
+
+// writeMarkdownList writes a headed bullet list, or nothing if it is empty
+func writeMarkdownList(b *strings.Builder, heading string, items []string) {
+	if len(items) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "## %s\n\n", heading)
+	for _, item := range items {
+		fmt.Fprintf(b, "- %s\n", strings.TrimSpace(item))
+	}
+	b.WriteString("\n")
+}
+
 // Job represents a research job in the system
 type Job struct {
 	ID           string       `json:"id"`
@@ -49,9 +351,51 @@ type Job struct {
 	CompletedAt  *time.Time   `json:"completed_at,omitempty"`
 	Result       string       `json:"result,omitempty"`
 	Sources      []string     `json:"sources,omitempty"`
-	Error        string       `json:"error,omitempty"`
-	Confidence   float64      `json:"confidence,omitempty"`
-	TokensUsed   int          `json:"tokens_used,omitempty"`
+	// References number the gathered sources for the [n] citations in Result
+	References []Reference `json:"references,omitempty"`
+	// InvalidCitations are the [n] in Result that match no reference, which
+	// the model made up
+	InvalidCitations []int `json:"invalid_citations,omitempty"`
+	// ServiceOutcomes record how each MCP service answered
+	ServiceOutcomes []ServiceOutcome `json:"service_outcomes,omitempty"`
+	// Provenance is simulated if any of the gathered data was simulated.
+	// CacheHits counts the MCP calls answered from the result cache.
+	Provenance Provenance `json:"provenance,omitempty"`
+	CacheHits  int        `json:"cache_hits,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	Confidence float64    `json:"confidence,omitempty"`
+	TokensUsed int        `json:"tokens_used,omitempty"`
+	// PromptTokens and CompletionTokens split TokensUsed into the tokens the
+	// model read and the tokens it generated
+	PromptTokens     int `json:"prompt_tokens,omitempty"`
+	CompletionTokens int `json:"completion_tokens,omitempty"`
+	// EstimatedPromptTokens and EstimatedCompletionTokens are the part of
+	// those counts estimated from word counts, because the inference server
+	// did not report the counts of some model calls
+	EstimatedPromptTokens     int `json:"estimated_prompt_tokens,omitempty"`
+	EstimatedCompletionTokens int `json:"estimated_completion_tokens,omitempty"`
+	// TokensPerSecond is the generation speed over the model calls of the job
+	// whose counts were reported
+	TokensPerSecond float64 `json:"tokens_per_second,omitempty"`
+	// ModelUsed is the model that ran the job, which is the runner's default
+	// when Model is empty
+	ModelUsed string `json:"model_used,omitempty"`
+	// PromptTemplate and PromptVersion identify the prompt templates the job
+	// ran with, so its prompts can be reproduced
+	PromptTemplate string `json:"prompt_template,omitempty"`
+	PromptVersion  int    `json:"prompt_version,omitempty"`
+	// Report is the structured form of Result. It is nil for models that
+	// did not follow the report schema.
+	Report *Report `json:"report,omitempty"`
+	// PartialResult accumulates streamed report text while the job is processing
+	PartialResult string        `json:"partial_result,omitempty"`
+	Model         string        `json:"model,omitempty"`
+	Options       *ModelOptions `json:"options,omitempty"`
+	NoCache       bool          `json:"no_cache,omitempty"`
+	ParentJobID   string        `json:"parent_job_id,omitempty"`
+	Attempt       int           `json:"attempt,omitempty"`
+	// Trace records the steps the research agent took
+	Trace []TraceStep `json:"trace,omitempty"`
 }
 
 // ResearchRequest represents a request to create a new research job
@@ -60,15 +404,36 @@ type ResearchRequest struct {
 	Query        string       `json:"query" binding:"required"`
 	ResearchType ResearchType `json:"research_type"`
 	MCPServices  []MCPService `json:"mcp_services"`
+	// Model selects one of the models listed by GET /api/models; empty
+	// uses the job runner's default
+	Model   string        `json:"model,omitempty"`
+	Options *ModelOptions `json:"options,omitempty"`
+	// NoCache bypasses the MCP result cache so every service is queried
+	// afresh; the fresh results are still cached for later jobs
+	NoCache bool `json:"no_cache,omitempty"`
+}
+
+// RetryRequest optionally overrides the settings of a job when it is re-run
+type RetryRequest struct {
+	Model        string        `json:"model,omitempty"`
+	Options      *ModelOptions `json:"options,omitempty"`
+	ResearchType ResearchType  `json:"research_type,omitempty"`
+	MCPServices  []MCPService  `json:"mcp_services,omitempty"`
+	// NoCache sets whether the new attempt bypasses the MCP result cache;
+	// when it is left out the attempt inherits the setting of the original job
+	NoCache *bool `json:"no_cache,omitempty"`
 }
 
 // JobMessage represents a message sent to the research queue
 type JobMessage struct {
-	JobID        string       `json:"job_id"`
-	Title        string       `json:"title"`
-	Query        string       `json:"query"`
-	ResearchType ResearchType `json:"research_type"`
-	MCPServices  []MCPService `json:"mcp_services"`
+	JobID        string        `json:"job_id"`
+	Title        string        `json:"title"`
+	Query        string        `json:"query"`
+	ResearchType ResearchType  `json:"research_type"`
+	MCPServices  []MCPService  `json:"mcp_services"`
+	Model        string        `json:"model,omitempty"`
+	Options      *ModelOptions `json:"options,omitempty"`
+	NoCache      bool          `json:"no_cache,omitempty"`
 }
 
 // JobResult represents the result of a completed research job
@@ -81,4 +446,58 @@ type JobResult struct {
 	CompletedAt time.Time `json:"completed_at"`
 	Confidence  float64   `json:"confidence,omitempty"`
 	TokensUsed  int       `json:"tokens_used,omitempty"`
+	// Token accounting as reported by the inference server; see Job
+	PromptTokens     int     `json:"prompt_tokens,omitempty"`
+	CompletionTokens int     `json:"completion_tokens,omitempty"`
+	TokensPerSecond  float64 `json:"tokens_per_second,omitempty"`
+	Model            string  `json:"model,omitempty"`
+	PromptTemplate   string  `json:"prompt_template,omitempty"`
+	PromptVersion    int     `json:"prompt_version,omitempty"`
+	Report           *Report `json:"report,omitempty"`
+	// The part of the token counts estimated from word counts; see Job
+	EstimatedPromptTokens     int `json:"estimated_prompt_tokens,omitempty"`
+	EstimatedCompletionTokens int `json:"estimated_completion_tokens,omitempty"`
+	// References and InvalidCitations link the report's citations to the
+	// gathered sources; see Job
+	References       []Reference      `json:"references,omitempty"`
+	InvalidCitations []int            `json:"invalid_citations,omitempty"`
+	ServiceOutcomes  []ServiceOutcome `json:"service_outcomes,omitempty"`
+	Provenance       Provenance       `json:"provenance,omitempty"`
+	CacheHits        int              `json:"cache_hits,omitempty"`
+	// Trace is the research trace so far; processing updates carry it as
+	// the agent works
+	Trace []TraceStep `json:"trace,omitempty"`
+}
+
+// JobPartialResult carries an incremental chunk of generated report text
+type JobPartialResult struct {
+	JobID     string    `json:"job_id"`
+	Sequence  int       `json:"sequence"`
+	Chunk     string    `json:"chunk"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ControlMessageType identifies the kind of control message broadcast to job runners
+type ControlMessageType string
+
+const (
+	ControlMessageCancel ControlMessageType = "cancel"
+)
+
+// ControlMessage is broadcast to every job runner to control in-flight jobs
+type ControlMessage struct {
+	Type        ControlMessageType `json:"type"`
+	JobID       string             `json:"job_id"`
+	RequestedAt time.Time          `json:"requested_at"`
+}
+
+// DeadLetter is a job message that could not be processed and was parked in
+// the dead-letter queue for inspection and replay
+type DeadLetter struct {
+	ID             string    `json:"id"`
+	JobID          string    `json:"job_id,omitempty"`
+	Reason         string    `json:"reason"`
+	Attempts       int       `json:"attempts"`
+	DeadLetteredAt time.Time `json:"dead_lettered_at"`
+	Body           string    `json:"body"`
 }
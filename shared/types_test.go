@@ -78,6 +78,7 @@ func TestJobStatusValues(t *testing.T) {
 		JobStatusProcessing,
 		JobStatusCompleted,
 		JobStatusFailed,
+		JobStatusCancelled,
 	}
 
 	expectedValues := []string{
@@ -85,6 +86,7 @@ func TestJobStatusValues(t *testing.T) {
 		"processing",
 		"completed",
 		"failed",
+		"cancelled",
 	}
 
 	for i, status := range statuses {
@@ -93,3 +95,97 @@ func TestJobStatusValues(t *testing.T) {
 		}
 	}
 }
+
+func TestJobStatusIsTerminal(t *testing.T) {
+	terminal := map[JobStatus]bool{
+		JobStatusPending:    false,
+		JobStatusProcessing: false,
+		JobStatusCompleted:  true,
+		JobStatusFailed:     true,
+		JobStatusCancelled:  true,
+	}
+
+	for status, expected := range terminal {
+		if status.IsTerminal() != expected {
+			t.Errorf("Expected %s terminal=%v", status, expected)
+		}
+	}
+}
+
+func TestModelOptionsValidate(t *testing.T) {
+	temperature := func(v float64) *float64 { return &v }
+
+	valid := []*ModelOptions{
+		nil,
+		{},
+		{Temperature: temperature(0), NumCtx: 8192, MaxTokens: 2048},
+		{Temperature: temperature(2)},
+	}
+	for _, options := range valid {
+		if err := options.Validate(); err != nil {
+			t.Errorf("Expected %+v to be valid, got %v", options, err)
+		}
+	}
+
+	invalid := []*ModelOptions{
+		{Temperature: temperature(-0.1)},
+		{Temperature: temperature(2.5)},
+		{NumCtx: -1},
+		{MaxTokens: -1},
+	}
+	for _, options := range invalid {
+		if err := options.Validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", options)
+		}
+	}
+}
+
+func TestReportMarkdown(t *testing.T) {
+	report := &Report{
+		Summary:         "Go generics use GC shape stenciling.",
+		KeyFindings:     []string{"Generics shipped in Go 1.18"},
+		Sections:        []ReportSection{{Heading: "Implementation", Content: "Dictionaries are passed at runtime."}},
+		Recommendations: []string{"Prefer interfaces for behaviour"},
+		Citations: []Citation{
+			{Title: "Go 1.18 release notes", URL: "https://go.dev/doc/go1.18"},
+			{URL: "https://go.dev/blog/intro-generics"},
+		},
+	}
+
+	expected := `## Summary
+
+Go generics use GC shape stenciling.
+
+## Key Findings
+
+- Generics shipped in Go 1.18
+
+## Implementation
+
+Dictionaries are passed at runtime.
+
+## Recommendations
+
+- Prefer interfaces for behaviour
+
+## Sources
+
+1. [Go 1.18 release notes](https://go.dev/doc/go1.18)
+2. <https://go.dev/blog/intro-generics>`
+	if got := report.Markdown(); got != expected {
+		t.Errorf("Unexpected markdown:\n%s", got)
+	}
+}
+
+func TestReferenceLabel(t *testing.T) {
+	tests := map[string]Reference{
+		"https://go.dev":    {Service: MCPServiceWeb, Tool: "web_search", URL: "https://go.dev"},
+		"read_file (files)": {Service: MCPServiceFiles, Tool: "read_file"},
+		"github":            {Service: MCPServiceGitHub},
+	}
+	for expected, reference := range tests {
+		if got := reference.Label(); got != expected {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+	}
+}